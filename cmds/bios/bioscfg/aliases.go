@@ -0,0 +1,206 @@
+package main
+
+import (
+	"strings"
+)
+
+// alias is the native name of a canonical setting for a driver, along
+// with the native values of its canonical values.  Values with no entry
+// in Values are passed through as-is.
+type alias struct {
+	Native string
+	Values map[string]string
+}
+
+// aliasTable maps canonical setting names to the native ones for a driver.
+type aliasTable map[string]alias
+
+var (
+	enabledDisabled = map[string]string{"enabled": "Enabled", "disabled": "Disabled"}
+	enableDisable   = map[string]string{"enabled": "Enable", "disabled": "Disable"}
+)
+
+// The canonical settings are:
+//
+// * boot.mode: uefi or legacy
+// * cpu.virtualization: enabled or disabled
+// * cpu.sriov: enabled or disabled
+// * power.profile: performance, balanced, or powersave
+// * tpm.enabled: true or false
+//
+// Supermicro has no power.profile.  Its Power Technology setting only
+// picks between Disable, Energy Efficient, and Custom, none of which
+// means performance or powersave.
+var (
+	dellAliases = aliasTable{
+		"boot.mode": {
+			Native: "BIOS.Setup.1-1/BootMode",
+			Values: map[string]string{"uefi": "Uefi", "legacy": "Bios"},
+		},
+		"cpu.virtualization": {Native: "BIOS.Setup.1-1/ProcVirtualization", Values: enabledDisabled},
+		"cpu.sriov":          {Native: "BIOS.Setup.1-1/SriovGlobalEnable", Values: enabledDisabled},
+		"power.profile": {
+			Native: "BIOS.Setup.1-1/SysProfile",
+			Values: map[string]string{
+				"performance": "PerfOptimized",
+				"balanced":    "PerfPerWattOptimizedDapc",
+				"powersave":   "PerfPerWattOptimizedOs",
+			},
+		},
+		"tpm.enabled": {
+			Native: "BIOS.Setup.1-1/TpmSecurity",
+			Values: map[string]string{"true": "On", "false": "Off"},
+		},
+	}
+	hpAliases = aliasTable{
+		"boot.mode": {
+			Native: "Boot_Mode",
+			Values: map[string]string{"uefi": "UEFI_Mode", "legacy": "Legacy_BIOS_Mode"},
+		},
+		"cpu.virtualization": {Native: "Intel_Virtualization_Technology", Values: enabledDisabled},
+		"cpu.sriov":          {Native: "SR-IOV", Values: enabledDisabled},
+		"power.profile": {
+			Native: "HP_Power_Profile",
+			Values: map[string]string{
+				"performance": "Maximum_Performance",
+				"balanced":    "Balanced_Power_and_Performance",
+				"powersave":   "Minimum_Power_Usage",
+			},
+		},
+		"tpm.enabled": {
+			Native: "TPM_State",
+			Values: map[string]string{"true": "Present_Enabled", "false": "Present_Disabled"},
+		},
+	}
+	lenovoAliases = aliasTable{
+		"boot.mode": {
+			Native: "BootModes.SystemBootMode",
+			Values: map[string]string{"uefi": "UEFI Mode", "legacy": "Legacy Mode"},
+		},
+		"cpu.virtualization": {Native: "Processors.IntelVirtualizationTechnology", Values: enableDisable},
+		"cpu.sriov":          {Native: "DevicesandIOPorts.SRIOV", Values: enableDisable},
+		"power.profile": {
+			Native: "OperatingModes.ChooseOperatingMode",
+			Values: map[string]string{
+				"performance": "Maximum Performance",
+				"balanced":    "Efficiency - Favor Performance",
+				"powersave":   "Minimal Power",
+			},
+		},
+		"tpm.enabled": {
+			Native: "TrustedComputingGroup.TPMDevice",
+			Values: map[string]string{"true": "Enable", "false": "Disable"},
+		},
+	}
+	superMicroAliases = aliasTable{
+		"boot.mode": {
+			Native: "Bios::Boot::Boot mode select",
+			Values: map[string]string{"uefi": "UEFI", "legacy": "LEGACY"},
+		},
+		"cpu.virtualization": {
+			Native: "Bios::Advanced::CPU Configuration::Intel Virtualization Technology",
+			Values: enableDisable,
+		},
+		"cpu.sriov": {
+			Native: "Bios::Advanced::PCIe/PCI/PnP Configuration::SR-IOV Support",
+			Values: enabledDisabled,
+		},
+		"tpm.enabled": {
+			Native: "Bios::Advanced::Trusted Computing::Security Device Support",
+			Values: map[string]string{"true": "Enable", "false": "Disable"},
+		},
+	}
+)
+
+// driverAliases returns the alias table for a driver, or nil if it has
+// none.
+func driverAliases(driver string) aliasTable {
+	switch driver {
+	case "dell":
+		return dellAliases
+	case "dell-legacy":
+		// omconfig only knows about the BIOS settings, and names them
+		// without the FQDD.
+		res := aliasTable{}
+		for k, v := range dellAliases {
+			v.Native = strings.TrimPrefix(v.Native, "BIOS.Setup.1-1/")
+			res[k] = v
+		}
+		return res
+	case "hp":
+		return hpAliases
+	case "lenovo":
+		return lenovoAliases
+	case "supermicro":
+		return superMicroAliases
+	}
+	return nil
+}
+
+// native translates canonical settings and values in wanted to the
+// native ones.  Settings that are not canonical are left alone.
+func (t aliasTable) native(wanted map[string]string) map[string]string {
+	res := map[string]string{}
+	for k, v := range wanted {
+		if a, ok := t[k]; ok {
+			k = a.Native
+			if nv, ok := a.Values[v]; ok {
+				v = nv
+			}
+		}
+		res[k] = v
+	}
+	return res
+}
+
+// canonical adds an entry for each canonical setting whose native one is
+// in current, with the values translated back to canonical ones.
+func (t aliasTable) canonical(current map[string]Entry) {
+	for name, a := range t {
+		ent, ok := current[a.Native]
+		if !ok {
+			continue
+		}
+		back := map[string]string{}
+		for k, v := range a.Values {
+			back[v] = k
+		}
+		val := func(v string) string {
+			if cv, ok := back[v]; ok {
+				return cv
+			}
+			return v
+		}
+		ent.Name = name
+		ent.Alias = a.Native
+		ent.Current = val(ent.Current)
+		ent.Pending = val(ent.Pending)
+		ent.Default = val(ent.Default)
+		if ent.Checker.Enum.Valid {
+			vals := make([]string, len(ent.Checker.Enum.Values))
+			for i, v := range ent.Checker.Enum.Values {
+				vals[i] = val(v)
+			}
+			ent.Checker.Enum.Values = vals
+		}
+		current[name] = ent
+	}
+}
+
+// aliasConfig wraps a Configurator to let it take and show the canonical
+// setting names as well as its native ones.
+type aliasConfig struct {
+	Configurator
+	aliases aliasTable
+}
+
+func (a *aliasConfig) Current() (res map[string]Entry, err error) {
+	if res, err = a.Configurator.Current(); err == nil {
+		a.aliases.canonical(res)
+	}
+	return
+}
+
+func (a *aliasConfig) FixWanted(wanted map[string]string) map[string]string {
+	return a.Configurator.FixWanted(a.aliases.native(wanted))
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// fromDump returns the configurator for driver reading the dump in
+// test-data/name.
+func fromDump(t *testing.T, driver, name string) Configurator {
+	t.Helper()
+	var cfg Configurator
+	switch driver {
+	case "dell":
+		cfg = &dellRacadmConfig{}
+	case "hp":
+		cfg = &hpConfig{}
+	case "lenovo":
+		cfg = &lenovoConfig{}
+	case "supermicro":
+		cfg = &superMicroConfig{}
+	case "redfish":
+		cfg = &redfishConfig{}
+	default:
+		t.Fatalf("No driver %s", driver)
+	}
+	fi, err := os.Open(filepath.Join("test-data", name))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	t.Cleanup(func() { fi.Close() })
+	cfg.Source(fi)
+	return cfg
+}
+
+func TestAliasNative(t *testing.T) {
+	for _, tc := range []struct {
+		driver      string
+		wanted, res map[string]string
+	}{
+		{"dell",
+			map[string]string{"boot.mode": "legacy", "power.profile": "performance", "LogicalProc": "Disabled"},
+			map[string]string{"BIOS.Setup.1-1/BootMode": "Bios", "BIOS.Setup.1-1/SysProfile": "PerfOptimized", "LogicalProc": "Disabled"}},
+		{"dell-legacy",
+			map[string]string{"cpu.virtualization": "disabled", "tpm.enabled": "true"},
+			map[string]string{"ProcVirtualization": "Disabled", "TpmSecurity": "On"}},
+		{"hp",
+			map[string]string{"boot.mode": "uefi", "power.profile": "powersave", "tpm.enabled": "false"},
+			map[string]string{"Boot_Mode": "UEFI_Mode", "HP_Power_Profile": "Minimum_Power_Usage", "TPM_State": "Present_Disabled"}},
+		{"lenovo",
+			map[string]string{"cpu.sriov": "enabled", "power.profile": "balanced"},
+			map[string]string{"DevicesandIOPorts.SRIOV": "Enable", "OperatingModes.ChooseOperatingMode": "Efficiency - Favor Performance"}},
+		{"supermicro",
+			map[string]string{"cpu.sriov": "enabled", "cpu.virtualization": "enabled", "power.profile": "performance"},
+			map[string]string{
+				"Bios::Advanced::PCIe/PCI/PnP Configuration::SR-IOV Support":         "Enabled",
+				"Bios::Advanced::CPU Configuration::Intel Virtualization Technology": "Enable",
+				"power.profile": "performance",
+			}},
+		// Values with no canonical equivalent are passed through.
+		{"dell",
+			map[string]string{"power.profile": "Custom"},
+			map[string]string{"BIOS.Setup.1-1/SysProfile": "Custom"}},
+	} {
+		if got := driverAliases(tc.driver).native(tc.wanted); !reflect.DeepEqual(got, tc.res) {
+			t.Errorf("%s: expected %v, got %v", tc.driver, tc.res, got)
+		}
+	}
+	if driverAliases("redfish") != nil || driverAliases("none") != nil {
+		t.Errorf("Expected no aliases for redfish or none")
+	}
+	if dellAliases["boot.mode"].Native != "BIOS.Setup.1-1/BootMode" {
+		t.Errorf("dell-legacy changed the dell aliases")
+	}
+}
+
+func TestAliasCanonical(t *testing.T) {
+	for _, tc := range []struct {
+		driver, dump string
+		want         map[string]string
+	}{
+		{"dell", "dell.xml", map[string]string{
+			"boot.mode":          "uefi",
+			"cpu.virtualization": "enabled",
+			"cpu.sriov":          "disabled",
+			"power.profile":      "balanced",
+			"tpm.enabled":        "true",
+		}},
+		{"hp", "hp.xml", map[string]string{
+			"boot.mode":          "uefi",
+			"cpu.virtualization": "enabled",
+			"cpu.sriov":          "enabled",
+			"power.profile":      "balanced",
+			"tpm.enabled":        "false",
+		}},
+		{"lenovo", "lenovo.txt", map[string]string{
+			"boot.mode":          "uefi",
+			"cpu.virtualization": "enabled",
+			"cpu.sriov":          "disabled",
+			"power.profile":      "performance",
+			"tpm.enabled":        "true",
+		}},
+		{"supermicro", "supermicro.xml", map[string]string{
+			"boot.mode":          "uefi",
+			"cpu.virtualization": "enabled",
+			"cpu.sriov":          "disabled",
+			"tpm.enabled":        "true",
+		}},
+	} {
+		aliases := driverAliases(tc.driver)
+		cfg := &aliasConfig{Configurator: fromDump(t, tc.driver, tc.dump), aliases: aliases}
+		cur, err := cfg.Current()
+		if err != nil {
+			t.Errorf("%s: %v", tc.driver, err)
+			continue
+		}
+		for name := range aliases {
+			ent, ok := cur[name]
+			if want, wanted := tc.want[name]; ok != wanted || ent.Current != want {
+				t.Errorf("%s: expected %s to be %q, got %q", tc.driver, name, want, ent.Current)
+				continue
+			}
+			if !ok {
+				continue
+			}
+			// The native setting is still there, and the canonical value
+			// maps back to it.
+			native := aliases[name].Native
+			if ent.Name != name || cur[native].Current == "" {
+				t.Errorf("%s: expected %s alongside %s, got %+v", tc.driver, name, native, cur[native])
+			}
+			if got := cfg.FixWanted(map[string]string{name: ent.Current}); got[native] != cur[native].Current {
+				t.Errorf("%s: expected %s=%s to map back to %q, got %v", tc.driver, name, ent.Current, cur[native].Current, got)
+			}
+		}
+	}
+
+	cur, err := (&aliasConfig{Configurator: fromDump(t, "supermicro", "supermicro.xml"), aliases: superMicroAliases}).Current()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	ent := cur["boot.mode"]
+	if want := []string{"DUAL", "legacy", "uefi"}; !reflect.DeepEqual(ent.Checker.Enum.Values, want) || ent.Default != "DUAL" {
+		t.Errorf("Expected boot.mode to allow %v with a default of DUAL, got %+v", want, ent)
+	}
+	if err := ent.Valid("legacy"); err != nil {
+		t.Errorf("Expected legacy to be a valid boot.mode: %v", err)
+	}
+}
+
+// Applying an export has to leave everything alone, so the canonical
+// settings must not show up in it alongside their native ones.
+func TestAliasExportRoundTrip(t *testing.T) {
+	for driver, dump := range map[string]string{
+		"dell":       "dell.xml",
+		"hp":         "hp.xml",
+		"lenovo":     "lenovo.txt",
+		"supermicro": "supermicro.xml",
+	} {
+		aliases := driverAliases(driver)
+		cfg := &aliasConfig{Configurator: fromDump(t, driver, dump), aliases: aliases}
+		cur, err := cfg.Current()
+		if err != nil {
+			t.Errorf("%s: %v", driver, err)
+			continue
+		}
+		exported := Export(cur)
+		for name, a := range aliases {
+			if _, ok := exported[name]; ok {
+				t.Errorf("%s: expected %s to be left out of the export", driver, name)
+			}
+			if _, ok := cur[a.Native]; ok && cur[name].Alias != a.Native {
+				t.Errorf("%s: expected %s to be an alias for %s, got %q", driver, name, a.Native, cur[name].Alias)
+			}
+		}
+		if len(exported) != len(cur)-len(aliases) {
+			t.Errorf("%s: expected %d settings in the export, got %d", driver, len(cur)-len(aliases), len(exported))
+		}
+		cfg = &aliasConfig{Configurator: fromDump(t, driver, dump), aliases: aliases}
+		if _, trimmed, err := Test(cfg, cfg.FixWanted(exported)); err != nil || len(trimmed) != 0 {
+			t.Errorf("%s: expected applying the export to change nothing, got %v %v", driver, trimmed, err)
+		}
+		// An edit to the export is what gets applied, with nothing
+		// left over to put the old value back.
+		virt := aliases["cpu.virtualization"]
+		exported[virt.Native] = virt.Values["disabled"]
+		cfg = &aliasConfig{Configurator: fromDump(t, driver, dump), aliases: aliases}
+		if _, trimmed, err := Test(cfg, cfg.FixWanted(exported)); err != nil || len(trimmed) != 1 || trimmed[virt.Native] != virt.Values["disabled"] {
+			t.Errorf("%s: expected only %s to be disabled, got %v %v", driver, virt.Native, trimmed, err)
+		}
+	}
+}
@@ -5,34 +5,53 @@ import (
 	"flag"
 	"log"
 	"os"
+	"time"
 )
 
 func main() {
 	var driver, op, src, cfgSrc string
 	var dryRun bool
-	flag.StringVar(&driver, "driver", "", "Driver to use for BIOS configuration. One of dell hp lenovo none dell-legacy supermicro")
-	flag.StringVar(&op, "operation", "get", "Operation to perform, one of: get test apply export")
-	flag.StringVar(&src, "source", "", "Source config file to read from for testing.  Can be left blank to use the current system config.  Must be in the native tooling format for the driver (racadm get --clone XML for Dell, conrep xml for HP, list for OneCli)")
-	flag.StringVar(&cfgSrc, "config", "-", "Configuration to test or apply.  '-' means read from stdin.")
+	var setupPassword string
+	rf := &redfishConfig{}
+	dell := &dellRacadmConfig{}
+	flag.StringVar(&driver, "driver", "", "Driver to use for BIOS configuration. One of dell hp lenovo none dell-legacy supermicro redfish")
+	flag.StringVar(&op, "operation", "get", "Operation to perform, one of: get test apply export password")
+	flag.StringVar(&src, "source", "", "Source config file to read from for testing.  Can be left blank to use the current system config.  Must be in the native tooling format for the driver (racadm get --clone XML for Dell, conrep xml for HP, list for OneCli, the Bios resource JSON for Redfish)")
+	flag.StringVar(&cfgSrc, "config", "-", "Configuration to test or apply, or the Old and New passwords for the password op.  '-' means read from stdin.")
 	flag.BoolVar(&dryRun, "dryRun", false, "Skip actually making changes when apply is the op.")
+	flag.StringVar(&setupPassword, "setup-password", os.Getenv("BIOS_SETUP_PASSWORD"), "Current BIOS setup password, for drivers that need it to change settings.  Only redfish does, for HPE iLOs.  Defaults to $BIOS_SETUP_PASSWORD, which keeps it out of ps.")
+	flag.DurationVar(&dell.timeout, "job-timeout", 30*time.Minute, "How long the dell driver waits for an iDRAC job to finish.")
+	flag.StringVar(&rf.address, "redfish-address", "", "Address of the Redfish service for the redfish driver.  If blank, the host interface the BMC offers the OS is used.")
+	flag.StringVar(&rf.username, "redfish-username", os.Getenv("REDFISH_USERNAME"), "User to log in to Redfish as.  Defaults to $REDFISH_USERNAME.")
+	flag.StringVar(&rf.password, "redfish-password", os.Getenv("REDFISH_PASSWORD"), "Password to log in to Redfish with.  Defaults to $REDFISH_PASSWORD, which keeps it out of ps.")
+	flag.StringVar(&rf.system, "redfish-system", "", "ID of the Redfish system to configure.  Defaults to the first one.")
 	flag.Parse()
 	var cfg Configurator
 	switch driver {
 	case "dell-legacy":
 		cfg = &dellBiosOnlyConfig{}
 	case "dell":
-		cfg = &dellRacadmConfig{}
+		cfg = dell
 	case "hp":
 		cfg = &hpConfig{}
 	case "lenovo":
 		cfg = &lenovoConfig{}
 	case "supermicro":
 		cfg = &superMicroConfig{}
+	case "redfish":
+		cfg = rf
 	case "none":
 		cfg = &noneConfig{}
 	default:
 		log.Fatalf("Unknown driver %s", driver)
 	}
+	native := cfg
+	if a, ok := native.(Authorizer); ok && setupPassword != "" {
+		a.Authorize(setupPassword)
+	}
+	if aliases := driverAliases(driver); aliases != nil {
+		cfg = &aliasConfig{Configurator: cfg, aliases: aliases}
+	}
 
 	if src != "" {
 		toRead, err := os.Open(src)
@@ -67,11 +86,7 @@ func main() {
 		if err != nil {
 			log.Fatalf("Error getting config: %v", err)
 		}
-		vals := map[string]string{}
-		for k, v := range ents {
-			vals[k] = v.Current
-		}
-		res = vals
+		res = Export(ents)
 	case "get":
 		ents, err = cfg.Current()
 		if err != nil {
@@ -103,11 +118,34 @@ func main() {
 			if needReboot {
 				exitCode += 192
 			}
+			if r, ok := native.(Resulter); ok && r.Result() != nil {
+				buf, _ := json.MarshalIndent(r.Result(), ``, `  `)
+				log.Printf("Apply result:\n%s", string(buf))
+			}
 		}
 		if err != nil {
 			exitCode += 1
 		}
 		res = trimmed
+	case "password":
+		ps, ok := native.(PasswordSetter)
+		if !ok {
+			log.Fatalf("The %s driver cannot set the BIOS password", driver)
+		}
+		pw := &Passwords{}
+		if err = dec.Decode(pw); err != nil {
+			log.Fatalf("Unable to parse JSON passwords on stdin: %v", err)
+		}
+		if pw.Old == "" && pw.New == "" {
+			log.Fatalf("No Old or New password on stdin")
+		}
+		needReboot, err = ps.SetPassword(pw.Old, pw.New, dryRun)
+		if needReboot {
+			exitCode += 192
+		}
+		if err != nil {
+			exitCode += 1
+		}
 	default:
 		log.Fatalf("Unknown op '%s'", op)
 	}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// dellJobsFile records the IDs of the configuration jobs Apply has
+// created, so that clearStaleJobs only ever deletes its own.
+var dellJobsFile = "/var/lib/drp-bioscfg/dell-jobs"
+
+// dellImportJob is the name the iDRAC gives server configuration profile
+// import jobs.
+const dellImportJob = "Import Configuration"
+
+func racadm(args ...string) ([]byte, error) {
+	return exec.Command("/opt/dell/srvadmin/sbin/racadm", args...).CombinedOutput()
+}
+
+// dellJob is an iDRAC job as racadm jobqueue view shows it.
+type dellJob struct {
+	ID, Name, Status, Message string
+}
+
+// finished is true once the job will make no more progress on its own.
+// A configuration job submitted with NoReboot is left scheduled until
+// the host reboots.
+func (j *dellJob) finished() bool {
+	switch j.Status {
+	case "Completed", "Completed with Errors", "CompletedWithErrors", "Failed",
+		"Scheduled", "Pending", "Waiting", "Ready For Execution":
+		return true
+	}
+	return false
+}
+
+// result turns the job into an ApplyResult.
+func (j *dellJob) result() *ApplyResult {
+	res := &ApplyResult{Job: j.ID, Message: j.Message}
+	switch j.Status {
+	case "Completed":
+		res.Status = "applied"
+		if strings.Contains(j.Message, "SYS069") {
+			res.Status = "unchanged"
+		}
+	case "Scheduled", "Pending", "Waiting", "Ready For Execution":
+		res.Status = "pending-reboot"
+	default:
+		res.Status = "failed"
+	}
+	return res
+}
+
+// racadmField splits a line of racadm output into a key and value,
+// dropping the brackets racadm puts around some of them.
+func racadmField(line string) (key, val string, ok bool) {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+		line = line[1 : len(line)-1]
+	}
+	idx := strings.IndexAny(line, "=:")
+	if idx == -1 {
+		return
+	}
+	key = strings.ToLower(strings.Join(strings.Fields(line[:idx]), ""))
+	val = strings.TrimSpace(line[idx+1:])
+	val = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(val, "["), "]"))
+	return key, val, key != ""
+}
+
+// parseDellJobs parses the output of racadm jobqueue view.
+func parseDellJobs(buf []byte) []*dellJob {
+	res := []*dellJob{}
+	var job *dellJob
+	sc := bufio.NewScanner(bytes.NewReader(buf))
+	for sc.Scan() {
+		key, val, ok := racadmField(sc.Text())
+		if !ok {
+			continue
+		}
+		switch key {
+		case "jobid":
+			job = &dellJob{ID: val}
+			res = append(res, job)
+		case "jobname":
+			if job != nil {
+				job.Name = val
+			}
+		case "status":
+			if job != nil {
+				job.Status = val
+			}
+		case "message":
+			if job != nil {
+				job.Message = val
+			}
+		}
+	}
+	return res
+}
+
+// parseDellFailures parses the output of racadm lclog viewconfigresult
+// into the attributes that failed to be set and why.
+func parseDellFailures(buf []byte) map[string]string {
+	res := map[string]string{}
+	var fqdd, name, status, msg string
+	flush := func() {
+		if name != "" && strings.Contains(strings.ToLower(status), "fail") {
+			if fqdd != "" {
+				name = fqdd + "/" + name
+			}
+			res[name] = msg
+		}
+		name, status, msg = "", "", ""
+	}
+	sc := bufio.NewScanner(bytes.NewReader(buf))
+	for sc.Scan() {
+		key, val, ok := racadmField(sc.Text())
+		if !ok {
+			continue
+		}
+		switch key {
+		case "fqdd":
+			flush()
+			fqdd = val
+		case "attributename", "name":
+			flush()
+			name = val
+		case "status":
+			status = val
+		case "errormessage", "message":
+			msg = val
+		}
+	}
+	flush()
+	return res
+}
+
+// waitJob polls the job until it is finished or the timeout passes.
+func (d *dellRacadmConfig) waitJob(jid string) (*dellJob, error) {
+	deadline := time.Now().Add(d.timeout)
+	for {
+		buf, err := racadm("jobqueue", "view", "-i", jid)
+		if err != nil {
+			return nil, fmt.Errorf("Racadm failed to view the jobqueue: %s %v", string(buf), err)
+		}
+		jobs := parseDellJobs(buf)
+		if len(jobs) == 0 {
+			return nil, fmt.Errorf("Job %s not found:\n%s", jid, string(buf))
+		}
+		if jobs[0].finished() {
+			return jobs[0], nil
+		}
+		if time.Now().After(deadline) {
+			return jobs[0], fmt.Errorf("Timed out after %v waiting for job %s, which is %s", d.timeout, jid, jobs[0].Status)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// failures gets the attributes the job failed to set.
+func (d *dellRacadmConfig) failures(jid string) map[string]string {
+	buf, err := racadm("lclog", "viewconfigresult", "-j", jid)
+	if err != nil {
+		log.Printf("Unable to get the results of job %s: %s %v", jid, string(buf), err)
+		return nil
+	}
+	return parseDellFailures(buf)
+}
+
+// ownJobs returns the IDs of the jobs recorded in dellJobsFile.
+func ownJobs() map[string]bool {
+	res := map[string]bool{}
+	buf, err := ioutil.ReadFile(dellJobsFile)
+	if err != nil {
+		return res
+	}
+	for _, jid := range strings.Fields(string(buf)) {
+		res[jid] = true
+	}
+	return res
+}
+
+// recordJob adds jid to dellJobsFile.
+func recordJob(jid string) error {
+	if err := os.MkdirAll(filepath.Dir(dellJobsFile), 0755); err != nil {
+		return err
+	}
+	fi, err := os.OpenFile(dellJobsFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer fi.Close()
+	_, err = fmt.Fprintln(fi, jid)
+	return err
+}
+
+// staleJobs returns the jobs in jobs that an earlier run created with
+// Apply and left waiting for a reboot.  Other pending configuration jobs
+// are logged and left alone, as they are not ours to delete.
+func staleJobs(jobs []*dellJob, own map[string]bool) []*dellJob {
+	res := []*dellJob{}
+	for _, job := range jobs {
+		if job.result().Status != "pending-reboot" {
+			continue
+		}
+		if own[job.ID] && job.Name == dellImportJob {
+			res = append(res, job)
+		} else if strings.Contains(job.Name, "Config") {
+			log.Printf("Leaving pending job %s (%s) alone, it may keep the BIOS settings from being applied", job.ID, job.Name)
+		}
+	}
+	return res
+}
+
+// clearStaleJobs deletes the BIOS configuration jobs left scheduled by
+// earlier runs, since the iDRAC will not take a new one while they are
+// pending.  The settings they would have made are in the ones being
+// applied now.
+func (d *dellRacadmConfig) clearStaleJobs() error {
+	buf, err := racadm("jobqueue", "view")
+	if err != nil {
+		return fmt.Errorf("Racadm failed to view the jobqueue: %s %v", string(buf), err)
+	}
+	for _, job := range staleJobs(parseDellJobs(buf), ownJobs()) {
+		log.Printf("Deleting stale job %s (%s)", job.ID, job.Name)
+		if buf, err = racadm("jobqueue", "delete", "-i", job.ID); err != nil {
+			return fmt.Errorf("Racadm failed to delete job %s: %s %v", job.ID, string(buf), err)
+		}
+	}
+	return nil
+}
+
+// track waits for the job to finish and records how it went.
+func (d *dellRacadmConfig) track(jid string) (res *ApplyResult, err error) {
+	job, err := d.waitJob(jid)
+	if err != nil {
+		return
+	}
+	res = job.result()
+	if res.Status == "failed" {
+		res.Failures = d.failures(jid)
+		err = fmt.Errorf("Job %s %s: %s", jid, job.Status, job.Message)
+	}
+	return
+}
+
+func (d *dellRacadmConfig) Result() *ApplyResult {
+	return d.result
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+const racadmJobQueue = `-------------------------JOB QUEUE------------------------
+[Job ID=JID_708491357212]
+Job Name=Import Configuration
+Status=Completed
+Scheduled Start Time=[Not Applicable]
+Expiration Time=[Not Applicable]
+Actual Start Time=[Thu, 15 Oct 2026 10:12:14]
+Actual Completion Time=[Thu, 15 Oct 2026 10:12:40]
+Message=[SYS081: Successfully previewed Server Configuration Profile import operation.]
+Percent Complete=[100]
+----------------------------------------------------------
+[Job ID=JID_708491699054]
+Job Name=Import Configuration
+Status=Scheduled
+Scheduled Start Time=[Not Applicable]
+Expiration Time=[Not Applicable]
+Actual Start Time=[Not Applicable]
+Actual Completion Time=[Not Applicable]
+Message=[SYS098: Scheduled job is created to apply Server Configuration Profile.]
+Percent Complete=[0]
+----------------------------------------------------------
+[Job ID=JID_708492011734]
+Job Name=Configure: RAID.Integrated.1-1
+Status=Scheduled
+Scheduled Start Time=[Now]
+Expiration Time=[Not Applicable]
+Actual Start Time=[Not Applicable]
+Actual Completion Time=[Not Applicable]
+Message=[JCP001: Task successfully scheduled.]
+Percent Complete=[0]
+----------------------------------------------------------
+[Job ID=JID_708492233871]
+Job Name=Import Configuration
+Status=Pending
+Scheduled Start Time=[Not Applicable]
+Expiration Time=[Not Applicable]
+Actual Start Time=[Not Applicable]
+Actual Completion Time=[Not Applicable]
+Message=[SYS098: Scheduled job is created to apply Server Configuration Profile.]
+Percent Complete=[0]
+----------------------------------------------------------
+[Job ID=JID_708492561002]
+Job Name=Import Configuration
+Status=Failed
+Scheduled Start Time=[Not Applicable]
+Expiration Time=[Not Applicable]
+Actual Start Time=[Thu, 15 Oct 2026 10:31:02]
+Actual Completion Time=[Thu, 15 Oct 2026 10:31:30]
+Message=[SYS055: Unable to preview the Server Configuration Profile import operation.]
+Percent Complete=[100]
+----------------------------------------------------------
+[Job ID=JID_708492800115]
+Job Name=Import Configuration
+Status=Completed
+Scheduled Start Time=[Not Applicable]
+Expiration Time=[Not Applicable]
+Actual Start Time=[Thu, 15 Oct 2026 10:35:11]
+Actual Completion Time=[Thu, 15 Oct 2026 10:35:20]
+Message=[SYS069: No changes were applied since the current component configuration matched the requested configuration.]
+Percent Complete=[100]
+----------------------------------------------------------
+`
+
+const racadmConfigResult = `SeqNumber       = 4311
+Job Name        = Import Configuration
+Message ID      = SYS055
+Message         = Unable to preview the Server Configuration Profile import operation.
+
+FQDD            = BIOS.Setup.1-1
+Attribute Name  = ProcVirtualization
+Status          = Success
+Old Value       = Enabled
+New Value       = Disabled
+Message ID      =
+Error Message   =
+
+FQDD            = BIOS.Setup.1-1
+Attribute Name  = SysProfile
+Status          = Failure
+Old Value       = PerfPerWattOptimizedDapc
+New Value       = Fast
+Message ID      = BIOS002
+Error Message   = The value Fast is not valid for SysProfile.
+
+FQDD            = NIC.Integrated.1-1-1
+Attribute Name  = LegacyBootProto
+Status          = Failed
+Old Value       = PXE
+New Value       = iSCSI
+Message ID      = NIC011
+Error Message   = The attribute is read only.
+`
+
+func TestParseDellJobs(t *testing.T) {
+	jobs := parseDellJobs([]byte(racadmJobQueue))
+	if len(jobs) != 6 {
+		t.Fatalf("Expected 6 jobs, got %d", len(jobs))
+	}
+	want := &dellJob{
+		ID:      "JID_708492011734",
+		Name:    "Configure: RAID.Integrated.1-1",
+		Status:  "Scheduled",
+		Message: "JCP001: Task successfully scheduled.",
+	}
+	if !reflect.DeepEqual(jobs[2], want) {
+		t.Errorf("Expected %+v, got %+v", want, jobs[2])
+	}
+	for i, status := range []string{"applied", "pending-reboot", "pending-reboot", "pending-reboot", "failed", "unchanged"} {
+		if res := jobs[i].result(); res.Status != status || res.Job != jobs[i].ID || res.Message != jobs[i].Message {
+			t.Errorf("Job %s: expected %s, got %+v", jobs[i].ID, status, res)
+		}
+		if !jobs[i].finished() {
+			t.Errorf("Job %s should be finished", jobs[i].ID)
+		}
+	}
+	running := &dellJob{ID: "JID_1", Status: "Running"}
+	if running.finished() || running.result().Status != "failed" {
+		t.Errorf("A running job should not be finished, and has no result yet")
+	}
+}
+
+func TestParseDellFailures(t *testing.T) {
+	want := map[string]string{
+		"BIOS.Setup.1-1/SysProfile":            "The value Fast is not valid for SysProfile.",
+		"NIC.Integrated.1-1-1/LegacyBootProto": "The attribute is read only.",
+	}
+	if got := parseDellFailures([]byte(racadmConfigResult)); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestStaleJobs(t *testing.T) {
+	jobs := parseDellJobs([]byte(racadmJobQueue))
+	own := map[string]bool{
+		"JID_708491357212": true,
+		"JID_708491699054": true,
+		"JID_708492011734": true,
+	}
+	got := []string{}
+	for _, job := range staleJobs(jobs, own) {
+		got = append(got, job.ID)
+	}
+	// The RAID job is not an import even though it is listed, and the
+	// pending import was not created by us.
+	if want := []string{"JID_708491699054"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v to be stale, got %v", want, got)
+	}
+	if res := staleJobs(jobs, map[string]bool{}); len(res) != 0 {
+		t.Errorf("Expected no stale jobs without any of our own, got %d", len(res))
+	}
+}
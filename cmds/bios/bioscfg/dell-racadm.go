@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	"encoding/xml"
 	"errors"
 	"fmt"
@@ -21,9 +20,22 @@ type dellRacadmAttrib struct {
 	Value   string   `xml:",chardata"`
 }
 
+// dellRacadmSeqs are the BIOS attributes that are comma separated
+// sequences of devices.
+var dellRacadmSeqs = map[string]bool{
+	"BootSeq":     true,
+	"UefiBootSeq": true,
+	"HddSeq":      true,
+}
+
 func (d *dellRacadmAttrib) decode(target map[string]Entry, names []string) map[string]Entry {
 	name := strings.Join(append(names, d.Name), "/")
-	target[name] = Entry{Name: name, Current: d.Value}
+	ent := Entry{Name: name, Current: d.Value}
+	if len(names) > 0 && strings.HasPrefix(names[0], "BIOS.") && dellRacadmSeqs[d.Name] {
+		ent.Type = "Seq"
+		ent.Checker.Seq.Valid = true
+	}
+	target[name] = ent
 	return target
 }
 
@@ -79,6 +91,8 @@ func (d *dellRacadmComponent) fc() {
 
 type dellRacadmConfig struct {
 	source     io.Reader
+	timeout    time.Duration
+	result     *ApplyResult
 	XMLName    xml.Name              `xml:"SystemConfiguration"`
 	Components []dellRacadmComponent `xml:"Component"`
 }
@@ -129,6 +143,12 @@ func (d *dellRacadmConfig) FixWanted(wanted map[string]string) map[string]string
 }
 
 func (d *dellRacadmConfig) Apply(_ map[string]Entry, trimmed map[string]string, dryRun bool) (needReboot bool, err error) {
+	return d.apply(trimmed, dryRun, true)
+}
+
+// apply imports trimmed as a server configuration profile.  If purge is
+// true, the BIOS jobs earlier runs left pending are deleted first.
+func (d *dellRacadmConfig) apply(trimmed map[string]string, dryRun, purge bool) (needReboot bool, err error) {
 	d.XMLName.Local = "SystemComponent"
 	tk := make([]string, 0, len(trimmed))
 	for k := range trimmed {
@@ -172,8 +192,12 @@ func (d *dellRacadmConfig) Apply(_ map[string]Entry, trimmed map[string]string,
 		return
 	}
 	queueRE := regexp.MustCompile(`racadm jobqueue view -i (JID_[[:digit:]]+)`)
-	cmd := exec.Command("/opt/dell/srvadmin/sbin/racadm", "set", "-f", "update.xml", "-t", "xml", "--preview")
-	buf, err := cmd.CombinedOutput()
+	if purge {
+		if err = d.clearStaleJobs(); err != nil {
+			return
+		}
+	}
+	buf, err := racadm("set", "-f", "update.xml", "-t", "xml", "--preview")
 	if err != nil {
 		err = fmt.Errorf("Racadm set preview failed: %s %v", string(buf), err)
 		return
@@ -184,29 +208,20 @@ func (d *dellRacadmConfig) Apply(_ map[string]Entry, trimmed map[string]string,
 		return
 	}
 	jid := string(matches[1])
-	for {
-		time.Sleep(time.Second)
-		cmd = exec.Command("/opt/dell/srvadmin/sbin/racadm", "jobqueue", "view", "-i", jid)
-		buf, err = cmd.CombinedOutput()
-		if err != nil {
-			err = fmt.Errorf("Racadm failed to view the jobqueue: %s %v", string(buf), err)
-			return
-		}
-		if !bytes.Contains(buf, []byte(`Status=Completed`)) {
-			continue
-		}
-		break
+	if d.result, err = d.track(jid); err != nil {
+		return
 	}
-	if bytes.Contains(buf, []byte(`SYS069: No changes were applied since the current component configuration matched the requested configuration.`)) {
+	if d.result.Status == "unchanged" {
 		log.Printf("Nothing needs to be updated\n")
 		return
 	}
-	if !bytes.Contains(buf, []byte(`SYS081: Successfully previewed Server Configuration Profile import operation.`)) {
-		err = fmt.Errorf("Requested system settings update will not succeed\n%s", string(buf))
+	if !strings.Contains(d.result.Message, "SYS081") {
+		d.result.Status = "failed"
+		d.result.Failures = d.failures(jid)
+		err = fmt.Errorf("Requested system settings update will not succeed: %s", d.result.Message)
 		return
 	}
-	cmd = exec.Command("/opt/dell/srvadmin/sbin/racadm", "set", "-f", "update.xml", "-t", "xml", "-b", "NoReboot")
-	buf, err = cmd.CombinedOutput()
+	buf, err = racadm("set", "-f", "update.xml", "-t", "xml", "-b", "NoReboot")
 	if err != nil {
 		err = fmt.Errorf("Racadm failed to update: %s %v", string(buf), err)
 		return
@@ -218,6 +233,26 @@ func (d *dellRacadmConfig) Apply(_ map[string]Entry, trimmed map[string]string,
 	}
 	jid = string(matches[1])
 	log.Printf("Job %s created for system settings application", jid)
-	needReboot = true
+	if purge {
+		if rerr := recordJob(jid); rerr != nil {
+			log.Printf("Unable to record job %s in %s: %v", jid, dellJobsFile, rerr)
+		}
+	}
+	d.result, err = d.track(jid)
+	needReboot = d.result != nil && d.result.Status == "pending-reboot"
 	return
 }
+
+// SetPassword changes BIOS.SysSecurity.SetupPassword through the same
+// server configuration profile import Apply uses, so that the passwords
+// never show up in the arguments to racadm.  It leaves pending jobs
+// alone, as one may hold settings an earlier apply is waiting to reboot
+// for, so it fails if the iDRAC will not take another job until then.
+func (d *dellRacadmConfig) SetPassword(old, new string, dryRun bool) (needReboot bool, err error) {
+	defer os.Remove("update.xml")
+	pw := map[string]string{"BIOS.Setup.1-1/NewSetupPassword": new}
+	if old != "" {
+		pw["BIOS.Setup.1-1/OldSetupPassword"] = old
+	}
+	return d.apply(pw, dryRun, false)
+}
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"bytes"
 	"encoding/xml"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"strings"
 )
 
 type hpConfigEnt struct {
@@ -53,14 +56,60 @@ func (h *hpConfig) Current() (res map[string]Entry, err error) {
 	}
 	for i := range cfg.Ents {
 		ent := cfg.Ents[i]
-		res[ent.Name] = Entry{
+		working := Entry{
 			Name:    ent.Name,
 			Current: ent.Value,
 		}
+		if hpSeqs[ent.Name] {
+			if working.Current, err = hpDecodeSeq(ent.Value); err != nil {
+				err = fmt.Errorf("Error decoding %s: %v", ent.Name, err)
+				return
+			}
+			working.Type = "Seq"
+			working.Checker.Seq.Valid = true
+		}
+		res[ent.Name] = working
 	}
 	return
 }
 
+// hpSeqs are the conrep sections that hold an ordered list of devices as
+// Index0, Index1, ... elements.
+var hpSeqs = map[string]bool{
+	"IPL_Order":       true,
+	"UEFI_Boot_Order": true,
+}
+
+// hpDecodeSeq turns the elements of a sequence section into a comma
+// separated list.
+func hpDecodeSeq(inner string) (string, error) {
+	seq := struct {
+		Members []struct {
+			Value string `xml:",chardata"`
+		} `xml:",any"`
+	}{}
+	if err := xml.Unmarshal([]byte("<Section>"+inner+"</Section>"), &seq); err != nil {
+		return "", err
+	}
+	vals := make([]string, len(seq.Members))
+	for i, m := range seq.Members {
+		vals[i] = strings.TrimSpace(m.Value)
+	}
+	return strings.Join(vals, ","), nil
+}
+
+// hpEncodeSeq turns a comma separated list back into the elements of a
+// sequence section.
+func hpEncodeSeq(val string) string {
+	buf := &bytes.Buffer{}
+	for i, m := range strings.Split(val, ",") {
+		fmt.Fprintf(buf, "<Index%d>", i)
+		xml.EscapeText(buf, []byte(strings.TrimSpace(m)))
+		fmt.Fprintf(buf, "</Index%d>", i)
+	}
+	return buf.String()
+}
+
 func (d *hpConfig) FixWanted(wanted map[string]string) map[string]string {
 	return wanted
 }
@@ -70,6 +119,9 @@ func (h *hpConfig) Apply(current map[string]Entry, trimmed map[string]string, dr
 		Ents: make([]hpConfigEnt, 0, len(trimmed)),
 	}
 	for k, v := range trimmed {
+		if current[k].Checker.Seq.Valid {
+			v = hpEncodeSeq(v)
+		}
 		toAdd.Ents = append(toAdd.Ents, hpConfigEnt{Name: k, Value: v})
 	}
 	var fi *os.File
@@ -99,3 +151,16 @@ func (h *hpConfig) Apply(current map[string]Entry, trimmed map[string]string, dr
 	needReboot = true
 	return
 }
+
+// SetPassword changes the admin password with conrep.  conrep.dat is
+// removed afterwards, even for a dry run, since it has the passwords in it.
+func (h *hpConfig) SetPassword(old, new string, dryRun bool) (needReboot bool, err error) {
+	defer os.Remove("conrep.dat")
+	buf := &bytes.Buffer{}
+	for _, pw := range []struct{ tag, val string }{{"NewPassword", new}, {"OldPassword", old}} {
+		fmt.Fprintf(buf, "<%s>", pw.tag)
+		xml.EscapeText(buf, []byte(pw.val))
+		fmt.Fprintf(buf, "</%s>", pw.tag)
+	}
+	return h.Apply(nil, map[string]string{"Admin_Password": buf.String()}, dryRun)
+}
@@ -55,10 +55,17 @@ func (l *lenovoConfig) Current() (res map[string]Entry, err error) {
 			// ugh, just no.  We have RAID config stuff for that
 			continue
 		}
-		res[parts[0]] = Entry{
+		ent := Entry{
 			Name:    parts[0],
 			Current: parts[1],
 		}
+		if strings.HasPrefix(parts[0], "BootOrder.") {
+			// Boot orders are lists of devices separated by =
+			ent.Type = "Seq"
+			ent.Checker.Seq.Valid = true
+			ent.Checker.Seq.Sep = "="
+		}
+		res[parts[0]] = ent
 	}
 	return
 }
@@ -96,3 +103,10 @@ func (l *lenovoConfig) Apply(current map[string]Entry, trimmed map[string]string
 	}
 	return
 }
+
+// SetPassword sets the UEFI admin password through the IMM, which does
+// not need the old one.
+func (l *lenovoConfig) SetPassword(_, new string, dryRun bool) (needReboot bool, err error) {
+	defer os.Remove("apply.dat")
+	return l.Apply(nil, map[string]string{"IMM.UefiAdminPassword": new}, dryRun)
+}
@@ -7,8 +7,69 @@ import (
 	"math/big"
 	"regexp"
 	"sort"
+	"strings"
 )
 
+// seqRest ends a wanted sequence that only says which members come first.
+// The rest of the members follow in their current order.
+const seqRest = "..."
+
+// seq splits a sequence value into its members.
+func (e *Entry) seq(val string) []string {
+	sep := e.Checker.Seq.Sep
+	if sep == "" {
+		sep = ","
+	}
+	res := []string{}
+	for _, m := range strings.Split(val, sep) {
+		if m = strings.TrimSpace(m); m != "" {
+			res = append(res, m)
+		}
+	}
+	return res
+}
+
+func (e *Entry) joinSeq(members []string) string {
+	sep := e.Checker.Seq.Sep
+	if sep == "" {
+		sep = ","
+	}
+	return strings.Join(members, sep)
+}
+
+// seqWanted expands a wanted sequence ending in seqRest to the full one,
+// using the pending value if there is one or the current one otherwise.
+func (e *Entry) seqWanted(val string) string {
+	wanted := e.seq(val)
+	if len(wanted) == 0 || wanted[len(wanted)-1] != seqRest {
+		return e.joinSeq(wanted)
+	}
+	wanted = wanted[:len(wanted)-1]
+	base := e.Current
+	if e.PendingValid {
+		base = e.Pending
+	}
+	seen := map[string]bool{}
+	for _, m := range wanted {
+		seen[m] = true
+	}
+	for _, m := range e.seq(base) {
+		if !seen[m] {
+			wanted = append(wanted, m)
+		}
+	}
+	return e.joinSeq(wanted)
+}
+
+// same is true if the setting would not change going from a to b.
+// Sequences are compared member by member, in order.
+func (e *Entry) same(a, b string) bool {
+	if !e.Checker.Seq.Valid {
+		return a == b
+	}
+	return e.joinSeq(e.seq(a)) == e.joinSeq(e.seq(b))
+}
+
 // Entry is what we expect a BIOS configuration setting to contain.
 type Entry struct {
 	Name         string
@@ -18,6 +79,7 @@ type Entry struct {
 	Current      string `json:",omitempty"`
 	Pending      string `json:",omitempty"`
 	Default      string `json:",omitempty"`
+	Alias        string `json:",omitempty"` // The native setting a canonical one stands for.
 	Checker      struct {
 		Int struct {
 			Valid bool     `json:",omitempty"`
@@ -36,11 +98,40 @@ type Entry struct {
 		} `json:",omitempty"`
 		Seq struct {
 			Valid bool `json:",omitempty"`
+			// Sep separates the members of the sequence, and defaults to ",".
+			Sep string `json:",omitempty"`
 		} `json:",omitempty"`
 	}
 }
 
 func (e *Entry) Valid(val string) error {
+	if e.Checker.Seq.Valid {
+		known := map[string]bool{}
+		for _, m := range e.seq(e.Current) {
+			known[m] = true
+		}
+		for _, m := range e.seq(e.Pending) {
+			known[m] = true
+		}
+		seen := map[string]bool{}
+		members := e.seq(val)
+		for i, m := range members {
+			if m == seqRest {
+				if i != len(members)-1 {
+					return fmt.Errorf("%s: %s can only end the sequence", e.Name, seqRest)
+				}
+				continue
+			}
+			if !known[m] {
+				return fmt.Errorf("%s: %s is not in the sequence %s", e.Name, m, e.Current)
+			}
+			if seen[m] {
+				return fmt.Errorf("%s: %s is in the sequence more than once", e.Name, m)
+			}
+			seen[m] = true
+		}
+		return nil
+	}
 	if e.Checker.Enum.Valid {
 		vals := e.Checker.Enum.Values
 		sort.Strings(vals)
@@ -53,7 +144,7 @@ func (e *Entry) Valid(val string) error {
 		min, max := e.Checker.String.MinLen, e.Checker.String.MaxLen
 		regex := e.Checker.String.Regex
 		vlen := big.NewInt(int64(len(val)))
-		if vlen.Cmp(min) == -1 || vlen.Cmp(max) == 1 {
+		if (min != nil && vlen.Cmp(min) == -1) || (max != nil && vlen.Cmp(max) == 1) {
 			return fmt.Errorf("%s: %s is not a valid string, it must be between %d and %d in length", e.Name, val, min, max)
 		}
 		if regex != `` {
@@ -68,18 +159,32 @@ func (e *Entry) Valid(val string) error {
 		return nil
 	}
 	if e.Checker.Int.Valid {
-		v, err := (&big.Int{}).SetString(val, 0)
-		if err {
+		v, ok := (&big.Int{}).SetString(val, 0)
+		if !ok {
 			return fmt.Errorf("%s: %s is not a number", e.Name, val)
 		}
-		if v.Cmp(e.Checker.Int.Min) == -1 || v.Cmp(e.Checker.Int.Max) == 1 {
-			return fmt.Errorf("%s: %d must be between %d and %d", e.Name, v, e.Checker.Int.Min, e.Checker.Int.Max)
+		min, max := e.Checker.Int.Min, e.Checker.Int.Max
+		if (min != nil && v.Cmp(min) == -1) || (max != nil && v.Cmp(max) == 1) {
+			return fmt.Errorf("%s: %d must be between %d and %d", e.Name, v, min, max)
 		}
 		return nil
 	}
 	return nil
 }
 
+// Export returns the current value of each setting in current.  Canonical
+// settings are left out, since their native ones are already there and
+// applying both would set the same thing twice.
+func Export(current map[string]Entry) map[string]string {
+	res := map[string]string{}
+	for k, v := range current {
+		if v.Alias == "" {
+			res[k] = v.Current
+		}
+	}
+	return res
+}
+
 func Test(c Configurator, wanted map[string]string) (map[string]Entry, map[string]string, error) {
 	current, err := c.Current()
 	if err != nil {
@@ -95,11 +200,19 @@ func Test(c Configurator, wanted map[string]string) (map[string]Entry, map[strin
 		if ent.ReadOnly {
 			continue
 		}
+		if ent.Checker.Seq.Valid {
+			// Partial sequences have to be checked before they are
+			// expanded to compare with the current one.
+			if err = ent.Valid(v); err != nil {
+				return current, nil, err
+			}
+			v = ent.seqWanted(v)
+		}
 		if ent.PendingValid {
-			if v == ent.Pending {
+			if ent.same(v, ent.Pending) {
 				continue
 			}
-		} else if v == ent.Current {
+		} else if ent.same(v, ent.Current) {
 			continue
 		}
 		if err = ent.Valid(v); err != nil {
@@ -124,3 +237,41 @@ type Configurator interface {
 	// Fix wanted
 	FixWanted(map[string]string) map[string]string
 }
+
+// ApplyResult is what a Configurator that tracks its changes through to
+// the end can say about how an Apply went.
+type ApplyResult struct {
+	// Status is one of applied, pending-reboot, unchanged, or failed.
+	Status string
+	// Job is the ID the tooling gave the changes, if any.
+	Job     string `json:",omitempty"`
+	Message string `json:",omitempty"`
+	// Failures maps the settings that could not be changed to why.
+	Failures map[string]string `json:",omitempty"`
+}
+
+// Resulter is implemented by Configurators that have an ApplyResult
+// after Apply.
+type Resulter interface {
+	Result() *ApplyResult
+}
+
+// Passwords is what the password operation reads from stdin.  An empty
+// Old sets a password where there was none, and an empty New clears it.
+type Passwords struct {
+	Old string `json:",omitempty"`
+	New string `json:",omitempty"`
+}
+
+// PasswordSetter is implemented by Configurators that can set, change,
+// or clear the BIOS setup password.
+type PasswordSetter interface {
+	SetPassword(old, new string, dryRun bool) (needReboot bool, err error)
+}
+
+// Authorizer is implemented by Configurators that need the current BIOS
+// setup password to change settings once one is set.  Only redfish does,
+// for HPE iLOs.
+type Authorizer interface {
+	Authorize(password string)
+}
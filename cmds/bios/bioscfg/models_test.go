@@ -0,0 +1,134 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSeqEntries(t *testing.T) {
+	dell := &Entry{Name: "BootSeq", Current: "NIC.Integrated.1-1-1, HardDisk.List.1-1,Optical.SATAEmbedded.J-1"}
+	dell.Checker.Seq.Valid = true
+	lenovo := &Entry{Name: "BootOrder.BootOrder", Current: "CD/DVD Rom=Hard Disk 0=Network=USB Storage"}
+	lenovo.Checker.Seq.Valid = true
+	lenovo.Checker.Seq.Sep = "="
+
+	if got, want := dell.seq(dell.Current), []string{"NIC.Integrated.1-1-1", "HardDisk.List.1-1", "Optical.SATAEmbedded.J-1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if got, want := lenovo.seq(lenovo.Current), []string{"CD/DVD Rom", "Hard Disk 0", "Network", "USB Storage"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	for _, tc := range []struct {
+		ent        *Entry
+		val, want  string
+		same, fail bool
+	}{
+		{ent: dell, val: "HardDisk.List.1-1,...", want: "HardDisk.List.1-1,NIC.Integrated.1-1-1,Optical.SATAEmbedded.J-1"},
+		{ent: dell, val: "NIC.Integrated.1-1-1,...", want: "NIC.Integrated.1-1-1,HardDisk.List.1-1,Optical.SATAEmbedded.J-1", same: true},
+		{ent: dell, val: "NIC.Integrated.1-1-1,HardDisk.List.1-1,Optical.SATAEmbedded.J-1", same: true,
+			want: "NIC.Integrated.1-1-1,HardDisk.List.1-1,Optical.SATAEmbedded.J-1"},
+		{ent: dell, val: "Optical.SATAEmbedded.J-1,HardDisk.List.1-1",
+			want: "Optical.SATAEmbedded.J-1,HardDisk.List.1-1"},
+		{ent: dell, val: "...,HardDisk.List.1-1", fail: true},
+		{ent: dell, val: "HardDisk.List.1-1,...,NIC.Integrated.1-1-1", fail: true},
+		{ent: dell, val: "HardDisk.List.1-1,HardDisk.List.1-1,...", fail: true},
+		{ent: dell, val: "Floppy.iDRACVirtual.1-1,...", fail: true},
+		{ent: lenovo, val: "Network=...", want: "Network=CD/DVD Rom=Hard Disk 0=USB Storage"},
+		{ent: lenovo, val: "CD/DVD Rom = Hard Disk 0 = Network = USB Storage", want: "CD/DVD Rom=Hard Disk 0=Network=USB Storage", same: true},
+		{ent: lenovo, val: "...=Network", fail: true},
+	} {
+		err := tc.ent.Valid(tc.val)
+		if tc.fail {
+			if err == nil {
+				t.Errorf("%s: expected %q to be invalid", tc.ent.Name, tc.val)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.ent.Name, err)
+			continue
+		}
+		got := tc.ent.seqWanted(tc.val)
+		if got != tc.want {
+			t.Errorf("%s: expected %q to expand to %q, got %q", tc.ent.Name, tc.val, tc.want, got)
+		}
+		if same := tc.ent.same(got, tc.ent.Current); same != tc.same {
+			t.Errorf("%s: expected same(%q) to be %v", tc.ent.Name, got, tc.same)
+		}
+	}
+
+	// A pending order is the one the rest is taken from.
+	pending := *dell
+	pending.Pending, pending.PendingValid = "Optical.SATAEmbedded.J-1,HardDisk.List.1-1,NIC.Integrated.1-1-1", true
+	if got, want := pending.seqWanted("HardDisk.List.1-1,..."), "HardDisk.List.1-1,Optical.SATAEmbedded.J-1,NIC.Integrated.1-1-1"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestTestSeqs(t *testing.T) {
+	for _, tc := range []struct {
+		driver, dump string
+		wanted, want map[string]string
+		fail         bool
+	}{
+		{driver: "dell", dump: "dell.xml",
+			wanted: map[string]string{
+				"BIOS.Setup.1-1/BootSeq":     "NIC.Integrated.1-1-1,...",
+				"BIOS.Setup.1-1/UefiBootSeq": "RAID.Integrated.1-1,...",
+			},
+			want: map[string]string{
+				"BIOS.Setup.1-1/UefiBootSeq": "RAID.Integrated.1-1,NIC.PxeDevice.1-1,Disk.SATAEmbedded.J-1",
+			}},
+		{driver: "dell", dump: "dell.xml",
+			wanted: map[string]string{"BIOS.Setup.1-1/UefiBootSeq": "...,RAID.Integrated.1-1"},
+			fail:   true},
+		{driver: "hp", dump: "hp.xml",
+			wanted: map[string]string{"IPL_Order": "Network,HardDisk,..."},
+			want:   map[string]string{"IPL_Order": "Network,HardDisk,CD-ROM,Floppy,USBKey"}},
+		{driver: "hp", dump: "hp.xml",
+			wanted: map[string]string{"IPL_Order": "CD-ROM, Floppy, USBKey, HardDisk, Network"},
+			want:   map[string]string{}},
+		{driver: "lenovo", dump: "lenovo.txt",
+			wanted: map[string]string{
+				"BootOrder.BootOrder":    "Network=Hard Disk 0=...",
+				"BootOrder.WolBootOrder": "Network=...",
+			},
+			want: map[string]string{"BootOrder.BootOrder": "Network=Hard Disk 0=CD/DVD Rom=USB Storage"}},
+		{driver: "lenovo", dump: "lenovo.txt",
+			wanted: map[string]string{"BootOrder.BootOrder": "Network=Floppy=..."},
+			fail:   true},
+	} {
+		_, got, err := Test(fromDump(t, tc.driver, tc.dump), tc.wanted)
+		if tc.fail {
+			if err == nil {
+				t.Errorf("%s: expected %v to be invalid", tc.driver, tc.wanted)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: expected %v, got %v %v", tc.driver, tc.want, got, err)
+		}
+	}
+}
+
+func TestHpSeqs(t *testing.T) {
+	cur, err := fromDump(t, "hp", "hp.xml").Current()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	ent := cur["IPL_Order"]
+	if ent.Current != "CD-ROM,Floppy,USBKey,HardDisk,Network" || !ent.Checker.Seq.Valid {
+		t.Errorf("Unexpected IPL_Order %+v", ent)
+	}
+	for _, val := range []string{"CD-ROM,Floppy,USBKey,HardDisk,Network", "Network", "Embedded LOM 1 Port 1 : HPE Ethernet 1Gb 4-port 331i Adapter - NIC (PXE IPv4)", "A&B,<C>"} {
+		enc := hpEncodeSeq(val)
+		if !strings.HasPrefix(enc, "<Index0>") {
+			t.Errorf("Unexpected encoding of %q: %s", val, enc)
+		}
+		if dec, err := hpDecodeSeq(enc); err != nil || dec != val {
+			t.Errorf("Expected %q to round trip, got %q %v", val, dec, err)
+		}
+	}
+}
@@ -0,0 +1,519 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/stmcginnis/gofish"
+)
+
+// redfishBios is the part of a Redfish Bios resource, or of its settings
+// object, that we care about.
+type redfishBios struct {
+	AttributeRegistry string                 `json:",omitempty"`
+	Attributes        map[string]interface{} `json:"Attributes"`
+	Settings          struct {
+		SettingsObject struct {
+			ID string `json:"@odata.id,omitempty"`
+		} `json:",omitempty"`
+		SupportedApplyTimes []string `json:",omitempty"`
+	} `json:"@Redfish.Settings,omitempty"`
+	Actions struct {
+		ChangePassword struct {
+			Target string `json:",omitempty"`
+		} `json:"#Bios.ChangePassword,omitempty"`
+	} `json:",omitempty"`
+}
+
+// redfishRegistryAttr describes a BIOS attribute in an AttributeRegistry.
+type redfishRegistryAttr struct {
+	AttributeName string
+	Type          string
+	ReadOnly      bool
+	Value         []struct {
+		ValueName string
+	}
+	LowerBound      *big.Int
+	UpperBound      *big.Int
+	MinLength       *big.Int
+	MaxLength       *big.Int
+	ValueExpression string
+	DefaultValue    interface{}
+}
+
+type redfishRegistry struct {
+	RegistryEntries struct {
+		Attributes []redfishRegistryAttr
+	}
+}
+
+// redfishDump is what the redfish driver reads with -source: the Bios
+// resource, and optionally its settings object and attribute registry.
+// A bare Bios resource is also accepted.
+type redfishDump struct {
+	Bios     *redfishBios
+	Settings *redfishBios     `json:",omitempty"`
+	Registry *redfishRegistry `json:",omitempty"`
+}
+
+// redfishConfig gets and sets BIOS settings through the Bios resource of
+// a Redfish service.  If no address is given, it uses the Redfish host
+// interface the BMC offers the OS.
+type redfishConfig struct {
+	source                              io.Reader
+	address, username, password, system string
+	client                              *gofish.APIClient
+	// settings is where changes are PATCHed, and applyTime is the
+	// @Redfish.SettingsApplyTime to ask for, if the service takes one.
+	settings, applyTime string
+	// changePassword is the target of the Bios.ChangePassword action, and
+	// passwordName the attribute it changes for the setup password.
+	changePassword, passwordName string
+	// biosPassword is the current setup password, if one is set.
+	biosPassword string
+}
+
+// hpeBiosAuth adds the header HPE iLOs need to change BIOS settings once
+// an admin password is set.  Other services do not get it.
+type hpeBiosAuth struct {
+	base  http.RoundTripper
+	token string
+}
+
+func (h *hpeBiosAuth) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("X-HPRESTFULAPI-AuthToken", h.token)
+	return h.base.RoundTrip(req)
+}
+
+func (r *redfishConfig) Authorize(password string) {
+	r.biosPassword = password
+}
+
+func (r *redfishConfig) Source(src io.Reader) {
+	r.source = src
+}
+
+// redfishHostInterface finds the address of the Redfish service in the
+// SMBIOS type 42 management controller host interface records.
+func redfishHostInterface() (string, error) {
+	paths, _ := filepath.Glob("/sys/firmware/dmi/entries/42-*/raw")
+	for _, p := range paths {
+		buf, err := ioutil.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		if addr := parseHostInterface(buf); addr != "" {
+			return addr, nil
+		}
+	}
+	return "", fmt.Errorf("No Redfish host interface found, use -redfish-address")
+}
+
+// parseHostInterface returns the host:port of the first Redfish over IP
+// protocol record in a raw SMBIOS type 42 structure.
+func parseHostInterface(buf []byte) string {
+	if len(buf) < 6 || buf[0] != 42 {
+		return ""
+	}
+	// Skip the header and the interface specific data.
+	i := 6 + int(buf[5])
+	if i >= len(buf) {
+		return ""
+	}
+	count := int(buf[i])
+	i++
+	for ; count > 0 && i+2 <= len(buf); count-- {
+		proto, n := buf[i], int(buf[i+1])
+		data := buf[i+2:]
+		i += 2 + n
+		if proto != 4 || n < 86 || len(data) < 86 {
+			continue
+		}
+		var ip net.IP
+		switch data[51] {
+		case 1:
+			ip = net.IP(data[52:56])
+		case 2:
+			ip = net.IP(data[52:68])
+		default:
+			continue
+		}
+		port := binary.LittleEndian.Uint16(data[84:86])
+		if port == 0 {
+			port = 443
+		}
+		return net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))
+	}
+	return ""
+}
+
+func (r *redfishConfig) connect() (err error) {
+	if r.client != nil {
+		return
+	}
+	addr := r.address
+	if addr == "" {
+		if addr, err = redfishHostInterface(); err != nil {
+			return
+		}
+	}
+	if !strings.Contains(addr, "://") {
+		addr = "https://" + addr
+	}
+	r.client, err = gofish.Connect(gofish.ClientConfig{
+		Endpoint:  strings.TrimSuffix(addr, "/"),
+		Username:  r.username,
+		Password:  r.password,
+		Insecure:  true,
+		BasicAuth: true,
+	})
+	if err != nil {
+		err = fmt.Errorf("Unable to connect to Redfish at %s: %v", addr, err)
+		return
+	}
+	if r.biosPassword != "" && r.isILO() {
+		sum := sha256.Sum256([]byte(r.biosPassword))
+		base := r.client.HTTPClient.Transport
+		if base == nil {
+			base = http.DefaultTransport
+		}
+		r.client.HTTPClient.Transport = &hpeBiosAuth{
+			base:  base,
+			token: strings.ToUpper(hex.EncodeToString(sum[:])),
+		}
+	}
+	return
+}
+
+// isILO is true if the service root says the service is an HPE iLO.
+func (r *redfishConfig) isILO() bool {
+	root := &struct {
+		Vendor string
+		Oem    map[string]json.RawMessage
+	}{}
+	if err := r.get("/redfish/v1/", root); err != nil {
+		log.Printf("Unable to tell who made the Redfish service: %v", err)
+		return false
+	}
+	if root.Vendor == "HPE" {
+		return true
+	}
+	for k := range root.Oem {
+		if k == "Hpe" || k == "Hp" {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *redfishConfig) get(uri string, target interface{}) error {
+	resp, err := r.client.Get(uri)
+	if err != nil {
+		return fmt.Errorf("Error getting %s: %v", uri, err)
+	}
+	defer resp.Body.Close()
+	if err = json.NewDecoder(resp.Body).Decode(target); err != nil {
+		return fmt.Errorf("Error decoding %s: %v", uri, err)
+	}
+	return nil
+}
+
+// registry finds the attribute registry the Bios resource refers to.
+func (r *redfishConfig) registry(name string) (*redfishRegistry, error) {
+	regs := &struct {
+		Members []struct {
+			ID string `json:"@odata.id"`
+		}
+	}{}
+	if err := r.get("/redfish/v1/Registries", regs); err != nil {
+		return nil, err
+	}
+	for _, m := range regs.Members {
+		base := path.Base(strings.TrimSuffix(m.ID, "/"))
+		if base != name && !strings.HasPrefix(name, base) {
+			continue
+		}
+		file := &struct {
+			Location []struct {
+				Language string
+				URI      string `json:"Uri"`
+			}
+		}{}
+		if err := r.get(m.ID, file); err != nil {
+			return nil, err
+		}
+		uri := ""
+		for _, loc := range file.Location {
+			if uri == "" || loc.Language == "en" {
+				uri = loc.URI
+			}
+		}
+		if uri == "" {
+			continue
+		}
+		res := &redfishRegistry{}
+		if err := r.get(uri, res); err != nil {
+			return nil, err
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("Attribute registry %s not found", name)
+}
+
+// fetch reads the Bios resource, its settings object, and its attribute
+// registry from the Redfish service.
+func (r *redfishConfig) fetch() (res *redfishDump, err error) {
+	if err = r.connect(); err != nil {
+		return
+	}
+	systems, err := r.client.Service.Systems()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get the systems from Redfish: %v", err)
+	}
+	sysURI := ""
+	for _, sys := range systems {
+		if r.system == "" || sys.ID == r.system {
+			sysURI = sys.ODataID
+			break
+		}
+	}
+	if sysURI == "" {
+		return nil, fmt.Errorf("No Redfish system %q", r.system)
+	}
+	sys := &struct {
+		Bios struct {
+			ID string `json:"@odata.id"`
+		}
+	}{}
+	if err = r.get(sysURI, sys); err != nil {
+		return
+	}
+	if sys.Bios.ID == "" {
+		return nil, fmt.Errorf("System %s has no Bios resource", sysURI)
+	}
+	res = &redfishDump{Bios: &redfishBios{}}
+	if err = r.get(sys.Bios.ID, res.Bios); err != nil {
+		return
+	}
+	r.settings = res.Bios.Settings.SettingsObject.ID
+	if r.settings == "" {
+		r.settings = sys.Bios.ID + "/Settings"
+	}
+	for _, at := range res.Bios.Settings.SupportedApplyTimes {
+		if at == "OnReset" {
+			r.applyTime = at
+		}
+	}
+	r.changePassword = res.Bios.Actions.ChangePassword.Target
+	res.Settings = &redfishBios{}
+	if err = r.get(r.settings, res.Settings); err != nil {
+		log.Printf("No pending settings: %v", err)
+		res.Settings, err = nil, nil
+	}
+	if res.Bios.AttributeRegistry != "" {
+		if res.Registry, err = r.registry(res.Bios.AttributeRegistry); err != nil {
+			log.Printf("Settings will not be checked: %v", err)
+			err = nil
+		}
+	}
+	r.passwordName = "AdministratorPassword"
+	if res.Registry != nil {
+		for _, attr := range res.Registry.RegistryEntries.Attributes {
+			name := strings.ToLower(attr.AttributeName)
+			if attr.Type == "Password" && (strings.Contains(name, "admin") || strings.Contains(name, "setup")) {
+				r.passwordName = attr.AttributeName
+				break
+			}
+		}
+	}
+	return
+}
+
+// redfishString turns a Redfish attribute value into the string we
+// compare wanted values with.
+func redfishString(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case bool:
+		return strconv.FormatBool(val)
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	default:
+		return fmt.Sprintf("%v", val)
+	}
+}
+
+// redfishType guesses the registry type of an attribute from its value,
+// for services without an attribute registry.
+func redfishType(v interface{}) string {
+	switch v.(type) {
+	case bool:
+		return "Boolean"
+	case float64:
+		return "Integer"
+	default:
+		return "String"
+	}
+}
+
+func (r *redfishConfig) Current() (res map[string]Entry, err error) {
+	res = map[string]Entry{}
+	var dump *redfishDump
+	if r.source == nil {
+		if dump, err = r.fetch(); err != nil {
+			return
+		}
+	} else {
+		var buf []byte
+		if buf, err = ioutil.ReadAll(r.source); err != nil {
+			return
+		}
+		dump = &redfishDump{}
+		if err = json.Unmarshal(buf, dump); err == nil && dump.Bios == nil {
+			dump.Bios = &redfishBios{}
+			err = json.Unmarshal(buf, dump.Bios)
+		}
+		if err != nil {
+			return
+		}
+	}
+	for k, v := range dump.Bios.Attributes {
+		res[k] = Entry{Name: k, Type: redfishType(v), Current: redfishString(v)}
+	}
+	if dump.Registry != nil {
+		for _, attr := range dump.Registry.RegistryEntries.Attributes {
+			ent, ok := res[attr.AttributeName]
+			if !ok {
+				continue
+			}
+			ent.Type = attr.Type
+			ent.ReadOnly = attr.ReadOnly
+			if attr.DefaultValue != nil {
+				ent.Default = redfishString(attr.DefaultValue)
+			}
+			switch attr.Type {
+			case "Enumeration":
+				ent.Checker.Enum.Valid = true
+				for _, val := range attr.Value {
+					ent.Checker.Enum.Values = append(ent.Checker.Enum.Values, val.ValueName)
+				}
+			case "Boolean":
+				ent.Checker.Enum.Valid = true
+				ent.Checker.Enum.Values = []string{"false", "true"}
+			case "Integer":
+				ent.Checker.Int.Valid = true
+				ent.Checker.Int.Min = attr.LowerBound
+				ent.Checker.Int.Max = attr.UpperBound
+			case "String":
+				ent.Checker.String.Valid = true
+				ent.Checker.String.MinLen = attr.MinLength
+				ent.Checker.String.MaxLen = attr.MaxLength
+				ent.Checker.String.Regex = attr.ValueExpression
+			}
+			res[attr.AttributeName] = ent
+		}
+	}
+	if dump.Settings != nil {
+		for k, v := range dump.Settings.Attributes {
+			ent, ok := res[k]
+			if !ok || redfishString(v) == ent.Current {
+				continue
+			}
+			ent.Pending = redfishString(v)
+			ent.PendingValid = true
+			res[k] = ent
+		}
+	}
+	return
+}
+
+func (r *redfishConfig) FixWanted(wanted map[string]string) map[string]string {
+	return wanted
+}
+
+// redfishValue turns a wanted value back into the JSON type the
+// attribute takes.
+func redfishValue(ent Entry, v string) interface{} {
+	switch ent.Type {
+	case "Integer":
+		if n, err := strconv.ParseInt(v, 0, 64); err == nil {
+			return n
+		}
+	case "Boolean":
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return v
+}
+
+func (r *redfishConfig) Apply(current map[string]Entry, trimmed map[string]string, dryRun bool) (needReboot bool, err error) {
+	attrs := map[string]interface{}{}
+	for k, v := range trimmed {
+		attrs[k] = redfishValue(current[k], v)
+	}
+	if dryRun {
+		return
+	}
+	if r.settings == "" {
+		if _, err = r.fetch(); err != nil {
+			return
+		}
+	}
+	payload := map[string]interface{}{"Attributes": attrs}
+	if r.applyTime != "" {
+		payload["@Redfish.SettingsApplyTime"] = map[string]string{"ApplyTime": r.applyTime}
+	}
+	resp, err := r.client.Patch(r.settings, payload)
+	if err != nil {
+		err = fmt.Errorf("Error updating %s: %v", r.settings, err)
+		return
+	}
+	resp.Body.Close()
+	needReboot = true
+	return
+}
+
+// SetPassword changes the setup password with the Bios.ChangePassword
+// action.
+func (r *redfishConfig) SetPassword(old, new string, dryRun bool) (needReboot bool, err error) {
+	if dryRun {
+		return
+	}
+	if _, err = r.fetch(); err != nil {
+		return
+	}
+	if r.changePassword == "" {
+		err = fmt.Errorf("The Bios resource has no ChangePassword action")
+		return
+	}
+	resp, err := r.client.Post(r.changePassword, map[string]string{
+		"PasswordName": r.passwordName,
+		"OldPassword":  old,
+		"NewPassword":  new,
+	})
+	if err != nil {
+		err = fmt.Errorf("Error changing %s: %v", r.passwordName, err)
+		return
+	}
+	resp.Body.Close()
+	needReboot = true
+	return
+}
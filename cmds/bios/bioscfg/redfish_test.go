@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// hostInterface builds a raw SMBIOS type 42 structure with one protocol
+// record per entry in protos, each with the Redfish service at ip:port.
+func hostInterface(ip []byte, port uint16, protos ...byte) []byte {
+	buf := []byte{42, 0, 0x2a, 0x00, 0x40, 3, 1, 2, 3}
+	buf = append(buf, byte(len(protos)))
+	for _, proto := range protos {
+		data := make([]byte, 91)
+		data[51] = 1
+		if len(ip) == 16 {
+			data[51] = 2
+		}
+		copy(data[52:], ip)
+		binary.LittleEndian.PutUint16(data[84:86], port)
+		buf = append(buf, proto, byte(len(data)))
+		buf = append(buf, data...)
+	}
+	buf[1] = byte(len(buf))
+	return buf
+}
+
+func TestParseHostInterface(t *testing.T) {
+	v6 := []byte{0xfe, 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}
+	for _, tc := range []struct {
+		buf  []byte
+		want string
+	}{
+		{hostInterface([]byte{169, 254, 95, 118}, 0, 4), "169.254.95.118:443"},
+		{hostInterface([]byte{169, 254, 0, 1}, 8443, 2, 4), "169.254.0.1:8443"},
+		{hostInterface(v6, 443, 4), "[fe80::1]:443"},
+		{hostInterface([]byte{169, 254, 0, 1}, 443, 2), ""},
+		{hostInterface([]byte{169, 254, 0, 1}, 443, 4)[:60], ""},
+		{[]byte{41, 6, 0, 0, 0x40, 0}, ""},
+		{nil, ""},
+	} {
+		if got := parseHostInterface(tc.buf); got != tc.want {
+			t.Errorf("Expected %q, got %q", tc.want, got)
+		}
+	}
+}
+
+func TestRedfishCurrent(t *testing.T) {
+	cur, err := fromDump(t, "redfish", "redfish.json").Current()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(cur) != 8 {
+		t.Errorf("Expected 8 settings, got %d", len(cur))
+	}
+	ent := cur["BootMode"]
+	if ent.Type != "Enumeration" || ent.Current != "Uefi" || ent.Default != "Uefi" || ent.PendingValid ||
+		!reflect.DeepEqual(ent.Checker.Enum.Values, []string{"Uefi", "LegacyBios"}) {
+		t.Errorf("Unexpected BootMode %+v", ent)
+	}
+	if ent := cur["Sriov"]; !ent.PendingValid || ent.Pending != "Enabled" || ent.Current != "Disabled" {
+		t.Errorf("Expected Sriov to be pending Enabled, got %+v", ent)
+	}
+	ent = cur["ProcCoreDisable"]
+	if ent.Type != "Integer" || ent.Current != "0" || !ent.Checker.Int.Valid || ent.Checker.Int.Max.Int64() != 27 {
+		t.Errorf("Unexpected ProcCoreDisable %+v", ent)
+	}
+	if ent.Valid("28") == nil || ent.Valid("4") != nil {
+		t.Errorf("Expected ProcCoreDisable to be checked against its bounds")
+	}
+	ent = cur["ServerAssetTag"]
+	if !ent.Checker.String.Valid || ent.Valid("rack 4") != nil || ent.Valid("rack_4") == nil {
+		t.Errorf("Expected ServerAssetTag to be checked against its expression, got %+v", ent)
+	}
+	if ent := cur["TpmVisibility"]; ent.Current != "true" || ent.Valid("false") != nil || ent.Valid("no") == nil {
+		t.Errorf("Unexpected TpmVisibility %+v", ent)
+	}
+	if !cur["SerialNumber"].ReadOnly {
+		t.Errorf("Expected SerialNumber to be read only")
+	}
+	if ent := cur["AssetTagProtection"]; ent.Type != "String" || ent.Checker.String.Valid || ent.Checker.Enum.Valid {
+		t.Errorf("Expected an attribute missing from the registry to be unchecked, got %+v", ent)
+	}
+
+	// A bare Bios resource works too, without checks.
+	buf, err := ioutil.ReadFile(filepath.Join("test-data", "redfish.json"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	dump := &redfishDump{}
+	if err := json.Unmarshal(buf, dump); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if buf, err = json.Marshal(dump.Bios); err != nil {
+		t.Fatalf("%v", err)
+	}
+	r := &redfishConfig{}
+	r.Source(bytes.NewReader(buf))
+	if cur, err = r.Current(); err != nil || len(cur) != 8 || cur["ProcCoreDisable"].Type != "Integer" ||
+		cur["TpmVisibility"].Type != "Boolean" || cur["BootMode"].Checker.Enum.Valid {
+		t.Errorf("Unexpected settings from a bare Bios resource %+v %v", cur, err)
+	}
+}
+
+func TestRedfishValue(t *testing.T) {
+	for _, tc := range []struct {
+		typ, val string
+		want     interface{}
+	}{
+		{"Integer", "4", int64(4)},
+		{"Integer", "0x10", int64(16)},
+		{"Integer", "four", "four"},
+		{"Boolean", "true", true},
+		{"Boolean", "false", false},
+		{"Boolean", "maybe", "maybe"},
+		{"Enumeration", "1", "1"},
+		{"String", "true", "true"},
+		{"", "4", "4"},
+	} {
+		if got := redfishValue(Entry{Type: tc.typ}, tc.val); got != tc.want {
+			t.Errorf("%s %q: expected %#v, got %#v", tc.typ, tc.val, tc.want, got)
+		}
+	}
+}
+
+// redfishServer serves the resources in test-data/redfish.json, and
+// records the PATCHes and POSTs made to it.
+type redfishServer struct {
+	sync.Mutex
+	resources map[string]interface{}
+	requests  []*http.Request
+	bodies    []map[string]interface{}
+}
+
+func newRedfishServer(t *testing.T, root map[string]interface{}) (*httptest.Server, *redfishServer) {
+	t.Helper()
+	buf, err := ioutil.ReadFile(filepath.Join("test-data", "redfish.json"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	dump := map[string]interface{}{}
+	if err := json.Unmarshal(buf, &dump); err != nil {
+		t.Fatalf("%v", err)
+	}
+	root["@odata.id"] = "/redfish/v1/"
+	root["Systems"] = map[string]string{"@odata.id": "/redfish/v1/Systems"}
+	rs := &redfishServer{resources: map[string]interface{}{
+		"/redfish/v1/": root,
+		"/redfish/v1/Systems": map[string]interface{}{
+			"Members":             []interface{}{map[string]string{"@odata.id": "/redfish/v1/Systems/1"}},
+			"Members@odata.count": 1,
+		},
+		"/redfish/v1/Systems/1": map[string]interface{}{
+			"@odata.id": "/redfish/v1/Systems/1",
+			"Id":        "1",
+			"Bios":      map[string]string{"@odata.id": "/redfish/v1/Systems/1/Bios"},
+		},
+		"/redfish/v1/Systems/1/Bios":          dump["Bios"],
+		"/redfish/v1/Systems/1/Bios/Settings": dump["Settings"],
+		"/redfish/v1/Registries": map[string]interface{}{
+			"Members": []interface{}{map[string]string{"@odata.id": "/redfish/v1/Registries/BiosAttributeRegistryU32"}},
+		},
+		"/redfish/v1/Registries/BiosAttributeRegistryU32": map[string]interface{}{
+			"Location": []interface{}{
+				map[string]string{"Language": "ja", "Uri": "/redfish/v1/registrystore/bios/ja"},
+				map[string]string{"Language": "en", "Uri": "/redfish/v1/registrystore/bios/en"},
+			},
+		},
+		"/redfish/v1/registrystore/bios/en": dump["Registry"],
+	}}
+	srv := httptest.NewTLSServer(rs)
+	t.Cleanup(srv.Close)
+	return srv, rs
+}
+
+func (rs *redfishServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	rs.Lock()
+	defer rs.Unlock()
+	rs.requests = append(rs.requests, req)
+	if req.Method != http.MethodGet {
+		body := map[string]interface{}{}
+		json.NewDecoder(req.Body).Decode(&body)
+		rs.bodies = append(rs.bodies, body)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	res, ok := rs.resources[req.URL.Path]
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func TestRedfishApply(t *testing.T) {
+	srv, rs := newRedfishServer(t, map[string]interface{}{"Vendor": "Dell"})
+	r := &redfishConfig{address: srv.URL, username: "root", password: "calvin"}
+	cur, trimmed, err := Test(r, map[string]string{
+		"BootMode":        "LegacyBios",
+		"ProcCoreDisable": "4",
+		"TpmVisibility":   "false",
+		"Sriov":           "Enabled",
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(trimmed) != 3 {
+		t.Errorf("Expected the pending Sriov to be left alone, got %v", trimmed)
+	}
+	needReboot, err := r.Apply(cur, trimmed, false)
+	if err != nil || !needReboot {
+		t.Fatalf("Expected a reboot to be needed, got %v %v", needReboot, err)
+	}
+	if len(rs.bodies) != 1 {
+		t.Fatalf("Expected one PATCH, got %d", len(rs.bodies))
+	}
+	req := rs.requests[len(rs.requests)-1]
+	if req.Method != http.MethodPatch || req.URL.Path != "/redfish/v1/Systems/1/Bios/Settings" {
+		t.Errorf("Unexpected %s %s", req.Method, req.URL.Path)
+	}
+	if user, pass, ok := req.BasicAuth(); !ok || user != "root" || pass != "calvin" {
+		t.Errorf("Expected basic auth as root")
+	}
+	want := map[string]interface{}{
+		"Attributes": map[string]interface{}{
+			"BootMode":        "LegacyBios",
+			"ProcCoreDisable": float64(4),
+			"TpmVisibility":   false,
+		},
+		"@Redfish.SettingsApplyTime": map[string]interface{}{"ApplyTime": "OnReset"},
+	}
+	if !reflect.DeepEqual(rs.bodies[0], want) {
+		t.Errorf("Expected %v, got %v", want, rs.bodies[0])
+	}
+
+	needReboot, err = r.SetPassword("old", "new", false)
+	if err != nil || !needReboot {
+		t.Fatalf("Expected a reboot to be needed, got %v %v", needReboot, err)
+	}
+	req = rs.requests[len(rs.requests)-1]
+	if req.Method != http.MethodPost || req.URL.Path != "/redfish/v1/Systems/1/Bios/Actions/Bios.ChangePassword" {
+		t.Errorf("Unexpected %s %s", req.Method, req.URL.Path)
+	}
+	want = map[string]interface{}{"PasswordName": "AdminPassword", "OldPassword": "old", "NewPassword": "new"}
+	if !reflect.DeepEqual(rs.bodies[1], want) {
+		t.Errorf("Expected %v, got %v", want, rs.bodies[1])
+	}
+
+	r = &redfishConfig{address: srv.URL, username: "root", password: "calvin", system: "2"}
+	if _, err := r.Current(); err == nil {
+		t.Errorf("Expected a missing system to be an error")
+	}
+}
+
+func TestRedfishAuthorize(t *testing.T) {
+	sum := sha256.Sum256([]byte("setup"))
+	token := strings.ToUpper(hex.EncodeToString(sum[:]))
+	for _, tc := range []struct {
+		root map[string]interface{}
+		want string
+	}{
+		{map[string]interface{}{"Vendor": "HPE"}, token},
+		{map[string]interface{}{"Oem": map[string]interface{}{"Hpe": map[string]interface{}{}}}, token},
+		{map[string]interface{}{"Vendor": "Dell", "Oem": map[string]interface{}{"Dell": map[string]interface{}{}}}, ""},
+		{map[string]interface{}{}, ""},
+	} {
+		srv, rs := newRedfishServer(t, tc.root)
+		r := &redfishConfig{address: srv.URL, username: "root", password: "calvin"}
+		r.Authorize("setup")
+		cur, err := r.Current()
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if _, err := r.Apply(cur, map[string]string{"BootMode": "LegacyBios"}, false); err != nil {
+			t.Fatalf("%v", err)
+		}
+		req := rs.requests[len(rs.requests)-1]
+		if got := req.Header.Get("X-HPRESTFULAPI-AuthToken"); req.Method != http.MethodPatch || got != tc.want {
+			t.Errorf("%v: expected the auth token %q on the PATCH, got %q", tc.root, tc.want, got)
+		}
+	}
+}
@@ -627,3 +627,21 @@ func (s *superMicroConfig) Apply(current map[string]Entry, trimmed map[string]st
 	needReboot, err = s.applyBmc(bmcCurrent, bmcTrimmed, dryRun)
 	return
 }
+
+// SetPassword sets the Administrator Password setting with SUM, which does
+// not need the old one.
+func (s *superMicroConfig) SetPassword(_, new string, dryRun bool) (needReboot bool, err error) {
+	current, err := s.Current()
+	if err != nil {
+		return
+	}
+	for k, ent := range current {
+		if ent.Type != "Password" || !strings.HasSuffix(k, "::Administrator Password") {
+			continue
+		}
+		defer os.Remove("updateBios.xml")
+		return s.applyBios(map[string]Entry{k: ent}, map[string]string{k: new}, dryRun)
+	}
+	err = fmt.Errorf("No Administrator Password setting found")
+	return
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/digitalrebar/logger"
+	"github.com/digitalrebar/provision/v4/models"
+	"github.com/pborman/uuid"
+)
+
+/*
+ * Intel AMT (vPro) driver.
+ *
+ * AMT speaks WS-Management (SOAP over HTTP) on port 16992, or 16993 for TLS.
+ * All requests are POSTed to /wsman and authenticated with HTTP digest auth.
+ *
+ * Power control goes through CIM_PowerManagementService.RequestPowerStateChange.
+ * Boot overrides are always one-time in AMT and take three steps:
+ *   1. Put AMT_BootSettingData to turn IDE-R on or off.
+ *   2. CIM_BootConfigSetting.ChangeBootOrder to pick the boot source.
+ *   3. CIM_BootService.SetBootConfigRole to make that config apply on next boot.
+ */
+
+const (
+	wsmanAnonymous   = "http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous"
+	wsmanGetAction   = "http://schemas.xmlsoap.org/ws/2004/09/transfer/Get"
+	wsmanPutAction   = "http://schemas.xmlsoap.org/ws/2004/09/transfer/Put"
+	wsmanCimSchema   = "http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/"
+	wsmanAmtSchema   = "http://intel.com/wbem/wscim/1/amt-schema/1/"
+	amtBootConfig    = "Intel(r) AMT: Boot Configuration 0"
+	amtBootSettings  = "Intel(r) AMT:BootSettingData 0"
+	amtBootService   = "Intel(r) AMT Boot Service"
+	amtPowerService  = "Intel(r) AMT Power Management Service"
+	amtBootSourcePxe = "Intel(r) AMT: Force PXE Boot"
+	amtBootSourceHdd = "Intel(r) AMT: Force Hard-drive Boot"
+	amtBootSourceCd  = "Intel(r) AMT: Force CD/DVD Boot"
+)
+
+// CIM power states as used by AMT
+const (
+	amtPowerOn        = 2
+	amtPowerSleep     = 4
+	amtPowerCycle     = 5
+	amtPowerHibernate = 7
+	amtPowerOffHard   = 8
+)
+
+// IDERBootDevice values
+const (
+	amtIderFloppy = 0
+	amtIderCd     = 1
+)
+
+const wsmanEnvelope = `<?xml version="1.0" encoding="UTF-8"?>
+<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd">
+<s:Header>
+<a:Action s:mustUnderstand="true">%s</a:Action>
+<a:To s:mustUnderstand="true">/wsman</a:To>
+<w:ResourceURI s:mustUnderstand="true">%s</w:ResourceURI>
+<a:MessageID>uuid:%s</a:MessageID>
+<a:ReplyTo><a:Address>` + wsmanAnonymous + `</a:Address></a:ReplyTo>
+<w:OperationTimeout>PT60S</w:OperationTimeout>
+%s
+</s:Header>
+<s:Body>%s</s:Body>
+</s:Envelope>`
+
+type amt struct {
+	client                  *http.Client
+	url, username, password string
+
+	// Digest auth state from the last challenge
+	realm, nonce, opaque, qop string
+	nc                        int
+}
+
+// wsmanFault is the part of a SOAP fault we care about.
+type wsmanFault struct {
+	Code   string `xml:"Body>Fault>Code>Subcode>Value"`
+	Reason string `xml:"Body>Fault>Reason>Text"`
+	Detail string `xml:"Body>Fault>Detail"`
+}
+
+// wsmanReturn pulls ReturnValue out of any <Method>_OUTPUT body.
+type wsmanReturn struct {
+	Body struct {
+		Output struct {
+			XMLName     xml.Name
+			ReturnValue int `xml:"ReturnValue"`
+		} `xml:",any"`
+	} `xml:"Body"`
+}
+
+type amtPowerStatus struct {
+	PowerState int `xml:"Body>CIM_AssociatedPowerManagementService>PowerState"`
+}
+
+// AMT_BootSettingData has to be sent back whole on a Put.
+type amtBootSettingData struct {
+	XMLName                xml.Name
+	BIOSPause              bool   `xml:"BIOSPause"`
+	BIOSSetup              bool   `xml:"BIOSSetup"`
+	BootMediaIndex         int    `xml:"BootMediaIndex"`
+	ConfigurationDataReset bool   `xml:"ConfigurationDataReset"`
+	ElementName            string `xml:"ElementName"`
+	EnforceSecureBoot      bool   `xml:"EnforceSecureBoot"`
+	FirmwareVerbosity      int    `xml:"FirmwareVerbosity"`
+	ForcedProgressEvents   bool   `xml:"ForcedProgressEvents"`
+	IDERBootDevice         int    `xml:"IDERBootDevice"`
+	InstanceID             string `xml:"InstanceID"`
+	LockKeyboard           bool   `xml:"LockKeyboard"`
+	LockPowerButton        bool   `xml:"LockPowerButton"`
+	LockResetButton        bool   `xml:"LockResetButton"`
+	LockSleepButton        bool   `xml:"LockSleepButton"`
+	OwningEntity           string `xml:"OwningEntity"`
+	ReflashBIOS            bool   `xml:"ReflashBIOS"`
+	SecureErase            bool   `xml:"SecureErase"`
+	UseIDER                bool   `xml:"UseIDER"`
+	UseSOL                 bool   `xml:"UseSOL"`
+	UseSafeMode            bool   `xml:"UseSafeMode"`
+	UserPasswordBypass     bool   `xml:"UserPasswordBypass"`
+}
+
+type amtBootSettingResponse struct {
+	Data amtBootSettingData `xml:"Body>AMT_BootSettingData"`
+}
+
+func (a *amt) Name() string { return "amt" }
+
+func md5hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseChallenge records the fields of a Digest WWW-Authenticate header.
+func (a *amt) parseChallenge(hdr string) error {
+	if !strings.HasPrefix(hdr, "Digest ") {
+		return fmt.Errorf("Unsupported auth challenge: %s", hdr)
+	}
+	a.realm, a.nonce, a.opaque, a.qop = "", "", "", ""
+	a.nc = 0
+	for _, part := range strings.Split(strings.TrimPrefix(hdr, "Digest "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		val := strings.Trim(kv[1], `"`)
+		switch kv[0] {
+		case "realm":
+			a.realm = val
+		case "nonce":
+			a.nonce = val
+		case "opaque":
+			a.opaque = val
+		case "qop":
+			// We only do auth, not auth-int
+			for _, q := range strings.Split(val, ",") {
+				if strings.TrimSpace(q) == "auth" {
+					a.qop = "auth"
+				}
+			}
+		}
+	}
+	if a.nonce == "" {
+		return fmt.Errorf("Auth challenge missing nonce: %s", hdr)
+	}
+	return nil
+}
+
+func (a *amt) authorization(method, uri string) string {
+	a.nc++
+	ha1 := md5hex(a.username + ":" + a.realm + ":" + a.password)
+	ha2 := md5hex(method + ":" + uri)
+	hdr := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s"`,
+		a.username, a.realm, a.nonce, uri)
+	if a.qop == "" {
+		hdr += fmt.Sprintf(`, response="%s"`, md5hex(ha1+":"+a.nonce+":"+ha2))
+	} else {
+		cb := make([]byte, 8)
+		rand.Read(cb)
+		cnonce := hex.EncodeToString(cb)
+		nc := fmt.Sprintf("%08x", a.nc)
+		resp := md5hex(strings.Join([]string{ha1, a.nonce, nc, cnonce, a.qop, ha2}, ":"))
+		hdr += fmt.Sprintf(`, qop=%s, nc=%s, cnonce="%s", response="%s"`, a.qop, nc, cnonce, resp)
+	}
+	if a.opaque != "" {
+		hdr += fmt.Sprintf(`, opaque="%s"`, a.opaque)
+	}
+	return hdr
+}
+
+// post sends a SOAP envelope to /wsman, answering a digest challenge if needed.
+func (a *amt) post(body []byte) ([]byte, error) {
+	for try := 0; try < 2; try++ {
+		req, err := http.NewRequest("POST", a.url+"/wsman", bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/soap+xml; charset=utf-8")
+		if a.nonce != "" {
+			req.Header.Set("Authorization", a.authorization("POST", "/wsman"))
+		}
+		resp, err := a.client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("Request failed: %v", err)
+		}
+		rdata, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read body of response: %v", err)
+		}
+		if resp.StatusCode == http.StatusUnauthorized && try == 0 {
+			if cerr := a.parseChallenge(resp.Header.Get("WWW-Authenticate")); cerr != nil {
+				return nil, cerr
+			}
+			continue
+		}
+		if resp.StatusCode == http.StatusUnauthorized {
+			return nil, fmt.Errorf("Authentication failed for %s", a.username)
+		}
+		fault := &wsmanFault{}
+		if xml.Unmarshal(rdata, fault) == nil && (fault.Code != "" || fault.Reason != "") {
+			return nil, fmt.Errorf("WS-Man fault %s: %s %s", fault.Code, fault.Reason, strings.TrimSpace(fault.Detail))
+		}
+		if resp.StatusCode >= 400 {
+			return nil, fmt.Errorf("wsman return %d: %s", resp.StatusCode, string(rdata))
+		}
+		return rdata, nil
+	}
+	return nil, fmt.Errorf("Authentication failed for %s", a.username)
+}
+
+func wsmanSelectors(selectors ...string) string {
+	if len(selectors) == 0 {
+		return ""
+	}
+	buf := &bytes.Buffer{}
+	buf.WriteString("<w:SelectorSet>")
+	for i := 0; i+1 < len(selectors); i += 2 {
+		buf.WriteString(`<w:Selector Name="`)
+		xml.EscapeText(buf, []byte(selectors[i]))
+		buf.WriteString(`">`)
+		xml.EscapeText(buf, []byte(selectors[i+1]))
+		buf.WriteString("</w:Selector>")
+	}
+	buf.WriteString("</w:SelectorSet>")
+	return buf.String()
+}
+
+// wsmanReference renders an endpoint reference to a CIM instance.
+func wsmanReference(resourceURI string, selectors ...string) string {
+	return fmt.Sprintf(`<a:Address>%s</a:Address><a:ReferenceParameters><w:ResourceURI>%s</w:ResourceURI>%s</a:ReferenceParameters>`,
+		wsmanAnonymous, resourceURI, wsmanSelectors(selectors...))
+}
+
+func (a *amt) call(action, resourceURI, selectors, body string) ([]byte, error) {
+	msg := fmt.Sprintf(wsmanEnvelope, action, resourceURI, uuid.New(), selectors, body)
+	return a.post([]byte(msg))
+}
+
+// invoke runs a CIM method and checks its ReturnValue.
+func (a *amt) invoke(resourceURI, method, selectors, input string) error {
+	rdata, err := a.call(resourceURI+"/"+method, resourceURI, selectors, input)
+	if err != nil {
+		return err
+	}
+	ret := &wsmanReturn{}
+	if err := xml.Unmarshal(rdata, ret); err != nil {
+		return fmt.Errorf("failed to parse %s response: %v", method, err)
+	}
+	if ret.Body.Output.XMLName.Local != method+"_OUTPUT" {
+		return fmt.Errorf("unexpected %s response: %s", method, ret.Body.Output.XMLName.Local)
+	}
+	if ret.Body.Output.ReturnValue != 0 {
+		return fmt.Errorf("%s returned %d", method, ret.Body.Output.ReturnValue)
+	}
+	return nil
+}
+
+func (a *amt) powerState() (int, error) {
+	rdata, err := a.call(wsmanGetAction, wsmanCimSchema+"CIM_AssociatedPowerManagementService", "", "")
+	if err != nil {
+		return 0, err
+	}
+	ps := &amtPowerStatus{}
+	if err := xml.Unmarshal(rdata, ps); err != nil {
+		return 0, fmt.Errorf("failed to parse power state: %v", err)
+	}
+	return ps.PowerState, nil
+}
+
+func (a *amt) setPowerState(state int) error {
+	uri := wsmanCimSchema + "CIM_PowerManagementService"
+	input := fmt.Sprintf(`<h:RequestPowerStateChange_INPUT xmlns:h="%s"><h:PowerState>%d</h:PowerState><h:ManagedElement>%s</h:ManagedElement></h:RequestPowerStateChange_INPUT>`,
+		uri, state,
+		wsmanReference(wsmanCimSchema+"CIM_ComputerSystem",
+			"CreationClassName", "CIM_ComputerSystem",
+			"Name", "ManagedSystem"))
+	return a.invoke(uri, "RequestPowerStateChange", wsmanSelectors("Name", amtPowerService), input)
+}
+
+// setIDER turns IDE-R boot on or off in AMT_BootSettingData.
+func (a *amt) setIDER(use bool, device int) error {
+	uri := wsmanAmtSchema + "AMT_BootSettingData"
+	sel := wsmanSelectors("InstanceID", amtBootSettings)
+	rdata, err := a.call(wsmanGetAction, uri, sel, "")
+	if err != nil {
+		return err
+	}
+	bsd := &amtBootSettingResponse{}
+	if err := xml.Unmarshal(rdata, bsd); err != nil {
+		return fmt.Errorf("failed to parse boot settings: %v", err)
+	}
+	data := bsd.Data
+	data.XMLName = xml.Name{Space: uri, Local: "AMT_BootSettingData"}
+	data.UseIDER = use
+	data.IDERBootDevice = device
+	if use {
+		data.UseSOL = false
+		data.BIOSPause = false
+		data.BIOSSetup = false
+	}
+	buf, err := xml.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = a.call(wsmanPutAction, uri, sel, string(buf))
+	return err
+}
+
+// nextBoot sets a one-time boot override.  An empty source clears the
+// boot order, which is what IDE-R boot wants.
+func (a *amt) nextBoot(source string, ider bool, iderDevice int) error {
+	if err := a.setIDER(ider, iderDevice); err != nil {
+		return err
+	}
+	cfgURI := wsmanCimSchema + "CIM_BootConfigSetting"
+	input := fmt.Sprintf(`<h:ChangeBootOrder_INPUT xmlns:h="%s">`, cfgURI)
+	if source != "" {
+		input += fmt.Sprintf(`<h:Source>%s</h:Source>`,
+			wsmanReference(wsmanCimSchema+"CIM_BootSourceSetting", "InstanceID", source))
+	}
+	input += `</h:ChangeBootOrder_INPUT>`
+	if err := a.invoke(cfgURI, "ChangeBootOrder", wsmanSelectors("InstanceID", amtBootConfig), input); err != nil {
+		return err
+	}
+	svcURI := wsmanCimSchema + "CIM_BootService"
+	input = fmt.Sprintf(`<h:SetBootConfigRole_INPUT xmlns:h="%s"><h:BootConfigSetting>%s</h:BootConfigSetting><h:Role>1</h:Role></h:SetBootConfigRole_INPUT>`,
+		svcURI, wsmanReference(cfgURI, "InstanceID", amtBootConfig))
+	return a.invoke(svcURI, "SetBootConfigRole", wsmanSelectors("Name", amtBootService), input)
+}
+
+func (a *amt) Probe(l logger.Logger, address string, port int, username, password string) bool {
+	a.username = username
+	a.password = password
+	scheme := "http"
+	if port == 16993 || port == 664 {
+		scheme = "https"
+	}
+	a.url = fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(address, strconv.Itoa(port)))
+
+	defaultTransport := http.DefaultTransport.(*http.Transport)
+	transport := &http.Transport{
+		Proxy:                 defaultTransport.Proxy,
+		DialContext:           defaultTransport.DialContext,
+		MaxIdleConns:          defaultTransport.MaxIdleConns,
+		IdleConnTimeout:       defaultTransport.IdleConnTimeout,
+		ExpectContinueTimeout: defaultTransport.ExpectContinueTimeout,
+		TLSHandshakeTimeout:   time.Duration(3) * time.Second,
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+		},
+	}
+	a.client = &http.Client{Transport: transport, Timeout: time.Duration(60) * time.Second}
+	if _, err := a.powerState(); err != nil {
+		l.Errorf("Unable to talk to AMT: %v", err)
+		return false
+	}
+	return true
+}
+
+func (a *amt) Action(l logger.Logger, ma *models.Action) (supported bool, res interface{}, err *models.Error) {
+	var cmdErr error
+	switch ma.Command {
+	case "powerstatus":
+		supported = true
+		state, serr := a.powerState()
+		if serr != nil {
+			cmdErr = serr
+			break
+		}
+		switch state {
+		case amtPowerOn:
+			res = "On"
+		case amtPowerSleep:
+			res = "Sleep"
+		case amtPowerHibernate:
+			res = "Hibernate"
+		default:
+			res = "Off"
+		}
+	case "poweron":
+		supported = true
+		cmdErr = a.setPowerState(amtPowerOn)
+		res = "Success"
+	case "poweroff":
+		supported = true
+		cmdErr = a.setPowerState(amtPowerOffHard)
+		res = "Success"
+	case "powercycle":
+		supported = true
+		state, serr := a.powerState()
+		if serr != nil {
+			cmdErr = serr
+			break
+		}
+		// AMT refuses to power cycle a system that is already off.
+		if state == amtPowerOn {
+			cmdErr = a.setPowerState(amtPowerCycle)
+		} else {
+			cmdErr = a.setPowerState(amtPowerOn)
+		}
+		res = "Success"
+	case "nextbootpxe":
+		supported = true
+		cmdErr = a.nextBoot(amtBootSourcePxe, false, amtIderFloppy)
+		res = "Success"
+	case "nextbootdisk":
+		supported = true
+		cmdErr = a.nextBoot(amtBootSourceHdd, false, amtIderFloppy)
+		res = "Success"
+	case "nextbootcd":
+		supported = true
+		// IDE-R needs a redirection session to be serving the image
+		// when the system boots, which is outside of this plugin.
+		if ider, ok := ma.Params["ipmi/amt-ider"].(bool); ok && ider {
+			cmdErr = a.nextBoot("", true, amtIderCd)
+		} else {
+			cmdErr = a.nextBoot(amtBootSourceCd, false, amtIderFloppy)
+		}
+		res = "Success"
+	default:
+		// AMT boot overrides are one-time only, so there is no
+		// forceboot support.
+		return
+	}
+	if cmdErr != nil {
+		res = nil
+		err = &models.Error{
+			Model: "plugin",
+			Key:   "ipmi",
+			Type:  "rpc",
+			Code:  400,
+		}
+		err.Errorf("AMT error: %v", cmdErr)
+	}
+	return
+}
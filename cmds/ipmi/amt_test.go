@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/digitalrebar/logger"
+	"github.com/digitalrebar/provision/v4/models"
+)
+
+// fakeAmt is a minimal stand-in for the AMT WS-Man service.
+type fakeAmt struct {
+	username, password, realm, nonce string
+	power                            int
+	source                           string
+	useIDER                          bool
+	iderDevice                       int
+	role                             int
+	calls                            []string
+}
+
+type fakeAmtRequest struct {
+	Action       string             `xml:"Header>Action"`
+	ResourceURI  string             `xml:"Header>ResourceURI"`
+	PowerState   int                `xml:"Body>RequestPowerStateChange_INPUT>PowerState"`
+	Source       string             `xml:"Body>ChangeBootOrder_INPUT>Source>ReferenceParameters>SelectorSet>Selector"`
+	Role         int                `xml:"Body>SetBootConfigRole_INPUT>Role"`
+	BootSettings amtBootSettingData `xml:"Body>AMT_BootSettingData"`
+}
+
+const fakeAmtResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<a:Envelope xmlns:a="http://www.w3.org/2003/05/soap-envelope" xmlns:h="%s"><a:Header></a:Header><a:Body>%s</a:Body></a:Envelope>`
+
+func (f *fakeAmt) authorized(hdr string) bool {
+	if !strings.HasPrefix(hdr, "Digest ") {
+		return false
+	}
+	vals := map[string]string{}
+	for _, part := range strings.Split(strings.TrimPrefix(hdr, "Digest "), ", ") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) == 2 {
+			vals[kv[0]] = strings.Trim(kv[1], `"`)
+		}
+	}
+	if vals["username"] != f.username || vals["nonce"] != f.nonce || vals["qop"] != "auth" {
+		return false
+	}
+	ha1 := md5hex(f.username + ":" + f.realm + ":" + f.password)
+	ha2 := md5hex("POST:" + vals["uri"])
+	return vals["response"] == md5hex(strings.Join([]string{ha1, f.nonce, vals["nc"], vals["cnonce"], "auth", ha2}, ":"))
+}
+
+func (f *fakeAmt) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/wsman" || r.Method != "POST" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !f.authorized(r.Header.Get("Authorization")) {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Digest realm="%s", nonce="%s", stale="false", qop="auth"`, f.realm, f.nonce))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	buf, _ := ioutil.ReadAll(r.Body)
+	req := &fakeAmtRequest{}
+	if err := xml.Unmarshal(buf, req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	f.calls = append(f.calls, req.Action)
+	reply := func(ns, body string) {
+		w.Header().Set("Content-Type", "application/soap+xml; charset=UTF-8")
+		fmt.Fprintf(w, fakeAmtResponse, ns, body)
+	}
+	bsd := func() string {
+		return fmt.Sprintf(`<h:AMT_BootSettingData><h:BIOSPause>false</h:BIOSPause><h:BIOSSetup>false</h:BIOSSetup><h:BootMediaIndex>0</h:BootMediaIndex><h:ElementName>Intel(r) AMT Boot Configuration Settings</h:ElementName><h:IDERBootDevice>%d</h:IDERBootDevice><h:InstanceID>Intel(r) AMT:BootSettingData 0</h:InstanceID><h:UseIDER>%v</h:UseIDER><h:UseSOL>false</h:UseSOL></h:AMT_BootSettingData>`,
+			f.iderDevice, f.useIDER)
+	}
+	switch req.Action {
+	case wsmanGetAction:
+		switch req.ResourceURI {
+		case wsmanCimSchema + "CIM_AssociatedPowerManagementService":
+			reply(req.ResourceURI, fmt.Sprintf(`<h:CIM_AssociatedPowerManagementService><h:PowerState>%d</h:PowerState></h:CIM_AssociatedPowerManagementService>`, f.power))
+			return
+		case wsmanAmtSchema + "AMT_BootSettingData":
+			reply(req.ResourceURI, bsd())
+			return
+		}
+	case wsmanPutAction:
+		if req.ResourceURI == wsmanAmtSchema+"AMT_BootSettingData" &&
+			req.BootSettings.XMLName.Space == req.ResourceURI &&
+			req.BootSettings.InstanceID == amtBootSettings {
+			f.useIDER = req.BootSettings.UseIDER
+			f.iderDevice = req.BootSettings.IDERBootDevice
+			reply(req.ResourceURI, bsd())
+			return
+		}
+	case wsmanCimSchema + "CIM_PowerManagementService/RequestPowerStateChange":
+		rv := 0
+		switch {
+		case req.PowerState == amtPowerCycle && f.power != amtPowerOn:
+			rv = 2
+		case req.PowerState == amtPowerCycle:
+		default:
+			f.power = req.PowerState
+		}
+		reply(req.ResourceURI, fmt.Sprintf(`<h:RequestPowerStateChange_OUTPUT><h:ReturnValue>%d</h:ReturnValue></h:RequestPowerStateChange_OUTPUT>`, rv))
+		return
+	case wsmanCimSchema + "CIM_BootConfigSetting/ChangeBootOrder":
+		f.source = req.Source
+		reply(req.ResourceURI, `<h:ChangeBootOrder_OUTPUT><h:ReturnValue>0</h:ReturnValue></h:ChangeBootOrder_OUTPUT>`)
+		return
+	case wsmanCimSchema + "CIM_BootService/SetBootConfigRole":
+		f.role = req.Role
+		reply(req.ResourceURI, `<h:SetBootConfigRole_OUTPUT><h:ReturnValue>0</h:ReturnValue></h:SetBootConfigRole_OUTPUT>`)
+		return
+	}
+	w.Header().Set("Content-Type", "application/soap+xml; charset=UTF-8")
+	w.WriteHeader(http.StatusBadRequest)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<a:Envelope xmlns:a="http://www.w3.org/2003/05/soap-envelope"><a:Body><a:Fault><a:Code><a:Value>a:Sender</a:Value><a:Subcode><a:Value>wsa:ActionNotSupported</a:Value></a:Subcode></a:Code><a:Reason><a:Text xml:lang="en-US">The action is not supported by the service.</a:Text></a:Reason></a:Fault></a:Body></a:Envelope>`)
+}
+
+func TestAmt(t *testing.T) {
+	fake := &fakeAmt{
+		username: "admin",
+		password: "P@ssw0rd",
+		realm:    "Digest:A3829B3827DE4D33D4449B366831FD01",
+		nonce:    "dcdf1f4a-0000-0000-0000-000000000000",
+		power:    amtPowerOn,
+	}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+	host, portStr, _ := net.SplitHostPort(u.Host)
+	port, _ := strconv.Atoi(portStr)
+	l := logger.New(nil).Log("amt-test")
+
+	probe := func(password string) *amt {
+		a := &amt{}
+		if !a.Probe(l, host, port, fake.username, password) {
+			return nil
+		}
+		return a
+	}
+	if probe("wrong") != nil {
+		t.Errorf("Probe with a bad password should have failed")
+	}
+	action := func(cmd string, params map[string]interface{}) (bool, interface{}, *models.Error) {
+		a := probe(fake.password)
+		if a == nil {
+			t.Fatalf("%s: probe failed", cmd)
+		}
+		return a.Action(l, &models.Action{Command: cmd, Params: params})
+	}
+
+	for _, tc := range []struct {
+		cmd    string
+		params map[string]interface{}
+		res    interface{}
+		check  func() bool
+	}{
+		{"powerstatus", nil, "On", nil},
+		{"poweroff", nil, "Success", func() bool { return fake.power == amtPowerOffHard }},
+		{"powerstatus", nil, "Off", nil},
+		{"powercycle", nil, "Success", func() bool { return fake.power == amtPowerOn }},
+		{"powercycle", nil, "Success", func() bool { return fake.power == amtPowerOn }},
+		{"nextbootpxe", nil, "Success", func() bool {
+			return fake.source == amtBootSourcePxe && fake.role == 1 && !fake.useIDER
+		}},
+		{"nextbootdisk", nil, "Success", func() bool {
+			return fake.source == amtBootSourceHdd && fake.role == 1 && !fake.useIDER
+		}},
+		{"nextbootcd", map[string]interface{}{"ipmi/amt-ider": true}, "Success", func() bool {
+			return fake.source == "" && fake.role == 1 && fake.useIDER && fake.iderDevice == amtIderCd
+		}},
+		{"nextbootcd", map[string]interface{}{"ipmi/amt-ider": false}, "Success", func() bool {
+			return fake.source == amtBootSourceCd && fake.role == 1 && !fake.useIDER
+		}},
+	} {
+		fake.role = 0
+		supported, res, err := action(tc.cmd, tc.params)
+		if !supported {
+			t.Errorf("%s: expected to be supported", tc.cmd)
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tc.cmd, err)
+			continue
+		}
+		if res != tc.res {
+			t.Errorf("%s: expected %v, got %v", tc.cmd, tc.res, res)
+		}
+		if tc.check != nil && !tc.check() {
+			t.Errorf("%s: fake AMT in unexpected state: %+v", tc.cmd, fake)
+		}
+	}
+
+	for _, cmd := range []string{"forcebootpxe", "forcebootdisk", "identify"} {
+		if supported, _, _ := action(cmd, nil); supported {
+			t.Errorf("%s: expected to be unsupported", cmd)
+		}
+	}
+
+	// A fault from the service should come back as an error.
+	a := probe(fake.password)
+	if err := a.invoke(wsmanCimSchema+"CIM_Bogus", "Bogus", "", ""); err == nil ||
+		!strings.Contains(err.Error(), "ActionNotSupported") {
+		t.Errorf("Expected ActionNotSupported fault, got %v", err)
+	}
+}
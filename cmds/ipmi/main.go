@@ -38,6 +38,7 @@ var (
 					"ipmi/port-racadm",
 					"ipmi/port-redfish",
 					"ipmi/port-lpar",
+					"ipmi/port-amt",
 					"ipmi/lpar-id",
 				},
 			},
@@ -54,6 +55,7 @@ var (
 					"ipmi/port-racadm",
 					"ipmi/port-redfish",
 					"ipmi/port-lpar",
+					"ipmi/port-amt",
 					"ipmi/lpar-id",
 				},
 			},
@@ -70,6 +72,7 @@ var (
 					"ipmi/port-racadm",
 					"ipmi/port-redfish",
 					"ipmi/port-lpar",
+					"ipmi/port-amt",
 					"ipmi/lpar-id",
 				},
 			},
@@ -87,7 +90,9 @@ var (
 					"ipmi/port-racadm",
 					"ipmi/port-redfish",
 					"ipmi/port-lpar",
+					"ipmi/port-amt",
 					"ipmi/lpar-id",
+					"ipmi/amt-ider",
 				},
 			},
 			{Command: "nextbootpxe",
@@ -104,6 +109,7 @@ var (
 					"ipmi/port-racadm",
 					"ipmi/port-redfish",
 					"ipmi/port-lpar",
+					"ipmi/port-amt",
 					"ipmi/lpar-id",
 				},
 			},
@@ -121,6 +127,7 @@ var (
 					"ipmi/port-racadm",
 					"ipmi/port-redfish",
 					"ipmi/port-lpar",
+					"ipmi/port-amt",
 					"ipmi/lpar-id",
 				},
 			},
@@ -138,6 +145,7 @@ var (
 					"ipmi/port-racadm",
 					"ipmi/port-redfish",
 					"ipmi/port-lpar",
+					"ipmi/port-amt",
 					"ipmi/lpar-id",
 				},
 			},
@@ -155,6 +163,7 @@ var (
 					"ipmi/port-racadm",
 					"ipmi/port-redfish",
 					"ipmi/port-lpar",
+					"ipmi/port-amt",
 					"ipmi/lpar-id",
 				},
 			},
@@ -171,6 +180,7 @@ var (
 					"ipmi/port-racadm",
 					"ipmi/port-redfish",
 					"ipmi/port-lpar",
+					"ipmi/port-amt",
 					"ipmi/lpar-id",
 					"ipmi/identify-duration",
 				},
@@ -188,6 +198,7 @@ var (
 					"ipmi/port-racadm",
 					"ipmi/port-redfish",
 					"ipmi/port-lpar",
+					"ipmi/port-amt",
 					"ipmi/lpar-id",
 				},
 			},
@@ -204,6 +215,7 @@ var (
 					"ipmi/port-racadm",
 					"ipmi/port-redfish",
 					"ipmi/port-lpar",
+					"ipmi/port-amt",
 					"ipmi/lpar-id",
 				},
 			},
@@ -220,6 +232,7 @@ var (
 					"ipmi/port-racadm",
 					"ipmi/port-redfish",
 					"ipmi/port-lpar",
+					"ipmi/port-amt",
 					"ipmi/lpar-id",
 				},
 			},
@@ -236,6 +249,7 @@ var (
 					"ipmi/port-racadm",
 					"ipmi/port-redfish",
 					"ipmi/port-lpar",
+					"ipmi/port-amt",
 					"ipmi/lpar-id",
 				},
 			},
@@ -252,6 +266,7 @@ var (
 					"ipmi/port-racadm",
 					"ipmi/port-redfish",
 					"ipmi/port-lpar",
+					"ipmi/port-amt",
 					"ipmi/lpar-id",
 				},
 			},
@@ -268,6 +283,7 @@ var (
 					"ipmi/port-racadm",
 					"ipmi/port-redfish",
 					"ipmi/port-lpar",
+					"ipmi/port-amt",
 					"ipmi/lpar-id",
 				},
 			},
@@ -284,6 +300,7 @@ var (
 					"ipmi/port-racadm",
 					"ipmi/port-redfish",
 					"ipmi/port-lpar",
+					"ipmi/port-amt",
 					"ipmi/lpar-id",
 				},
 			},
@@ -300,6 +317,7 @@ var (
 					"ipmi/port-racadm",
 					"ipmi/port-redfish",
 					"ipmi/port-lpar",
+					"ipmi/port-amt",
 					"ipmi/lpar-id",
 				},
 			},
@@ -316,6 +334,7 @@ var (
 					"ipmi/port-racadm",
 					"ipmi/port-redfish",
 					"ipmi/port-lpar",
+					"ipmi/port-amt",
 					"ipmi/lpar-id",
 				},
 			},
@@ -332,6 +351,7 @@ var (
 					"ipmi/port-racadm",
 					"ipmi/port-redfish",
 					"ipmi/port-lpar",
+					"ipmi/port-amt",
 					"ipmi/lpar-id",
 				},
 			},
@@ -348,6 +368,7 @@ var (
 					"ipmi/port-racadm",
 					"ipmi/port-redfish",
 					"ipmi/port-lpar",
+					"ipmi/port-amt",
 					"ipmi/lpar-id",
 				},
 			},
@@ -364,6 +385,7 @@ var (
 					"ipmi/port-racadm",
 					"ipmi/port-redfish",
 					"ipmi/port-lpar",
+					"ipmi/port-amt",
 					"ipmi/lpar-id",
 				},
 			},
@@ -382,6 +404,7 @@ var (
 					"ipmi/port-racadm",
 					"ipmi/port-redfish",
 					"ipmi/port-lpar",
+					"ipmi/port-amt",
 					"ipmi/lpar-id",
 				},
 			},
@@ -398,6 +421,7 @@ var (
 					"ipmi/port-racadm",
 					"ipmi/port-redfish",
 					"ipmi/port-lpar",
+					"ipmi/port-amt",
 					"ipmi/lpar-id",
 				},
 			},
@@ -414,6 +438,7 @@ var (
 					"ipmi/port-racadm",
 					"ipmi/port-redfish",
 					"ipmi/port-lpar",
+					"ipmi/port-amt",
 					"ipmi/lpar-id",
 				},
 			},
@@ -471,6 +496,9 @@ func (p *Plugin) Action(l logger.Logger, ma *models.Action) (answer interface{},
 	case "lpar":
 		ipmiDriver = &lpar{}
 		port = int(ma.Params["ipmi/port-lpar"].(float64) + 0.5)
+	case "amt":
+		ipmiDriver = &amt{}
+		port = int(ma.Params["ipmi/port-amt"].(float64) + 0.5)
 	default:
 		err = &models.Error{Code: 404,
 			Model:    "plugin",
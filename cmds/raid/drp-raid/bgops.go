@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BackgroundOp is an operation a controller is running on a volume or
+// disk in the background.  Operation is one of init, rebuild, copyback,
+// check, reshape, or erase.
+type BackgroundOp struct {
+	Controller string
+	Volume     string `json:",omitempty"`
+	Disk       string `json:",omitempty"`
+	Operation  string
+	// Progress is the percent complete.
+	Progress float64
+	// Elapsed and Remaining are in seconds, and are 0 if not known.
+	Elapsed   int64 `json:",omitempty"`
+	Remaining int64 `json:",omitempty"`
+}
+
+func (b *BackgroundOp) key() string {
+	return strings.Join([]string{b.Controller, b.Volume, b.Disk, b.Operation}, "|")
+}
+
+func (b *BackgroundOp) String() string {
+	target := "volume " + b.Volume
+	if b.Disk != "" {
+		target = "disk " + b.Disk
+	}
+	res := fmt.Sprintf("%s of %s on %s: %.0f%% complete", b.Operation, target, b.Controller, b.Progress)
+	if b.Remaining > 0 {
+		res += fmt.Sprintf(", about %v left", time.Duration(b.Remaining)*time.Second)
+	}
+	return res
+}
+
+// estimate fills in Remaining from how long the operation has taken so
+// far, or failing that from how far it got since prev was seen.
+func (b *BackgroundOp) estimate(prev *BackgroundOp, since time.Duration) {
+	if b.Remaining > 0 || b.Progress <= 0 || b.Progress >= 100 {
+		return
+	}
+	if b.Elapsed > 0 {
+		b.Remaining = int64(float64(b.Elapsed) * (100 - b.Progress) / b.Progress)
+		return
+	}
+	if prev == nil || since <= 0 || b.Progress <= prev.Progress {
+		return
+	}
+	rate := (b.Progress - prev.Progress) / since.Seconds()
+	b.Remaining = int64((100 - b.Progress) / rate)
+}
+
+var (
+	timeLeftRE = regexp.MustCompile(`(\d+)\s*(Hour|Hr|Minute|Min|Second|Sec)`)
+	percentRE  = regexp.MustCompile(`(\d+(?:\.\d+)?)\s*%`)
+)
+
+// parseTimeLeft parses durations like "1 Hours 12 Minutes" into seconds.
+func parseTimeLeft(v string) int64 {
+	var res int64
+	for _, m := range timeLeftRE.FindAllStringSubmatch(v, -1) {
+		n, _ := strconv.ParseInt(m[1], 10, 64)
+		switch m[2] {
+		case "Hour", "Hr":
+			res += n * 3600
+		case "Minute", "Min":
+			res += n * 60
+		default:
+			res += n
+		}
+	}
+	return res
+}
+
+// parsePercent returns the first percentage in v.
+func parsePercent(v string) (float64, bool) {
+	m := percentRE.FindStringSubmatch(v)
+	if len(m) != 2 {
+		return 0, false
+	}
+	res, err := strconv.ParseFloat(m[1], 64)
+	return res, err == nil
+}
+
+// checkable returns an error if v has no redundancy to check.
+func checkable(v *Volume) error {
+	switch v.RaidLevel {
+	case "jbod", "raid0", "concat", "namespace":
+		return fmt.Errorf("Cannot check the consistency of %s volume %s", v.RaidLevel, v.ID)
+	}
+	return nil
+}
+
+// progressValue handles Progress% fields that are either a number or "-".
+func progressValue(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case string:
+		res, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return res, err == nil
+	}
+	return 0, false
+}
+
+// percOpKinds maps the operations perccli and storcli report to ours.
+var percOpKinds = map[string]string{
+	"BGI":  "init",
+	"INIT": "init",
+	"CC":   "check",
+	"OCE":  "reshape",
+}
+
+// percDriveIDRE splits drive ids like /c0/e32/s2 or /c0/s2.
+var percDriveIDRE = regexp.MustCompile(`^/c\d+(?:/e(\d+))?/s(\d+)$`)
+
+var (
+	//  0 BGI              23 In progress     12 Minutes
+	percVDOpRE = regexp.MustCompile(`^\s*(\d+)\s+(BGI|CC|INIT|OCE)\s+(\d+|-)\s+In progress\s*(.*)$`)
+	// /c0/e32/s2         45 In progress 20 Minutes
+	percDriveOpRE = regexp.MustCompile(`^/c\d+(?:/e(\d+))?/s(\d+)\s+(\d+|-)\s+In progress\s*(.*)$`)
+)
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTimeLeft(t *testing.T) {
+	for v, want := range map[string]int64{
+		"1 Hours 12 Minutes":  4320,
+		"12 Minutes":          720,
+		"2 Hrs 3 Mins 4 Secs": 7384,
+		"-":                   0,
+	} {
+		if got := parseTimeLeft(v); got != want {
+			t.Errorf("%q: expected %d, got %d", v, want, got)
+		}
+	}
+	if p, ok := parsePercent("Transforming, 42.5% complete"); !ok || p != 42.5 {
+		t.Errorf("Unexpected percent %v %v", p, ok)
+	}
+}
+
+func TestEstimate(t *testing.T) {
+	op := &BackgroundOp{Progress: 25, Elapsed: 600}
+	op.estimate(nil, 0)
+	if op.Remaining != 1800 {
+		t.Errorf("Expected 1800 seconds from elapsed time, got %d", op.Remaining)
+	}
+	op = &BackgroundOp{Progress: 30}
+	op.estimate(&BackgroundOp{Progress: 20}, time.Minute)
+	if op.Remaining != 420 {
+		t.Errorf("Expected 420 seconds from the last poll, got %d", op.Remaining)
+	}
+	op = &BackgroundOp{Progress: 30}
+	op.estimate(&BackgroundOp{Progress: 30}, time.Minute)
+	if op.Remaining != 0 {
+		t.Errorf("Expected no estimate without progress, got %d", op.Remaining)
+	}
+}
+
+func TestWatchAndPlanCheck(t *testing.T) {
+	oldFake := fake
+	defer func() { fake = oldFake }()
+	fake = true
+	cs := ctrlrs(1, "megacli")
+	c := cs[0]
+	c.addDisks(3, 1<<40, "sas", "disk")
+	c.Volumes = append(c.Volumes,
+		&Volume{ID: "0", Name: "os", RaidLevel: "raid1", Disks: c.Disks[:2],
+			Info: map[string]string{"Check Consistency": "Completed 25%, Taken 10 min."}},
+		&Volume{ID: "1", Name: "scratch", RaidLevel: "raid0", Disks: c.Disks[2:]})
+	s := &session{log: log.New(ioutil.Discard, "", 0), controllers: cs}
+	ops := s.Watch(true, time.Millisecond, time.Millisecond)
+	if !s.HasError() {
+		t.Errorf("Expected a timeout waiting for the check to finish")
+	}
+	if len(ops) != 1 || ops[0].Operation != "check" || ops[0].Progress != 25 || ops[0].Remaining != 1800 {
+		t.Fatalf("Unexpected operations %v", ops)
+	}
+	s = &session{log: log.New(ioutil.Discard, "", 0), controllers: cs}
+	p := s.PlanCheck("os", true)
+	if s.HasError() || len(p.Steps) != 1 || p.Steps[0].Action != "check 0" ||
+		strings.Join(p.Steps[0].Commands[0][1:], " ") != "-LDCC -Start -L0 -a0" {
+		t.Errorf("Unexpected plan %+v", p)
+	}
+	p = s.PlanCheck("0/1", false)
+	if !s.HasError() || len(p.Steps) != 0 {
+		t.Errorf("Expected an error stopping a check on a raid0 volume")
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestBootVolume(t *testing.T) {
+	oldFake := fake
+	fake = false
+	defer func() { fake = oldFake }()
+	newSess := func(driver string, specs VolSpecs) *session {
+		c := ctrlrs(1, driver)[0]
+		c.addDisks(4, 1<<40, "sas", "disk")
+		c.Volumes = append(c.Volumes, &Volume{
+			ControllerID:     c.ID,
+			ControllerDriver: c.Driver,
+			ID:               "0",
+			RaidLevel:        "raid1",
+			Size:             1 << 40,
+			StripeSize:       64 << 10,
+			Disks:            c.Disks[:2],
+			Info:             map[string]string{},
+			controller:       c,
+			driver:           c.driver,
+		})
+		for _, d := range c.Disks[:2] {
+			d.VolumeID = "0"
+		}
+		return &session{
+			log:         log.New(ioutil.Discard, "", 0),
+			controllers: Controllers{c},
+			inSpecs:     specs,
+		}
+	}
+	osSpec := func() *VolSpec {
+		return &VolSpec{RaidLevel: "raid1", StripeSize: "64 KB", Disks: VolSpecDisks{{Slot: 0}, {Slot: 1}}, Bootable: true}
+	}
+	dataSpec := func(boot bool) *VolSpec {
+		return &VolSpec{RaidLevel: "raid1", StripeSize: "64 KB", Disks: VolSpecDisks{{Slot: 2}, {Slot: 3}}, Bootable: boot}
+	}
+
+	s := newSess("megacli", VolSpecs{osSpec()})
+	s.compiledSpecs = VolSpecs{osSpec()}
+	cmp, err := s.Diff()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cmp["add"]) != 0 || len(cmp["rm"]) != 0 || len(cmp["boot"]) != 1 || cmp["boot"][0].VolumeID != "0" {
+		t.Errorf("Expected volume 0 to need to be made bootable, got %+v", cmp)
+	}
+	s.controllers[0].Volumes[0].Bootable = true
+	if cmp, _ := s.Diff(); len(cmp["boot"]) != 0 {
+		t.Errorf("Expected no boot change once volume 0 is the boot volume, got %+v", cmp["boot"])
+	}
+
+	for _, tc := range []struct {
+		driver   string
+		specs    VolSpecs
+		doAppend bool
+		want     []string
+	}{
+		{"megacli", VolSpecs{osSpec()}, false, []string{"boot", "-AdpBootDrive -Set -L0 -a0"}},
+		{"storcli7", VolSpecs{osSpec()}, false, []string{"boot", "/c0/v0 set bootdrive=on"}},
+		{"storcli7-json", VolSpecs{osSpec()}, false, []string{"boot", "/c0/v0 set bootdrive=on J"}},
+		{"ssacli", VolSpecs{osSpec()}, false, []string{"boot", "controller slot=0 ld 0 modify bootvolume=primary"}},
+		{"storcli7", VolSpecs{osSpec(), dataSpec(false)}, false, []string{"create", "", "boot", "/c0/v0 set bootdrive=on"}},
+		{"megacli", VolSpecs{dataSpec(true)}, true, []string{"create", "", "boot", "-AdpBootDrive -Set -L<new> -a0"}},
+	} {
+		s := newSess(tc.driver, tc.specs)
+		p := s.PlanConfigure(tc.doAppend, false, false)
+		if s.HasError() {
+			t.Errorf("%s: unexpected error planning", tc.driver)
+			continue
+		}
+		if len(p.Steps)*2 != len(tc.want) {
+			t.Errorf("%s: expected %d steps, got %+v", tc.driver, len(tc.want)/2, p.Steps)
+			continue
+		}
+		for i, step := range p.Steps {
+			if !strings.HasPrefix(step.Action, tc.want[i*2]) {
+				t.Errorf("%s: step %d: expected %s, got %s", tc.driver, i, tc.want[i*2], step.Action)
+			}
+			if cmd := tc.want[i*2+1]; cmd != "" && strings.Join(step.Commands[0][1:], " ") != cmd {
+				t.Errorf("%s: step %d: expected `%s`, got %v", tc.driver, i, cmd, step.Commands)
+			}
+		}
+	}
+
+	s = newSess("mdadm", VolSpecs{osSpec()})
+	if s.PlanConfigure(false, false, false); !s.HasError() {
+		t.Errorf("Expected mdadm to be unable to set the boot volume")
+	}
+	s = newSess("megacli", VolSpecs{osSpec(), dataSpec(true)})
+	if s.PlanConfigure(false, false, false); !s.HasError() {
+		t.Errorf("Expected an error for two Bootable volumes on one controller")
+	}
+	// A jbod volspec makes a volume per disk, and only one of them can
+	// be the boot volume.
+	buf := &strings.Builder{}
+	s = newSess("megacli", VolSpecs{{RaidLevel: "jbod", DiskCount: "max", Bootable: true}})
+	s.log = log.New(buf, "", 0)
+	if s.PlanConfigure(true, false, false); !s.HasError() || !strings.Contains(buf.String(), "only be Bootable with one disk") {
+		t.Errorf("Expected a Bootable jbod over several disks to be rejected, got %s", buf.String())
+	}
+	s = newSess("megacli", VolSpecs{{RaidLevel: "jbod", Disks: VolSpecDisks{{Slot: 2}}, Bootable: true}})
+	if p := s.PlanConfigure(true, false, false); s.HasError() || len(p.Steps) != 2 || p.Steps[1].Action != "boot 0:jbod,:2" {
+		t.Errorf("Expected a Bootable jbod on one disk to be created and made bootable, got %+v", p.Steps)
+	}
+}
+
+// Compare and configure have to agree on whether the boot volume is
+// already set, or every configure run sets it again.
+func TestBootConvergence(t *testing.T) {
+	oldExecutor, oldFake := executor, fake
+	fake = false
+	defer func() { executor, fake = oldExecutor, oldFake }()
+	for _, tc := range []struct {
+		name              string
+		bootable, unknown bool
+		same              bool
+		calls             []*Call
+	}{
+		{"boot volume", true, false, true, nil},
+		{"boot unknown", false, true, true, nil},
+		{"not boot volume", false, false, false, []*Call{{
+			Op:       "run",
+			Path:     "/opt/MegaRAID/perccli/perccli64",
+			Args:     []string{"/c0/v0", "set", "bootdrive=on"},
+			Combined: true,
+			Stdout:   "Controller = 0\nStatus = Success\nDescription = None\n",
+		}}},
+	} {
+		c := ctrlrs(1, "perccli")[0]
+		c.addDisks(2, 1<<40, "sas", "disk")
+		c.BootUnknown = tc.unknown
+		c.Volumes = append(c.Volumes, &Volume{
+			ControllerID:     c.ID,
+			ControllerDriver: c.Driver,
+			ID:               "0",
+			RaidLevel:        "raid1",
+			Size:             1 << 40,
+			StripeSize:       64 << 10,
+			Disks:            c.Disks,
+			Bootable:         tc.bootable,
+			Info:             map[string]string{},
+			controller:       c,
+			driver:           c.driver,
+		})
+		for _, d := range c.Disks {
+			d.VolumeID = "0"
+		}
+		c.driver.Logger(log.New(ioutil.Discard, "", 0))
+		r := &replayer{calls: tc.calls}
+		executor = r
+		spec := &VolSpec{RaidLevel: "raid1", StripeSize: "64 KB", Disks: VolSpecDisks{{Slot: 0}, {Slot: 1}}, Bootable: true}
+		s := &session{
+			log:         log.New(ioutil.Discard, "", 0),
+			controllers: Controllers{c},
+			inSpecs:     VolSpecs{spec},
+		}
+		if _, same := s.Compare(); same != tc.same || s.HasError() {
+			t.Errorf("%s: expected compare to say same %v, got %v", tc.name, tc.same, same)
+		}
+		s.Configure(false, false)
+		if s.HasError() {
+			t.Errorf("%s: unexpected error configuring", tc.name)
+		}
+		for _, c := range r.Missed() {
+			t.Errorf("%s: unexpected %s", tc.name, c)
+		}
+		for _, c := range r.Unused() {
+			t.Errorf("%s: did not run %s", tc.name, c)
+		}
+	}
+}
@@ -21,6 +21,9 @@ type Controller struct {
 	Volumes     []*Volume
 	Disks       []*PhysicalDisk
 	Info        map[string]string
+	// BootUnknown is set when the driver could not find out which volume
+	// the controller boots from, so any of them might be the boot volume.
+	BootUnknown bool `json:",omitempty"`
 	driver      Driver
 	idx         int
 }
@@ -75,11 +78,28 @@ func (c Controllers) ToVolSpecs(specific bool) VolSpecs {
 			}
 			tvols = append(tvols, tv)
 		}
+		// Global hot spares are reported on the first volume that can use them.
+		if globals := controller.GlobalHotSpares(); len(globals) > 0 {
+			for _, tv := range tvols {
+				if _, ok := noSpareLevels[tv.RaidLevel]; ok || tv.hasSpares() {
+					continue
+				}
+				tv.GlobalHotSpares = true
+				tv.HotSpareDisks = make(VolSpecDisks, len(globals))
+				for i := range globals {
+					tv.HotSpareDisks[i] = globals[i].volSpecDisk()
+				}
+				sort.Stable(vsdByPos(tv.HotSpareDisks))
+				break
+			}
+		}
 		if !specific {
 			// Convert the disks to counts
 			for _, v := range tvols {
 				v.DiskCount = fmt.Sprintf("%d", len(v.Disks))
 				v.Disks = VolSpecDisks{}
+				v.HotSpares = len(v.HotSpareDisks)
+				v.HotSpareDisks = nil
 			}
 			if raidS {
 				tvol := &VolSpec{
@@ -107,6 +127,18 @@ func (c Controllers) ToVolSpecs(specific bool) VolSpecs {
 	return res
 }
 
+// GlobalHotSpares returns the disks that are hot spares for any volume
+// on the controller.
+func (c *Controller) GlobalHotSpares() []*PhysicalDisk {
+	res := []*PhysicalDisk{}
+	for _, d := range c.Disks {
+		if d.HotSpare && d.SpareFor == "" {
+			res = append(res, d)
+		}
+	}
+	return res
+}
+
 func (c *Controller) addJBODVolume(d *PhysicalDisk) {
 	// This is a JBOD, and we need to fake up a volume for it.
 	c.Volumes = append(c.Volumes, &Volume{
@@ -125,22 +157,106 @@ func (c *Controller) addJBODVolume(d *PhysicalDisk) {
 }
 
 func (c *Controller) VolSpecDisks() VolSpecDisks {
-	res := make(VolSpecDisks, len(c.Disks))
+	res := make(VolSpecDisks, 0, len(c.Disks))
 	for i := range c.Disks {
-		res[i] = VolSpecDisk{
+		// Foreign disks cannot be used until they are imported or cleared.
+		if c.Disks[i].Foreign {
+			continue
+		}
+		res = append(res, VolSpecDisk{
 			Size:      c.Disks[i].Size,
 			Enclosure: c.Disks[i].Enclosure,
 			Type:      c.Disks[i].MediaType,
 			Protocol:  c.Disks[i].Protocol,
 			Slot:      c.Disks[i].Slot,
 			info:      c.Disks[i].Info,
-		}
+		})
 	}
 	return res
 }
 
 func (c *Controller) Encrypt(key, password string) error {
-	return c.driver.Encrypt(c, key, password)
+	return (&Secrets{Password: password}).redactErr(c.driver.Encrypt(c, key, password))
+}
+
+// Secure encrypts the existing volume v with the controller key.
+func (c *Controller) Secure(v *Volume) error {
+	return c.driver.Secure(c, v)
+}
+
+// Rekey changes the controller key to the new one in sec.
+func (c *Controller) Rekey(sec *Secrets) error {
+	return sec.redactErr(c.driver.Rekey(c, sec))
+}
+
+// Unlock unlocks the secured disks locked with password.
+func (c *Controller) Unlock(password string) error {
+	return (&Secrets{Password: password}).redactErr(c.driver.Unlock(c, password))
+}
+
+// Locate turns the locate LED for d on or off.
+func (c *Controller) Locate(d *PhysicalDisk, on bool) error {
+	return c.driver.Locate(c, d, on)
+}
+
+// Erase starts erasing d with method.
+func (c *Controller) Erase(d *PhysicalDisk, method string) error {
+	return c.driver.Erase(c, d, method)
+}
+
+// EraseProgress returns how far along erasing d is, or how it ended.
+func (c *Controller) EraseProgress(d *PhysicalDisk, method string) (*EraseStatus, error) {
+	return c.driver.EraseProgress(c, d, method)
+}
+
+// BackgroundOps returns the operations running in the background as of
+// the last Refresh.
+func (c *Controller) BackgroundOps() ([]*BackgroundOp, error) {
+	return c.driver.BackgroundOps(c)
+}
+
+// Check starts or stops a consistency check of v.
+func (c *Controller) Check(v *Volume, start bool) error {
+	return c.driver.Check(c, v, start)
+}
+
+// Expand starts growing or migrating a volume as described by e.
+func (c *Controller) Expand(e *Expansion) error {
+	return c.driver.Expand(c, e)
+}
+
+// ForeignConfigs returns the foreign configs on the controller.  Only
+// controllers with foreign disks are asked.
+func (c *Controller) ForeignConfigs() ([]*ForeignConfig, error) {
+	if !c.hasForeignDisks() {
+		return []*ForeignConfig{}, nil
+	}
+	res, err := c.driver.ForeignConfigs(c)
+	for _, cfg := range res {
+		cfg.controller = c
+	}
+	return res, err
+}
+
+// ImportForeign imports the foreign config with the passed-in ID, or all
+// of them.
+func (c *Controller) ImportForeign(id string) error {
+	return c.driver.ImportForeign(c, id)
+}
+
+// Health returns the normalized health of d.
+func (c *Controller) Health(d *PhysicalDisk) (*DiskHealth, error) {
+	return c.driver.Health(c, d)
+}
+
+// Settings returns the controller-wide settings a ControllerSpec can change.
+func (c *Controller) Settings() (*ControllerSettings, error) {
+	return c.driver.Settings(c)
+}
+
+// ApplySettings changes the controller-wide settings that are set in spec.
+func (c *Controller) ApplySettings(spec *ControllerSpec) error {
+	return c.driver.ApplySettings(c, spec)
 }
 
 func (c *Controller) Clear() error {
@@ -150,3 +266,101 @@ func (c *Controller) Clear() error {
 func (c *Controller) Create(v *VolSpec, forceGood bool) error {
 	return c.driver.Create(c, v, forceGood)
 }
+
+func (c *Controller) Delete(v *Volume) error {
+	return c.driver.Delete(c, v)
+}
+
+// Update changes the name and cache policies of v in place to match
+// the ones set in spec.
+func (c *Controller) Update(v *Volume, spec *VolSpec) error {
+	return c.driver.Update(c, v, spec)
+}
+
+// SetBoot makes v the volume the controller boots from.
+func (c *Controller) SetBoot(v *Volume) error {
+	return c.driver.SetBoot(c, v)
+}
+
+// commandLines prepends the driver executable to each of cmds.
+func (c *Controller) commandLines(cmds [][]string, err error) ([][]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	res := make([][]string, len(cmds))
+	for i, cmd := range cmds {
+		res[i] = append([]string{c.driver.Executable()}, cmd...)
+	}
+	return res, nil
+}
+
+func (c *Controller) EncryptCmds(key, password string) ([][]string, error) {
+	return c.commandLines(c.driver.EncryptCmds(c, key, password))
+}
+
+func (c *Controller) SecureCmds(v *Volume) ([][]string, error) {
+	return c.commandLines(c.driver.SecureCmds(c, v))
+}
+
+func (c *Controller) RekeyCmds(sec *Secrets) ([][]string, error) {
+	return c.commandLines(c.driver.RekeyCmds(c, sec))
+}
+
+func (c *Controller) UnlockCmds(password string) ([][]string, error) {
+	return c.commandLines(c.driver.UnlockCmds(c, password))
+}
+
+func (c *Controller) LocateCmds(d *PhysicalDisk, on bool) ([][]string, error) {
+	return c.commandLines(c.driver.LocateCmds(c, d, on))
+}
+
+func (c *Controller) EraseCmds(d *PhysicalDisk, method string) ([][]string, error) {
+	return c.commandLines(c.driver.EraseCmds(c, d, method))
+}
+
+func (c *Controller) CheckCmds(v *Volume, start bool) ([][]string, error) {
+	return c.commandLines(c.driver.CheckCmds(c, v, start))
+}
+
+func (c *Controller) ExpandCmds(e *Expansion) ([][]string, error) {
+	return c.commandLines(c.driver.ExpandCmds(c, e))
+}
+
+func (c *Controller) ImportForeignCmds(id string) ([][]string, error) {
+	return c.commandLines(c.driver.ImportForeignCmds(c, id))
+}
+
+func (c *Controller) SettingsCmds(spec *ControllerSpec) ([][]string, error) {
+	return c.commandLines(c.driver.SettingsCmds(c, spec))
+}
+
+func (c *Controller) ClearCmds() ([][]string, error) {
+	return c.commandLines(c.driver.ClearCmds(c, false))
+}
+
+func (c *Controller) CreateCmds(v *VolSpec, forceGood bool) ([][]string, error) {
+	return c.commandLines(c.driver.CreateCmds(c, v, forceGood))
+}
+
+func (c *Controller) DeleteCmds(v *Volume) ([][]string, error) {
+	return c.commandLines(c.driver.DeleteCmds(c, v))
+}
+
+func (c *Controller) UpdateCmds(v *Volume, spec *VolSpec) ([][]string, error) {
+	return c.commandLines(c.driver.UpdateCmds(c, v, spec))
+}
+
+func (c *Controller) SetBootCmds(v *Volume) ([][]string, error) {
+	return c.commandLines(c.driver.SetBootCmds(c, v))
+}
+
+// Volume returns the volume with the passed-in ID, or nil if there is
+// no such volume on the controller.
+func (c *Controller) Volume(id string) *Volume {
+	for _, v := range c.Volumes {
+		if v.ID == id {
+			return v
+		}
+	}
+	return nil
+}
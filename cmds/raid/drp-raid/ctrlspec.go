@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ControllerSpec is the wanted controller-wide configuration of the
+// controller at index Controller.  Settings left empty are not changed.
+type ControllerSpec struct {
+	Controller int
+	// Personality is one of raid, hba, or mixed.  raid controllers only
+	// expose volumes to the OS, hba controllers pass every disk through,
+	// and mixed controllers do both.
+	Personality string `json:",omitempty"`
+	// AutoJBOD passes disks that are not part of a volume through to the OS.
+	AutoJBOD *bool `json:",omitempty"`
+}
+
+// Fill validates the spec and normalizes its values.
+func (cs *ControllerSpec) Fill() error {
+	cs.Personality = strings.ToLower(cs.Personality)
+	switch cs.Personality {
+	case "", "raid", "hba", "mixed":
+	default:
+		return fmt.Errorf("Personality must be one of raid,hba,mixed, not `%s`", cs.Personality)
+	}
+	if cs.AutoJBOD != nil {
+		switch {
+		case cs.Personality == "mixed" && !*cs.AutoJBOD:
+			return fmt.Errorf("A mixed personality needs AutoJBOD")
+		case cs.Personality == "raid" && *cs.AutoJBOD:
+			return fmt.Errorf("A raid personality cannot have AutoJBOD, use mixed instead")
+		}
+	}
+	return nil
+}
+
+// Specs is the object form of the volspecs read on stdin, which can also
+// carry controller-wide settings.
+type Specs struct {
+	Controllers []*ControllerSpec `json:",omitempty"`
+	VolSpecs    VolSpecs
+}
+
+// ControllerSettings are the controller-wide settings a ControllerSpec
+// can change, as a controller currently has them.  Settings a driver
+// cannot report are empty.
+type ControllerSettings struct {
+	Personality string `json:",omitempty"`
+	// PendingPersonality is the personality the controller will have
+	// after the next reboot, if that is different from Personality.
+	PendingPersonality string `json:",omitempty"`
+	AutoJBOD           *bool  `json:",omitempty"`
+}
+
+// ControllerChange is a setting -compare found that differs from the
+// wanted ControllerSpec.
+type ControllerChange struct {
+	Controller int
+	Name       string
+	Setting    string
+	Current    string
+	Wanted     string
+	// Pending is set when the change has already been made, and is
+	// waiting for a reboot to take effect.
+	Pending        bool `json:",omitempty"`
+	RebootRequired bool
+}
+
+// personalityMode returns the mode the controller firmware has to run in
+// for a personality.  Changing it needs a reboot, while raid and mixed
+// only differ in whether unused disks are passed through.
+func personalityMode(p string) string {
+	if p == "mixed" {
+		return "raid"
+	}
+	return p
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+var lsiPersonalityRE = regexp.MustCompile(`^\s*(Current|Requested) Personality\s*[=:]?\s+(\S+)`)
+
+// lsiPersonality maps the personalities storcli and perccli report to ours.
+func lsiPersonality(v string) string {
+	v = strings.ToLower(v)
+	switch {
+	case strings.Contains(v, "jbod"), strings.Contains(v, "hba"):
+		return "hba"
+	case strings.Contains(v, "raid"):
+		return "raid"
+	}
+	return ""
+}
+
+// lsiPersonalities finds the current and requested personality in the
+// output of show personality.
+func lsiPersonalities(lines []string) (current, requested string) {
+	for _, line := range lines {
+		matches := lsiPersonalityRE.FindStringSubmatch(line)
+		if len(matches) != 3 {
+			continue
+		}
+		if matches[1] == "Current" {
+			current = matches[2]
+		} else {
+			requested = matches[2]
+		}
+	}
+	return
+}
+
+// lsiSettings fills in settings from the current and requested
+// personality and the Enable JBOD setting of a storcli or perccli
+// controller.
+func lsiSettings(c *Controller, current, requested string) *ControllerSettings {
+	res := &ControllerSettings{Personality: lsiPersonality(current)}
+	if res.Personality == "" {
+		res.Personality = "raid"
+	}
+	if p := lsiPersonality(requested); p != "" && p != res.Personality {
+		res.PendingPersonality = p
+	}
+	if v, ok := c.Info["Enable JBOD"]; ok {
+		jbod := strings.ToLower(v) == "yes"
+		res.AutoJBOD = &jbod
+		if jbod && res.Personality == "raid" {
+			res.Personality = "mixed"
+		}
+	}
+	return res
+}
+
+// lsiSettingsCmds returns the storcli or perccli commands that change the
+// settings in spec.  hba is what the tool calls the hba personality, and
+// suffix is appended to every command.
+func lsiSettingsCmds(c *Controller, spec *ControllerSpec, hba string, suffix ...string) [][]string {
+	res := [][]string{}
+	ctrl := "/c" + c.ID
+	jbod := spec.AutoJBOD
+	switch spec.Personality {
+	case "raid", "mixed":
+		res = append(res, append([]string{ctrl, "set", "personality=RAID"}, suffix...))
+		on := spec.Personality == "mixed"
+		jbod = &on
+	case "hba":
+		res = append(res, append([]string{ctrl, "set", "personality=" + hba}, suffix...))
+	}
+	if jbod != nil {
+		res = append(res, append([]string{ctrl, "set", "jbod=" + onOff(*jbod)}, suffix...))
+	}
+	return res
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+)
+
+const storcliPersonality = `CLI Version = 007.1017.0000.0000 May 10, 2019
+Operating system = Linux 5.4.0
+Controller = 0
+Status = Success
+Description = None
+
+
+Controller Properties :
+=====================
+
+-------------------------------------------
+Prop                    Value
+-------------------------------------------
+Current Personality     RAID-Mode
+Requested Personality   JBOD-Mode
+Supported Personalities RAID-Mode,JBOD-Mode
+-------------------------------------------
+`
+
+func TestControllerSpecs(t *testing.T) {
+	oldExecutor, oldFake := executor, fake
+	defer func() { executor, fake = oldExecutor, oldFake }()
+	fake = false
+	cs := Controllers{ctrlrs(1, "storcli7")[0], ctrlrs(1, "ssacli")[0]}
+	cs[0].Info = map[string]string{"Enable JBOD": "No"}
+	cs[1].ID, cs[1].idx = "1", 1
+	cs[1].Info = map[string]string{"Controller Mode": "Mixed"}
+	newSess := func(in string) *session {
+		return &session{in: strings.NewReader(in), log: log.New(ioutil.Discard, "", 0), controllers: cs}
+	}
+	for _, in := range []string{
+		`{"Controllers": [{"Controller": 2, "Personality": "hba"}]}`,
+		`{"Controllers": [{"Controller": 0, "Personality": "passthrough"}]}`,
+		`{"Controllers": [{"Controller": 0, "Personality": "raid", "AutoJBOD": true}]}`,
+	} {
+		if s := newSess(in).WantedSpecs(); !s.HasError() {
+			t.Errorf("Expected an error for %s", in)
+		}
+	}
+
+	executor = &replayer{calls: []*Call{
+		{Op: "run", Path: "/opt/MegaRAID/storcli7/storcli", Args: []string{"/c0", "show", "personality"}, Stdout: storcliPersonality},
+		{Op: "run", Path: "/opt/MegaRAID/storcli7/storcli", Args: []string{"/c0", "show", "personality"}, Stdout: storcliPersonality},
+	}}
+	s := newSess(`{"Controllers": [{"Controller": 0, "Personality": "HBA"}, {"Controller": 1, "Personality": "raid"}]}`)
+	res, same := s.Compare()
+	if s.HasError() || same {
+		t.Fatalf("Expected differences without errors")
+	}
+	cmp := res.(*Comparison)
+	if len(cmp.Controllers) != 2 || !cmp.RebootRequired || cmp.Volumes != nil {
+		t.Fatalf("Unexpected comparison %+v", cmp)
+	}
+	if c := cmp.Controllers[0]; !c.Pending || c.Current != "raid" || c.Wanted != "hba" {
+		t.Errorf("Expected the hba personality to be pending, got %+v", c)
+	}
+	if c := cmp.Controllers[1]; c.Pending || c.RebootRequired || c.Current != "mixed" || c.Wanted != "raid" {
+		t.Errorf("Expected mixed to raid without a reboot, got %+v", c)
+	}
+	p := s.PlanConfigure(false, false, false)
+	if s.HasError() || len(p.Steps) != 1 || p.Steps[0].Action != "set personality raid" ||
+		strings.Join(p.Steps[0].Commands[0][1:], " ") != "controller slot=1 modify hbamode=off forced" {
+		t.Errorf("Unexpected plan %+v", p)
+	}
+
+	on := true
+	for _, tc := range []struct {
+		spec *ControllerSpec
+		want []string
+	}{
+		{&ControllerSpec{Personality: "hba"}, []string{"/c0 set personality=JBOD"}},
+		{&ControllerSpec{Personality: "mixed"}, []string{"/c0 set personality=RAID", "/c0 set jbod=on"}},
+		{&ControllerSpec{AutoJBOD: &on}, []string{"/c0 set jbod=on"}},
+	} {
+		cmds, err := cs[0].driver.SettingsCmds(cs[0], tc.spec)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		got := []string{}
+		for _, cmd := range cmds {
+			got = append(got, strings.Join(cmd, " "))
+		}
+		if strings.Join(got, "|") != strings.Join(tc.want, "|") {
+			t.Errorf("%+v: expected %v, got %v", tc.spec, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The disk info keys the drivers report the model, serial number, and
+// rotational speed of a disk under, compared without regard to case.
+// MegaCli only reports them run together in Inquiry Data.
+var (
+	diskModelKeys  = []string{"model", "model number", "product id", "inquiry data"}
+	diskSerialKeys = []string{"serial number", "sn", "serial", "inquiry data"}
+	diskSpeedKeys  = []string{"rotational speed", "rotation speed", "rotation rate", "nominal media rotation rate"}
+)
+
+func (v VolSpecDisk) infoValue(keys []string) string {
+	for _, want := range keys {
+		for k, val := range v.info {
+			if strings.EqualFold(k, want) && strings.TrimSpace(val) != "" {
+				return strings.TrimSpace(val)
+			}
+		}
+	}
+	return ""
+}
+
+func (v VolSpecDisk) model() string {
+	return v.infoValue(diskModelKeys)
+}
+
+func (v VolSpecDisk) serial() string {
+	return v.infoValue(diskSerialKeys)
+}
+
+// rotationalSpeed is the speed the disk spins at in RPM, or 0 for disks
+// that do not spin or do not say.
+func (v VolSpecDisk) rotationalSpeed() int64 {
+	return leadingInt(v.infoValue(diskSpeedKeys))
+}
+
+// rangeList matches values against a comma separated list of values and
+// numeric ranges, like "0-3,8".
+type rangeList []string
+
+func parseRangeList(src string) (rangeList, error) {
+	res := rangeList{}
+	for _, item := range strings.Split(src, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			return nil, fmt.Errorf("Empty item in `%s`", src)
+		}
+		if lo, hi, ok := splitRange(item); ok && lo > hi {
+			return nil, fmt.Errorf("Range %s runs backwards", item)
+		}
+		res = append(res, item)
+	}
+	return res, nil
+}
+
+func splitRange(item string) (lo, hi uint64, ok bool) {
+	parts := strings.SplitN(item, "-", 2)
+	if len(parts) != 2 {
+		return
+	}
+	var err error
+	if lo, err = strconv.ParseUint(parts[0], 10, 64); err != nil {
+		return
+	}
+	if hi, err = strconv.ParseUint(parts[1], 10, 64); err != nil {
+		return
+	}
+	return lo, hi, true
+}
+
+func (r rangeList) matches(val string) bool {
+	n, err := strconv.ParseUint(val, 10, 64)
+	for _, item := range r {
+		if item == val {
+			return true
+		}
+		if lo, hi, ok := splitRange(item); ok && err == nil && n >= lo && n <= hi {
+			return true
+		}
+	}
+	return false
+}
+
+// diskSelector is the compiled form of the disk selectors in a VolSpec.
+type diskSelector struct {
+	model, serial     *regexp.Regexp
+	minSize, maxSize  uint64
+	enclosures, slots rangeList
+	speed             int64
+	exclude           VolSpecDisks
+}
+
+func (v *VolSpec) hasSelectors() bool {
+	return v.Model != "" || v.Serial != "" || v.MinDiskSize != "" || v.MaxDiskSize != "" ||
+		v.Enclosures != "" || v.Slots != "" || v.RotationalSpeed != 0 || len(v.ExcludeDisks) > 0
+}
+
+// selector compiles the disk selectors, and returns an error if any of
+// them are not valid.
+func (v *VolSpec) selector() (*diskSelector, error) {
+	res := &diskSelector{speed: v.RotationalSpeed, exclude: v.ExcludeDisks}
+	var err error
+	if v.Model != "" {
+		if res.model, err = regexp.Compile(v.Model); err != nil {
+			return nil, fmt.Errorf("Model `%s` is not a valid regular expression: %v", v.Model, err)
+		}
+	}
+	if v.Serial != "" {
+		if res.serial, err = regexp.Compile(v.Serial); err != nil {
+			return nil, fmt.Errorf("Serial `%s` is not a valid regular expression: %v", v.Serial, err)
+		}
+	}
+	if v.MinDiskSize != "" {
+		if res.minSize, err = sizeParser(v.MinDiskSize); err != nil {
+			return nil, fmt.Errorf("MinDiskSize %s is not a valid size: %v", v.MinDiskSize, err)
+		}
+	}
+	if v.MaxDiskSize != "" {
+		if res.maxSize, err = sizeParser(v.MaxDiskSize); err != nil {
+			return nil, fmt.Errorf("MaxDiskSize %s is not a valid size: %v", v.MaxDiskSize, err)
+		}
+		if res.maxSize < res.minSize {
+			return nil, fmt.Errorf("MaxDiskSize %s is smaller than MinDiskSize %s", v.MaxDiskSize, v.MinDiskSize)
+		}
+	}
+	if v.Enclosures != "" {
+		if res.enclosures, err = parseRangeList(v.Enclosures); err != nil {
+			return nil, fmt.Errorf("Enclosures: %v", err)
+		}
+	}
+	if v.Slots != "" {
+		if res.slots, err = parseRangeList(v.Slots); err != nil {
+			return nil, fmt.Errorf("Slots: %v", err)
+		}
+	}
+	if v.RotationalSpeed < 0 {
+		return nil, fmt.Errorf("RotationalSpeed must not be negative")
+	}
+	return res, nil
+}
+
+func (ds *diskSelector) matches(d VolSpecDisk) bool {
+	switch {
+	case ds.model != nil && !ds.model.MatchString(d.model()):
+	case ds.serial != nil && !ds.serial.MatchString(d.serial()):
+	case ds.minSize > 0 && d.Size < ds.minSize:
+	case ds.maxSize > 0 && d.Size > ds.maxSize:
+	case ds.enclosures != nil && !ds.enclosures.matches(d.Enclosure):
+	case ds.slots != nil && !ds.slots.matches(strconv.FormatUint(d.Slot, 10)):
+	case ds.speed > 0 && d.rotationalSpeed() != ds.speed:
+	case ds.excluded(d):
+	default:
+		return true
+	}
+	return false
+}
+
+func (ds *diskSelector) excluded(d VolSpecDisk) bool {
+	for _, x := range ds.exclude {
+		if x.Equal(d) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectDisks returns the disks that match the disk selectors.
+func (v *VolSpec) selectDisks(s *session, disks VolSpecDisks) (VolSpecDisks, error) {
+	if !v.hasSelectors() {
+		return disks, nil
+	}
+	ds, err := v.selector()
+	if err != nil {
+		return nil, err
+	}
+	res := VolSpecDisks{}
+	for _, d := range disks {
+		if ds.matches(d) {
+			res = append(res, d)
+		}
+	}
+	s.log.Printf("%d of %d disks match the disk selectors", len(res), len(disks))
+	return res, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// eraseMethods are the ways -erase can erase a disk.  crypto throws away
+// the media encryption key of a self encrypting drive, overwrite writes
+// over every block, and block has the drive erase its flash blocks.
+var eraseMethods = []string{"crypto", "overwrite", "block"}
+
+func validEraseMethod(method string) error {
+	for _, m := range eraseMethods {
+		if m == method {
+			return nil
+		}
+	}
+	return fmt.Errorf("Erase method must be one of %s, not `%s`", strings.Join(eraseMethods, ","), method)
+}
+
+// erasable returns an error if d holds data the controller is using.
+func erasable(d *PhysicalDisk) error {
+	switch {
+	case d.VolumeID != "" && !d.JBOD:
+		return fmt.Errorf("Disk %s is part of volume %s, clear the configuration first", d.Name(), d.VolumeID)
+	case d.JBOD:
+		return fmt.Errorf("Disk %s is a JBOD, clear the configuration first", d.Name())
+	case d.HotSpare:
+		return fmt.Errorf("Disk %s is a hot spare, clear the configuration first", d.Name())
+	}
+	return nil
+}
+
+// ErasureReport records the erasure of a single disk, with enough detail
+// about the disk to serve as evidence that it was erased.
+type ErasureReport struct {
+	Controller string
+	Disk       string
+	Model      string `json:",omitempty"`
+	Serial     string `json:",omitempty"`
+	Size       uint64
+	Method     string
+	// Status is one of running, erased, or failed.
+	Status   string
+	Progress float64 `json:",omitempty"`
+	Started  time.Time
+	Finished *time.Time `json:",omitempty"`
+	Error    string     `json:",omitempty"`
+	target   diskTarget
+	// seen is true once the erase has been seen running.
+	seen bool
+}
+
+func newErasureReport(t diskTarget, method string) *ErasureReport {
+	info := VolSpecDisk{info: t.d.Info}
+	return &ErasureReport{
+		Controller: t.c.Name(),
+		Disk:       t.d.Name(),
+		Model:      info.model(),
+		Serial:     info.serial(),
+		Size:       t.d.Size,
+		Method:     method,
+		Status:     "running",
+		Started:    time.Now().UTC(),
+		target:     t,
+	}
+}
+
+func (r *ErasureReport) finish(err error) {
+	now := time.Now().UTC()
+	r.Finished = &now
+	if err != nil {
+		r.Status, r.Error = "failed", err.Error()
+		return
+	}
+	r.Status, r.Progress = "erased", 100
+}
+
+// EraseStatus is where an erase stands.  A status with none of its fields
+// set means the controller reports that no erase is running on the disk,
+// without saying how the last one ended.
+type EraseStatus struct {
+	// Op is the progress of the erase while it is running.
+	Op *BackgroundOp
+	// Erased is true once the controller reports the erase finished.
+	Erased bool
+	// Failure is why the erase stopped, if the controller reports that it
+	// failed or was aborted.
+	Failure string
+}
+
+// lsiDrivePath returns the storcli and perccli path to d.
+func lsiDrivePath(c *Controller, d *PhysicalDisk) string {
+	path := "/c" + c.ID
+	if d.Enclosure != "" {
+		path += "/e" + d.Enclosure
+	}
+	return fmt.Sprintf("%s/s%d", path, d.Slot)
+}
+
+// lsiEraseCmd returns the storcli and perccli command that erases d.
+func lsiEraseCmd(c *Controller, d *PhysicalDisk, method string) []string {
+	path := lsiDrivePath(c, d)
+	switch method {
+	case "crypto":
+		return []string{path, "secureerase", "force"}
+	case "block":
+		return []string{path, "start", "sanitize", "blockerase"}
+	}
+	return []string{path, "start", "erase", "normal"}
+}
+
+// lsiEraseShow returns the storcli and perccli command that shows the
+// progress of an erase, or nil for methods that finish before the erase
+// command returns, whose success is that of the command.
+func lsiEraseShow(c *Controller, d *PhysicalDisk, method string) []string {
+	switch method {
+	case "crypto":
+		return nil
+	case "block":
+		return []string{lsiDrivePath(c, d), "show", "sanitize"}
+	}
+	return []string{lsiDrivePath(c, d), "show", "erase"}
+}
+
+var lsiEraseRowRE = regexp.MustCompile(`^/c\d+(?:/e\d+)?/s\d+\s+(\d+|-)\s+(.*)$`)
+
+// lsiEraseStatus finds the status of the erase of d in the output of
+// lsiEraseShow.
+func lsiEraseStatus(c *Controller, d *PhysicalDisk, lines []string) *EraseStatus {
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if matches := percDriveOpRE.FindStringSubmatch(line); len(matches) == 5 {
+			op := &BackgroundOp{Controller: c.Name(), Disk: d.Name(), Operation: "erase"}
+			op.Progress, _ = progressValue(matches[3])
+			op.Remaining = parseTimeLeft(matches[4])
+			return &EraseStatus{Op: op}
+		}
+		if matches := lsiEraseRowRE.FindStringSubmatch(line); len(matches) == 3 {
+			return eraseStatusFor(matches[2])
+		}
+	}
+	return &EraseStatus{}
+}
+
+// eraseStatusFor turns the status a controller reports for a stopped
+// erase into an EraseStatus.
+func eraseStatusFor(status string) *EraseStatus {
+	lower := strings.ToLower(status)
+	switch {
+	case strings.Contains(lower, "fail"), strings.Contains(lower, "abort"),
+		strings.Contains(lower, "error"), strings.Contains(lower, "stopped"):
+		return &EraseStatus{Failure: status}
+	case strings.Contains(lower, "not in progress"):
+		return &EraseStatus{}
+	case strings.Contains(lower, "complete"), strings.Contains(lower, "success"):
+		return &EraseStatus{Erased: true}
+	}
+	return &EraseStatus{}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+const storcliShowErase = `CLI Version = 007.1017.0000.0000 May 10, 2019
+Operating system = Linux 5.4.0
+Controller = 0
+Status = Success
+Description = Show Drive Erase Status Succeeded.
+
+
+------------------------------------------------------
+Drive-ID    Progress% Status      Estimated Time Left
+------------------------------------------------------
+/c0/e32/s2         45 In progress 20 Minutes
+------------------------------------------------------
+`
+
+const ssacliDriveErasing = `
+Smart Array P440ar in Slot 0 (Embedded)
+
+   physicaldrive 32:2
+      Port: 1I
+      Status: Erase In Progress
+      Erase Progress: 30%
+`
+
+const ssacliDriveEraseError = `
+Smart Array P440ar in Slot 0 (Embedded)
+
+   physicaldrive 32:2
+      Port: 1I
+      Status: Erase Error
+`
+
+func TestErase(t *testing.T) {
+	oldExecutor, oldFake := executor, fake
+	defer func() { executor, fake = oldExecutor, oldFake }()
+	fake = false
+	newSess := func(driver string) *session {
+		cs := ctrlrs(1, driver)
+		c := cs[0].addDisks(3, 1<<40, "sas", "disk")
+		for i, d := range c.Disks {
+			d.Enclosure = "32"
+			d.Info = map[string]string{"Model": "HUS726T4TAL", "SN": "V6G0" + string(rune('A'+i))}
+		}
+		c.Disks[0].VolumeID = "0"
+		c.Disks[1].HotSpare = true
+		s := &session{log: log.New(ioutil.Discard, "", 0), controllers: cs}
+		c.driver.Logger(s.log)
+		return s
+	}
+
+	for _, tc := range []struct{ sel, method string }{
+		{"32:0", "overwrite"},
+		{"32:1", "overwrite"},
+		{"all", "overwrite"},
+		{"32:2", "shred"},
+		{"32:9", "crypto"},
+	} {
+		s := newSess("storcli7")
+		if s.PlanErase(tc.sel, tc.method); !s.HasError() {
+			t.Errorf("Expected an error erasing %s with %s", tc.sel, tc.method)
+		}
+	}
+
+	for _, tc := range []struct {
+		driver, method, want string
+	}{
+		{"megacli", "crypto", "-PDInstantSecureErase -PhysDrv [32:2] -Force -a0"},
+		{"megacli", "overwrite", "-PDClear -Start -PhysDrv [32:2] -a0"},
+		{"storcli7", "crypto", "/c0/e32/s2 secureerase force"},
+		{"perccli", "block", "/c0/e32/s2 start sanitize blockerase"},
+		{"perccli-json", "overwrite", "/c0/e32/s2 start erase normal J"},
+		{"ssacli", "crypto", "controller slot=0 physicaldrive 32:2 modify erase erasepattern=crypto forced"},
+	} {
+		s := newSess(tc.driver)
+		p := s.PlanErase("32:2", tc.method)
+		if s.HasError() || len(p.Steps) != 1 || strings.Join(p.Steps[0].Commands[0][1:], " ") != tc.want {
+			t.Errorf("%s %s: expected `%s`, got %+v", tc.driver, tc.method, tc.want, p)
+		}
+	}
+	s := newSess("megacli")
+	if s.PlanErase("32:2", "block"); !s.HasError() {
+		t.Errorf("Expected megacli block erase to be unsupported")
+	}
+
+	storcli := "/opt/MegaRAID/storcli7/storcli"
+	executor = &replayer{calls: []*Call{
+		{Op: "run", Path: storcli, Args: []string{"/c0/e32/s2", "start", "erase", "normal"}},
+		{Op: "run", Path: storcli, Args: []string{"/c0/e32/s2", "show", "erase"}, Stdout: storcliShowErase},
+		{Op: "run", Path: storcli, Args: []string{"/c0/e32/s2", "show", "erase"}, Stdout: "Status = Success\n"},
+	}}
+	s = newSess("storcli7")
+	reports := s.Erase("32:2", "overwrite", true, time.Millisecond, 0)
+	if s.HasError() || len(reports) != 1 {
+		t.Fatalf("Expected one erased disk, got %+v", reports)
+	}
+	if r := reports[0]; r.Status != "erased" || r.Progress != 100 || r.Serial != "V6G0C" || r.Finished == nil ||
+		r.Finished.Before(r.Started) {
+		t.Errorf("Unexpected report %+v", r)
+	}
+	if unused := executor.(*replayer).Unused(); len(unused) != 0 {
+		t.Errorf("Expected every command to be run, %d were not", len(unused))
+	}
+
+	ssacli := "/opt/smartstorageadmin/ssacli/bin/ssacli"
+	show := []string{"controller", "slot=0", "physicaldrive", "32:2", "show"}
+	executor = &replayer{calls: []*Call{
+		{Op: "run", Path: ssacli, Combined: true, Args: []string{"controller", "slot=0", "physicaldrive", "32:2", "modify", "erase",
+			"erasepattern=overwrite", "forced"}},
+		{Op: "run", Path: ssacli, Combined: true, Args: show, Stdout: ssacliDriveErasing},
+		{Op: "run", Path: ssacli, Combined: true, Args: show, Stdout: ssacliDriveEraseError},
+	}}
+	s = newSess("ssacli")
+	reports = s.Erase("32:2", "overwrite", true, time.Millisecond, 0)
+	if !s.HasError() || len(reports) != 1 {
+		t.Fatalf("Expected the failed erase to be an error, got %+v", reports)
+	}
+	if r := reports[0]; r.Status != "failed" || r.Error != "Erase Error" || r.Progress != 30 {
+		t.Errorf("Unexpected report for a failed erase %+v", r)
+	}
+
+	executor = &replayer{calls: []*Call{
+		{Op: "run", Path: storcli, Args: []string{"/c0/e32/s2", "start", "erase", "normal"}},
+		{Op: "run", Path: storcli, Args: []string{"/c0/e32/s2", "show", "erase"},
+			Stdout: "/c0/e32/s2         -  Not in progress -\n"},
+	}}
+	s = newSess("storcli7")
+	reports = s.Erase("32:2", "overwrite", true, time.Millisecond, 0)
+	if !s.HasError() || len(reports) != 1 || reports[0].Status != "failed" {
+		t.Errorf("Expected an erase that never ran to fail, got %+v", reports)
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Executor is how the drivers talk to the system.  Everything a driver
+// needs to run or read to find and configure its controllers goes
+// through the current executor, so that it can be recorded on a real
+// system and replayed later without the hardware.
+type Executor interface {
+	// Stat returns the mode of the passed-in file.
+	Stat(name string) (os.FileMode, error)
+	// Run runs executable with args.  If combined is true, stderr is
+	// collected along with stdout and the returned stderr is empty.
+	Run(combined bool, executable string, args ...string) (stdout, stderr []byte, err error)
+	// ReadFile returns the contents of the passed-in file.
+	ReadFile(name string) ([]byte, error)
+	// ReadDir returns the sorted names of the entries in the passed-in
+	// directory.
+	ReadDir(name string) ([]string, error)
+	// EvalSymlinks returns the passed-in path with its symlinks resolved.
+	EvalSymlinks(name string) (string, error)
+}
+
+var executor Executor = shellExecutor{}
+
+// shellExecutor runs commands and reads files on the running system.
+type shellExecutor struct{}
+
+func (shellExecutor) Stat(name string) (os.FileMode, error) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return 0, err
+	}
+	return fi.Mode(), nil
+}
+
+func (shellExecutor) Run(combined bool, executable string, args ...string) ([]byte, []byte, error) {
+	cmd := exec.Command(executable, args...)
+	outBuf, errBuf := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd.Stdout, cmd.Stderr = outBuf, errBuf
+	if combined {
+		cmd.Stderr = outBuf
+	}
+	err := cmd.Run()
+	return outBuf.Bytes(), errBuf.Bytes(), err
+}
+
+func (shellExecutor) ReadFile(name string) ([]byte, error) {
+	return ioutil.ReadFile(name)
+}
+
+func (shellExecutor) ReadDir(name string) ([]string, error) {
+	entries, err := ioutil.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, len(entries))
+	for i, entry := range entries {
+		res[i] = entry.Name()
+	}
+	return res, nil
+}
+
+func (shellExecutor) EvalSymlinks(name string) (string, error) {
+	return filepath.EvalSymlinks(name)
+}
+
+// Call is a single recorded interaction with the system.
+type Call struct {
+	Op       string
+	Path     string
+	Args     []string    `json:",omitempty"`
+	Combined bool        `json:",omitempty"`
+	Stdout   string      `json:",omitempty"`
+	Stderr   string      `json:",omitempty"`
+	Names    []string    `json:",omitempty"`
+	Mode     os.FileMode `json:",omitempty"`
+	Error    string      `json:",omitempty"`
+	used     bool
+}
+
+func (c *Call) err() error {
+	if c.Error == "" {
+		return nil
+	}
+	return fmt.Errorf("%s", c.Error)
+}
+
+func (c *Call) matches(op, path string, combined bool, args []string) bool {
+	if c.Op != op || c.Path != path || c.Combined != combined {
+		return false
+	}
+	if len(c.Args) == 0 && len(args) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(c.Args, args)
+}
+
+func (c *Call) String() string {
+	return strings.TrimSpace(fmt.Sprintf("%s %s %s", c.Op, c.Path, strings.Join(c.Args, " ")))
+}
+
+// recorder passes everything through to the system executor and writes
+// every call it sees to a file.  The file is rewritten after every
+// call, so it is complete no matter how drp-raid exits.  The passwords
+// in secrets are redacted from what is written, and the file is only
+// readable by its owner.
+type recorder struct {
+	sync.Mutex
+	dest    string
+	calls   []*Call
+	secrets *Secrets
+}
+
+func newRecorder(dest string) *recorder {
+	return &recorder{dest: dest, calls: []*Call{}}
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func (r *recorder) record(c *Call) {
+	r.Lock()
+	defer r.Unlock()
+	c.Args = r.secrets.redact([][]string{c.Args})[0]
+	c.Stdout, c.Stderr = r.secrets.redactString(c.Stdout), r.secrets.redactString(c.Stderr)
+	c.Error = r.secrets.redactString(c.Error)
+	r.calls = append(r.calls, c)
+	buf, err := json.MarshalIndent(r.calls, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(r.dest, buf, 0600)
+	}
+	if err == nil {
+		// WriteFile leaves the mode of an existing file alone.
+		err = os.Chmod(r.dest, 0600)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving recorded calls to %s: %v\n", r.dest, err)
+	}
+}
+
+func (r *recorder) Stat(name string) (os.FileMode, error) {
+	mode, err := shellExecutor{}.Stat(name)
+	r.record(&Call{Op: "stat", Path: name, Mode: mode, Error: errString(err)})
+	return mode, err
+}
+
+func (r *recorder) Run(combined bool, executable string, args ...string) ([]byte, []byte, error) {
+	stdout, stderr, err := shellExecutor{}.Run(combined, executable, args...)
+	r.record(&Call{
+		Op:       "run",
+		Path:     executable,
+		Args:     args,
+		Combined: combined,
+		Stdout:   string(stdout),
+		Stderr:   string(stderr),
+		Error:    errString(err),
+	})
+	return stdout, stderr, err
+}
+
+func (r *recorder) ReadFile(name string) ([]byte, error) {
+	buf, err := shellExecutor{}.ReadFile(name)
+	r.record(&Call{Op: "readfile", Path: name, Stdout: string(buf), Error: errString(err)})
+	return buf, err
+}
+
+func (r *recorder) ReadDir(name string) ([]string, error) {
+	names, err := shellExecutor{}.ReadDir(name)
+	r.record(&Call{Op: "readdir", Path: name, Names: names, Error: errString(err)})
+	return names, err
+}
+
+func (r *recorder) EvalSymlinks(name string) (string, error) {
+	real, err := shellExecutor{}.EvalSymlinks(name)
+	r.record(&Call{Op: "evalsymlinks", Path: name, Stdout: real, Error: errString(err)})
+	return real, err
+}
+
+// replayer answers calls from ones saved by a recorder.  Each call is
+// answered by the first recorded call with the same arguments that has
+// not been used yet, so the same command can return different output
+// before and after a change.  Calls that were not recorded fail.  The
+// passwords in secrets are redacted from the arguments before they are
+// matched, as they were when they were recorded.
+type replayer struct {
+	sync.Mutex
+	calls   []*Call
+	missed  []*Call
+	secrets *Secrets
+}
+
+func newReplayer(src string) (*replayer, error) {
+	buf, err := ioutil.ReadFile(src)
+	if err != nil {
+		return nil, err
+	}
+	r := &replayer{}
+	if err := json.Unmarshal(buf, &r.calls); err != nil {
+		return nil, fmt.Errorf("Error parsing recorded calls in %s: %v", src, err)
+	}
+	return r, nil
+}
+
+func (r *replayer) find(op, path string, combined bool, args []string) (*Call, error) {
+	r.Lock()
+	defer r.Unlock()
+	if args != nil {
+		args = r.secrets.redact([][]string{args})[0]
+	}
+	for _, c := range r.calls {
+		if !c.used && c.matches(op, path, combined, args) {
+			c.used = true
+			return c, nil
+		}
+	}
+	want := &Call{Op: op, Path: path, Args: args, Combined: combined}
+	r.missed = append(r.missed, want)
+	return nil, fmt.Errorf("No recorded call for `%s`", want)
+}
+
+// Missed returns the calls that had no recorded answer.
+func (r *replayer) Missed() []*Call {
+	r.Lock()
+	defer r.Unlock()
+	return append([]*Call{}, r.missed...)
+}
+
+// Unused returns the recorded calls that have not been replayed.
+func (r *replayer) Unused() []*Call {
+	r.Lock()
+	defer r.Unlock()
+	res := []*Call{}
+	for _, c := range r.calls {
+		if !c.used {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+func (r *replayer) Stat(name string) (os.FileMode, error) {
+	c, err := r.find("stat", name, false, nil)
+	if err != nil {
+		return 0, err
+	}
+	return c.Mode, c.err()
+}
+
+func (r *replayer) Run(combined bool, executable string, args ...string) ([]byte, []byte, error) {
+	c, err := r.find("run", executable, combined, args)
+	if err != nil {
+		return nil, nil, err
+	}
+	return []byte(c.Stdout), []byte(c.Stderr), c.err()
+}
+
+func (r *replayer) ReadFile(name string) ([]byte, error) {
+	c, err := r.find("readfile", name, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(c.Stdout), c.err()
+}
+
+func (r *replayer) ReadDir(name string) ([]string, error) {
+	c, err := r.find("readdir", name, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Names, c.err()
+}
+
+func (r *replayer) EvalSymlinks(name string) (string, error) {
+	c, err := r.find("evalsymlinks", name, false, nil)
+	if err != nil {
+		return "", err
+	}
+	return c.Stdout, c.err()
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Expansion is an existing volume that -expand will grow onto more
+// disks, migrate to a different RAID level, or both.
+type Expansion struct {
+	Controller int
+	VolumeID   string
+	// From and To are the current and wanted RAID levels.
+	From string
+	To   string
+	// Added is the disks being added to the volume.
+	Added []string `json:",omitempty"`
+	// Size is the usable size of the volume once the expansion is done.
+	Size   uint64
+	volume *Volume
+	disks  []*PhysicalDisk
+}
+
+// expandVolume finds the existing volume spec wants expanded.  That is
+// the volume with the spec's VolumeID, or failing that the volume whose
+// disks are all in the spec.
+func expandVolume(c *Controller, spec *VolSpec) (*Volume, error) {
+	if spec.VolumeID != "" {
+		v := c.Volume(spec.VolumeID)
+		if v == nil || v.Fake {
+			return nil, fmt.Errorf("No volume %s on %s", spec.VolumeID, c.Name())
+		}
+		return v, nil
+	}
+	for _, v := range c.Volumes {
+		if v.Fake || len(v.Disks) == 0 {
+			continue
+		}
+		matched := true
+		for _, d := range v.Disks {
+			if len(spec.Disks.Contains(d.volSpecDisk())) == 0 {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("No volume on %s has all its disks in %s", c.Name(), spec.Key())
+}
+
+// newExpansion works out what it would take to turn v into spec, and
+// checks that the raid level math allows it.  Only a single span can be
+// expanded, and the volume cannot lose disks or usable space.
+func newExpansion(c *Controller, v *Volume, spec *VolSpec) (*Expansion, error) {
+	res := &Expansion{Controller: c.idx, VolumeID: v.ID, From: v.RaidLevel, To: spec.RaidLevel, volume: v}
+	if res.To == "" {
+		res.To = res.From
+	}
+	from, ok := raidLevels[res.From]
+	if !ok {
+		return nil, fmt.Errorf("Volume %s is %s, which cannot be expanded", v.ID, res.From)
+	}
+	to, ok := raidLevels[res.To]
+	if !ok {
+		return nil, fmt.Errorf("Raid level '%s' is not supported", res.To)
+	}
+	for _, lvl := range []string{res.From, res.To} {
+		switch lvl {
+		case "jbod", "raidS", "concat", "namespace":
+			return nil, fmt.Errorf("Cannot expand or migrate %s volumes", lvl)
+		}
+		if raidLevels[lvl].spanned {
+			return nil, fmt.Errorf("Cannot expand or migrate spanned %s volumes", lvl)
+		}
+	}
+	if len(c.RaidLevels) > 0 {
+		found := false
+		for _, lvl := range c.RaidLevels {
+			found = found || lvl == res.To
+		}
+		if !found {
+			return nil, fmt.Errorf("%s does not support %s", c.Name(), res.To)
+		}
+	}
+	current := map[string]bool{}
+	for _, d := range v.Disks {
+		current[d.Name()] = true
+	}
+	for _, sd := range spec.Disks {
+		var d *PhysicalDisk
+		for _, pd := range c.Disks {
+			if pd.Enclosure == sd.Enclosure && pd.Slot == sd.Slot {
+				d = pd
+				break
+			}
+		}
+		if d == nil {
+			return nil, fmt.Errorf("No disk %s:%d on %s", sd.Enclosure, sd.Slot, c.Name())
+		}
+		if current[d.Name()] {
+			delete(current, d.Name())
+			continue
+		}
+		if d.VolumeID != "" || d.HotSpare || d.JBOD {
+			return nil, fmt.Errorf("Disk %s is already in use", d.Name())
+		}
+		res.disks = append(res.disks, d)
+		res.Added = append(res.Added, d.Name())
+	}
+	if len(spec.Disks) > 0 && len(current) > 0 {
+		return nil, fmt.Errorf("Cannot remove disks from volume %s", v.ID)
+	}
+	total := uint64(len(v.Disks) + len(res.disks))
+	if min := to.minDisks(1); total < min {
+		return nil, fmt.Errorf("%s needs at least %d disks, volume %s would have %d", res.To, min, v.ID, total)
+	}
+	// Every disk, old and new, has to hold as much of the volume as the
+	// current disks do.
+	perDisk := from.perDiskSize(1, uint64(len(v.Disks)), v.Size)
+	for _, d := range res.disks {
+		if d.Size < perDisk {
+			return nil, fmt.Errorf("Disk %s is too small, it needs at least %d bytes", d.Name(), perDisk)
+		}
+	}
+	res.Size = to.targetUseableSize(1, total, perDisk)
+	if res.Size < v.Size {
+		return nil, fmt.Errorf("Migrating volume %s to %s on %d disks would shrink it from %d to %d bytes",
+			v.ID, res.To, total, v.Size, res.Size)
+	}
+	return res, nil
+}
+
+// noop is true if the expansion would not change anything.
+func (e *Expansion) noop() bool {
+	return e.From == e.To && len(e.disks) == 0
+}
+
+func (e *Expansion) String() string {
+	res := fmt.Sprintf("%s volume %s", e.From, e.VolumeID)
+	if e.From != e.To {
+		res += " to " + e.To
+	}
+	if len(e.Added) > 0 {
+		res += " adding " + strings.Join(e.Added, ",")
+	}
+	return res
+}
+
+// driveList formats disks the way storcli, perccli, and ssacli want them
+// in drives= arguments.
+func driveList(disks []*PhysicalDisk) string {
+	parts := make([]string, len(disks))
+	for i, d := range disks {
+		parts[i] = d.Name()
+	}
+	return strings.Join(parts, ",")
+}
+
+// lsiMigrateCmd returns the storcli and perccli command that starts e.
+func lsiMigrateCmd(c *Controller, e *Expansion) []string {
+	cmd := []string{fmt.Sprintf("/c%s/v%s", c.ID, e.VolumeID), "start", "migrate", "type=" + e.To}
+	if len(e.disks) > 0 {
+		cmd = append(cmd, "option=add", "drives="+driveList(e.disks))
+	}
+	return cmd
+}
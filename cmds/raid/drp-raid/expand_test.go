@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+)
+
+func expandControllers(driver string) Controllers {
+	cs := ctrlrs(1, driver)
+	c := cs[0].addDisks(4, 1<<40, "sas", "disk").addDisks(1, 1<<30, "sas", "disk")
+	for _, d := range c.Disks {
+		d.Enclosure = "32"
+	}
+	v := &Volume{ID: "0", RaidLevel: "raid1", Size: c.Disks[0].Size, Disks: c.Disks[:2], controller: c, driver: c.driver}
+	for _, d := range v.Disks {
+		d.VolumeID, d.volume = v.ID, v
+	}
+	c.Volumes = append(c.Volumes, v)
+	return cs
+}
+
+func TestExpand(t *testing.T) {
+	newSess := func(cs Controllers, in string) *session {
+		return &session{in: strings.NewReader(in), log: log.New(ioutil.Discard, "", 0), controllers: cs}
+	}
+	for _, tc := range []struct {
+		name, in string
+	}{
+		{"unknown volume", `[{"Controller": 0, "VolumeID": "7", "RaidLevel": "raid5"}]`},
+		{"spanned", `[{"Controller": 0, "VolumeID": "0", "RaidLevel": "raid10", "Disks": [{"Enclosure": "32", "Slot": 0}, {"Enclosure": "32", "Slot": 1}, {"Enclosure": "32", "Slot": 2}, {"Enclosure": "32", "Slot": 3}]}]`},
+		{"too few disks", `[{"Controller": 0, "VolumeID": "0", "RaidLevel": "raid6", "Disks": [{"Enclosure": "32", "Slot": 0}, {"Enclosure": "32", "Slot": 1}, {"Enclosure": "32", "Slot": 2}]}]`},
+		{"removed disk", `[{"Controller": 0, "VolumeID": "0", "RaidLevel": "raid0", "Disks": [{"Enclosure": "32", "Slot": 0}, {"Enclosure": "32", "Slot": 2}]}]`},
+		{"small disk", `[{"Controller": 0, "RaidLevel": "raid5", "Disks": [{"Enclosure": "32", "Slot": 0}, {"Enclosure": "32", "Slot": 1}, {"Enclosure": "32", "Slot": 4}]}]`},
+		{"missing disk", `[{"Controller": 0, "RaidLevel": "raid5", "Disks": [{"Enclosure": "32", "Slot": 0}, {"Enclosure": "32", "Slot": 1}, {"Enclosure": "32", "Slot": 9}]}]`},
+	} {
+		s := newSess(expandControllers("storcli7"), tc.in)
+		if s.PlanExpand(); !s.HasError() {
+			t.Errorf("%s: expected an error", tc.name)
+		}
+	}
+
+	same := newSess(expandControllers("storcli7"), `[{"Controller": 0, "RaidLevel": "raid1", "Disks": [{"Enclosure": "32", "Slot": 0}, {"Enclosure": "32", "Slot": 1}]}]`)
+	if p := same.PlanExpand(); same.HasError() || len(p.Steps) != 0 {
+		t.Errorf("Expected nothing to do, got %+v", p)
+	}
+
+	in := `[{"Controller": 0, "RaidLevel": "raid5", "Disks": [{"Enclosure": "32", "Slot": 0}, {"Enclosure": "32", "Slot": 1}, {"Enclosure": "32", "Slot": 2}]}]`
+	for driver, want := range map[string]string{
+		"storcli7":     "/c0/v0 start migrate type=raid5 option=add drives=32:2",
+		"megacli":      "-LDRecon -Start -r5 -Add -PhysDrv[32:2] -L0 -a0",
+		"perccli-json": "/c0/v0 start migrate type=raid5 option=add drives=32:2 J",
+	} {
+		s := newSess(expandControllers(driver), in)
+		exps := s.Expansions()
+		if s.HasError() || len(exps) != 1 {
+			t.Fatalf("%s: expected one expansion", driver)
+		}
+		if e := exps[0]; e.From != "raid1" || e.To != "raid5" || e.Size != 2*e.volume.Size {
+			t.Errorf("%s: unexpected expansion %+v", driver, e)
+		}
+		p := s.PlanExpand()
+		if s.HasError() || len(p.Steps) != 1 || len(p.Steps[0].Commands) != 1 ||
+			strings.Join(p.Steps[0].Commands[0][1:], " ") != want {
+			t.Errorf("%s: expected `%s`, got %+v", driver, want, p)
+		}
+	}
+
+	cs := expandControllers("ssacli")
+	cs[0].Volumes[0].Info = map[string]string{"Array": "A"}
+	s := newSess(cs, in)
+	p := s.PlanExpand()
+	got := []string{}
+	for _, cmd := range p.Steps[0].Commands {
+		got = append(got, strings.Join(cmd[1:], " "))
+	}
+	want := []string{
+		"controller slot=0 array A add drives=32:2 forced",
+		"controller slot=0 ld 0 modify size=max forced",
+		"controller slot=0 ld 0 modify raid=5 forced",
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("ssacli: expected %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/VictorLowther/jsonpatch2/utils"
+)
+
+// ForeignConfig is a RAID configuration on disks that were moved from
+// another controller.  The controller will not use the disks until the
+// configuration is imported or cleared.
+type ForeignConfig struct {
+	Controller string
+	// ID picks the configuration out for -import-foreign.
+	ID         string
+	Disks      []string
+	Volumes    []*ForeignVolume `json:",omitempty"`
+	controller *Controller
+}
+
+// ForeignVolume is a volume that importing a ForeignConfig would create.
+type ForeignVolume struct {
+	ID        string
+	Name      string `json:",omitempty"`
+	RaidLevel string
+	Size      uint64
+}
+
+// hasForeignDisks is true if any disk on the controller has a foreign
+// configuration on it.
+func (c *Controller) hasForeignDisks() bool {
+	for _, d := range c.Disks {
+		if d.Foreign {
+			return true
+		}
+	}
+	return false
+}
+
+// mcliForeignCountRE matches the -CfgForeign -Scan summary, which says
+// There is no foreign configuration when there are none.
+var mcliForeignCountRE = regexp.MustCompile(`There (?:are|is) (\d+) foreign configuration`)
+
+// mcliForeignConfig parses the output of -CfgForeign -Dsply, which lists
+// each volume followed by the disks in its disk group.
+func (m *MegaCli) mcliForeignConfig(c *Controller, id string, out []string) *ForeignConfig {
+	res := &ForeignConfig{Controller: c.Name(), ID: id, Disks: []string{}}
+	var section []string
+	flush := func() {
+		if section == nil {
+			return
+		}
+		vol := &Volume{Info: map[string]string{}}
+		m.fillVolume(vol, section)
+		res.Volumes = append(res.Volumes, &ForeignVolume{ID: vol.ID, Name: vol.Name, RaidLevel: vol.RaidLevel, Size: vol.Size})
+		section = nil
+	}
+	d := &PhysicalDisk{}
+	for _, line := range out {
+		switch {
+		case mcliVolRE.MatchString(line):
+			flush()
+			section = []string{line}
+		case mcliEnclLine.MatchString(line):
+			flush()
+			d.Enclosure = strings.TrimSpace(mcliEnclLine.FindStringSubmatch(line)[1])
+			if d.Enclosure == "N/A" {
+				d.Enclosure = ""
+			}
+		case section != nil:
+			section = append(section, line)
+		default:
+			if k, v := kv(line, ":"); k == "Slot Number" {
+				d.Slot, _ = strconv.ParseUint(v, 10, 64)
+				res.Disks = append(res.Disks, d.Name())
+			}
+		}
+	}
+	flush()
+	return res
+}
+
+// lsiField is a storcli JSON table cell, which is a number for some rows
+// and a string like "-" for others.
+type lsiField string
+
+func (f *lsiField) UnmarshalJSON(buf []byte) error {
+	var s string
+	if err := json.Unmarshal(buf, &s); err != nil {
+		s = string(buf)
+	}
+	*f = lsiField(strings.TrimSpace(s))
+	return nil
+}
+
+// lsiForeignConfigs parses the output of /cX/fall show all J, which has
+// the foreign drive group topology and the volumes in them.
+func lsiForeignConfigs(c *Controller, out string) ([]*ForeignConfig, error) {
+	cc := &struct {
+		Controllers []*PercJsonCommand
+	}{}
+	if err := json.Unmarshal([]byte(out), cc); err != nil {
+		return nil, err
+	}
+	if len(cc.Controllers) == 0 {
+		return nil, fmt.Errorf("No controller in foreign config output")
+	}
+	data := map[string]json.RawMessage{}
+	utils.Remarshal(cc.Controllers[0].ResponseData, &data)
+	type topoRow struct {
+		DG      lsiField
+		EIDSlot lsiField `json:"EID:Slot"`
+		Type    lsiField
+	}
+	type vdRow struct {
+		DGVD lsiField `json:"DG/VD"`
+		TYPE lsiField
+		Size string
+		Name string
+	}
+	groups := map[string]*ForeignConfig{}
+	group := func(dg string) *ForeignConfig {
+		if res, ok := groups[dg]; ok {
+			return res
+		}
+		groups[dg] = &ForeignConfig{Controller: c.Name(), ID: dg, Disks: []string{}}
+		return groups[dg]
+	}
+	for k, raw := range data {
+		key := strings.ToUpper(k)
+		switch {
+		case strings.Contains(key, "VD LIST"):
+			rows := []*vdRow{}
+			if json.Unmarshal(raw, &rows) != nil {
+				continue
+			}
+			for _, row := range rows {
+				parts := strings.SplitN(string(row.DGVD), "/", 2)
+				if len(parts) != 2 {
+					continue
+				}
+				vol := &ForeignVolume{ID: parts[1], Name: row.Name, RaidLevel: storcliRaidLevel(string(row.TYPE))}
+				vol.Size, _ = sizeParser(row.Size)
+				cfg := group(parts[0])
+				cfg.Volumes = append(cfg.Volumes, vol)
+			}
+		case strings.Contains(key, "FOREIGN"):
+			rows := []*topoRow{}
+			if json.Unmarshal(raw, &rows) != nil {
+				continue
+			}
+			for _, row := range rows {
+				if row.Type != "DRIVE" {
+					continue
+				}
+				parts := strings.SplitN(string(row.EIDSlot), ":", 2)
+				if len(parts) != 2 {
+					continue
+				}
+				d := &PhysicalDisk{Enclosure: strings.TrimSpace(parts[0])}
+				d.Slot, _ = strconv.ParseUint(parts[1], 10, 64)
+				cfg := group(string(row.DG))
+				cfg.Disks = append(cfg.Disks, d.Name())
+			}
+		}
+	}
+	res := []*ForeignConfig{}
+	for _, cfg := range groups {
+		res = append(res, cfg)
+	}
+	sort.Slice(res, func(i, j int) bool { return foreignIDLess(res[i].ID, res[j].ID) })
+	return res, nil
+}
+
+// foreignIDLess sorts foreign config IDs numerically.
+func foreignIDLess(a, b string) bool {
+	an, aerr := strconv.Atoi(a)
+	bn, berr := strconv.Atoi(b)
+	if aerr != nil || berr != nil {
+		return a < b
+	}
+	return an < bn
+}
+
+// lsiImportForeignCmd returns the storcli and perccli command that imports
+// foreign configs.  They can only import all of them at once.
+func lsiImportForeignCmd(c *Controller, id string) ([]string, error) {
+	if id != "all" {
+		return nil, fmt.Errorf("Only all of the foreign configs can be imported at once, not just %s", id)
+	}
+	return []string{"/c" + c.ID + "/fall", "import"}, nil
+}
+
+// foreignImport is the foreign configs picked on one controller.
+type foreignImport struct {
+	c *Controller
+	// ids is either just "all", or the IDs highest first, because
+	// importing a config renumbers the ones after it.
+	ids []string
+}
+
+// foreignImports returns the foreign configs matching the passed-in comma
+// separated selectors.  A selector is the ID of a foreign config, or
+// all, with an optional controller index and a slash in front, as in
+// 1/0.  Selectors that match nothing are an error.
+func (s *session) foreignImports(selectors string) []*foreignImport {
+	configs := map[*Controller][]*ForeignConfig{}
+	picked := map[*Controller]map[string]struct{}{}
+	for _, sel := range strings.Split(selectors, ",") {
+		sel = strings.TrimSpace(sel)
+		if sel == "" {
+			continue
+		}
+		controllers, id, ok := s.selectorControllers(sel)
+		if !ok {
+			continue
+		}
+		found := false
+		for _, c := range controllers {
+			cfgs, seen := configs[c]
+			if !seen {
+				var err error
+				if cfgs, err = c.ForeignConfigs(); err != nil {
+					s.Errorf("Cannot get the foreign configs of %s: %v", c.Name(), err)
+				}
+				configs[c] = cfgs
+			}
+			for _, cfg := range cfgs {
+				if id != "all" && cfg.ID != id {
+					continue
+				}
+				if picked[c] == nil {
+					picked[c] = map[string]struct{}{}
+				}
+				picked[c][cfg.ID] = struct{}{}
+				found = true
+			}
+		}
+		if !found {
+			s.Errorf("No foreign configs match %s", sel)
+		}
+	}
+	res := []*foreignImport{}
+	for _, c := range s.controllers {
+		if len(picked[c]) == 0 {
+			continue
+		}
+		fi := &foreignImport{c: c, ids: []string{"all"}}
+		if len(picked[c]) != len(configs[c]) {
+			fi.ids = []string{}
+			for id := range picked[c] {
+				fi.ids = append(fi.ids, id)
+			}
+			sort.Slice(fi.ids, func(i, j int) bool { return foreignIDLess(fi.ids[j], fi.ids[i]) })
+		}
+		res = append(res, fi)
+	}
+	return res
+}
+
+// ForeignConfigs returns the foreign configs on every controller.
+func (s *session) ForeignConfigs() []*ForeignConfig {
+	res := []*ForeignConfig{}
+	for _, c := range s.controllers {
+		cfgs, err := c.ForeignConfigs()
+		if err != nil {
+			s.Errorf("Cannot get the foreign configs of %s: %v", c.Name(), err)
+			continue
+		}
+		res = append(res, cfgs...)
+	}
+	return res
+}
+
+// ImportForeign imports the foreign configs matching the passed-in
+// selectors.  Every import is checked before any are run.
+func (s *session) ImportForeign(selectors string) {
+	imports := s.foreignImports(selectors)
+	for _, fi := range imports {
+		for _, id := range fi.ids {
+			if _, err := fi.c.ImportForeignCmds(id); err != nil {
+				s.Errorf("Cannot import foreign config %s on %s: %v", id, fi.c.Name(), err)
+			}
+		}
+	}
+	if s.HasError() {
+		return
+	}
+	for _, fi := range imports {
+		for _, id := range fi.ids {
+			s.log.Printf("Importing foreign config %s on %s", id, fi.c.Name())
+			if err := fi.c.ImportForeign(id); err != nil {
+				s.Errorf("Error importing foreign config %s on %s: %v", id, fi.c.Name(), err)
+				break
+			}
+		}
+	}
+	if !fake {
+		s.Controllers("")
+	}
+}
+
+// PlanImportForeign returns the commands ImportForeign would run.
+func (s *session) PlanImportForeign(selectors string) *Plan {
+	p := &Plan{Steps: []PlanStep{}}
+	for _, fi := range s.foreignImports(selectors) {
+		for _, id := range fi.ids {
+			cmds, err := fi.c.ImportForeignCmds(id)
+			s.planStep(p, fi.c, "import foreign "+id, cmds, err)
+		}
+	}
+	return p
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+)
+
+const megacliForeignScan = `
+
+There are 2 foreign configuration(s) on controller 0.
+
+Exit Code: 0x00
+`
+
+const megacliForeignDsply0 = `
+
+Foreign Configuration Information:
+====================================
+Number of DISK GROUPS: 1
+
+DISK GROUP: 0
+Number of Spans: 1
+SPAN: 0
+Span Reference: 0x00
+Number of PDs: 2
+Number of VDs: 1
+Number of dedicated Hotspares: 0
+Virtual Drive Information:
+Virtual Drive: 0 (Target Id: 0)
+Name                :os
+RAID Level          : Primary-1, Secondary-0, RAID Level Qualifier-0
+Size                : 558.375 GB
+Sector Size         : 512
+State               : Optimal
+Strip Size          : 64 KB
+Number Of Drives    : 2
+Span Depth          : 1
+Physical Disk Information:
+Physical Disk: 0
+Enclosure Device ID: 32
+Slot Number: 2
+Drive's position: DiskGroup: 0, Span: 0, Arm: 0
+Raw Size: 558.911 GB [0x45dd2fb0 Sectors]
+Physical Disk: 1
+Enclosure Device ID: 32
+Slot Number: 3
+Drive's position: DiskGroup: 0, Span: 0, Arm: 1
+Raw Size: 558.911 GB [0x45dd2fb0 Sectors]
+
+Exit Code: 0x00
+`
+
+const storcliForeignShow = `{
+"Controllers":[
+{
+	"Command Status" : {
+		"CLI Version" : "007.1017.0000.0000 May 10, 2019",
+		"Operating system" : "Linux 5.4.0",
+		"Controller" : 0,
+		"Status" : "Success",
+		"Description" : "Operation on foreign configuration Succeeded"
+	},
+	"Response Data" : {
+		"FOREIGN CONFIGURATION" : [
+			{"DG":0,"Arr":"-","Row":"-","EID:Slot":"-","DID":"-","Type":"RAID1","State":"Frgn","BT":"N","Size":"558.375 GB"},
+			{"DG":0,"Arr":0,"Row":"-","EID:Slot":"-","DID":"-","Type":"RAID1","State":"Frgn","BT":"N","Size":"558.375 GB"},
+			{"DG":0,"Arr":0,"Row":0,"EID:Slot":"32:2","DID":2,"Type":"DRIVE","State":"Frgn","BT":"N","Size":"558.375 GB"},
+			{"DG":0,"Arr":0,"Row":1,"EID:Slot":"32:3","DID":3,"Type":"DRIVE","State":"Frgn","BT":"N","Size":"558.375 GB"},
+			{"DG":1,"Arr":"-","Row":"-","EID:Slot":"-","DID":"-","Type":"RAID0","State":"Frgn","BT":"N","Size":"558.375 GB"},
+			{"DG":1,"Arr":0,"Row":0,"EID:Slot":"32:4","DID":4,"Type":"DRIVE","State":"Frgn","BT":"N","Size":"558.375 GB"}
+		],
+		"VD LIST" : [
+			{"DG/VD":"0/0","TYPE":"RAID1","State":"Frgn","Access":"RW","Consist":"Yes","Cache":"RWBD","Cac":"-","sCC":"ON","Size":"558.375 GB","Name":"os"},
+			{"DG/VD":"1/1","TYPE":"RAID0","State":"Frgn","Access":"RW","Consist":"No","Cache":"RWBD","Cac":"-","sCC":"ON","Size":"558.375 GB","Name":"scratch"}
+		],
+		"Total foreign drive groups" : 2
+	}
+}
+]
+}`
+
+func TestForeign(t *testing.T) {
+	oldExecutor, oldFake := executor, fake
+	defer func() { executor, fake = oldExecutor, oldFake }()
+	fake = false
+	newSess := func(driver string) *session {
+		cs := ctrlrs(1, driver)
+		c := cs[0].addDisks(5, 600<<30, "sas", "disk")
+		for _, d := range c.Disks {
+			d.Enclosure = "32"
+			d.Foreign = d.Slot >= 2
+		}
+		s := &session{in: strings.NewReader(`[{"Controller": 0, "RaidLevel": "raid1", "DiskCount": "max"}]`),
+			log: log.New(ioutil.Discard, "", 0), controllers: cs}
+		c.driver.Logger(s.log)
+		return s
+	}
+
+	phy := &PercJsonPhysicalDiskCntr{}
+	if err := json.Unmarshal([]byte(`{"EID:Slt": "32:2", "DG": "F", "State": "UGood"}`), phy); err != nil || phy.DG != "F" {
+		t.Errorf("Expected a foreign DG, got %q: %v", phy.DG, err)
+	}
+	if err := json.Unmarshal([]byte(`{"EID:Slt": "32:0", "DG": 0, "State": "Onln"}`), phy); err != nil || phy.DG != "0" {
+		t.Errorf("Expected DG 0, got %q: %v", phy.DG, err)
+	}
+	s := newSess("megacli")
+	d := &PhysicalDisk{controller: s.controllers[0], Info: map[string]string{"Foreign State": "Foreign"}}
+	if s.controllers[0].driver.(*MegaCli).finalizeDisk(d); !d.Foreign {
+		t.Errorf("Expected megacli Foreign State to mark the disk foreign")
+	}
+	if disks := s.controllers[0].VolSpecDisks(); len(disks) != 2 {
+		t.Errorf("Expected the foreign disks to be left out of the disk pool, got %d disks", len(disks))
+	}
+
+	megacli := "/opt/MegaRAID/MegaCli/MegaCli64"
+	executor = &replayer{calls: []*Call{
+		{Op: "run", Path: megacli, Args: []string{"-CfgForeign", "-Scan", "-a0"}, Stdout: megacliForeignScan},
+		{Op: "run", Path: megacli, Args: []string{"-CfgForeign", "-Dsply", "0", "-a0"}, Stdout: megacliForeignDsply0},
+		{Op: "run", Path: megacli, Args: []string{"-CfgForeign", "-Dsply", "1", "-a0"}, Stdout: "Exit Code: 0x00\n"},
+	}}
+	cfgs := s.ForeignConfigs()
+	if s.HasError() || len(cfgs) != 2 {
+		t.Fatalf("Expected two foreign configs, got %+v", cfgs)
+	}
+	if cfg := cfgs[0]; cfg.ID != "0" || strings.Join(cfg.Disks, ",") != "32:2,32:3" || len(cfg.Volumes) != 1 ||
+		cfg.Volumes[0].RaidLevel != "raid1" || cfg.Volumes[0].Name != "os" || cfg.Volumes[0].Size != mustSize("558.375 GB") {
+		t.Errorf("Unexpected foreign config %+v", cfg)
+	}
+
+	for sel, want := range map[string]string{
+		"all":   "-CfgForeign -Import -a0",
+		"0/1,0": "-CfgForeign -Import -a0",
+		"1":     "-CfgForeign -Import 1 -a0",
+	} {
+		executor = &replayer{calls: []*Call{
+			{Op: "run", Path: megacli, Args: []string{"-CfgForeign", "-Scan", "-a0"}, Stdout: megacliForeignScan},
+			{Op: "run", Path: megacli, Args: []string{"-CfgForeign", "-Dsply", "0", "-a0"}, Stdout: megacliForeignDsply0},
+			{Op: "run", Path: megacli, Args: []string{"-CfgForeign", "-Dsply", "1", "-a0"}, Stdout: "Exit Code: 0x00\n"},
+		}}
+		s := newSess("megacli")
+		p := s.PlanImportForeign(sel)
+		if s.HasError() || len(p.Steps) != 1 || strings.Join(p.Steps[0].Commands[0][1:], " ") != want {
+			t.Errorf("%s: expected `%s`, got %+v", sel, want, p)
+		}
+	}
+
+	storcli := "/opt/MegaRAID/storcli7/storcli"
+	showForeign := func() *replayer {
+		return &replayer{calls: []*Call{
+			{Op: "run", Path: storcli, Combined: true, Args: []string{"/c0/fall", "show", "all", "J"}, Stdout: storcliForeignShow},
+		}}
+	}
+	executor = showForeign()
+	s = newSess("storcli7-json")
+	cfgs = s.ForeignConfigs()
+	if s.HasError() || len(cfgs) != 2 {
+		t.Fatalf("Expected two foreign configs, got %+v", cfgs)
+	}
+	if cfg := cfgs[1]; cfg.ID != "1" || strings.Join(cfg.Disks, ",") != "32:4" || len(cfg.Volumes) != 1 ||
+		cfg.Volumes[0].ID != "1" || cfg.Volumes[0].RaidLevel != "raid0" || cfg.Volumes[0].Name != "scratch" {
+		t.Errorf("Unexpected foreign config %+v", cfg)
+	}
+	executor = showForeign()
+	if p := s.PlanImportForeign("all"); s.HasError() || len(p.Steps) != 1 ||
+		strings.Join(p.Steps[0].Commands[0][1:], " ") != "/c0/fall import J" {
+		t.Errorf("Expected /c0/fall import J, got %+v", p)
+	}
+	for _, sel := range []string{"1", "7", "3/0"} {
+		executor = showForeign()
+		s := newSess("storcli7-json")
+		if s.PlanImportForeign(sel); !s.HasError() {
+			t.Errorf("Expected an error importing %s", sel)
+		}
+	}
+
+	executor = showForeign()
+	s = newSess("storcli7-json")
+	res, same := s.Compare()
+	if s.HasError() || same {
+		t.Fatalf("Expected a pending foreign config to be a difference")
+	}
+	if cmp, ok := res.(*Comparison); !ok || len(cmp.Foreign) != 2 || len(cmp.Volumes["add"]) != 1 ||
+		len(cmp.Volumes["add"][0].Disks) != 2 {
+		t.Errorf("Unexpected comparison %+v", res)
+	}
+}
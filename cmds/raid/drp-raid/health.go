@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DiskHealth is the normalized health of a physical disk, as reported by
+// the controller it is attached to or by smartctl for disks the OS can
+// talk to directly.  Counters a tool does not report are left at 0.
+type DiskHealth struct {
+	// Source is the driver or tool the health came from.
+	Source             string
+	MediaErrors        uint64
+	OtherErrors        uint64
+	PredictiveFailures uint64
+	// SmartAlert is set when the disk itself says it is failing.
+	SmartAlert bool
+	// Temperature is in degrees Celsius, or 0 if it is not known.
+	Temperature int64             `json:",omitempty"`
+	Smart       map[string]string `json:",omitempty"`
+}
+
+// HealthThresholds are the limits -health checks disks against.
+// Negative limits are not checked.
+type HealthThresholds struct {
+	MediaErrors        int64
+	OtherErrors        int64
+	PredictiveFailures int64
+	Temperature        int64
+}
+
+// DiskHealthReport is the -health output for a single disk.
+type DiskHealthReport struct {
+	Controller string
+	Disk       string
+	Status     string
+	Health     *DiskHealth `json:",omitempty"`
+	Problems   []string
+}
+
+var tempRE = regexp.MustCompile(`^\s*(-?[0-9]+)`)
+
+// leadingInt parses the number at the start of values like "31C (87.80 F)".
+func leadingInt(v string) int64 {
+	matches := tempRE.FindStringSubmatch(v)
+	if len(matches) != 2 {
+		return 0
+	}
+	res, _ := strconv.ParseInt(matches[1], 10, 64)
+	return res
+}
+
+// lsiDiskHealth pulls the health counters out of the disk info that
+// MegaCli, storcli, and perccli all report with the same names.
+func lsiDiskHealth(source string, info map[string]string) *DiskHealth {
+	h := &DiskHealth{Source: source}
+	for k, v := range info {
+		switch k {
+		case "Media Error Count":
+			h.MediaErrors, _ = strconv.ParseUint(v, 10, 64)
+		case "Other Error Count":
+			h.OtherErrors, _ = strconv.ParseUint(v, 10, 64)
+		case "Predictive Failure Count":
+			h.PredictiveFailures, _ = strconv.ParseUint(v, 10, 64)
+		case "Drive Temperature":
+			h.Temperature = leadingInt(v)
+		case "Drive has flagged a S.M.A.R.T alert", "S.M.A.R.T alert flagged by drive":
+			h.SmartAlert = v == "Yes"
+		}
+	}
+	return h
+}
+
+type smartctlAttr struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	WhenFailed string `json:"when_failed"`
+	Raw        struct {
+		Value  uint64 `json:"value"`
+		String string `json:"string"`
+	} `json:"raw"`
+}
+
+type smartctlScsiCounters struct {
+	TotalUncorrectedErrors uint64 `json:"total_uncorrected_errors"`
+}
+
+type smartctlOutput struct {
+	Smartctl struct {
+		ExitStatus int `json:"exit_status"`
+		Messages   []struct {
+			String string `json:"string"`
+		} `json:"messages"`
+	} `json:"smartctl"`
+	SmartStatus *struct {
+		Passed bool `json:"passed"`
+	} `json:"smart_status"`
+	Temperature struct {
+		Current int64 `json:"current"`
+	} `json:"temperature"`
+	AtaSmartAttributes struct {
+		Table []smartctlAttr `json:"table"`
+	} `json:"ata_smart_attributes"`
+	AtaSmartErrorLog struct {
+		Summary struct {
+			Count uint64 `json:"count"`
+		} `json:"summary"`
+	} `json:"ata_smart_error_log"`
+	NvmeSmartHealthInformationLog *struct {
+		CriticalWarning  uint64 `json:"critical_warning"`
+		AvailableSpare   uint64 `json:"available_spare"`
+		PercentageUsed   uint64 `json:"percentage_used"`
+		MediaErrors      uint64 `json:"media_errors"`
+		NumErrLogEntries uint64 `json:"num_err_log_entries"`
+	} `json:"nvme_smart_health_information_log"`
+	ScsiErrorCounterLog struct {
+		Read   smartctlScsiCounters `json:"read"`
+		Write  smartctlScsiCounters `json:"write"`
+		Verify smartctlScsiCounters `json:"verify"`
+	} `json:"scsi_error_counter_log"`
+	ScsiGrownDefectList *uint64 `json:"scsi_grown_defect_list"`
+}
+
+// smartctlHealth gets the health of a disk the OS can see directly.
+// It needs smartctl 7.0 or later for JSON output.
+func smartctlHealth(dev string) (*DiskHealth, error) {
+	h := &DiskHealth{Source: "smartctl", Smart: map[string]string{}}
+	if fake {
+		return h, nil
+	}
+	if dev == "" {
+		return nil, fmt.Errorf("No OS device to run smartctl against")
+	}
+	out, _, err := executor.Run(false, "smartctl", "-j", "-a", dev)
+	// smartctl sets bits in its exit status when the disk is unhappy, so
+	// only the JSON can say whether it failed to run.
+	res := &smartctlOutput{}
+	if jerr := json.Unmarshal(out, res); jerr != nil {
+		if err == nil {
+			err = jerr
+		}
+		return nil, fmt.Errorf("smartctl %s failed: %v", dev, err)
+	}
+	if res.Smartctl.ExitStatus&0x3 != 0 {
+		msgs := []string{}
+		for _, m := range res.Smartctl.Messages {
+			msgs = append(msgs, m.String)
+		}
+		return nil, fmt.Errorf("smartctl %s failed: %s", dev, strings.Join(msgs, ", "))
+	}
+	h.SmartAlert = res.SmartStatus != nil && !res.SmartStatus.Passed
+	h.Temperature = res.Temperature.Current
+	for _, attr := range res.AtaSmartAttributes.Table {
+		h.Smart[attr.Name] = attr.Raw.String
+		switch attr.ID {
+		case 187, 198: // Reported_Uncorrect, Offline_Uncorrectable
+			h.MediaErrors += attr.Raw.Value
+		}
+		if attr.WhenFailed != "" {
+			h.PredictiveFailures++
+		}
+	}
+	h.OtherErrors = res.AtaSmartErrorLog.Summary.Count
+	if nvme := res.NvmeSmartHealthInformationLog; nvme != nil {
+		h.MediaErrors = nvme.MediaErrors
+		h.OtherErrors = nvme.NumErrLogEntries
+		h.SmartAlert = h.SmartAlert || nvme.CriticalWarning != 0
+		h.Smart["Critical Warning"] = strconv.FormatUint(nvme.CriticalWarning, 10)
+		h.Smart["Available Spare"] = strconv.FormatUint(nvme.AvailableSpare, 10)
+		h.Smart["Percentage Used"] = strconv.FormatUint(nvme.PercentageUsed, 10)
+	}
+	scsi := res.ScsiErrorCounterLog
+	h.MediaErrors += scsi.Read.TotalUncorrectedErrors + scsi.Write.TotalUncorrectedErrors + scsi.Verify.TotalUncorrectedErrors
+	if res.ScsiGrownDefectList != nil {
+		h.Smart["Grown Defects"] = strconv.FormatUint(*res.ScsiGrownDefectList, 10)
+	}
+	return h, nil
+}
+
+// badDiskStatus returns true if the status a driver reports for a disk
+// means it has failed or is about to.
+func badDiskStatus(status string) bool {
+	status = strings.ToLower(status)
+	for _, bad := range []string{"fail", "bad", "offl", "missing"} {
+		if strings.Contains(status, bad) {
+			return true
+		}
+	}
+	return false
+}
+
+// Problems returns the ways a disk with the passed-in status and health
+// crosses the thresholds.
+func (t HealthThresholds) Problems(status string, h *DiskHealth) []string {
+	res := []string{}
+	if badDiskStatus(status) {
+		res = append(res, fmt.Sprintf("Status is %s", status))
+	}
+	if h == nil {
+		return res
+	}
+	if h.SmartAlert {
+		res = append(res, "SMART alert flagged by drive")
+	}
+	check := func(name string, val uint64, limit int64) {
+		if limit >= 0 && val > uint64(limit) {
+			res = append(res, fmt.Sprintf("%s %d over limit %d", name, val, limit))
+		}
+	}
+	check("Media errors", h.MediaErrors, t.MediaErrors)
+	check("Other errors", h.OtherErrors, t.OtherErrors)
+	check("Predictive failures", h.PredictiveFailures, t.PredictiveFailures)
+	if t.Temperature >= 0 && h.Temperature > t.Temperature {
+		res = append(res, fmt.Sprintf("Temperature %dC over limit %dC", h.Temperature, t.Temperature))
+	}
+	return res
+}
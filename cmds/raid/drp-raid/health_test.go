@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+)
+
+const smartctlAta = `{
+  "smartctl": {"version": [7, 1], "exit_status": 4},
+  "device": {"name": "/dev/sda", "type": "sat"},
+  "smart_status": {"passed": true},
+  "ata_smart_attributes": {
+    "table": [
+      {"id": 5, "name": "Reallocated_Sector_Ct", "when_failed": "", "raw": {"value": 8, "string": "8"}},
+      {"id": 187, "name": "Reported_Uncorrect", "when_failed": "", "raw": {"value": 2, "string": "2"}},
+      {"id": 194, "name": "Temperature_Celsius", "when_failed": "", "raw": {"value": 34, "string": "34 (Min/Max 20/45)"}},
+      {"id": 198, "name": "Offline_Uncorrectable", "when_failed": "past", "raw": {"value": 1, "string": "1"}}
+    ]
+  },
+  "ata_smart_error_log": {"summary": {"count": 3}},
+  "temperature": {"current": 34}
+}`
+
+const smartctlNvme = `{
+  "smartctl": {"version": [7, 1], "exit_status": 0},
+  "device": {"name": "/dev/nvme0", "type": "nvme"},
+  "smart_status": {"passed": true},
+  "nvme_smart_health_information_log": {
+    "critical_warning": 4,
+    "temperature": 71,
+    "available_spare": 100,
+    "percentage_used": 12,
+    "media_errors": 0,
+    "num_err_log_entries": 9
+  },
+  "temperature": {"current": 71}
+}`
+
+const smartctlMissing = `{
+  "smartctl": {
+    "version": [7, 1],
+    "messages": [{"string": "/dev/sdz: Unable to detect device type", "severity": "error"}],
+    "exit_status": 1
+  }
+}`
+
+func TestSmartctlHealth(t *testing.T) {
+	oldExecutor, oldFake := executor, fake
+	defer func() { executor, fake = oldExecutor, oldFake }()
+	fake = false
+	executor = &replayer{calls: []*Call{
+		{Op: "run", Path: "smartctl", Args: []string{"-j", "-a", "/dev/sda"}, Stdout: smartctlAta, Error: "exit status 4"},
+		{Op: "run", Path: "smartctl", Args: []string{"-j", "-a", "/dev/nvme0"}, Stdout: smartctlNvme},
+		{Op: "run", Path: "smartctl", Args: []string{"-j", "-a", "/dev/sdz"}, Stdout: smartctlMissing, Error: "exit status 1"},
+	}}
+	h, err := smartctlHealth("/dev/sda")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if h.MediaErrors != 3 || h.OtherErrors != 3 || h.PredictiveFailures != 1 || h.SmartAlert ||
+		h.Temperature != 34 || h.Smart["Reallocated_Sector_Ct"] != "8" {
+		t.Errorf("Unexpected ATA health %+v", h)
+	}
+	h, err = smartctlHealth("/dev/nvme0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if h.MediaErrors != 0 || h.OtherErrors != 9 || !h.SmartAlert || h.Temperature != 71 || h.Smart["Percentage Used"] != "12" {
+		t.Errorf("Unexpected NVMe health %+v", h)
+	}
+	if _, err := smartctlHealth("/dev/sdz"); err == nil {
+		t.Errorf("Expected an error for a disk smartctl cannot open")
+	}
+}
+
+func TestHealthProblems(t *testing.T) {
+	info := map[string]string{
+		"Media Error Count":                   "4",
+		"Other Error Count":                   "12",
+		"Predictive Failure Count":            "0",
+		"Drive Temperature":                   "31C (87.80 F)",
+		"Drive has flagged a S.M.A.R.T alert": "No",
+	}
+	h := lsiDiskHealth("megacli", info)
+	if h.MediaErrors != 4 || h.OtherErrors != 12 || h.Temperature != 31 || h.SmartAlert {
+		t.Fatalf("Unexpected health %+v", h)
+	}
+	t0 := HealthThresholds{MediaErrors: 0, OtherErrors: -1, PredictiveFailures: 0, Temperature: 60}
+	if p := t0.Problems("Online, Spun Up", h); len(p) != 1 || p[0] != "Media errors 4 over limit 0" {
+		t.Errorf("Unexpected problems %v", p)
+	}
+	t1 := HealthThresholds{MediaErrors: 10, OtherErrors: 10, PredictiveFailures: 0, Temperature: 30}
+	if p := t1.Problems("Unconfigured(bad)", h); len(p) != 3 {
+		t.Errorf("Expected status, other errors, and temperature problems, got %v", p)
+	}
+	if p := t0.Problems("UGood", nil); len(p) != 0 {
+		t.Errorf("Unexpected problems %v", p)
+	}
+}
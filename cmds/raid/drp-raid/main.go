@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -9,11 +10,15 @@ import (
 	"log"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 var allDrivers = []Driver{
 	&SsaCli{"ssacli", "/opt/smartstorageadmin/ssacli/bin/ssacli", 10, nil, true},
+	&StorCliJson{PercJsonCli{"storcli7-json", "/opt/MegaRAID/storcli7/storcli", 15, nil, true}},
+	&StorCliJson{PercJsonCli{"storcli6-json", "/opt/MegaRAID/storcli6/storcli", 18, nil, true}},
 	&MegaCli{"storcli7", "/opt/MegaRAID/storcli7/storcli", 20, nil, true},
 	&MegaCli{"storcli6", "/opt/MegaRAID/storcli6/storcli", 30, nil, true},
 	&MegaCli{"megacli", "/opt/MegaRAID/MegaCli/MegaCli64", 40, nil, true},
@@ -21,6 +26,8 @@ var allDrivers = []Driver{
 	&MVCli{"mvcli", "/usr/local/bin/mvcli", 60, nil, true},
 	&MNVCli{"mnvcli", "/usr/local/bin/mnv_cli", 65, nil, true},
 	&PercJsonCli{"perccli-json", "/opt/MegaRAID/perccli/perccli64", 70, nil, true},
+	&NvmeCli{"nvme", "/usr/sbin/nvme", 75, nil, false},
+	&MdAdm{"mdadm", "/sbin/mdadm", 80, nil, false},
 }
 
 var fake = false
@@ -31,8 +38,24 @@ type session struct {
 	log           *log.Logger
 	controllers   Controllers
 	inSpecs       VolSpecs
+	ctrlSpecs     []*ControllerSpec
 	compiledSpecs VolSpecs
 	errors        bool
+	deleteBoot    bool
+	deleteInUse   bool
+	updateInPlace bool
+	// recreatePolicies lets -reconcile recreate volumes whose policies
+	// differ when they are not updated in place.
+	recreatePolicies bool
+	// secrets is the key material for encrypting volumes, if any was
+	// passed in.
+	secrets *Secrets
+	// keyIDs is the key ID of each controller that has been rekeyed to
+	// one other than secrets.KeyID, by controller name.
+	keyIDs map[string]string
+	// needReboot is set when controller settings were changed that only
+	// take effect after a reboot.
+	needReboot bool
 }
 
 func newSession() *session {
@@ -41,12 +64,13 @@ func newSession() *session {
 
 func (s *session) Log(w io.Writer) *session {
 	s.log = log.New(w, "", 0)
-	return s
+	return s.Secrets(s.secrets)
 }
 
 func (s *session) PrettyPrint(val interface{}) {
 	out := json.NewEncoder(s.out)
 	out.SetIndent("  ", "  ")
+	out.SetEscapeHTML(false)
 	out.Encode(val)
 }
 
@@ -153,10 +177,14 @@ func (s *session) Controllers(src string) *session {
 					continue
 				}
 				if c.Driver == d.Name() {
+					d.Logger(s.log)
 					c.driver = d
 					break
 				}
 			}
+			for _, v := range c.Volumes {
+				v.controller, v.driver = c, c.driver
+			}
 		}
 	}
 	return s
@@ -177,16 +205,152 @@ func (s *session) WantedSpecs() *session {
 		s.Errorf("No source to read specs from")
 		return s
 	}
-	inSpecs := VolSpecs{}
+	var raw json.RawMessage
 	dec := json.NewDecoder(s.in)
-	if err := dec.Decode(&inSpecs); err != nil {
+	if err := dec.Decode(&raw); err != nil {
+		s.Errorf("Unable to decode JSON on stdin: %v", err)
+		return s
+	}
+	// Either a list of volspecs, or an object with controller specs too.
+	specs := &Specs{VolSpecs: VolSpecs{}}
+	var err error
+	if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 && trimmed[0] == '{' {
+		err = json.Unmarshal(raw, specs)
+	} else {
+		err = json.Unmarshal(raw, &specs.VolSpecs)
+	}
+	if err != nil {
 		s.Errorf("Unable to decode JSON on stdin: %v", err)
 		return s
 	}
-	s.inSpecs = inSpecs
+	for _, cs := range specs.Controllers {
+		if cs.Controller < 0 || cs.Controller >= len(s.controllers) {
+			s.Errorf("Controller spec for controller %d, but there are only %d controllers", cs.Controller, len(s.controllers))
+		} else if err := cs.Fill(); err != nil {
+			s.Errorf("Controller spec for controller %d: %v", cs.Controller, err)
+		}
+	}
+	if s.HasError() {
+		return s
+	}
+	if specs.VolSpecs == nil {
+		specs.VolSpecs = VolSpecs{}
+	}
+	s.inSpecs, s.ctrlSpecs = specs.VolSpecs, specs.Controllers
 	return s
 }
 
+// ControllerChanges returns the controller settings that differ from
+// the wanted controller specs.
+func (s *session) ControllerChanges() []*ControllerChange {
+	s.WantedSpecs()
+	res := []*ControllerChange{}
+	for _, cs := range s.ctrlSpecs {
+		c := s.controllers[cs.Controller]
+		cur, err := c.Settings()
+		if err != nil {
+			s.Errorf("Cannot get the settings of %s: %v", c.Name(), err)
+			continue
+		}
+		if cs.Personality != "" {
+			change := &ControllerChange{
+				Controller: cs.Controller,
+				Name:       c.Name(),
+				Setting:    "Personality",
+				Current:    cur.Personality,
+				Wanted:     cs.Personality,
+			}
+			switch {
+			case cur.PendingPersonality == cs.Personality:
+				change.Pending, change.RebootRequired = true, true
+				res = append(res, change)
+			case cur.Personality != cs.Personality:
+				change.RebootRequired = personalityMode(cur.Personality) != personalityMode(cs.Personality)
+				res = append(res, change)
+			}
+		}
+		if cs.AutoJBOD != nil {
+			if cur.AutoJBOD == nil {
+				s.Errorf("%s cannot report whether AutoJBOD is on", c.Name())
+			} else if *cur.AutoJBOD != *cs.AutoJBOD && cs.Personality == "" {
+				res = append(res, &ControllerChange{
+					Controller: cs.Controller,
+					Name:       c.Name(),
+					Setting:    "AutoJBOD",
+					Current:    onOff(*cur.AutoJBOD),
+					Wanted:     onOff(*cs.AutoJBOD),
+				})
+			}
+		}
+	}
+	return res
+}
+
+// settingsChanges turns changes into the specs to apply to each
+// controller, leaving out ones that are only waiting for a reboot.
+func settingsChanges(changes []*ControllerChange) []*ControllerSpec {
+	res := []*ControllerSpec{}
+	byIdx := map[int]*ControllerSpec{}
+	for _, change := range changes {
+		if change.Pending {
+			continue
+		}
+		cs, ok := byIdx[change.Controller]
+		if !ok {
+			cs = &ControllerSpec{Controller: change.Controller}
+			byIdx[change.Controller] = cs
+			res = append(res, cs)
+		}
+		switch change.Setting {
+		case "Personality":
+			cs.Personality = change.Wanted
+		case "AutoJBOD":
+			on := change.Wanted == "on"
+			cs.AutoJBOD = &on
+		}
+	}
+	return res
+}
+
+// settingsAction describes the changes in cs for -plan.
+func settingsAction(cs *ControllerSpec) string {
+	res := []string{}
+	if cs.Personality != "" {
+		res = append(res, "set personality "+cs.Personality)
+	}
+	if cs.AutoJBOD != nil {
+		res = append(res, "set autojbod "+onOff(*cs.AutoJBOD))
+	}
+	return strings.Join(res, ", ")
+}
+
+// ConfigureControllers changes the controller settings to match the
+// wanted controller specs.  It returns true if any of the changes need a
+// reboot to take effect.
+func (s *session) ConfigureControllers() bool {
+	changes := s.ControllerChanges()
+	if s.HasError() {
+		return false
+	}
+	reboot := false
+	for _, change := range changes {
+		reboot = reboot || change.RebootRequired
+	}
+	specs := settingsChanges(changes)
+	for _, cs := range specs {
+		c := s.controllers[cs.Controller]
+		if err := c.ApplySettings(cs); err != nil {
+			s.Errorf("Error changing settings of %s: %v", c.Name(), err)
+			return reboot
+		}
+		s.log.Printf("Changed settings of %s: %s", c.Name(), settingsAction(cs))
+	}
+	if len(specs) != 0 && !fake {
+		s.Controllers("")
+	}
+	return reboot
+}
+
 func (s *session) Compile() *session {
 	if s.compiledSpecs != nil {
 		return s
@@ -210,11 +374,11 @@ func (s *session) Compile() *session {
 
 func toAdd(same map[string]struct{}, specs VolSpecs) VolSpecs {
 	res := VolSpecs{}
-	for _, spec := range specs {
-		if _, ok := same[spec.Key()]; ok {
+	for i, k := range specs.Keys() {
+		if _, ok := same[k]; ok {
 			continue
 		}
-		res = append(res, spec)
+		res = append(res, specs[i])
 	}
 	return res
 }
@@ -241,10 +405,47 @@ func (s *session) Diff() (map[string]VolSpecs, error) {
 		}
 	}
 	sort.Stable(rm)
+	update := VolSpecs{}
+	for k := range same {
+		if len(wanted[k].PolicyChanges(current[k])) == 0 {
+			continue
+		}
+		spec := *wanted[k]
+		spec.VolumeID = current[k].VolumeID
+		update = append(update, &spec)
+	}
+	sort.Stable(update)
+	// Volumes that are laid out right, but are not the boot volume.
+	// Controllers that cannot say which volume they boot from would never
+	// match, so they are left alone.
+	boot := VolSpecs{}
+	for k := range same {
+		if !wanted[k].Bootable || current[k].Bootable || s.controllers[current[k].Controller].BootUnknown {
+			continue
+		}
+		spec := *wanted[k]
+		spec.VolumeID = current[k].VolumeID
+		boot = append(boot, &spec)
+	}
+	sort.Stable(boot)
+	// Volumes that are laid out right, but are not encrypted yet.
+	secure := VolSpecs{}
+	for k := range same {
+		if !wanted[k].Encrypt || current[k].Encrypt {
+			continue
+		}
+		spec := *wanted[k]
+		spec.VolumeID = current[k].VolumeID
+		secure = append(secure, &spec)
+	}
+	sort.Stable(secure)
 	return map[string]VolSpecs{
 		"current": currents,
 		"add":     toAdd(same, s.compiledSpecs),
 		"rm":      rm,
+		"update":  update,
+		"boot":    boot,
+		"secure":  secure,
 	}, nil
 }
 
@@ -264,32 +465,598 @@ func (s *session) Encrypt(key, password string) {
 	}
 }
 
+// Health returns a report on every disk on every controller.  Disks
+// whose health cannot be read are reported as a problem.
+func (s *session) Health(t HealthThresholds) []*DiskHealthReport {
+	res := []*DiskHealthReport{}
+	for _, c := range s.controllers {
+		for _, d := range c.Disks {
+			r := &DiskHealthReport{Controller: c.Name(), Disk: d.Name(), Status: d.Status}
+			h, err := c.Health(d)
+			if err != nil {
+				s.log.Printf("Cannot get health of %s on %s: %v", d.Name(), c.Name(), err)
+			}
+			r.Health = h
+			r.Problems = t.Problems(d.Status, h)
+			if err != nil {
+				r.Problems = append(r.Problems, err.Error())
+			}
+			res = append(res, r)
+		}
+	}
+	return res
+}
+
+// diskTarget is a disk picked by a disk selector.
+type diskTarget struct {
+	c *Controller
+	d *PhysicalDisk
+}
+
+// selectorControllers splits the controller index off the front of a
+// disk or volume selector, and returns the controllers the rest of the
+// selector should be matched against.
+func (s *session) selectorControllers(sel string) (Controllers, string, bool) {
+	parts := strings.SplitN(sel, "/", 2)
+	if len(parts) != 2 {
+		return s.controllers, sel, true
+	}
+	idx, err := strconv.Atoi(parts[0])
+	if err != nil || idx < 0 || idx >= len(s.controllers) {
+		s.Errorf("Invalid controller index in selector %s", sel)
+		return nil, "", false
+	}
+	return s.controllers[idx : idx+1], parts[1], true
+}
+
+// SelectDisks returns the disks matching the passed-in comma separated
+// selectors.  A selector is either a disk as enclosure:slot, or just the
+// slot on controllers without enclosures, or the name of a volume, which
+// selects every disk in it.  A controller index and a slash in front of
+// a selector, as in 1/32:4, limits it to that controller.  Selectors that
+// match nothing are an error.
+func (s *session) SelectDisks(selectors string) []diskTarget {
+	res := []diskTarget{}
+	for _, sel := range strings.Split(selectors, ",") {
+		sel = strings.TrimSpace(sel)
+		if sel == "" {
+			continue
+		}
+		controllers, sel, ok := s.selectorControllers(sel)
+		if !ok {
+			continue
+		}
+		found := []diskTarget{}
+		for _, c := range controllers {
+			for _, d := range c.Disks {
+				if d != nil && d.Name() == sel {
+					found = append(found, diskTarget{c, d})
+				}
+			}
+		}
+		if len(found) == 0 {
+			for _, v := range s.selectVolumes(controllers, sel) {
+				for _, d := range v.Disks {
+					if d != nil {
+						found = append(found, diskTarget{v.controller, d})
+					}
+				}
+			}
+		}
+		if len(found) == 0 {
+			s.Errorf("No disks or volumes match %s", sel)
+		}
+		res = append(res, found...)
+	}
+	return res
+}
+
+// selectVolumes returns the real volumes on controllers with the passed-in
+// name, or all of them if name is "all".
+func (s *session) selectVolumes(controllers Controllers, name string) []*Volume {
+	res := []*Volume{}
+	for _, c := range controllers {
+		for _, v := range c.Volumes {
+			if !v.Fake && (name == "all" || v.Name == name) {
+				v.controller = c
+				res = append(res, v)
+			}
+		}
+	}
+	return res
+}
+
+// SelectVolumes returns the volumes matching the passed-in comma
+// separated selectors.  A selector is a volume name, the ID of a volume
+// on a controller, as in 0/1, or "all".  Selectors that match nothing
+// are an error.
+func (s *session) SelectVolumes(selectors string) []*Volume {
+	res := []*Volume{}
+	for _, sel := range strings.Split(selectors, ",") {
+		sel = strings.TrimSpace(sel)
+		if sel == "" {
+			continue
+		}
+		controllers, name, ok := s.selectorControllers(sel)
+		if !ok {
+			continue
+		}
+		found := s.selectVolumes(controllers, name)
+		if len(found) == 0 && name != sel {
+			if v := controllers[0].Volume(name); v != nil && !v.Fake {
+				v.controller = controllers[0]
+				found = append(found, v)
+			}
+		}
+		if len(found) == 0 {
+			s.Errorf("No volumes match %s", sel)
+		}
+		res = append(res, found...)
+	}
+	return res
+}
+
+// Locate turns the locate LED on or off for the disks matching the
+// passed-in selectors.
+func (s *session) Locate(selectors string, on bool) {
+	targets := s.SelectDisks(selectors)
+	if s.HasError() {
+		return
+	}
+	for _, t := range targets {
+		if err := t.c.Locate(t.d, on); err != nil {
+			s.Errorf("Error changing locate LED for %s on %s: %v", t.d.Name(), t.c.Name(), err)
+		}
+	}
+}
+
+// Check starts or stops a consistency check on the volumes matching
+// the passed-in selectors.
+func (s *session) Check(selectors string, start bool) {
+	vols := s.SelectVolumes(selectors)
+	if s.HasError() {
+		return
+	}
+	for _, v := range vols {
+		if err := v.controller.Check(v, start); err != nil {
+			s.Errorf("Error changing consistency check of %s on %s: %v", v.ID, v.controller.Name(), err)
+		}
+	}
+	if !fake {
+		s.Controllers("")
+	}
+}
+
+// eraseTargets returns the disks matching the passed-in selectors, or
+// every disk if selectors is "all", after checking that they can be
+// erased with method.
+func (s *session) eraseTargets(selectors, method string) []diskTarget {
+	if err := validEraseMethod(method); err != nil {
+		s.Errorf("%v", err)
+		return nil
+	}
+	targets := []diskTarget{}
+	if selectors == "all" {
+		for _, c := range s.controllers {
+			for _, d := range c.Disks {
+				targets = append(targets, diskTarget{c, d})
+			}
+		}
+	} else {
+		targets = s.SelectDisks(selectors)
+	}
+	for _, t := range targets {
+		if err := erasable(t.d); err != nil {
+			s.Errorf("Cannot erase disk on %s: %v", t.c.Name(), err)
+		}
+	}
+	return targets
+}
+
+// Erase erases the disks matching the passed-in selectors with method,
+// and returns a report of what was erased.  Every disk is checked before
+// any are erased.  If wait is true, it polls the erases every interval
+// until they have all finished or timeout passes.  A timeout of 0 waits
+// forever.  A disk is only reported as erased once its controller says
+// the erase finished, or, for controllers that only say whether an erase
+// is running, once an erase that was seen running has stopped.
+func (s *session) Erase(selectors, method string, wait bool, interval, timeout time.Duration) []*ErasureReport {
+	targets := s.eraseTargets(selectors, method)
+	if s.HasError() {
+		return nil
+	}
+	res := []*ErasureReport{}
+	for _, t := range targets {
+		r := newErasureReport(t, method)
+		res = append(res, r)
+		s.log.Printf("Erasing %s on %s with %s", r.Disk, r.Controller, method)
+		if err := t.c.Erase(t.d, method); err != nil {
+			s.Errorf("Error erasing %s on %s: %v", r.Disk, r.Controller, err)
+			r.finish(err)
+		}
+	}
+	start := time.Now()
+	for {
+		running := 0
+		for _, r := range res {
+			if r.Status != "running" {
+				continue
+			}
+			st, err := r.target.c.EraseProgress(r.target.d, method)
+			switch {
+			case err != nil:
+				s.log.Printf("Cannot get the erase progress of %s on %s: %v", r.Disk, r.Controller, err)
+				running++
+			case st.Op != nil:
+				s.log.Println(st.Op)
+				r.Progress, r.seen = st.Op.Progress, true
+				running++
+			case st.Failure != "":
+				s.Errorf("Erasing %s on %s failed: %s", r.Disk, r.Controller, st.Failure)
+				r.finish(fmt.Errorf("%s", st.Failure))
+			case st.Erased || r.seen:
+				s.log.Printf("Erased %s on %s", r.Disk, r.Controller)
+				r.finish(nil)
+			default:
+				s.Errorf("Erase of %s on %s is not running and was never seen running", r.Disk, r.Controller)
+				r.finish(fmt.Errorf("Erase stopped without the controller reporting that it finished"))
+			}
+		}
+		if running == 0 || !wait {
+			return res
+		}
+		if timeout > 0 && time.Since(start) >= timeout {
+			s.Errorf("Timed out after %v waiting for %d disks to finish erasing", timeout, running)
+			return res
+		}
+		time.Sleep(interval)
+	}
+}
+
+// BackgroundOps returns the background operations running on every
+// controller.  If refresh is true, the controllers are refreshed first.
+func (s *session) BackgroundOps(refresh bool) []*BackgroundOp {
+	res := []*BackgroundOp{}
+	for _, c := range s.controllers {
+		if refresh && !fake {
+			c.driver.Refresh(c)
+		}
+		ops, err := c.BackgroundOps()
+		if err != nil {
+			s.log.Printf("Cannot get background operations on %s: %v", c.Name(), err)
+		}
+		res = append(res, ops...)
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].key() < res[j].key() })
+	return res
+}
+
+// Watch returns the background operations running on the controllers.
+// If wait is true, it polls them every interval, logging their progress,
+// until they have all finished or timeout passes.  A timeout of 0 waits
+// forever.
+func (s *session) Watch(wait bool, interval, timeout time.Duration) []*BackgroundOp {
+	start := time.Now()
+	last, lastSeen := map[string]*BackgroundOp{}, start
+	ops := s.BackgroundOps(false)
+	for {
+		now := time.Now()
+		for _, op := range ops {
+			op.estimate(last[op.key()], now.Sub(lastSeen))
+		}
+		if !wait || len(ops) == 0 {
+			return ops
+		}
+		for _, op := range ops {
+			s.log.Println(op)
+		}
+		sleep := interval
+		if timeout > 0 {
+			left := timeout - now.Sub(start)
+			if left <= 0 {
+				s.Errorf("Timed out after %v waiting for %d background operations", timeout, len(ops))
+				return ops
+			}
+			if left < sleep {
+				sleep = left
+			}
+		}
+		last, lastSeen = map[string]*BackgroundOp{}, now
+		for _, op := range ops {
+			last[op.key()] = op
+		}
+		time.Sleep(sleep)
+		ops = s.BackgroundOps(true)
+	}
+}
+
+// Expansions returns the expansions the volspecs on stdin ask for.  Each
+// volspec names an existing volume by VolumeID or by listing its disks,
+// along with any disks to add to it and the raid level it should have.
+func (s *session) Expansions() []*Expansion {
+	if s.WantedSpecs(); s.HasError() {
+		return nil
+	}
+	res := []*Expansion{}
+	for _, spec := range s.inSpecs {
+		if spec.Controller < 0 || spec.Controller >= len(s.controllers) {
+			s.Errorf("Volspec %s is for controller %d, but there are only %d controllers",
+				spec.Key(), spec.Controller, len(s.controllers))
+			continue
+		}
+		c := s.controllers[spec.Controller]
+		v, err := expandVolume(c, spec)
+		if err != nil {
+			s.Errorf("Cannot expand %s: %v", spec.Key(), err)
+			continue
+		}
+		e, err := newExpansion(c, v, spec)
+		if err != nil {
+			s.Errorf("Cannot expand %s on %s: %v", spec.Key(), c.Name(), err)
+			continue
+		}
+		if e.noop() {
+			s.log.Printf("Volume %s on %s already matches %s, nothing to do", v.ID, c.Name(), spec.Key())
+			continue
+		}
+		res = append(res, e)
+	}
+	return res
+}
+
+// Expand starts the expansions the volspecs on stdin ask for.  They are
+// all validated before any are started.
+func (s *session) Expand() {
+	exps := s.Expansions()
+	if s.HasError() {
+		return
+	}
+	for _, e := range exps {
+		c := s.controllers[e.Controller]
+		s.log.Printf("Expanding %s on %s", e, c.Name())
+		if err := c.Expand(e); err != nil {
+			s.Errorf("Error expanding %s on %s: %v", e, c.Name(), err)
+		}
+	}
+	if !fake {
+		s.Controllers("")
+	}
+}
+
+// deletable returns the volumes that need to be deleted to get rid
+// of the passed-in volspecs.  Volumes the controller boots from or that
+// the running OS is using are refused unless the session allows it.
+func (s *session) deletable(specs VolSpecs) []*Volume {
+	res := []*Volume{}
+	for _, spec := range specs {
+		c := s.controllers[spec.Controller]
+		vol := c.Volume(spec.VolumeID)
+		if vol == nil {
+			s.Errorf("Volume %s not found on %s:%s", spec.VolumeID, c.Driver, c.ID)
+			continue
+		}
+		if vol.Bootable && !s.deleteBoot {
+			s.Errorf("Refusing to delete boot volume %s on %s:%s without -delete-boot", vol.ID, c.Driver, c.ID)
+			continue
+		}
+		if c.BootUnknown && !s.deleteBoot {
+			s.Errorf("Refusing to delete volume %s on %s:%s without -delete-boot, the controller did not say which volume it boots from",
+				vol.ID, c.Driver, c.ID)
+			continue
+		}
+		if vol.InUse() && !s.deleteInUse {
+			dev := vol.OSDevice()
+			if dev == "" {
+				dev = "unknown device"
+			}
+			s.Errorf("Refusing to delete in use volume %s (%s) on %s:%s without -delete-in-use",
+				vol.ID, dev, c.Driver, c.ID)
+			continue
+		}
+		res = append(res, vol)
+	}
+	return res
+}
+
+// Comparison is the -compare output when controller specs are passed in,
+// or when there are foreign configs waiting to be imported or cleared.
+type Comparison struct {
+	Controllers    []*ControllerChange
+	RebootRequired bool
+	Volumes        map[string]VolSpecs `json:",omitempty"`
+	Foreign        []*ForeignConfig    `json:",omitempty"`
+}
+
+// Compare returns the differences between the current configuration and
+// the wanted one, and whether there are none.  Without controller specs
+// or foreign configs, that is just the volspec Diff.
+func (s *session) Compare() (interface{}, bool) {
+	if s.WantedSpecs(); s.HasError() {
+		return nil, false
+	}
+	var cmp map[string]VolSpecs
+	if len(s.inSpecs) != 0 || len(s.ctrlSpecs) == 0 {
+		var err error
+		if cmp, err = s.Compile().Diff(); err != nil {
+			s.Errorf("%v", err)
+			return nil, false
+		}
+	}
+	same := len(cmp[`add`]) == 0 && len(cmp[`rm`]) == 0 && len(cmp[`update`]) == 0 && len(cmp[`boot`]) == 0 &&
+		len(cmp[`secure`]) == 0
+	foreign := s.ForeignConfigs()
+	if len(s.ctrlSpecs) == 0 && len(foreign) == 0 {
+		return cmp, same
+	}
+	res := &Comparison{Controllers: s.ControllerChanges(), Volumes: cmp, Foreign: foreign}
+	for _, change := range res.Controllers {
+		res.RebootRequired = res.RebootRequired || change.RebootRequired
+	}
+	return res, same && len(res.Controllers) == 0 && len(foreign) == 0
+}
+
 func (s *session) Configure(doAppend, force bool) {
+	s.configure(doAppend, false, force)
+}
+
+// Reconcile is like Configure, except that volumes that are not wanted
+// are deleted instead of being an error.  Volumes that match a wanted volspec
+// are left alone.
+func (s *session) Reconcile(force bool) {
+	s.configure(false, true, force)
+}
+
+// changes works out what configure needs to do to make the controllers
+// match the wanted volspecs.  It returns the diff and the volumes that
+// need to be deleted, or false if configure should not go any further.
+func (s *session) changes(doAppend, reconcile bool) (map[string]VolSpecs, []*Volume, bool) {
 	s.WantedSpecs()
 	if s.HasError() {
-		return
+		return nil, nil, false
 	}
 	if doAppend {
-		s.inSpecs = append(s.CurrentSpecs(true), s.inSpecs...)
+		current := s.CurrentSpecs(true)
+		for _, spec := range s.inSpecs {
+			if !spec.Bootable {
+				continue
+			}
+			// The new boot volume takes over from the current one.
+			for _, cur := range current {
+				cur.Bootable = false
+			}
+		}
+		s.inSpecs = append(current, s.inSpecs...)
 	}
 	s.Compile()
 	if s.HasError() {
-		return
+		return nil, nil, false
 	}
 	cmp, _ := s.Diff()
+	if len(cmp[`update`]) != 0 && !s.updateInPlace {
+		if !reconcile || !s.recreatePolicies {
+			for _, spec := range cmp[`update`] {
+				s.log.Printf("Volume %s on %s does not have the wanted name or policies", spec.VolumeID, s.controllers[spec.Controller].Name())
+			}
+			s.Errorf("Volume policies differ, use -update-policies to change them, or -reconcile -recreate-policies to recreate the volumes")
+			return nil, nil, false
+		}
+		// Recreate the volumes with the wanted policies.
+		for _, spec := range cmp[`update`] {
+			for _, cur := range cmp[`current`] {
+				if cur.Controller == spec.Controller && cur.VolumeID == spec.VolumeID {
+					cmp[`rm`] = append(cmp[`rm`], cur)
+				}
+			}
+			spec.VolumeID = ""
+			cmp[`add`] = append(cmp[`add`], spec)
+		}
+		cmp[`update`] = VolSpecs{}
+		// Recreated volumes are secured and made bootable after they are
+		// created instead.
+		added := cmp[`add`].ByKey()
+		for _, k := range []string{`boot`, `secure`} {
+			left := VolSpecs{}
+			for _, spec := range cmp[k] {
+				if _, ok := added[spec.Key()]; !ok {
+					left = append(left, spec)
+				}
+			}
+			cmp[k] = left
+		}
+	}
+	vols := []*Volume{}
 	if len(cmp[`rm`]) != 0 {
-		s.Errorf("Cannot remove volumes using -configure")
+		if !reconcile {
+			s.Errorf("Cannot remove volumes using -configure")
+			return nil, nil, false
+		}
+		// Check everything before deleting anything.
+		vols = s.deletable(cmp[`rm`])
+		if s.HasError() {
+			return nil, nil, false
+		}
+	}
+	startingIndex := len(cmp[`current`])
+	for ii, spec := range cmp[`add`] {
+		spec.index = startingIndex + ii
+	}
+	return cmp, vols, true
+}
+
+func (s *session) configure(doAppend, reconcile, force bool) {
+	if s.WantedSpecs(); s.HasError() {
+		return
+	}
+	if s.ConfigureControllers() {
+		s.needReboot = true
+		s.log.Printf("Controller settings changed that need a reboot, configure volumes after rebooting")
+		return
+	}
+	if s.HasError() {
+		return
+	}
+	cmp, vols, ok := s.changes(doAppend, reconcile)
+	if !ok {
+		return
+	}
+	if len(vols) != 0 {
+		for _, vol := range vols {
+			c := vol.controller
+			if err := c.Delete(vol); err != nil {
+				s.Errorf("Error deleting %s %s on %s:%s : %v",
+					vol.RaidLevel,
+					vol.ID,
+					c.Driver,
+					c.ID,
+					err)
+				return
+			}
+			s.log.Printf("Deleted %s %s on %s:%s",
+				vol.RaidLevel,
+				vol.ID,
+				c.Driver,
+				c.ID)
+		}
+		if !fake {
+			s.Controllers("")
+		}
+	}
+	for _, spec := range cmp[`update`] {
+		c := s.controllers[spec.Controller]
+		vol := c.Volume(spec.VolumeID)
+		if vol == nil {
+			s.Errorf("Volume %s not found on %s:%s", spec.VolumeID, c.Driver, c.ID)
+			return
+		}
+		if err := c.Update(vol, spec); err != nil {
+			s.Errorf("Error updating %s %s on %s:%s : %v",
+				vol.RaidLevel,
+				vol.ID,
+				c.Driver,
+				c.ID,
+				err)
+			return
+		}
+		s.log.Printf("Updated %s %s on %s:%s",
+			vol.RaidLevel,
+			vol.ID,
+			c.Driver,
+			c.ID)
+	}
+	if s.setKeys(cmp); s.HasError() {
+		return
+	}
+	if s.secureVolumes(cmp[`secure`]); s.HasError() {
 		return
 	}
 	if len(cmp[`add`]) == 0 {
 		s.log.Printf("All volumes already present, nothing to to")
+		s.setBoot(nil)
 		return
 	}
-	startingIndex := len(cmp[`current`])
 	failed := false
-	for ii, spec := range cmp[`add`] {
+	for _, spec := range cmp[`add`] {
 		c := s.controllers[spec.Controller]
-		spec.index = startingIndex + ii
 		if err := c.Create(spec, force); err != nil {
 			failed = true
 			s.log.Printf("Error creating %s on %s:%s : %v",
@@ -309,27 +1076,267 @@ func (s *session) Configure(doAppend, force bool) {
 	}
 	if failed {
 		s.Errorf("Failed to create some volumes")
+		return
 	}
+	s.secureVolumes(s.unsecured())
+	s.setBoot(cmp[`add`])
+}
+
+// setBoot makes the volumes built from Bootable volspecs the ones their
+// controllers boot from.  Controllers that cannot say which volume they
+// boot from only get it set for the volumes in added, which were just
+// created, so that it is not set again on every run.
+func (s *session) setBoot(added VolSpecs) {
+	if fake {
+		return
+	}
+	current := s.CurrentSpecs(true).ByKey()
+	created := added.ByKey()
+	for _, spec := range s.compiledSpecs {
+		if !spec.Bootable {
+			continue
+		}
+		c := s.controllers[spec.Controller]
+		if _, ok := created[spec.Key()]; c.BootUnknown && !ok {
+			continue
+		}
+		cur, ok := current[spec.Key()]
+		if !ok {
+			s.Errorf("Cannot find the boot volume %s on %s", spec.Key(), c.Name())
+			continue
+		}
+		vol := c.Volume(cur.VolumeID)
+		if vol == nil || vol.Bootable {
+			continue
+		}
+		if err := c.SetBoot(vol); err != nil {
+			s.Errorf("Error making %s the boot volume of %s: %v", vol.ID, c.Name(), err)
+			continue
+		}
+		s.log.Printf("Made %s %s the boot volume of %s", vol.RaidLevel, vol.ID, c.Name())
+	}
+}
+
+// PlanStep is one thing -plan would have done to a controller, along
+// with the exact commands it would have run to do it.
+type PlanStep struct {
+	Controller string
+	Action     string
+	Commands   [][]string
+}
+
+// Plan is the output of -plan.  Commands that depend on the output of
+// earlier commands have "<new>" in place of the value they would use.
+type Plan struct {
+	Controllers []*ControllerChange `json:",omitempty"`
+	VolSpecs    VolSpecs            `json:",omitempty"`
+	Diff        map[string]VolSpecs `json:",omitempty"`
+	Steps       []PlanStep
+}
+
+func (s *session) planStep(p *Plan, c *Controller, action string, cmds [][]string, err error) {
+	if err != nil {
+		s.Errorf("Cannot %s on %s: %v", action, c.Name(), err)
+		return
+	}
+	p.Steps = append(p.Steps, PlanStep{Controller: c.Name(), Action: action, Commands: s.secrets.redact(cmds)})
+}
+
+// PlanClear returns the commands Clear would run.
+func (s *session) PlanClear() *Plan {
+	p := &Plan{Steps: []PlanStep{}}
+	for _, c := range s.controllers {
+		cmds, err := c.ClearCmds()
+		s.planStep(p, c, "clear", cmds, err)
+	}
+	return p
+}
+
+// PlanEncrypt returns the commands Clear and Encrypt would run.
+func (s *session) PlanEncrypt(key, password string) *Plan {
+	p := s.PlanClear()
+	for _, c := range s.controllers {
+		cmds, err := c.EncryptCmds(key, password)
+		s.planStep(p, c, "encrypt", cmds, err)
+	}
+	return p
+}
+
+// PlanLocate returns the commands Locate would run.
+func (s *session) PlanLocate(selectors string, on bool) *Plan {
+	p := &Plan{Steps: []PlanStep{}}
+	action := "unlocate "
+	if on {
+		action = "locate "
+	}
+	for _, t := range s.SelectDisks(selectors) {
+		cmds, err := t.c.LocateCmds(t.d, on)
+		s.planStep(p, t.c, action+t.d.Name(), cmds, err)
+	}
+	return p
+}
+
+// PlanErase returns the commands Erase would run.
+func (s *session) PlanErase(selectors, method string) *Plan {
+	p := &Plan{Steps: []PlanStep{}}
+	for _, t := range s.eraseTargets(selectors, method) {
+		cmds, err := t.c.EraseCmds(t.d, method)
+		s.planStep(p, t.c, method+" erase "+t.d.Name(), cmds, err)
+	}
+	return p
+}
+
+// PlanCheck returns the commands Check would run.
+func (s *session) PlanCheck(selectors string, start bool) *Plan {
+	p := &Plan{Steps: []PlanStep{}}
+	action := "stop check "
+	if start {
+		action = "check "
+	}
+	for _, v := range s.SelectVolumes(selectors) {
+		cmds, err := v.controller.CheckCmds(v, start)
+		s.planStep(p, v.controller, action+v.ID, cmds, err)
+	}
+	return p
+}
+
+// PlanExpand returns the commands Expand would run.
+func (s *session) PlanExpand() *Plan {
+	p := &Plan{Steps: []PlanStep{}}
+	for _, e := range s.Expansions() {
+		c := s.controllers[e.Controller]
+		cmds, err := c.ExpandCmds(e)
+		s.planStep(p, c, "expand "+e.String(), cmds, err)
+	}
+	return p
+}
+
+// PlanConfigure returns the commands configure would run, along with
+// the compiled volspecs and the diff they were planned from.
+func (s *session) PlanConfigure(doAppend, reconcile, force bool) *Plan {
+	p := &Plan{Steps: []PlanStep{}}
+	if s.WantedSpecs(); s.HasError() {
+		return p
+	}
+	p.Controllers = s.ControllerChanges()
+	for _, cs := range settingsChanges(p.Controllers) {
+		c := s.controllers[cs.Controller]
+		cmds, err := c.SettingsCmds(cs)
+		s.planStep(p, c, settingsAction(cs), cmds, err)
+	}
+	cmp, vols, ok := s.changes(doAppend, reconcile)
+	if !ok {
+		return p
+	}
+	p.VolSpecs, p.Diff = s.compiledSpecs, cmp
+	for _, vol := range vols {
+		cmds, err := vol.controller.DeleteCmds(vol)
+		s.planStep(p, vol.controller, "delete "+vol.ID, cmds, err)
+	}
+	for _, spec := range cmp[`update`] {
+		c := s.controllers[spec.Controller]
+		vol := c.Volume(spec.VolumeID)
+		if vol == nil {
+			s.Errorf("Volume %s not found on %s:%s", spec.VolumeID, c.Driver, c.ID)
+			continue
+		}
+		cmds, err := c.UpdateCmds(vol, spec)
+		s.planStep(p, c, "update "+vol.ID, cmds, err)
+	}
+	if s.secrets != nil {
+		for _, c := range s.keyControllers(cmp) {
+			cmds, err := c.EncryptCmds(s.secrets.KeyID, s.secrets.Password)
+			s.planStep(p, c, "set key "+s.secrets.KeyID, cmds, err)
+		}
+	}
+	for _, spec := range cmp[`secure`] {
+		c := s.controllers[spec.Controller]
+		vol := c.Volume(spec.VolumeID)
+		if vol == nil {
+			s.Errorf("Volume %s not found on %s", spec.VolumeID, c.Name())
+			continue
+		}
+		cmds, err := c.SecureCmds(vol)
+		s.planStep(p, c, "secure "+vol.ID, cmds, err)
+	}
+	for _, spec := range cmp[`add`] {
+		c := s.controllers[spec.Controller]
+		cmds, err := c.CreateCmds(spec, force)
+		s.planStep(p, c, "create "+spec.Key(), cmds, err)
+	}
+	for _, spec := range append(cmp[`boot`], cmp[`add`]...) {
+		if !spec.Bootable {
+			continue
+		}
+		c := s.controllers[spec.Controller]
+		vol := c.Volume(spec.VolumeID)
+		if vol == nil {
+			vol = &Volume{ID: newVolPlaceholder, RaidLevel: spec.RaidLevel}
+		}
+		cmds, err := c.SetBootCmds(vol)
+		s.planStep(p, c, "boot "+spec.Key(), cmds, err)
+	}
+	return p
 }
 
 func main() {
-	var volspecs, config, clear, force, compile, compare, addthem, encrypt, generic bool
+	var volspecs, config, clear, force, compile, compare, addthem, encrypt, generic, reconcile bool
+	var deleteBoot, deleteInUse, updateInPlace, recreatePolicies, plan, health bool
+	var thresholds HealthThresholds
 	var controllerFile string
-	var tools string
-	var password, key string
+	var tools, record, replay string
+	var locate, unlocate, erase, eraseMethod string
+	var watch, wait, expand, foreign bool
+	var importForeign string
+	var startCheck, stopCheck string
+	var interval, timeout time.Duration
+	var password, key, secretsFile string
+	var secretsFD int
+	var rekey, unlock, keyRecord bool
 	flag.BoolVar(&generic, "generic", false, "Output volspecs in generic format")
 	flag.BoolVar(&volspecs, "volspecs", false, "Output volspecs for all currently configured RAID volumes")
 	flag.BoolVar(&config, "configure", false, "Configure volumes on raid controllers to match volspecs on stdin")
+	flag.BoolVar(&reconcile, "reconcile", false, "Configure volumes to match volspecs on stdin, deleting volumes that are not wanted")
+	flag.BoolVar(&deleteBoot, "delete-boot", false, "Allow -reconcile to delete the volume the controller boots from")
+	flag.BoolVar(&deleteInUse, "delete-in-use", false, "Allow -reconcile to delete volumes that the running OS is using, or that have no known OS device")
+	flag.BoolVar(&updateInPlace, "update-policies", false, "Change the name and cache policies of existing volumes in place")
+	flag.BoolVar(&recreatePolicies, "recreate-policies", false, "Allow -reconcile to delete and recreate volumes whose name or cache policies differ, when -update-policies is not given")
+	flag.BoolVar(&plan, "plan", false, "Print the commands -configure, -append, -reconcile, -clear, -encrypt, -rekey, -unlock, -expand, -erase, -import-foreign, -locate, -unlocate, -start-check, or -stop-check would run without running them")
+	flag.BoolVar(&health, "health", false, "Report the health of every disk, and exit 1 if any cross the -max-* thresholds")
+	flag.Int64Var(&thresholds.MediaErrors, "max-media-errors", 0, "Media errors a disk can have before -health flags it, -1 to not check")
+	flag.Int64Var(&thresholds.OtherErrors, "max-other-errors", -1, "Other errors a disk can have before -health flags it, -1 to not check")
+	flag.Int64Var(&thresholds.PredictiveFailures, "max-predictive-failures", 0, "Predictive failures a disk can have before -health flags it, -1 to not check")
+	flag.Int64Var(&thresholds.Temperature, "max-temperature", 60, "Temperature in Celsius a disk can reach before -health flags it, -1 to not check")
+	flag.StringVar(&locate, "locate", "", "Turn on the locate LED for the comma separated list of enclosure:slot disks or volume names")
+	flag.StringVar(&unlocate, "unlocate", "", "Turn off the locate LED for the comma separated list of enclosure:slot disks or volume names")
+	flag.StringVar(&erase, "erase", "", "Erase the comma separated list of enclosure:slot disks or volume names, or all disks, and print a report of what was erased")
+	flag.StringVar(&eraseMethod, "erase-method", "overwrite", "How -erase erases disks: crypto, overwrite, or block")
+	flag.BoolVar(&watch, "watch", false, "Report background initialization, rebuild, copyback, and consistency check progress")
+	flag.BoolVar(&wait, "wait", false, "Make -watch and -erase wait until all background operations have finished")
+	flag.DurationVar(&interval, "interval", 30*time.Second, "How often -watch -wait and -erase -wait check progress")
+	flag.DurationVar(&timeout, "timeout", 0, "How long -watch -wait and -erase -wait wait before failing, 0 to wait forever")
+	flag.StringVar(&startCheck, "start-check", "", "Start a consistency check on the comma separated list of volume names, or all")
+	flag.StringVar(&stopCheck, "stop-check", "", "Stop the consistency check on the comma separated list of volume names, or all")
+	flag.BoolVar(&expand, "expand", false, "Add disks to or change the raid level of the existing volumes in the volspecs on stdin")
+	flag.BoolVar(&foreign, "foreign", false, "Print the foreign configs on the controllers, with their disks and the volumes importing them would create")
+	flag.StringVar(&importForeign, "import-foreign", "", "Import the comma separated list of foreign configs, as controller/ID or ID, or all")
 	flag.BoolVar(&compare, "compare", false, "Compare current config with passed-in volspecs")
 	flag.BoolVar(&clear, "clear", false, "Clear all local and foreign configuration")
 	flag.BoolVar(&force, "force", false, "Force any drives to be good when configuring or wiping")
 	flag.BoolVar(&compile, "compile", false, "Compile volspecs on stdin to final ones for the controller on stdout")
 	flag.BoolVar(&encrypt, "encrypt", false, "Encrypt the volumes on the controllers with the key and password. It implies clear")
-	flag.StringVar(&password, "password", "", "Password for encryption")
-	flag.StringVar(&key, "key", "", "Key for encryption")
+	flag.StringVar(&password, "password", "", "Password for encryption.  It shows up in ps, use -secrets-fd or -secrets-file instead")
+	flag.StringVar(&key, "key", "", "Key for encryption.  It shows up in ps, use -secrets-fd or -secrets-file instead")
+	flag.IntVar(&secretsFD, "secrets-fd", -1, "Read the encryption KeyID, Password, NewKeyID, and NewPassword as a JSON object from this file descriptor")
+	flag.StringVar(&secretsFile, "secrets-file", "", "Read the encryption KeyID, Password, NewKeyID, and NewPassword as a JSON object from this file")
+	flag.BoolVar(&rekey, "rekey", false, "Change the security key of the controllers with secured volumes from Password to NewPassword, and print the key record")
+	flag.BoolVar(&unlock, "unlock", false, "Unlock secured disks moved from another controller with Password and import their configuration, and print the key record")
+	flag.BoolVar(&keyRecord, "key-record", false, "Print which volumes are secured with which key ID")
 	flag.BoolVar(&addthem, "append", false, "Add new volumes to existing ones")
 	flag.StringVar(&controllerFile, "controller", "", "Controller json file for testing")
-	flag.StringVar(&tools, "tools", "", "Tools to limit")
+	flag.StringVar(&tools, "tools", "", "Comma separated tools to use, most preferred first.  mdadm and nvme are off unless they are listed here")
+	flag.StringVar(&record, "record", "", "Save every command run and file read, along with its output, to this file")
+	flag.StringVar(&replay, "replay", "", "Answer commands and file reads from a file saved with -record instead of the system")
 	flag.Parse()
 	if tools != "" {
 		pieces := strings.Split(tools, ",")
@@ -347,16 +1354,41 @@ func main() {
 		}
 		allDrivers = newDrivers
 	}
-	s := newSession().Controllers(controllerFile)
-	s.ExitOnError()
-	if compare {
-		cmp, err := s.Compile().Diff()
+	switch {
+	case record != "" && replay != "":
+		log.Fatalln("Cannot use -record and -replay together")
+	case record != "":
+		executor = newRecorder(record)
+	case replay != "":
+		r, err := newReplayer(replay)
 		if err != nil {
-			s.log.Fatalln(err.Error())
+			log.Fatalln(err)
 		}
+		executor = r
+	}
+	secrets, err := readSecrets(secretsFD, secretsFile, key, password)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	switch e := executor.(type) {
+	case *recorder:
+		e.secrets = secrets
+	case *replayer:
+		e.secrets = secrets
+	}
+	s := newSession().Secrets(secrets).Controllers(controllerFile)
+	s.deleteBoot, s.deleteInUse, s.updateInPlace = deleteBoot, deleteInUse, updateInPlace
+	s.recreatePolicies = recreatePolicies
+	s.ExitOnError()
+	if keyRecord {
+		s.PrettyPrint(s.KeyRecords())
+		os.Exit(0)
+	}
+	if compare {
+		res, same := s.Compare()
 		s.ExitOnError()
-		s.PrettyPrint(cmp)
-		if len(cmp[`add`]) == 0 && len(cmp[`rm`]) == 0 {
+		s.PrettyPrint(res)
+		if same {
 			os.Exit(0)
 		} else {
 			os.Exit(1)
@@ -366,15 +1398,132 @@ func main() {
 		s.PrettyPrint(s.CurrentSpecs(!generic))
 		os.Exit(0)
 	}
+	if health {
+		reports := s.Health(thresholds)
+		s.PrettyPrint(reports)
+		for _, r := range reports {
+			if len(r.Problems) > 0 {
+				os.Exit(1)
+			}
+		}
+		os.Exit(0)
+	}
+	if plan {
+		var p *Plan
+		switch {
+		case clear:
+			p = s.PlanClear()
+		case encrypt:
+			if s.needSecrets("-encrypt", false) {
+				p = s.PlanEncrypt(secrets.KeyID, secrets.Password)
+			}
+		case rekey:
+			p = s.PlanRekey()
+		case unlock:
+			p = s.PlanUnlock()
+		case expand:
+			p = s.PlanExpand()
+		case erase != "":
+			p = s.PlanErase(erase, eraseMethod)
+		case importForeign != "":
+			p = s.PlanImportForeign(importForeign)
+		case startCheck != "":
+			p = s.PlanCheck(startCheck, true)
+		case stopCheck != "":
+			p = s.PlanCheck(stopCheck, false)
+		case locate != "":
+			p = s.PlanLocate(locate, true)
+		case unlocate != "":
+			p = s.PlanLocate(unlocate, false)
+		case addthem:
+			p = s.PlanConfigure(true, false, force)
+		case reconcile:
+			p = s.PlanConfigure(false, true, force)
+		default:
+			p = s.PlanConfigure(false, false, force)
+		}
+		s.ExitOnError()
+		s.PrettyPrint(p)
+		os.Exit(0)
+	}
+	if locate != "" || unlocate != "" {
+		if locate != "" {
+			s.Locate(locate, true)
+		}
+		if unlocate != "" {
+			s.Locate(unlocate, false)
+		}
+		s.ExitOnError()
+		os.Exit(0)
+	}
+	if foreign {
+		cfgs := s.ForeignConfigs()
+		s.ExitOnError()
+		s.PrettyPrint(cfgs)
+		os.Exit(0)
+	}
+	if importForeign != "" {
+		s.ImportForeign(importForeign)
+		s.ExitOnError()
+		s.PrettyPrint(s.controllers)
+		os.Exit(0)
+	}
+	if erase != "" {
+		reports := s.Erase(erase, eraseMethod, wait, interval, timeout)
+		if reports != nil {
+			s.PrettyPrint(reports)
+		}
+		s.ExitOnError()
+		os.Exit(0)
+	}
+	if expand {
+		s.Expand()
+		s.ExitOnError()
+		if !watch {
+			s.PrettyPrint(s.controllers)
+			os.Exit(0)
+		}
+	}
+	if startCheck != "" || stopCheck != "" {
+		if startCheck != "" {
+			s.Check(startCheck, true)
+		}
+		if stopCheck != "" {
+			s.Check(stopCheck, false)
+		}
+		s.ExitOnError()
+		if !watch {
+			os.Exit(0)
+		}
+	}
+	if watch {
+		ops := s.Watch(wait, interval, timeout)
+		s.PrettyPrint(ops)
+		s.ExitOnError()
+		os.Exit(0)
+	}
 	if clear {
 		s.Clear()
 		s.ExitOnError()
 		os.Exit(0)
 	}
 	if encrypt {
-		s.Clear()
-		s.Encrypt(key, password)
+		if s.needSecrets("-encrypt", false) {
+			s.Clear()
+			s.Encrypt(secrets.KeyID, secrets.Password)
+		}
+		s.ExitOnError()
+		os.Exit(0)
+	}
+	if rekey || unlock {
+		if rekey {
+			s.Rekey()
+		}
+		if unlock {
+			s.Unlock()
+		}
 		s.ExitOnError()
+		s.PrettyPrint(s.KeyRecords())
 		os.Exit(0)
 	}
 	if compile {
@@ -389,7 +1538,13 @@ func main() {
 	if config {
 		s.Configure(false, force)
 	}
+	if reconcile {
+		s.Reconcile(force)
+	}
 	s.PrettyPrint(s.controllers)
 	s.ExitOnError()
+	if s.needReboot {
+		os.Exit(192)
+	}
 	os.Exit(0)
 }
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestDeletable(t *testing.T) {
+	c := ctrlrs(1, "megacli")[0]
+	c.addDisks(4, 1<<40, "sas", "disk")
+	for i, id := range []string{"0", "1"} {
+		vol := &Volume{
+			ControllerID:     c.ID,
+			ControllerDriver: c.Driver,
+			ID:               id,
+			RaidLevel:        "raid1",
+			Disks:            c.Disks[i*2 : i*2+2],
+			Info:             map[string]string{},
+			controller:       c,
+			driver:           c.driver,
+		}
+		c.Volumes = append(c.Volumes, vol)
+	}
+	c.Volumes[0].Bootable = true
+	specs := VolSpecs{
+		&VolSpec{VolumeID: "0"},
+		&VolSpec{VolumeID: "1"},
+	}
+	oldFake := fake
+	fake = false
+	defer func() { fake = oldFake }()
+	for _, tc := range []struct {
+		name        string
+		deleteBoot  bool
+		deleteInUse bool
+		specs       VolSpecs
+		count       int
+		err         bool
+	}{
+		{"boot protected", false, true, specs, 1, true},
+		{"boot allowed", true, true, specs, 2, false},
+		{"not boot", false, true, specs[1:], 1, false},
+		{"missing", true, true, VolSpecs{&VolSpec{VolumeID: "7"}}, 0, true},
+		// Neither volume has a known OS device, so either might be in use.
+		{"unknown device", true, false, specs, 0, true},
+	} {
+		s := &session{
+			log:         log.New(ioutil.Discard, "", 0),
+			controllers: Controllers{c},
+			deleteBoot:  tc.deleteBoot,
+			deleteInUse: tc.deleteInUse,
+		}
+		vols := s.deletable(tc.specs)
+		if len(vols) != tc.count {
+			t.Errorf("%s: expected %d volumes, got %d", tc.name, tc.count, len(vols))
+		}
+		if s.HasError() != tc.err {
+			t.Errorf("%s: expected error %v, got %v", tc.name, tc.err, s.HasError())
+		}
+	}
+
+	// When the controller cannot say which volume it boots from, any of
+	// them might be the boot volume.
+	c.BootUnknown = true
+	for _, deleteBoot := range []bool{false, true} {
+		s := &session{
+			log:         log.New(ioutil.Discard, "", 0),
+			controllers: Controllers{c},
+			deleteBoot:  deleteBoot,
+			deleteInUse: true,
+		}
+		want := 0
+		if deleteBoot {
+			want = 1
+		}
+		if vols := s.deletable(specs[1:]); len(vols) != want || s.HasError() == deleteBoot {
+			t.Errorf("unknown boot: expected %d volumes with -delete-boot %v, got %d", want, deleteBoot, len(vols))
+		}
+	}
+}
+
+func TestVolumeInUse(t *testing.T) {
+	oldExecutor, oldFake := executor, fake
+	fake = false
+	defer func() { executor, fake = oldExecutor, oldFake }()
+	// calls are what the check sees for /dev/sdb with one partition, up
+	// to the point where it knows the answer.
+	calls := func(holders []string, files ...string) []*Call {
+		res := []*Call{
+			{Op: "evalsymlinks", Path: "/dev/sdb", Stdout: "/dev/sdb"},
+			{Op: "readdir", Path: "/sys/class/block/sdb", Names: []string{"holders", "queue", "sdb1", "size"}},
+			{Op: "readdir", Path: "/sys/class/block/sdb/holders"},
+			{Op: "readdir", Path: "/sys/class/block/sdb1/holders", Names: holders},
+		}
+		for i, buf := range files {
+			res = append(res, &Call{Op: "readfile", Path: []string{"/proc/mounts", "/proc/swaps"}[i], Stdout: buf})
+		}
+		return res
+	}
+	const swapHeader = "Filename\tType\tSize\tUsed\tPriority\n"
+	for _, tc := range []struct {
+		name  string
+		calls []*Call
+		inUse bool
+	}{
+		{"unused", calls(nil, "/dev/sda1 / ext4 rw 0 0\n", swapHeader+"/dev/sda2 partition 8388604 0 -2\n"), false},
+		{"mounted", calls(nil, "/dev/sda1 / ext4 rw 0 0\n/dev/sdb1 /data xfs rw 0 0\n"), true},
+		{"held", calls([]string{"dm-0"}), true},
+		{"swap by uuid", append(calls(nil, "/dev/sda1 / ext4 rw 0 0\n", swapHeader+"/dev/disk/by-uuid/0e1f partition 8388604 0 -2\n"),
+			&Call{Op: "evalsymlinks", Path: "/dev/disk/by-uuid/0e1f", Stdout: "/dev/sdb1"}), true},
+	} {
+		r := &replayer{calls: tc.calls}
+		executor = r
+		vol := &Volume{ID: "1", Info: map[string]string{"OS Drive Name": "/dev/sdb"}}
+		if got := vol.InUse(); got != tc.inUse {
+			t.Errorf("%s: expected in use %v, got %v", tc.name, tc.inUse, got)
+		}
+		for _, c := range r.Missed() {
+			t.Errorf("%s: unexpected %s", tc.name, c)
+		}
+		for _, c := range r.Unused() {
+			t.Errorf("%s: did not check %s", tc.name, c)
+		}
+	}
+}
+
+func TestDiffPolicies(t *testing.T) {
+	oldFake := fake
+	fake = false
+	defer func() { fake = oldFake }()
+	c := ctrlrs(1, "megacli")[0]
+	c.addDisks(2, 1<<40, "sas", "disk")
+	c.Volumes = append(c.Volumes, &Volume{
+		ControllerID:     c.ID,
+		ControllerDriver: c.Driver,
+		ID:               "0",
+		RaidLevel:        "raid1",
+		Disks:            c.Disks,
+		WritePolicy:      "writeback",
+		Info:             map[string]string{},
+		controller:       c,
+		driver:           c.driver,
+	})
+	for _, d := range c.Disks {
+		d.VolumeID = "0"
+	}
+	for _, tc := range []struct {
+		name   string
+		policy string
+		update int
+	}{
+		{"same", "writeback", 0},
+		{"unset", "", 0},
+		{"changed", "writethrough", 1},
+	} {
+		s := &session{
+			log:         log.New(ioutil.Discard, "", 0),
+			controllers: Controllers{c},
+			compiledSpecs: VolSpecs{&VolSpec{
+				RaidLevel:   "raid1",
+				Disks:       VolSpecDisks{{Slot: 0}, {Slot: 1}},
+				WritePolicy: tc.policy,
+			}},
+		}
+		cmp, err := s.Diff()
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if len(cmp["add"]) != 0 || len(cmp["rm"]) != 0 || len(cmp["update"]) != tc.update {
+			t.Errorf("%s: unexpected diff add %d rm %d update %d", tc.name, len(cmp["add"]), len(cmp["rm"]), len(cmp["update"]))
+		}
+		if tc.update > 0 && cmp["update"][0].VolumeID != "0" {
+			t.Errorf("%s: update does not reference volume 0", tc.name)
+		}
+	}
+}
+
+func TestPlanConfigure(t *testing.T) {
+	oldFake := fake
+	fake = false
+	defer func() { fake = oldFake }()
+	c := ctrlrs(1, "megacli")[0]
+	c.addDisks(4, 1<<40, "sas", "disk")
+	c.Volumes = append(c.Volumes, &Volume{
+		ControllerID:     c.ID,
+		ControllerDriver: c.Driver,
+		ID:               "0",
+		RaidLevel:        "raid1",
+		Disks:            c.Disks[:2],
+		Info:             map[string]string{},
+		controller:       c,
+		driver:           c.driver,
+	})
+	for _, d := range c.Disks[:2] {
+		d.VolumeID = "0"
+	}
+	for _, tc := range []struct {
+		name      string
+		reconcile bool
+		actions   []string
+		err       bool
+	}{
+		{"configure", false, []string{}, true},
+		{"reconcile", true, []string{"delete 0", "create 0:raid1,:2,:3"}, false},
+	} {
+		specs := VolSpecs{&VolSpec{
+			RaidLevel:  "raid1",
+			StripeSize: "64 KB",
+			Disks:      VolSpecDisks{{Slot: 2}, {Slot: 3}},
+			compiled:   true,
+		}}
+		s := &session{
+			log:           log.New(ioutil.Discard, "", 0),
+			controllers:   Controllers{c},
+			inSpecs:       specs,
+			compiledSpecs: specs,
+			deleteInUse:   true,
+		}
+		p := s.PlanConfigure(false, tc.reconcile, false)
+		if s.HasError() != tc.err {
+			t.Errorf("%s: expected error %v, got %v", tc.name, tc.err, s.HasError())
+		}
+		if len(p.Steps) != len(tc.actions) {
+			t.Fatalf("%s: expected %d steps, got %d", tc.name, len(tc.actions), len(p.Steps))
+		}
+		for i, step := range p.Steps {
+			if step.Action != tc.actions[i] {
+				t.Errorf("%s: step %d: expected %s, got %s", tc.name, i, tc.actions[i], step.Action)
+			}
+			if len(step.Commands) == 0 || step.Commands[0][0] != "/opt/MegaRAID/MegaCli/MegaCli64" {
+				t.Errorf("%s: step %d: unexpected commands %v", tc.name, i, step.Commands)
+			}
+		}
+	}
+}
+
+func TestPlanPolicies(t *testing.T) {
+	oldFake := fake
+	fake = false
+	defer func() { fake = oldFake }()
+	c := ctrlrs(1, "megacli")[0]
+	c.addDisks(2, 1<<40, "sas", "disk")
+	c.Volumes = append(c.Volumes, &Volume{
+		ControllerID:     c.ID,
+		ControllerDriver: c.Driver,
+		ID:               "0",
+		RaidLevel:        "raid1",
+		StripeSize:       64 << 10,
+		Disks:            c.Disks,
+		WritePolicy:      "writeback",
+		Info:             map[string]string{},
+		controller:       c,
+		driver:           c.driver,
+	})
+	for _, d := range c.Disks {
+		d.VolumeID = "0"
+	}
+	for _, tc := range []struct {
+		name                        string
+		reconcile, update, recreate bool
+		actions                     []string
+		err                         bool
+	}{
+		{"configure", false, false, false, []string{}, true},
+		{"reconcile", true, false, false, []string{}, true},
+		{"configure recreate", false, false, true, []string{}, true},
+		{"reconcile recreate", true, false, true, []string{"delete 0", "create 0:raid1,:0,:1"}, false},
+		{"configure update", false, true, false, []string{"update 0"}, false},
+		{"reconcile update", true, true, true, []string{"update 0"}, false},
+	} {
+		specs := VolSpecs{&VolSpec{
+			RaidLevel:   "raid1",
+			StripeSize:  "64 KB",
+			WritePolicy: "writethrough",
+			Disks:       VolSpecDisks{{Slot: 0}, {Slot: 1}},
+			compiled:    true,
+		}}
+		s := &session{
+			log:              log.New(ioutil.Discard, "", 0),
+			controllers:      Controllers{c},
+			inSpecs:          specs,
+			compiledSpecs:    specs,
+			deleteInUse:      true,
+			updateInPlace:    tc.update,
+			recreatePolicies: tc.recreate,
+		}
+		p := s.PlanConfigure(false, tc.reconcile, false)
+		if s.HasError() != tc.err {
+			t.Errorf("%s: expected error %v, got %v", tc.name, tc.err, s.HasError())
+		}
+		got := []string{}
+		for _, step := range p.Steps {
+			got = append(got, step.Action)
+		}
+		if strings.Join(got, ";") != strings.Join(tc.actions, ";") {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.actions, got)
+		}
+	}
+}
+
+func TestPlanLocate(t *testing.T) {
+	cs := Controllers{ctrlrs(1, "megacli")[0], ctrlrs(1, "mdadm")[0]}
+	cs[1].ID = "1"
+	for i, c := range cs {
+		c.idx = i
+		c.addDisks(3, 1<<40, "sas", "disk")
+		for _, d := range c.Disks {
+			d.Enclosure = "32"
+		}
+	}
+	c := cs[0]
+	c.Volumes = append(c.Volumes, &Volume{ID: "0", Name: "os", RaidLevel: "raid1", Disks: c.Disks[1:]})
+	for _, tc := range []struct {
+		name      string
+		selectors string
+		on        bool
+		want      []string
+		err       bool
+	}{
+		{"disk", "0/32:0", true, []string{"-PdLocate -start -PhysDrv [32:0] -a0"}, false},
+		{"volume", "os", false, []string{"-PdLocate -stop -PhysDrv [32:1] -a0", "-PdLocate -stop -PhysDrv [32:2] -a0"}, false},
+		{"unsupported", "1/32:0", true, []string{}, true},
+		{"both controllers", "32:0", true, []string{"-PdLocate -start -PhysDrv [32:0] -a0"}, true},
+		{"missing", "32:7", true, []string{}, true},
+		{"bad controller", "2/32:0", true, []string{}, true},
+	} {
+		s := &session{log: log.New(ioutil.Discard, "", 0), controllers: cs}
+		p := s.PlanLocate(tc.selectors, tc.on)
+		if s.HasError() != tc.err {
+			t.Errorf("%s: expected error %v, got %v", tc.name, tc.err, s.HasError())
+		}
+		if len(p.Steps) != len(tc.want) {
+			t.Fatalf("%s: expected %d steps, got %d", tc.name, len(tc.want), len(p.Steps))
+		}
+		for i, step := range p.Steps {
+			if got := strings.Join(step.Commands[0][1:], " "); got != tc.want[i] {
+				t.Errorf("%s: step %d: expected %s, got %s", tc.name, i, tc.want[i], got)
+			}
+		}
+	}
+}
@@ -0,0 +1,598 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+ * MdAdm drives Linux software RAID.
+ *
+ * There is no real controller, so all the block devices in the system
+ * that are not already md arrays are gathered up into a single
+ * pseudo-controller.  The disks are numbered in device name order, and
+ * that number is used as the Slot.  There are no enclosures.
+ *
+ * This driver is not enabled by default, since it would happily claim
+ * disks exposed by a hardware RAID controller.  Use -tools mdadm to
+ * turn it on.
+ */
+
+type MdAdm struct {
+	name       string
+	executable string
+	order      int
+	log        *log.Logger
+	enabled    bool
+}
+
+// mdadmPseudoBus is past the end of the PCI bus range, so the mdadm
+// pseudo-controller always sorts after any real controller.
+const mdadmPseudoBus = 1 << 16
+
+func (m *MdAdm) Logger(l *log.Logger) {
+	m.log = l
+}
+
+func (m *MdAdm) Order() int    { return m.order }
+func (m *MdAdm) Enabled() bool { return m.enabled }
+func (m *MdAdm) Enable()       { m.enabled = true }
+func (m *MdAdm) Disable()      { m.enabled = false }
+
+func (m *MdAdm) Name() string { return m.name }
+
+func (m *MdAdm) Executable() string { return m.executable }
+
+func (m *MdAdm) exec(executable string, args ...string) ([]string, error) {
+	if fake {
+		return []string{}, nil
+	}
+	out, _, err := executor.Run(true, executable, args...)
+	return strings.Split(string(out), "\n"), err
+}
+
+func (m *MdAdm) run(args ...string) ([]string, error) {
+	return m.exec(m.executable, args...)
+}
+
+func (m *MdAdm) Useable() bool {
+	_, err := m.run("--version")
+	return err == nil
+}
+
+// fillDisks parses the output of lsblk -J -b -d.  Older versions of lsblk
+// output everything as strings, newer ones use real JSON types.
+func (m *MdAdm) fillDisks(c *Controller, buf []byte) {
+	devs := &struct {
+		BlockDevices []map[string]interface{} `json:"blockdevices"`
+	}{}
+	if err := json.Unmarshal(buf, devs); err != nil {
+		m.log.Printf("Failed to process lsblk json: %v", err)
+		return
+	}
+	names := []string{}
+	byName := map[string]map[string]string{}
+	for _, dev := range devs.BlockDevices {
+		info := map[string]string{}
+		for k, v := range dev {
+			if v == nil {
+				continue
+			}
+			switch v := v.(type) {
+			case float64:
+				info[k] = strconv.FormatUint(uint64(v), 10)
+			default:
+				info[k] = strings.TrimSpace(fmt.Sprintf("%v", v))
+			}
+		}
+		if info["type"] != "disk" || info["tran"] == "usb" {
+			continue
+		}
+		if strings.HasPrefix(info["name"], "md") {
+			continue
+		}
+		names = append(names, info["name"])
+		byName[info["name"]] = info
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		info := byName[name]
+		d := &PhysicalDisk{
+			ControllerID:       c.ID,
+			ControllerDriver:   c.Driver,
+			Slot:               uint64(i),
+			Status:             "Good",
+			LogicalSectorSize:  512,
+			PhysicalSectorSize: 512,
+			Info:               map[string]string{},
+			controller:         c,
+			driver:             m,
+		}
+		for k, v := range info {
+			d.Info[k] = v
+		}
+		d.Info["Device"] = "/dev/" + name
+		d.Size, _ = strconv.ParseUint(info["size"], 10, 64)
+		if v, err := strconv.ParseUint(info["log-sec"], 10, 64); err == nil && v > 0 {
+			d.LogicalSectorSize = v
+		}
+		if v, err := strconv.ParseUint(info["phy-sec"], 10, 64); err == nil && v > 0 {
+			d.PhysicalSectorSize = v
+		}
+		d.SectorCount = d.Size / d.LogicalSectorSize
+		switch info["rota"] {
+		case "true", "1":
+			d.MediaType = "disk"
+		default:
+			d.MediaType = "ssd"
+		}
+		switch info["tran"] {
+		case "sata", "sas", "nvme":
+			d.Protocol = info["tran"]
+		default:
+			if strings.HasPrefix(name, "nvme") {
+				d.Protocol = "nvme"
+			} else {
+				d.Protocol = "scsi"
+			}
+		}
+		c.Disks = append(c.Disks, d)
+	}
+}
+
+func (m *MdAdm) diskByDevice(c *Controller, dev string) *PhysicalDisk {
+	for _, d := range c.Disks {
+		if d.Info["Device"] == dev {
+			return d
+		}
+	}
+	return nil
+}
+
+// fillVolume parses the output of mdadm --detail for a single array.
+func (m *MdAdm) fillVolume(c *Controller, vol *Volume, lines []string) {
+	vol.Info = map[string]string{}
+	inDevices := false
+	for _, line := range lines {
+		if inDevices {
+			fields := strings.Fields(line)
+			if len(fields) == 0 || !strings.HasPrefix(fields[len(fields)-1], "/dev/") {
+				continue
+			}
+			if d := m.diskByDevice(c, fields[len(fields)-1]); d != nil {
+				d.VolumeID = vol.ID
+				d.volume = vol
+				d.Status = strings.Join(fields[4:len(fields)-1], " ")
+				vol.Disks = append(vol.Disks, d)
+			}
+			continue
+		}
+		if strings.Contains(line, "Number") && strings.Contains(line, "RaidDevice") {
+			inDevices = true
+			continue
+		}
+		k, v := kv(line, " : ")
+		if k == "" {
+			continue
+		}
+		vol.Info[k] = v
+		switch k {
+		case "Name":
+			// mdadm names are homehost:name
+			parts := strings.SplitN(strings.Fields(v)[0], ":", 2)
+			vol.Name = parts[len(parts)-1]
+		case "State":
+			vol.Status = v
+		case "Raid Level":
+			switch v {
+			case "linear":
+				vol.RaidLevel = "concat"
+			default:
+				vol.RaidLevel = v
+			}
+		case "Array Size":
+			// Reported in KiB
+			kb, _ := strconv.ParseUint(strings.Fields(v)[0], 10, 64)
+			vol.Size = kb << 10
+		case "Chunk Size":
+			vol.StripeSize, _ = sizeParser(strings.TrimSuffix(v, "K") + " KB")
+		}
+	}
+	vol.Spans, vol.SpanLength = 1, uint64(len(vol.Disks))
+	if vol.RaidLevel == "raid10" {
+		vol.Spans, vol.SpanLength = uint64(len(vol.Disks))>>1, 2
+	}
+}
+
+func (m *MdAdm) fillVolumes(c *Controller) {
+	scan, err := m.run("--detail", "--scan")
+	if err != nil {
+		return
+	}
+	for _, line := range scan {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "ARRAY" {
+			continue
+		}
+		dev := fields[1]
+		detail, err := m.run("--detail", dev)
+		if err != nil {
+			m.log.Printf("Failed to get details for %s: %v", dev, err)
+			continue
+		}
+		vol := &Volume{
+			ControllerID:     c.ID,
+			ControllerDriver: c.Driver,
+			ID:               dev,
+			controller:       c,
+			driver:           m,
+		}
+		m.fillVolume(c, vol, detail)
+		vol.Info["Device"] = dev
+		c.Volumes = append(c.Volumes, vol)
+	}
+}
+
+func (m *MdAdm) fillController(c *Controller) {
+	c.Disks = []*PhysicalDisk{}
+	c.Volumes = []*Volume{}
+	c.ID = "0"
+	c.PCI.Bus = mdadmPseudoBus
+	c.JBODCapable = true
+	c.RaidCapable = true
+	c.AutoJBOD = true
+	c.RaidLevels = []string{"jbod", "concat", "raid0", "raid1", "raid5", "raid6", "raid10"}
+	c.Info = map[string]string{}
+	if out, err := m.run("--version"); err == nil && len(out) > 0 {
+		c.Info["Version"] = strings.TrimSpace(out[0])
+	}
+	if !fake {
+		out, _, err := executor.Run(false, "lsblk", "-J", "-b", "-d", "-o",
+			"NAME,SIZE,ROTA,TRAN,TYPE,MODEL,SERIAL,HCTL,LOG-SEC,PHY-SEC")
+		if err != nil {
+			m.log.Printf("lsblk failed: %v", err)
+			return
+		}
+		m.fillDisks(c, out)
+	}
+	m.fillVolumes(c)
+	for _, d := range c.Disks {
+		if d.VolumeID == "" {
+			c.addJBODVolume(d)
+		}
+	}
+}
+
+func (m *MdAdm) Controllers() []*Controller {
+	c := &Controller{
+		Driver: m.name,
+		driver: m,
+	}
+	m.fillController(c)
+	return []*Controller{c}
+}
+
+func (m *MdAdm) Refresh(c *Controller) {
+	m.fillController(c)
+}
+
+func (m *MdAdm) ClearCmds(c *Controller, onlyForeign bool) ([][]string, error) {
+	cmds := [][]string{}
+	if onlyForeign {
+		// Software RAID has no notion of a foreign config.
+		return cmds, nil
+	}
+	for _, vol := range c.Volumes {
+		if vol.Fake {
+			continue
+		}
+		volCmds, _ := m.DeleteCmds(c, vol)
+		cmds = append(cmds, volCmds...)
+	}
+	return cmds, nil
+}
+
+func (m *MdAdm) Clear(c *Controller, onlyForeign bool) error {
+	if onlyForeign {
+		return nil
+	}
+	for _, vol := range c.Volumes {
+		if vol.Fake {
+			continue
+		}
+		if err := m.Delete(c, vol); err != nil {
+			return err
+		}
+	}
+	m.fillController(c)
+	return nil
+}
+
+func (m *MdAdm) UpdateCmds(c *Controller, v *Volume, spec *VolSpec) ([][]string, error) {
+	return nil, fmt.Errorf("Changing volume policies is not supported")
+}
+
+func (m *MdAdm) Update(c *Controller, v *Volume, spec *VolSpec) error {
+	_, err := m.UpdateCmds(c, v, spec)
+	return err
+}
+
+func (m *MdAdm) SetBootCmds(c *Controller, v *Volume) ([][]string, error) {
+	return nil, fmt.Errorf("Setting the boot volume is not supported")
+}
+
+func (m *MdAdm) SetBoot(c *Controller, v *Volume) error {
+	_, err := m.SetBootCmds(c, v)
+	return err
+}
+
+func (m *MdAdm) DeleteCmds(c *Controller, vol *Volume) ([][]string, error) {
+	cmds := [][]string{{"--stop", vol.ID}}
+	for _, d := range vol.Disks {
+		cmds = append(cmds, []string{"--zero-superblock", d.Info["Device"]})
+	}
+	return cmds, nil
+}
+
+func (m *MdAdm) Delete(c *Controller, vol *Volume) error {
+	cmds, _ := m.DeleteCmds(c, vol)
+	for _, cmd := range cmds {
+		m.log.Printf("Running %s %s", m.executable, strings.Join(cmd, " "))
+		if out, err := m.run(cmd...); err != nil {
+			return fmt.Errorf("Error running cmd `%s`: %v\n%s", strings.Join(cmd, " "), err, strings.Join(out, "\n"))
+		}
+	}
+	return nil
+}
+
+func (m *MdAdm) devices(c *Controller, disks []VolSpecDisk) ([]string, error) {
+	res := make([]string, len(disks))
+	for i, disk := range disks {
+		found := false
+		for _, d := range c.Disks {
+			if d.Slot == disk.Slot && d.Enclosure == disk.Enclosure {
+				res[i] = d.Info["Device"]
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("No disk at slot %d", disk.Slot)
+		}
+	}
+	return res, nil
+}
+
+func (m *MdAdm) CreateCmds(c *Controller, v *VolSpec, forceGood bool) ([][]string, error) {
+	if !v.compiled {
+		return nil, fmt.Errorf("Cannot create a VolSpec that has not been compiled")
+	}
+	if len(v.HotSpareDisks) > 0 {
+		return nil, fmt.Errorf("Hot spares not supported")
+	}
+	if v.Encrypt {
+		return nil, fmt.Errorf("Encryption not supported")
+	}
+	devs, err := m.devices(c, v.Disks)
+	if err != nil {
+		return nil, err
+	}
+	name := v.Name
+	if name == "" {
+		name = fmt.Sprintf("vol%d", v.index)
+	}
+	cmdLine := []string{
+		"--create",
+		"/dev/md/" + name,
+		"--run",
+		"--metadata=1.2",
+		"--name=" + name,
+	}
+	switch v.RaidLevel {
+	case "jbod":
+		if len(v.Disks) == 1 {
+			m.log.Printf("Disk is already exposed to the OS, nothing to do")
+			return [][]string{}, nil
+		}
+		return nil, fmt.Errorf("Cannot create multi-drive jbod")
+	case "concat":
+		cmdLine = append(cmdLine, "--level=linear")
+	case "raid0":
+		cmdLine = append(cmdLine, "--level=0")
+		if len(devs) == 1 {
+			// mdadm refuses single disk raid0 without --force
+			cmdLine = append(cmdLine, "--force")
+		}
+	case "raid1":
+		cmdLine = append(cmdLine, "--level=1")
+	case "raid5":
+		cmdLine = append(cmdLine, "--level=5")
+	case "raid6":
+		cmdLine = append(cmdLine, "--level=6")
+	case "raid10":
+		cmdLine = append(cmdLine, "--level=10", "--layout=n2")
+	default:
+		return nil, fmt.Errorf("Raid level %s not supported", v.RaidLevel)
+	}
+	if v.RaidLevel != "raid1" && v.RaidLevel != "concat" {
+		cmdLine = append(cmdLine, fmt.Sprintf("--chunk=%d", v.stripeSize()>>10))
+	}
+	cmdLine = append(cmdLine, fmt.Sprintf("--raid-devices=%d", len(devs)))
+	cmdLine = append(cmdLine, devs...)
+	cmds := [][]string{}
+	if forceGood {
+		for _, dev := range devs {
+			cmds = append(cmds, []string{"--zero-superblock", "--force", dev})
+		}
+	}
+	return append(cmds, cmdLine), nil
+}
+
+func (m *MdAdm) Create(c *Controller, v *VolSpec, forceGood bool) error {
+	cmds, err := m.CreateCmds(c, v, forceGood)
+	if err != nil {
+		return err
+	}
+	for _, cmd := range cmds {
+		m.log.Printf("Running %s %s", m.executable, strings.Join(cmd, " "))
+		res, err := m.run(cmd...)
+		if len(res) > 0 {
+			m.log.Println(strings.Join(res, "\n"))
+		}
+		if err != nil {
+			// Zeroing a disk that never had a superblock fails, and that is fine.
+			if cmd[0] == "--zero-superblock" {
+				continue
+			}
+			return fmt.Errorf("Error running cmd `%s`: %v", strings.Join(cmd, " "), err)
+		}
+	}
+	return nil
+}
+
+func (m *MdAdm) EncryptCmds(c *Controller, key, password string) ([][]string, error) {
+	return nil, fmt.Errorf("Encryption not supported")
+}
+
+func (m *MdAdm) Encrypt(c *Controller, key, password string) error {
+	_, err := m.EncryptCmds(c, key, password)
+	return err
+}
+
+func (m *MdAdm) SecureCmds(c *Controller, v *Volume) ([][]string, error) {
+	return nil, fmt.Errorf("Encryption not supported")
+}
+
+func (m *MdAdm) Secure(c *Controller, v *Volume) error {
+	_, err := m.SecureCmds(c, v)
+	return err
+}
+
+func (m *MdAdm) RekeyCmds(c *Controller, sec *Secrets) ([][]string, error) {
+	return nil, fmt.Errorf("Encryption not supported")
+}
+
+func (m *MdAdm) Rekey(c *Controller, sec *Secrets) error {
+	_, err := m.RekeyCmds(c, sec)
+	return err
+}
+
+func (m *MdAdm) UnlockCmds(c *Controller, password string) ([][]string, error) {
+	return nil, fmt.Errorf("Encryption not supported")
+}
+
+func (m *MdAdm) Unlock(c *Controller, password string) error {
+	_, err := m.UnlockCmds(c, password)
+	return err
+}
+
+func (m *MdAdm) Health(c *Controller, d *PhysicalDisk) (*DiskHealth, error) {
+	return smartctlHealth(d.Info["Device"])
+}
+
+func (m *MdAdm) LocateCmds(c *Controller, d *PhysicalDisk, on bool) ([][]string, error) {
+	return nil, fmt.Errorf("Locating drives is not supported")
+}
+
+func (m *MdAdm) Locate(c *Controller, d *PhysicalDisk, on bool) error {
+	_, err := m.LocateCmds(c, d, on)
+	return err
+}
+
+// mdadmOps maps the progress lines in mdadm --detail to operations.
+var mdadmOps = map[string]string{
+	"Resync Status":  "init",
+	"Rebuild Status": "rebuild",
+	"Check Status":   "check",
+	"Reshape Status": "reshape",
+}
+
+func (m *MdAdm) BackgroundOps(c *Controller) ([]*BackgroundOp, error) {
+	res := []*BackgroundOp{}
+	for _, vol := range c.Volumes {
+		for k, kind := range mdadmOps {
+			if progress, ok := parsePercent(vol.Info[k]); ok {
+				res = append(res, &BackgroundOp{Controller: c.Name(), Volume: vol.ID, Operation: kind, Progress: progress})
+			}
+		}
+	}
+	return res, nil
+}
+
+func (m *MdAdm) CheckCmds(c *Controller, v *Volume, start bool) ([][]string, error) {
+	if err := checkable(v); err != nil {
+		return nil, err
+	}
+	action := "--action=idle"
+	if start {
+		action = "--action=check"
+	}
+	return [][]string{{"--misc", action, v.ID}}, nil
+}
+
+func (m *MdAdm) Check(c *Controller, v *Volume, start bool) error {
+	cmds, err := m.CheckCmds(c, v, start)
+	if err != nil {
+		return err
+	}
+	for _, cmd := range cmds {
+		m.log.Printf("Running %s %s", m.executable, strings.Join(cmd, " "))
+		if out, err := m.run(cmd...); err != nil {
+			return fmt.Errorf("Error running cmd `%s`: %v\n%s", strings.Join(cmd, " "), err, strings.Join(out, "\n"))
+		}
+	}
+	return nil
+}
+
+func (m *MdAdm) EraseCmds(c *Controller, d *PhysicalDisk, method string) ([][]string, error) {
+	return nil, fmt.Errorf("Erasing disks is not supported")
+}
+
+func (m *MdAdm) Erase(c *Controller, d *PhysicalDisk, method string) error {
+	_, err := m.EraseCmds(c, d, method)
+	return err
+}
+
+func (m *MdAdm) EraseProgress(c *Controller, d *PhysicalDisk, method string) (*EraseStatus, error) {
+	return nil, fmt.Errorf("Erasing disks is not supported")
+}
+
+func (m *MdAdm) ExpandCmds(c *Controller, e *Expansion) ([][]string, error) {
+	return nil, fmt.Errorf("Expanding volumes is not supported")
+}
+
+func (m *MdAdm) Expand(c *Controller, e *Expansion) error {
+	_, err := m.ExpandCmds(c, e)
+	return err
+}
+
+// ForeignConfigs reports none, as software RAID has no notion of a foreign config.
+func (m *MdAdm) ForeignConfigs(c *Controller) ([]*ForeignConfig, error) {
+	return []*ForeignConfig{}, nil
+}
+
+func (m *MdAdm) ImportForeignCmds(c *Controller, id string) ([][]string, error) {
+	return nil, fmt.Errorf("Importing foreign configs is not supported")
+}
+
+func (m *MdAdm) ImportForeign(c *Controller, id string) error {
+	_, err := m.ImportForeignCmds(c, id)
+	return err
+}
+
+func (m *MdAdm) Settings(c *Controller) (*ControllerSettings, error) {
+	return nil, fmt.Errorf("Changing controller settings is not supported")
+}
+
+func (m *MdAdm) SettingsCmds(c *Controller, spec *ControllerSpec) ([][]string, error) {
+	return nil, fmt.Errorf("Changing controller settings is not supported")
+}
+
+func (m *MdAdm) ApplySettings(c *Controller, spec *ControllerSpec) error {
+	_, err := m.SettingsCmds(c, spec)
+	return err
+}
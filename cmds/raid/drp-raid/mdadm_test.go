@@ -0,0 +1,107 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+const mdadmLsblk = `{
+   "blockdevices": [
+      {"name":"sdb", "size":"1000204886016", "rota":"1", "tran":"sata", "type":"disk", "model":"ST1000NM0033", "serial":"Z1W0AAAB", "hctl":"1:0:0:0", "log-sec":"512", "phy-sec":"4096"},
+      {"name":"sda", "size":1000204886016, "rota":true, "tran":"sata", "type":"disk", "model":"ST1000NM0033", "serial":"Z1W0AAAA", "hctl":"0:0:0:0", "log-sec":512, "phy-sec":4096},
+      {"name":"sr0", "size":1073741312, "rota":true, "tran":"sata", "type":"rom", "model":"DVD", "serial":null, "hctl":"2:0:0:0", "log-sec":2048, "phy-sec":2048},
+      {"name":"sdc", "size":8004829184, "rota":true, "tran":"usb", "type":"disk", "model":"Flash", "serial":"1234", "hctl":"3:0:0:0", "log-sec":512, "phy-sec":512},
+      {"name":"nvme0n1", "size":400088457216, "rota":false, "tran":"nvme", "type":"disk", "model":"INTEL SSDPE2MD400G4", "serial":"CVFT0000", "hctl":null, "log-sec":512, "phy-sec":512},
+      {"name":"md127", "size":1000070512640, "rota":true, "tran":null, "type":"raid1", "model":null, "serial":null, "hctl":null, "log-sec":512, "phy-sec":4096}
+   ]
+}`
+
+const mdadmDetail = `/dev/md/data:
+           Version : 1.2
+     Creation Time : Mon Oct 19 10:00:00 2026
+        Raid Level : raid1
+        Array Size : 976631360 (931.39 GiB 1000.07 GB)
+     Used Dev Size : 976631360 (931.39 GiB 1000.07 GB)
+      Raid Devices : 2
+     Total Devices : 2
+       Persistence : Superblock is persistent
+
+             State : clean
+    Active Devices : 2
+   Working Devices : 2
+    Failed Devices : 0
+     Spare Devices : 0
+
+Consistency Policy : bitmap
+
+              Name : host:data  (local to host host)
+              UUID : 0b2d4e1a:5a1c2f3e:7d6b8a9c:1e2f3a4b
+            Events : 17
+
+    Number   Major   Minor   RaidDevice State
+       0       8        0        0      active sync   /dev/sda
+       1       8       16        1      active sync   /dev/sdb`
+
+func TestMdAdm(t *testing.T) {
+	m := &MdAdm{"mdadm", "/sbin/mdadm", 80, log.New(os.Stderr, "", 0), true}
+	c := &Controller{ID: "0", Driver: m.name, driver: m}
+	m.fillDisks(c, []byte(mdadmLsblk))
+	if len(c.Disks) != 3 {
+		t.Fatalf("Expected 3 disks, got %d", len(c.Disks))
+	}
+	for i, want := range []struct {
+		dev, proto, media string
+		phys              uint64
+	}{
+		{"/dev/nvme0n1", "nvme", "ssd", 512},
+		{"/dev/sda", "sata", "disk", 4096},
+		{"/dev/sdb", "sata", "disk", 4096},
+	} {
+		d := c.Disks[i]
+		if d.Slot != uint64(i) || d.Info["Device"] != want.dev || d.Protocol != want.proto ||
+			d.MediaType != want.media || d.PhysicalSectorSize != want.phys {
+			t.Errorf("Disk %d: unexpected %s %d %s %s %d", i, d.Info["Device"], d.Slot, d.Protocol, d.MediaType, d.PhysicalSectorSize)
+		}
+	}
+	if c.Disks[1].Size != 1000204886016 || c.Disks[2].Size != 1000204886016 {
+		t.Errorf("Failed to parse disk sizes")
+	}
+
+	vol := &Volume{ID: "/dev/md/data", controller: c, driver: m}
+	m.fillVolume(c, vol, strings.Split(mdadmDetail, "\n"))
+	if vol.Name != "data" || vol.RaidLevel != "raid1" || vol.Status != "clean" ||
+		vol.Size != 976631360<<10 || len(vol.Disks) != 2 {
+		t.Errorf("Unexpected volume %s %s %s %d %d", vol.Name, vol.RaidLevel, vol.Status, vol.Size, len(vol.Disks))
+	}
+	if c.Disks[1].VolumeID != vol.ID || c.Disks[2].VolumeID != vol.ID || c.Disks[0].VolumeID != "" {
+		t.Errorf("Volume membership not recorded on disks")
+	}
+
+	spec := &VolSpec{
+		RaidLevel:  "raid5",
+		StripeSize: "64KB",
+		Name:       "scratch",
+		Disks:      VolSpecDisks{{Slot: 0}, {Slot: 1}, {Slot: 2}},
+		compiled:   true,
+	}
+	cmds, err := m.CreateCmds(c, spec, true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(cmds) != 4 {
+		t.Fatalf("Expected 4 commands, got %d", len(cmds))
+	}
+	if got := strings.Join(cmds[0], " "); got != "--zero-superblock --force /dev/nvme0n1" {
+		t.Errorf("Unexpected zero command %s", got)
+	}
+	want := "--create /dev/md/scratch --run --metadata=1.2 --name=scratch --level=5 --chunk=64 --raid-devices=3 /dev/nvme0n1 /dev/sda /dev/sdb"
+	if got := strings.Join(cmds[3], " "); got != want {
+		t.Errorf("Unexpected create command:\n  got: %s\n want: %s", got, want)
+	}
+	spec.Disks = VolSpecDisks{{Slot: 7}}
+	if _, err := m.CreateCmds(c, spec, false); err == nil {
+		t.Errorf("Expected an error for a missing disk")
+	}
+}
@@ -1,10 +1,8 @@
 package main
 
 import (
-	"bytes"
 	"fmt"
 	"log"
-	"os/exec"
 	"regexp"
 	"strconv"
 	"strings"
@@ -17,6 +15,11 @@ var (
 	mcliAdaptRE   = regexp.MustCompile(`^Adapter #(\d+)$`)
 	mcliCountRE   = regexp.MustCompile(`Controller Count:.*([0-9]+)\.`)
 	mcliRaidLvlRE = regexp.MustCompile(`Primary-(\d+), Secondary-(\d+)`)
+	mcliBootVDRE  = regexp.MustCompile(`Boot Virtual Drive\s*-\s*#(\d+)`)
+	mcliDiskGrpRE = regexp.MustCompile(`DiskGroup:\s*(\d+)`)
+	mcliAffinRE   = regexp.MustCompile(`Affinity for array:\s*(\d+)`)
+	// Completed 23%, Taken 9 min. or Completed 45% in 20 Minutes.
+	mcliProgressRE = regexp.MustCompile(`Completed\s+(\d+)%(?:,\s+Taken|\s+in)\s+(\d+)\s+[Mm]in`)
 )
 
 type MegaCli struct {
@@ -44,11 +47,8 @@ func (m *MegaCli) run(args ...string) (out []string, err string, cmdError error)
 	if fake {
 		return []string{}, "", nil
 	}
-	cmd := exec.Command(m.executable, args...)
-	outBuf, errBuf := &bytes.Buffer{}, &bytes.Buffer{}
-	cmd.Stdout, cmd.Stderr = outBuf, errBuf
-	cmdError = cmd.Run()
-	return strings.Split(outBuf.String(), "\n"), errBuf.String(), cmdError
+	stdout, stderr, cmdError := executor.Run(false, m.executable, args...)
+	return strings.Split(string(stdout), "\n"), string(stderr), cmdError
 }
 
 func (m *MegaCli) Useable() bool {
@@ -87,6 +87,19 @@ func (m *MegaCli) fillVolume(volume *Volume, section []string) {
 			volume.Name = matches[2]
 		case "Name":
 			volume.Name = v
+		case "Encryption Type":
+			volume.Secured = securedState(v)
+		case "Current Cache Policy":
+			m.cachePolicy(volume, v)
+		case "Disk Cache Policy":
+			switch v {
+			case "Enabled":
+				volume.DiskCache = "enabled"
+			case "Disabled":
+				volume.DiskCache = "disabled"
+			case "Disk's Default":
+				volume.DiskCache = "default"
+			}
 		case "State":
 			volume.Status = v
 		case "Span Depth":
@@ -149,6 +162,33 @@ func (m *MegaCli) fillVolume(volume *Volume, section []string) {
 	}
 }
 
+// cachePolicy parses the Current Cache Policy line.  ReadAdaptive is
+// deprecated and is left unreported.
+func (m *MegaCli) cachePolicy(volume *Volume, v string) {
+	badBBU := false
+	for _, p := range strings.Split(v, ",") {
+		switch strings.TrimSpace(p) {
+		case "WriteBack":
+			volume.WritePolicy = "writeback"
+		case "WriteThrough":
+			volume.WritePolicy = "writethrough"
+		case "Write Cache OK if Bad BBU":
+			badBBU = true
+		case "ReadAhead":
+			volume.ReadPolicy = "readahead"
+		case "ReadAheadNone":
+			volume.ReadPolicy = "noreadahead"
+		case "Direct":
+			volume.IOPolicy = "direct"
+		case "Cached":
+			volume.IOPolicy = "cached"
+		}
+	}
+	if badBBU && volume.WritePolicy == "writeback" {
+		volume.WritePolicy = "alwayswriteback"
+	}
+}
+
 func (m *MegaCli) fillVolumes(c *Controller) {
 	out, _, _ := m.run("-ldpdInfo", "-a"+c.ID)
 	_, sections := partitionAt(out, mcliVolRE)
@@ -180,6 +220,19 @@ func (m *MegaCli) fillVolumes(c *Controller) {
 		}
 		volume.Disks = disks
 	}
+	out, _, err := m.run("-AdpBootDrive", "-Get", "-a"+c.ID)
+	c.BootUnknown = err != nil
+	if err == nil {
+		for _, line := range out {
+			matches := mcliBootVDRE.FindStringSubmatch(line)
+			if len(matches) < 2 {
+				continue
+			}
+			for _, volume := range res {
+				volume.Bootable = volume.ID == matches[1]
+			}
+		}
+	}
 	c.Volumes = append(c.Volumes, res...)
 }
 
@@ -243,8 +296,11 @@ func (m *MegaCli) finalizeDisk(d *PhysicalDisk) {
 			}
 			d.SectorCount = n
 			d.Size = sz
+		case "Foreign State":
+			d.Foreign = v == "Foreign"
 		case "Firmware state":
 			d.JBOD = v == "JBOD"
+			d.HotSpare = strings.HasPrefix(v, "Hotspare")
 			d.Status = v
 		}
 	}
@@ -263,6 +319,10 @@ func (m *MegaCli) finalizeDisk(d *PhysicalDisk) {
 func (m *MegaCli) fillDisk(d *PhysicalDisk, section []string) {
 	for _, line := range section {
 		parts := strings.SplitN(line, ": ", 2)
+		if len(parts) != 2 {
+			// Drive Temperature :30C (86.00 F)
+			parts = strings.SplitN(line, " :", 2)
+		}
 		if len(parts) != 2 {
 			continue
 		}
@@ -355,6 +415,49 @@ func (m *MegaCli) fillController(c *Controller, section []string) {
 	m.finalizeController(c)
 	m.fillDisks(c)
 	m.fillVolumes(c)
+	m.linkSpares(c)
+}
+
+// diskGroups returns the disk groups (arrays in megacli terms) that
+// make up the volume.
+func (m *MegaCli) diskGroups(vol *Volume) []string {
+	res := []string{}
+	seen := map[string]struct{}{}
+	for _, d := range vol.Disks {
+		matches := mcliDiskGrpRE.FindStringSubmatch(d.Info["Drive's position"])
+		if len(matches) != 2 {
+			continue
+		}
+		if _, ok := seen[matches[1]]; ok {
+			continue
+		}
+		seen[matches[1]] = struct{}{}
+		res = append(res, matches[1])
+	}
+	return res
+}
+
+// linkSpares ties dedicated hot spares to the volumes they protect.
+func (m *MegaCli) linkSpares(c *Controller) {
+	groups := map[string]*Volume{}
+	for _, vol := range c.Volumes {
+		for _, dg := range m.diskGroups(vol) {
+			groups[dg] = vol
+		}
+	}
+	for _, d := range c.Disks {
+		if !d.HotSpare || !strings.HasPrefix(d.Info["Type"], "Dedicated") {
+			continue
+		}
+		matches := mcliAffinRE.FindStringSubmatch(d.Info["Type"])
+		if len(matches) != 2 {
+			continue
+		}
+		if vol, ok := groups[matches[1]]; ok {
+			d.SpareFor = vol.ID
+			vol.HotSpares = append(vol.HotSpares, d)
+		}
+	}
 }
 
 func (m *MegaCli) Controllers() []*Controller {
@@ -384,26 +487,94 @@ func (m *MegaCli) Refresh(c *Controller) {
 	m.fillController(c, out)
 }
 
-func (m *MegaCli) Clear(c *Controller, onlyForeign bool) error {
-	out, outErr, err := m.run("-CfgForeign", "-Clear", "-a"+c.ID)
+func (m *MegaCli) ClearCmds(c *Controller, onlyForeign bool) ([][]string, error) {
+	cmds := [][]string{{"-CfgForeign", "-Clear", "-a" + c.ID}}
 	if !onlyForeign {
 		for _, v := range c.Volumes {
 			if v.RaidLevel == "jbod" {
-				_, outErr, err = m.run("-PDMakeGood", "PhysDrv", fmt.Sprintf(`[%s:%d]`, v.Disks[0].Enclosure, v.Disks[0].Slot), "-Force", "-a"+c.ID)
-				if err != nil {
-					return fmt.Errorf("Error %s:\n%s", err, outErr)
-				}
+				cmds = append(cmds, []string{"-PDMakeGood", "PhysDrv", fmt.Sprintf(`[%s:%d]`, v.Disks[0].Enclosure, v.Disks[0].Slot), "-Force", "-a" + c.ID})
 			}
 		}
-		out, outErr, err = m.run("-CfgClr", "-Force", "-a"+c.ID)
-		if err != nil {
+		cmds = append(cmds, []string{"-CfgClr", "-Force", "-a" + c.ID})
+	}
+	return cmds, nil
+}
+
+func (m *MegaCli) Clear(c *Controller, onlyForeign bool) error {
+	cmds, _ := m.ClearCmds(c, onlyForeign)
+	var out []string
+	for i, cmd := range cmds {
+		res, outErr, err := m.run(cmd...)
+		// There may not be a foreign config to clear.
+		if err != nil && i > 0 {
 			return fmt.Errorf("Error %s:\n%s", err, outErr)
 		}
+		out = res
 	}
 	m.fillController(c, out)
 	return nil
 }
 
+// runCmds runs cmds in order, stopping at the first one that fails.
+func (m *MegaCli) runCmds(cmds [][]string) ([]string, error) {
+	var out []string
+	for _, cmd := range cmds {
+		m.log.Printf("Running command: %s %s", m.executable, strings.Join(cmd, " "))
+		res, outErr, err := m.run(cmd...)
+		if err != nil {
+			return res, fmt.Errorf("Error running cmd `%s`: %v\n%s", strings.Join(cmd, " "), err, outErr)
+		}
+		out = res
+	}
+	return out, nil
+}
+
+func (m *MegaCli) DeleteCmds(c *Controller, v *Volume) ([][]string, error) {
+	if v.RaidLevel == "jbod" {
+		return [][]string{{"-PDMakeGood", "PhysDrv", fmt.Sprintf(`[%s:%d]`, v.Disks[0].Enclosure, v.Disks[0].Slot), "-Force", "-a" + c.ID}}, nil
+	}
+	return [][]string{{"-CfgLdDel", "-L" + v.ID, "-Force", "-a" + c.ID}}, nil
+}
+
+func (m *MegaCli) Delete(c *Controller, v *Volume) error {
+	cmds, _ := m.DeleteCmds(c, v)
+	_, err := m.runCmds(cmds)
+	return err
+}
+
+func (m *MegaCli) UpdateCmds(c *Controller, v *Volume, spec *VolSpec) ([][]string, error) {
+	if v.RaidLevel == "jbod" {
+		return nil, fmt.Errorf("Cannot change the policies of a jbod")
+	}
+	cmds := m.propCmds(v.ID, spec.PolicyChanges(v.VolSpec()))
+	for i := range cmds {
+		cmds[i] = append(cmds[i], "-a"+c.ID)
+	}
+	return cmds, nil
+}
+
+func (m *MegaCli) Update(c *Controller, v *Volume, spec *VolSpec) error {
+	cmds, err := m.UpdateCmds(c, v, spec)
+	if err != nil {
+		return err
+	}
+	_, err = m.runCmds(cmds)
+	return err
+}
+
+func (m *MegaCli) SetBootCmds(c *Controller, v *Volume) ([][]string, error) {
+	if m.storcli() {
+		return [][]string{lsiBootCmd(c, v)}, nil
+	}
+	return [][]string{{"-AdpBootDrive", "-Set", "-L" + v.ID, "-a" + c.ID}}, nil
+}
+
+func (m *MegaCli) SetBoot(c *Controller, v *Volume) error {
+	cmds, _ := m.SetBootCmds(c, v)
+	_, err := m.runCmds(cmds)
+	return err
+}
+
 func (m *MegaCli) diskList(disks []VolSpecDisk) string {
 	parts := make([]string, len(disks))
 	for i := range disks {
@@ -435,9 +606,69 @@ func (m *MegaCli) hasJBOD(v *VolSpec) bool {
 	return false
 }
 
+// cacheArgs returns the cache policy arguments for a new volume.
+// Unless told otherwise, volumes are created WB and RA.
+func (m *MegaCli) cacheArgs(v *VolSpec) []string {
+	res := []string{"WB", "RA"}
+	switch v.WritePolicy {
+	case "writethrough":
+		res[0] = "WT"
+	case "alwayswriteback":
+		res = append(res, "CachedBadBBU")
+	}
+	if v.ReadPolicy == "noreadahead" {
+		res[1] = "NORA"
+	}
+	switch v.IOPolicy {
+	case "direct":
+		res = append(res, "Direct")
+	case "cached":
+		res = append(res, "Cached")
+	}
+	return res
+}
+
+// propCmds returns the -LDSetProp commands that change the name and
+// policies of volume id.  There is no way to put the disk cache back
+// to the disk default, so that is left alone.
+func (m *MegaCli) propCmds(id string, changes map[string]string) [][]string {
+	res := [][]string{}
+	for _, k := range policyNames {
+		props := []string{}
+		switch k + "=" + changes[k] {
+		case "WritePolicy=writeback":
+			props = []string{"WB", "NoCachedBadBBU"}
+		case "WritePolicy=alwayswriteback":
+			props = []string{"WB", "CachedBadBBU"}
+		case "WritePolicy=writethrough":
+			props = []string{"WT"}
+		case "ReadPolicy=readahead":
+			props = []string{"RA"}
+		case "ReadPolicy=noreadahead":
+			props = []string{"NORA"}
+		case "DiskCache=enabled":
+			props = []string{"-EnDskCache"}
+		case "DiskCache=disabled":
+			props = []string{"-DisDskCache"}
+		case "IOPolicy=direct":
+			props = []string{"Direct"}
+		case "IOPolicy=cached":
+			props = []string{"Cached"}
+		}
+		if k == "Name" && changes[k] != "" {
+			res = append(res, []string{"-LDSetProp", "-Name", changes[k], "-L" + id})
+		}
+		for _, prop := range props {
+			res = append(res, []string{"-LDSetProp", prop, "-L" + id})
+		}
+	}
+	return res
+}
+
 func (m *MegaCli) createSimple(v *VolSpec) []string {
-	res := []string{"-CfgLdAdd", "-r", m.diskList(v.Disks), "WB", "RA", "-strpsz", "-Force"}
-	res[5] = fmt.Sprintf("-strpsz%d", v.stripeSize()>>10)
+	res := []string{"-CfgLdAdd", "-r", m.diskList(v.Disks)}
+	res = append(res, m.cacheArgs(v)...)
+	res = append(res, fmt.Sprintf("-strpsz%d", v.stripeSize()>>10), "-Force")
 	switch v.RaidLevel {
 	case "raid0":
 		res[1] = "-r0"
@@ -458,7 +689,8 @@ func (m *MegaCli) createSpanned(v *VolSpec) []string {
 	for span, spanDisks := range spans {
 		cmd = append(cmd, fmt.Sprintf("-Array%d%s", span, m.diskList(spanDisks)))
 	}
-	cmd = append(cmd, "WB", "RA", fmt.Sprintf("-strpsz%d", v.stripeSize()>>10), "-Force")
+	cmd = append(cmd, m.cacheArgs(v)...)
+	cmd = append(cmd, fmt.Sprintf("-strpsz%d", v.stripeSize()>>10), "-Force")
 	switch v.RaidLevel {
 	case "raid00":
 		cmd[1] = "-r00"
@@ -472,9 +704,58 @@ func (m *MegaCli) createSpanned(v *VolSpec) []string {
 	return cmd
 }
 
-func (m *MegaCli) Create(c *Controller, v *VolSpec, forceGood bool) error {
+// spareCmds returns the commands needed to make the hot spares for
+// the volume.  storcli has its own syntax for this, megacli uses -PDHSP.
+func (m *MegaCli) spareCmds(c *Controller, v *VolSpec, dgs []string) [][]string {
+	if !strings.HasPrefix(m.name, "storcli") {
+		cmd := []string{"-PDHSP", "-Set"}
+		if !v.GlobalHotSpares {
+			cmd = append(cmd, "-Dedicated", "-Array"+strings.Join(dgs, ","))
+		}
+		return [][]string{append(cmd, "-PhysDrv", m.diskList(v.HotSpareDisks), "-a"+c.ID)}
+	}
+	res := [][]string{}
+	for _, disk := range v.HotSpareDisks {
+		path := "/c" + c.ID
+		if disk.Enclosure != "" {
+			path += "/e" + disk.Enclosure
+		}
+		cmd := []string{fmt.Sprintf("%s/s%d", path, disk.Slot), "add", "hotsparedrive"}
+		if !v.GlobalHotSpares {
+			cmd = append(cmd, "dgs="+strings.Join(dgs, ","))
+		}
+		res = append(res, cmd)
+	}
+	return res
+}
+
+// addSpares adds the hot spares for a freshly created volume.  Dedicated
+// spares need the disk groups of the new volume, so the controller is
+// refreshed to find them.
+func (m *MegaCli) addSpares(c *Controller, v *VolSpec) error {
+	dgs := []string{}
+	if !v.GlobalHotSpares {
+		m.Refresh(c)
+		for _, vol := range c.Volumes {
+			for _, d := range vol.Disks {
+				if d.Enclosure == v.Disks[0].Enclosure && d.Slot == v.Disks[0].Slot {
+					dgs = m.diskGroups(vol)
+				}
+			}
+		}
+		if len(dgs) == 0 {
+			return fmt.Errorf("Cannot find the disk groups of the new volume to add hot spares to")
+		}
+	}
+	_, err := m.runCmds(m.spareCmds(c, v, dgs))
+	return err
+}
+
+// createCmds returns the commands that create the volume, not counting
+// hot spares.
+func (m *MegaCli) createCmds(c *Controller, v *VolSpec, forceGood bool) ([][]string, error) {
 	if !v.compiled {
-		return fmt.Errorf("Cannot create a VolSpec that has not been compiled")
+		return nil, fmt.Errorf("Cannot create a VolSpec that has not been compiled")
 	}
 	cmds := [][]string{}
 	if forceGood {
@@ -484,7 +765,7 @@ func (m *MegaCli) Create(c *Controller, v *VolSpec, forceGood bool) error {
 	case "jbod":
 		if m.hasJBOD(v) {
 			m.log.Printf("%s is already a JBOD, nothing to do", m.diskList(v.Disks))
-			return nil
+			return [][]string{}, nil
 		}
 		cmds = append(cmds, []string{"-PDMakeJBOD", "-PhysDrv", m.diskList(v.Disks)})
 	case "raid0", "raid1", "raid5", "raid6":
@@ -498,7 +779,13 @@ func (m *MegaCli) Create(c *Controller, v *VolSpec, forceGood bool) error {
 		}
 		cmds = append(cmds, m.createSpanned(v))
 	default:
-		return fmt.Errorf("Cannot create a %s volume", v.RaidLevel)
+		return nil, fmt.Errorf("Cannot create a %s volume", v.RaidLevel)
+	}
+	if v.RaidLevel != "jbod" {
+		cmds = append(cmds, m.propCmds(strconv.Itoa(v.index), map[string]string{
+			"Name":      v.Name,
+			"DiskCache": v.DiskCache,
+		})...)
 	}
 	if v.Encrypt {
 		ecmd := []string{
@@ -507,42 +794,58 @@ func (m *MegaCli) Create(c *Controller, v *VolSpec, forceGood bool) error {
 		ecmd = append(ecmd, fmt.Sprintf("-L%d", v.index))
 		cmds = append(cmds, ecmd)
 	}
-	var (
-		out    []string
-		outErr string
-		err    error
-	)
-	for _, cmd := range cmds {
-		cmd = append(cmd, "-a"+c.ID)
-		m.log.Printf("Running command: %s %s", m.executable, strings.Join(cmd, " "))
-		out, outErr, err = m.run(cmd...)
-		if err == nil {
-			continue
-		}
-		return fmt.Errorf("Error running cmd `%s`: %v\n%s", strings.Join(cmd, " "), err, outErr)
+	for i := range cmds {
+		cmds[i] = append(cmds[i], "-a"+c.ID)
+	}
+	return cmds, nil
+}
+
+func (m *MegaCli) CreateCmds(c *Controller, v *VolSpec, forceGood bool) ([][]string, error) {
+	cmds, err := m.createCmds(c, v, forceGood)
+	if err != nil || len(v.HotSpareDisks) == 0 {
+		return cmds, err
+	}
+	return append(cmds, m.spareCmds(c, v, []string{newVolPlaceholder})...), nil
+}
+
+func (m *MegaCli) Create(c *Controller, v *VolSpec, forceGood bool) error {
+	cmds, err := m.createCmds(c, v, forceGood)
+	if err != nil {
+		return err
+	}
+	out, err := m.runCmds(cmds)
+	if err != nil {
+		return err
 	}
 	m.log.Println(strings.Join(out, "\n"))
+	if len(v.HotSpareDisks) > 0 {
+		return m.addSpares(c, v)
+	}
 	return nil
 }
 
-func (m *MegaCli) Encrypt(c *Controller, key, password string) error {
+func (m *MegaCli) EncryptCmds(c *Controller, key, password string) ([][]string, error) {
 	passwordParam := "-SecurityKey"
 	if m.Name() == "megacli" {
 		passwordParam = "-Passphrase"
 	}
-	cmds := [][]string{
-		[]string{"-DeleteSecurityKey", "-Force"},
-		[]string{"-CreateSecurityKey", passwordParam, password, "-KeyID", key},
-	}
+	return [][]string{
+		[]string{"-DeleteSecurityKey", "-Force", "-a" + c.ID},
+		[]string{"-CreateSecurityKey", passwordParam, password, "-KeyID", key, "-a" + c.ID},
+	}, nil
+}
+
+func (m *MegaCli) Encrypt(c *Controller, key, password string) error {
+	cmds, _ := m.EncryptCmds(c, key, password)
 	var (
 		out    []string
 		outErr string
 		err    error
 	)
 	for i, cmd := range cmds {
-		cmd = append(cmd, "-a"+c.ID)
 		m.log.Printf("Running command: %s %s", m.executable, strings.Join(cmd, " "))
 		out, outErr, err = m.run(cmd...)
+		// There may not be a key to delete.
 		if i == 0 || err == nil {
 			continue
 		}
@@ -551,3 +854,294 @@ func (m *MegaCli) Encrypt(c *Controller, key, password string) error {
 	m.log.Println(strings.Join(out, "\n"))
 	return nil
 }
+
+func (m *MegaCli) SecureCmds(c *Controller, v *Volume) ([][]string, error) {
+	if m.storcli() {
+		return [][]string{lsiSecureCmd(c, v)}, nil
+	}
+	return [][]string{{"-LDMakeSecure", "-L" + v.ID, "-a" + c.ID}}, nil
+}
+
+func (m *MegaCli) Secure(c *Controller, v *Volume) error {
+	cmds, _ := m.SecureCmds(c, v)
+	_, err := m.runCmds(cmds)
+	return err
+}
+
+func (m *MegaCli) RekeyCmds(c *Controller, sec *Secrets) ([][]string, error) {
+	if m.storcli() {
+		return [][]string{lsiRekeyCmd(c, sec)}, nil
+	}
+	cmd := []string{"-ChangeSecurityKey", "-OldSecurityKey", sec.Password, "-SecurityKey", sec.NewPassword}
+	if sec.NewKeyID != "" {
+		cmd = append(cmd, "-KeyID", sec.NewKeyID)
+	}
+	return [][]string{append(cmd, "-a"+c.ID)}, nil
+}
+
+func (m *MegaCli) Rekey(c *Controller, sec *Secrets) error {
+	cmds, _ := m.RekeyCmds(c, sec)
+	_, err := m.runCmds(cmds)
+	return err
+}
+
+func (m *MegaCli) UnlockCmds(c *Controller, password string) ([][]string, error) {
+	if m.storcli() {
+		return [][]string{lsiUnlockCmd(c, password)}, nil
+	}
+	return [][]string{{"-CfgForeign", "-Import", "-SecurityKey", password, "-a" + c.ID}}, nil
+}
+
+func (m *MegaCli) Unlock(c *Controller, password string) error {
+	cmds, _ := m.UnlockCmds(c, password)
+	_, err := m.runCmds(cmds)
+	return err
+}
+
+func (m *MegaCli) Health(c *Controller, d *PhysicalDisk) (*DiskHealth, error) {
+	return lsiDiskHealth(m.name, d.Info), nil
+}
+
+func (m *MegaCli) LocateCmds(c *Controller, d *PhysicalDisk, on bool) ([][]string, error) {
+	action := "-stop"
+	if on {
+		action = "-start"
+	}
+	return [][]string{{"-PdLocate", action, "-PhysDrv", fmt.Sprintf(`[%s:%d]`, d.Enclosure, d.Slot), "-a" + c.ID}}, nil
+}
+
+func (m *MegaCli) Locate(c *Controller, d *PhysicalDisk, on bool) error {
+	cmds, _ := m.LocateCmds(c, d, on)
+	_, err := m.runCmds(cmds)
+	return err
+}
+
+func (m *MegaCli) EraseCmds(c *Controller, d *PhysicalDisk, method string) ([][]string, error) {
+	if m.storcli() {
+		return [][]string{lsiEraseCmd(c, d, method)}, nil
+	}
+	disk := fmt.Sprintf(`[%s:%d]`, d.Enclosure, d.Slot)
+	switch method {
+	case "crypto":
+		return [][]string{{"-PDInstantSecureErase", "-PhysDrv", disk, "-Force", "-a" + c.ID}}, nil
+	case "overwrite":
+		return [][]string{{"-PDClear", "-Start", "-PhysDrv", disk, "-a" + c.ID}}, nil
+	}
+	return nil, fmt.Errorf("%s erase is not supported", method)
+}
+
+func (m *MegaCli) Erase(c *Controller, d *PhysicalDisk, method string) error {
+	cmds, err := m.EraseCmds(c, d, method)
+	if err != nil {
+		return err
+	}
+	_, err = m.runCmds(cmds)
+	return err
+}
+
+// EraseProgress uses show erase on storcli.  MegaCli reports clear
+// progress as "Clear Progress on Device at Enclosure 32, Slot 2
+// Completed 45% in 10 Minutes."
+func (m *MegaCli) EraseProgress(c *Controller, d *PhysicalDisk, method string) (*EraseStatus, error) {
+	var cmd []string
+	if m.storcli() {
+		cmd = lsiEraseShow(c, d, method)
+	} else if method == "overwrite" {
+		cmd = []string{"-PDClear", "-ShowProg", "-PhysDrv", fmt.Sprintf(`[%s:%d]`, d.Enclosure, d.Slot), "-a" + c.ID}
+	}
+	if cmd == nil {
+		return &EraseStatus{Erased: true}, nil
+	}
+	out, _, err := m.run(cmd...)
+	if err != nil {
+		return nil, err
+	}
+	if m.storcli() {
+		return lsiEraseStatus(c, d, out), nil
+	}
+	for _, line := range out {
+		if !strings.Contains(line, "Clear Progress") {
+			continue
+		}
+		op := &BackgroundOp{Controller: c.Name(), Disk: d.Name(), Operation: "erase"}
+		op.Progress, _ = parsePercent(line)
+		if parts := strings.SplitN(line, "% in ", 2); len(parts) == 2 {
+			op.Elapsed = parseTimeLeft(parts[1])
+		}
+		return &EraseStatus{Op: op}, nil
+	}
+	return &EraseStatus{}, nil
+}
+
+// megaVolOps maps the Ongoing Progresses entries in -LDInfo output to
+// operations.
+var megaVolOps = map[string]string{
+	"Background Initialization": "init",
+	"Initialization":            "init",
+	"Check Consistency":         "check",
+	"Reconstruction":            "reshape",
+}
+
+func (m *MegaCli) progress(op *BackgroundOp, v string) bool {
+	matches := mcliProgressRE.FindStringSubmatch(v)
+	if len(matches) != 3 {
+		return false
+	}
+	op.Progress, _ = strconv.ParseFloat(matches[1], 64)
+	mins, _ := strconv.ParseInt(matches[2], 10, 64)
+	op.Elapsed = mins * 60
+	return true
+}
+
+func (m *MegaCli) BackgroundOps(c *Controller) ([]*BackgroundOp, error) {
+	res := []*BackgroundOp{}
+	for _, vol := range c.Volumes {
+		for k, kind := range megaVolOps {
+			op := &BackgroundOp{Controller: c.Name(), Volume: vol.ID, Operation: kind}
+			if m.progress(op, vol.Info[k]) {
+				res = append(res, op)
+			}
+		}
+	}
+	for _, d := range c.Disks {
+		var cmd, kind string
+		switch {
+		case strings.HasPrefix(d.Status, "Rebuild"):
+			cmd, kind = "-PDRbld", "rebuild"
+		case strings.HasPrefix(d.Status, "Copyback"):
+			cmd, kind = "-PDCpyBk", "copyback"
+		default:
+			continue
+		}
+		out, _, err := m.run(cmd, "-ShowProg", "-PhysDrv", fmt.Sprintf(`[%s:%d]`, d.Enclosure, d.Slot), "-a"+c.ID)
+		if err != nil {
+			return res, err
+		}
+		op := &BackgroundOp{Controller: c.Name(), Disk: d.Name(), Operation: kind}
+		if m.progress(op, strings.Join(out, " ")) {
+			res = append(res, op)
+		}
+	}
+	return res, nil
+}
+
+func (m *MegaCli) CheckCmds(c *Controller, v *Volume, start bool) ([][]string, error) {
+	if err := checkable(v); err != nil {
+		return nil, err
+	}
+	action := "-Abort"
+	if start {
+		action = "-Start"
+	}
+	return [][]string{{"-LDCC", action, "-L" + v.ID, "-a" + c.ID}}, nil
+}
+
+func (m *MegaCli) Check(c *Controller, v *Volume, start bool) error {
+	cmds, err := m.CheckCmds(c, v, start)
+	if err != nil {
+		return err
+	}
+	_, err = m.runCmds(cmds)
+	return err
+}
+
+func (m *MegaCli) ExpandCmds(c *Controller, e *Expansion) ([][]string, error) {
+	if m.storcli() {
+		return [][]string{lsiMigrateCmd(c, e)}, nil
+	}
+	cmd := []string{"-LDRecon", "-Start", "-r" + strings.TrimPrefix(e.To, "raid")}
+	if len(e.disks) > 0 {
+		cmd = append(cmd, "-Add", "-PhysDrv["+driveList(e.disks)+"]")
+	}
+	return [][]string{append(cmd, "-L"+e.VolumeID, "-a"+c.ID)}, nil
+}
+
+func (m *MegaCli) Expand(c *Controller, e *Expansion) error {
+	cmds, err := m.ExpandCmds(c, e)
+	if err != nil {
+		return err
+	}
+	_, err = m.runCmds(cmds)
+	return err
+}
+
+// ForeignConfigs scans for foreign configs, and then displays each of
+// them.  storcli understands the same commands.
+func (m *MegaCli) ForeignConfigs(c *Controller) ([]*ForeignConfig, error) {
+	// No checking the exit status, just parse the out.
+	out, _, _ := m.run("-CfgForeign", "-Scan", "-a"+c.ID)
+	count := 0
+	for _, line := range out {
+		if matches := mcliForeignCountRE.FindStringSubmatch(line); len(matches) == 2 {
+			count, _ = strconv.Atoi(matches[1])
+		}
+	}
+	res := []*ForeignConfig{}
+	for i := 0; i < count; i++ {
+		id := strconv.Itoa(i)
+		out, outErr, err := m.run("-CfgForeign", "-Dsply", id, "-a"+c.ID)
+		if err != nil {
+			return nil, fmt.Errorf("Error displaying foreign config %s: %v\n%s", id, err, outErr)
+		}
+		res = append(res, m.mcliForeignConfig(c, id, out))
+	}
+	return res, nil
+}
+
+func (m *MegaCli) ImportForeignCmds(c *Controller, id string) ([][]string, error) {
+	cmd := []string{"-CfgForeign", "-Import"}
+	if id != "all" {
+		cmd = append(cmd, id)
+	}
+	return [][]string{append(cmd, "-a"+c.ID)}, nil
+}
+
+func (m *MegaCli) ImportForeign(c *Controller, id string) error {
+	cmds, _ := m.ImportForeignCmds(c, id)
+	_, err := m.runCmds(cmds)
+	return err
+}
+
+func (m *MegaCli) storcli() bool {
+	return strings.HasPrefix(m.name, "storcli")
+}
+
+// Settings reports the personality storcli controllers have.  MegaCli
+// predates personalities, so its controllers are always raid ones.
+func (m *MegaCli) Settings(c *Controller) (*ControllerSettings, error) {
+	if !m.storcli() {
+		return lsiSettings(c, "RAID", ""), nil
+	}
+	out, _, err := m.run("/c"+c.ID, "show", "personality")
+	if err != nil {
+		return nil, err
+	}
+	current, requested := lsiPersonalities(out)
+	return lsiSettings(c, current, requested), nil
+}
+
+func (m *MegaCli) SettingsCmds(c *Controller, spec *ControllerSpec) ([][]string, error) {
+	if m.storcli() {
+		return lsiSettingsCmds(c, spec, "JBOD"), nil
+	}
+	if spec.Personality != "" && spec.Personality != "raid" {
+		return nil, fmt.Errorf("%s cannot change the controller personality", m.name)
+	}
+	if spec.AutoJBOD == nil {
+		return [][]string{}, nil
+	}
+	enable := "0"
+	if *spec.AutoJBOD {
+		enable = "1"
+	}
+	return [][]string{{"-AdpSetProp", "-EnableJBOD", "-" + enable, "-a" + c.ID}}, nil
+}
+
+func (m *MegaCli) ApplySettings(c *Controller, spec *ControllerSpec) error {
+	cmds, err := m.SettingsCmds(c, spec)
+	if err != nil {
+		return err
+	}
+	_, err = m.runCmds(cmds)
+	return err
+}
@@ -1,11 +1,9 @@
 package main
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"log"
-	"os/exec"
 	"regexp"
 	"strconv"
 	"strings"
@@ -49,14 +47,12 @@ func (s *MNVCli) run(args ...string) ([]string, error) {
 	if fake {
 		return []string{}, nil
 	}
-	cmd := exec.Command(s.executable, args...)
-	outBuf := &bytes.Buffer{}
-	cmd.Stdout, cmd.Stderr = outBuf, outBuf
-	if err := cmd.Run(); err != nil {
+	out, _, err := executor.Run(true, s.executable, args...)
+	if err != nil {
 		return nil, err
 	}
-	out := strings.Split(outBuf.String(), "\n")
-	return out, s.checkLinesForError(out)
+	lines := strings.Split(string(out), "\n")
+	return lines, s.checkLinesForError(lines)
 }
 
 func (s *MNVCli) Useable() bool {
@@ -137,8 +133,8 @@ func (s *MNVCli) fillVolume(vol *Volume, lines []string) {
 			}
 		case "size":
 			vol.Size, _ = sizeParser(v)
-		case "Stripe size":
-			vol.StripeSize, _ = sizeParser(v) // Size is in K
+		case "Stripe Block Size":
+			vol.StripeSize, _ = sizeParser(v + "B") // Size is like 128K
 		}
 	}
 }
@@ -258,23 +254,75 @@ func (s *MNVCli) canBeCleared(c *Controller) bool {
 	return false
 }
 
-func (s *MNVCli) Clear(c *Controller, onlyForeign bool) error {
+func (s *MNVCli) ClearCmds(c *Controller, onlyForeign bool) ([][]string, error) {
 	if onlyForeign {
 		// as far as I can tell, mnvcli has no notion of a foreign config.
 		// So if we are asked to clear just the foreign config, do nothing.
-		return nil
+		return [][]string{}, nil
 	}
 	if !s.canBeCleared(c) {
-		return nil
+		return [][]string{}, nil
 	}
+	cmds := [][]string{}
 	for _, vol := range c.Volumes {
-		if _, err := s.run("delete", "-o", "vd", "-i", vol.ID, "--waiveconfirmation"); err != nil {
+		cmds = append(cmds, []string{"delete", "-o", "vd", "-i", vol.ID, "--waiveconfirmation"})
+	}
+	return cmds, nil
+}
+
+func (s *MNVCli) Clear(c *Controller, onlyForeign bool) error {
+	cmds, _ := s.ClearCmds(c, onlyForeign)
+	for _, cmdLine := range cmds {
+		if _, err := s.run(cmdLine...); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+func (s *MNVCli) UpdateCmds(c *Controller, v *Volume, spec *VolSpec) ([][]string, error) {
+	return nil, fmt.Errorf("Changing volume policies is not supported")
+}
+
+func (s *MNVCli) Update(c *Controller, v *Volume, spec *VolSpec) error {
+	_, err := s.UpdateCmds(c, v, spec)
+	return err
+}
+
+func (s *MNVCli) SetBootCmds(c *Controller, v *Volume) ([][]string, error) {
+	return nil, fmt.Errorf("Setting the boot volume is not supported")
+}
+
+func (s *MNVCli) SetBoot(c *Controller, v *Volume) error {
+	_, err := s.SetBootCmds(c, v)
+	return err
+}
+
+// runCmds runs cmds in order, stopping at the first one that fails.
+func (s *MNVCli) runCmds(cmds [][]string) error {
+	for _, cmdLine := range cmds {
+		s.log.Printf("Running %s %s", s.executable, strings.Join(cmdLine, " "))
+		res, err := s.run(cmdLine...)
+		if len(res) > 0 {
+			s.log.Println(strings.Join(res, "\n"))
+		}
+		if err != nil {
+			s.log.Printf("Error running command: %s", strings.Join(cmdLine, " "))
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MNVCli) DeleteCmds(c *Controller, v *Volume) ([][]string, error) {
+	return [][]string{{"delete", "-o", "vd", "-i", v.ID, "--waiveconfirmation"}}, nil
+}
+
+func (s *MNVCli) Delete(c *Controller, v *Volume) error {
+	cmds, _ := s.DeleteCmds(c, v)
+	return s.runCmds(cmds)
+}
+
 func (s MNVCli) Refresh(c *Controller) {
 	lines, err := s.run("info", "-o", "hba", "-i", c.ID)
 	if err != nil {
@@ -291,9 +339,12 @@ func (s *MNVCli) diskList(disks []VolSpecDisk) string {
 	return fmt.Sprintf("%s", strings.Join(parts, ","))
 }
 
-func (s *MNVCli) Create(c *Controller, v *VolSpec, forceGood bool) error {
+func (s *MNVCli) CreateCmds(c *Controller, v *VolSpec, forceGood bool) ([][]string, error) {
 	if !v.compiled {
-		return fmt.Errorf("Cannot create a VolSpec that has not been compiled")
+		return nil, fmt.Errorf("Cannot create a VolSpec that has not been compiled")
+	}
+	if len(v.HotSpareDisks) > 0 {
+		return nil, fmt.Errorf("Hot spares not supported")
 	}
 	sSize := v.stripeSize() >> 10
 	if sSize < 128 {
@@ -308,16 +359,16 @@ func (s *MNVCli) Create(c *Controller, v *VolSpec, forceGood bool) error {
 	case "jbod":
 		if len(v.Disks) == 1 {
 			s.log.Printf("Controller always puts drives as volumes")
-			return nil
+			return [][]string{}, nil
 		}
-		return fmt.Errorf("Cannot create multi-drive jbod")
+		return nil, fmt.Errorf("Cannot create multi-drive jbod")
 	case "raid0":
 		cmdLine = append(cmdLine, "-r0")
 	case "raid1":
 		if len(v.Disks) == 2 {
 			cmdLine = append(cmdLine, "-r1")
 		} else {
-			return fmt.Errorf("Cannot create more than 2 drive raid1")
+			return nil, fmt.Errorf("Cannot create more than 2 drive raid1")
 		}
 	case "raid5":
 		cmdLine = append(cmdLine, "-r5")
@@ -326,24 +377,131 @@ func (s *MNVCli) Create(c *Controller, v *VolSpec, forceGood bool) error {
 	case "raid1e":
 		cmdLine = append(cmdLine, "-r1e")
 	default:
-		return fmt.Errorf("Raid level %s not supported", v.RaidLevel)
+		return nil, fmt.Errorf("Raid level %s not supported", v.RaidLevel)
 	}
 	if v.Name != "" {
 		cmdLine = append(cmdLine, "-n")
 		cmdLine = append(cmdLine, v.Name)
 	}
 	cmdLine = append(cmdLine, "-d", s.diskList(v.Disks))
-	s.log.Printf("Running %s %s", s.executable, strings.Join(cmdLine, " "))
-	res, err := s.run(cmdLine...)
-	if len(res) > 0 {
-		s.log.Println(strings.Join(res, "\n"))
-	}
+	return [][]string{cmdLine}, nil
+}
+
+func (s *MNVCli) Create(c *Controller, v *VolSpec, forceGood bool) error {
+	cmds, err := s.CreateCmds(c, v, forceGood)
 	if err != nil {
-		s.log.Printf("Error running command: %s", strings.Join(cmdLine, " "))
+		return err
 	}
-	return err
+	return s.runCmds(cmds)
+}
+
+func (s *MNVCli) EncryptCmds(c *Controller, key, password string) ([][]string, error) {
+	return nil, fmt.Errorf("Encryption not supported")
 }
 
 func (s *MNVCli) Encrypt(c *Controller, key, password string) error {
-	return fmt.Errorf("Encryption not supported")
+	_, err := s.EncryptCmds(c, key, password)
+	return err
+}
+
+func (s *MNVCli) SecureCmds(c *Controller, v *Volume) ([][]string, error) {
+	return nil, fmt.Errorf("Encryption not supported")
+}
+
+func (s *MNVCli) Secure(c *Controller, v *Volume) error {
+	_, err := s.SecureCmds(c, v)
+	return err
+}
+
+func (s *MNVCli) RekeyCmds(c *Controller, sec *Secrets) ([][]string, error) {
+	return nil, fmt.Errorf("Encryption not supported")
+}
+
+func (s *MNVCli) Rekey(c *Controller, sec *Secrets) error {
+	_, err := s.RekeyCmds(c, sec)
+	return err
+}
+
+func (s *MNVCli) UnlockCmds(c *Controller, password string) ([][]string, error) {
+	return nil, fmt.Errorf("Encryption not supported")
+}
+
+func (s *MNVCli) Unlock(c *Controller, password string) error {
+	_, err := s.UnlockCmds(c, password)
+	return err
+}
+
+func (s *MNVCli) Health(c *Controller, d *PhysicalDisk) (*DiskHealth, error) {
+	return &DiskHealth{Source: s.name, SmartAlert: d.Info["SMART Critical Warning"] == "Yes"}, nil
+}
+
+func (s *MNVCli) LocateCmds(c *Controller, d *PhysicalDisk, on bool) ([][]string, error) {
+	return nil, fmt.Errorf("Locating drives is not supported")
+}
+
+func (s *MNVCli) Locate(c *Controller, d *PhysicalDisk, on bool) error {
+	_, err := s.LocateCmds(c, d, on)
+	return err
+}
+
+func (s *MNVCli) BackgroundOps(c *Controller) ([]*BackgroundOp, error) {
+	return nil, fmt.Errorf("Background operation progress is not supported")
+}
+
+func (s *MNVCli) CheckCmds(c *Controller, v *Volume, start bool) ([][]string, error) {
+	return nil, fmt.Errorf("Consistency checks are not supported")
+}
+
+func (s *MNVCli) Check(c *Controller, v *Volume, start bool) error {
+	_, err := s.CheckCmds(c, v, start)
+	return err
+}
+
+func (s *MNVCli) EraseCmds(c *Controller, d *PhysicalDisk, method string) ([][]string, error) {
+	return nil, fmt.Errorf("Erasing disks is not supported")
+}
+
+func (s *MNVCli) Erase(c *Controller, d *PhysicalDisk, method string) error {
+	_, err := s.EraseCmds(c, d, method)
+	return err
+}
+
+func (s *MNVCli) EraseProgress(c *Controller, d *PhysicalDisk, method string) (*EraseStatus, error) {
+	return nil, fmt.Errorf("Erasing disks is not supported")
+}
+
+func (s *MNVCli) ExpandCmds(c *Controller, e *Expansion) ([][]string, error) {
+	return nil, fmt.Errorf("Expanding volumes is not supported")
+}
+
+func (s *MNVCli) Expand(c *Controller, e *Expansion) error {
+	_, err := s.ExpandCmds(c, e)
+	return err
+}
+
+// ForeignConfigs reports none, as mnvcli has no notion of a foreign config.
+func (s *MNVCli) ForeignConfigs(c *Controller) ([]*ForeignConfig, error) {
+	return []*ForeignConfig{}, nil
+}
+
+func (s *MNVCli) ImportForeignCmds(c *Controller, id string) ([][]string, error) {
+	return nil, fmt.Errorf("Importing foreign configs is not supported")
+}
+
+func (s *MNVCli) ImportForeign(c *Controller, id string) error {
+	_, err := s.ImportForeignCmds(c, id)
+	return err
+}
+
+func (s *MNVCli) Settings(c *Controller) (*ControllerSettings, error) {
+	return nil, fmt.Errorf("Changing controller settings is not supported")
+}
+
+func (s *MNVCli) SettingsCmds(c *Controller, spec *ControllerSpec) ([][]string, error) {
+	return nil, fmt.Errorf("Changing controller settings is not supported")
+}
+
+func (s *MNVCli) ApplySettings(c *Controller, spec *ControllerSpec) error {
+	_, err := s.SettingsCmds(c, spec)
+	return err
 }
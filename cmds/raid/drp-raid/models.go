@@ -3,7 +3,6 @@ package main
 import (
 	"fmt"
 	"log"
-	"os"
 )
 
 // RaidLevel contains meta-information used to calculate various
@@ -15,6 +14,9 @@ type raidLevel struct {
 	spans             func(totalDisks uint64) (spans, disksPerSpan uint64)
 	spanned           bool
 	subType           string
+	// sharesDisks is true for levels that only use part of a disk, and
+	// leave the rest of it for other volumes.
+	sharesDisks bool
 }
 
 func roundToStripe(stripeSize, v uint64) uint64 {
@@ -32,6 +34,13 @@ var raidLevels = map[string]raidLevel{
 		targetUseableSize: func(_, disks, pds uint64) uint64 { return pds },
 		spans:             func(disks uint64) (uint64, uint64) { return 1, disks },
 	},
+	"namespace": { // NVMe namespace carved out of a single drive
+		minDisks:          func(uint64) uint64 { return 1 },
+		perDiskSize:       func(_, disks, target uint64) uint64 { return target },
+		targetUseableSize: func(_, disks, pds uint64) uint64 { return pds },
+		spans:             func(disks uint64) (uint64, uint64) { return 1, 1 },
+		sharesDisks:       true,
+	},
 	"raidS": { // raid0:max as one volume / drive instead of all drives in single raid0
 		minDisks:          func(uint64) uint64 { return 1 },
 		perDiskSize:       func(_, disks, target uint64) uint64 { return target },
@@ -167,6 +176,11 @@ func (r raidLevel) FinalSize(src VolSpecDisks) uint64 {
 	return r.targetUseableSize(spans, dps, disks[0].Size)
 }
 
+// newVolPlaceholder stands in for things in planned commands that will
+// not be known until earlier commands have been run, like the ID of a
+// freshly created volume.
+const newVolPlaceholder = "<new>"
+
 // Driver represents the tooling used to manage RAID controllers.
 type Driver interface {
 	Logger(*log.Logger)
@@ -181,15 +195,70 @@ type Driver interface {
 	Disable()
 	Enable()
 	Create(c *Controller, v *VolSpec, forceGood bool) error
+	Delete(c *Controller, v *Volume) error
+	Update(c *Controller, v *Volume, spec *VolSpec) error
+	// The *Cmds methods return the commands that the matching method
+	// would run, without running them.
+	ClearCmds(c *Controller, foreignOnly bool) ([][]string, error)
+	CreateCmds(c *Controller, v *VolSpec, forceGood bool) ([][]string, error)
+	DeleteCmds(c *Controller, v *Volume) ([][]string, error)
+	UpdateCmds(c *Controller, v *Volume, spec *VolSpec) ([][]string, error)
+	// SetBoot makes v the volume the controller boots from.
+	SetBootCmds(c *Controller, v *Volume) ([][]string, error)
+	SetBoot(c *Controller, v *Volume) error
+	EncryptCmds(c *Controller, key, password string) ([][]string, error)
 	Encrypt(c *Controller, key, password string) error
+	// Secure encrypts the existing volume v with the controller key.
+	SecureCmds(c *Controller, v *Volume) ([][]string, error)
+	Secure(c *Controller, v *Volume) error
+	// Rekey changes the controller key from sec.Password to
+	// sec.NewPassword.
+	RekeyCmds(c *Controller, sec *Secrets) ([][]string, error)
+	Rekey(c *Controller, sec *Secrets) error
+	// Unlock unlocks secured disks that are locked with password, and
+	// imports the configuration on them.
+	UnlockCmds(c *Controller, password string) ([][]string, error)
+	Unlock(c *Controller, password string) error
+	// Locate turns the locate LED of the drive bay d is in on or off.
+	LocateCmds(c *Controller, d *PhysicalDisk, on bool) ([][]string, error)
+	Locate(c *Controller, d *PhysicalDisk, on bool) error
+	// Erase starts erasing d with one of the eraseMethods.  EraseProgress
+	// returns how far along the erase is, or how it ended once it has
+	// stopped.
+	EraseCmds(c *Controller, d *PhysicalDisk, method string) ([][]string, error)
+	Erase(c *Controller, d *PhysicalDisk, method string) error
+	EraseProgress(c *Controller, d *PhysicalDisk, method string) (*EraseStatus, error)
+	// BackgroundOps returns the operations running in the background on
+	// the controller as of the last Refresh.
+	BackgroundOps(c *Controller) ([]*BackgroundOp, error)
+	// Check starts or stops a consistency check of v.
+	CheckCmds(c *Controller, v *Volume, start bool) ([][]string, error)
+	Check(c *Controller, v *Volume, start bool) error
+	// Expand grows a volume onto more disks, migrates it to a different
+	// raid level, or both.  The controller does the work in the background.
+	ExpandCmds(c *Controller, e *Expansion) ([][]string, error)
+	Expand(c *Controller, e *Expansion) error
+	// ForeignConfigs returns the configurations on disks moved from other
+	// controllers.  ImportForeign imports the one with the passed-in ID,
+	// or all of them if it is "all".
+	ForeignConfigs(c *Controller) ([]*ForeignConfig, error)
+	ImportForeignCmds(c *Controller, id string) ([][]string, error)
+	ImportForeign(c *Controller, id string) error
+	// Health returns the normalized health of a disk on the controller.
+	Health(c *Controller, d *PhysicalDisk) (*DiskHealth, error)
+	// Settings returns the controller-wide settings a ControllerSpec can
+	// change, and ApplySettings changes the ones set in spec.
+	Settings(c *Controller) (*ControllerSettings, error)
+	SettingsCmds(c *Controller, spec *ControllerSpec) ([][]string, error)
+	ApplySettings(c *Controller, spec *ControllerSpec) error
 }
 
 func DriverInstalled(d Driver) error {
-	fi, err := os.Stat(d.Executable())
+	mode, err := executor.Stat(d.Executable())
 	if err != nil {
 		return fmt.Errorf("%s: %s is not present", d.Name(), d.Executable())
 	}
-	if !fi.Mode().IsRegular() || (fi.Mode().Perm()&0333) == 0 {
+	if !mode.IsRegular() || (mode.Perm()&0333) == 0 {
 		return fmt.Errorf("%s executable %s is not an executable", d.Name(), d.Executable())
 	}
 	return nil
@@ -1,11 +1,9 @@
 package main
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"log"
-	"os/exec"
 	"regexp"
 	"strconv"
 	"strings"
@@ -49,14 +47,12 @@ func (s *MVCli) run(args ...string) ([]string, error) {
 	if fake {
 		return []string{}, nil
 	}
-	cmd := exec.Command(s.executable, args...)
-	outBuf := &bytes.Buffer{}
-	cmd.Stdout, cmd.Stderr = outBuf, outBuf
-	if err := cmd.Run(); err != nil {
+	out, _, err := executor.Run(true, s.executable, args...)
+	if err != nil {
 		return nil, err
 	}
-	out := strings.Split(outBuf.String(), "\n")
-	return out, s.checkLinesForError(out)
+	lines := strings.Split(string(out), "\n")
+	return lines, s.checkLinesForError(lines)
 }
 
 func (s *MVCli) Useable() bool {
@@ -251,19 +247,71 @@ func (s *MVCli) canBeCleared(c *Controller) bool {
 	return false
 }
 
-func (s *MVCli) Clear(c *Controller, onlyForeign bool) error {
+func (s *MVCli) ClearCmds(c *Controller, onlyForeign bool) ([][]string, error) {
 	if onlyForeign {
 		// as far as I can tell, mvcli has no notion of a foreign config.
 		// So if we are asked to clear just the foreign config, do nothing.
-		return nil
+		return [][]string{}, nil
 	}
 	if !s.canBeCleared(c) {
-		return nil
+		return [][]string{}, nil
+	}
+	return [][]string{{"delete", "-o", "vd", "-i", "0", "-f", "--waiveconfirmation"}}, nil
+}
+
+func (s *MVCli) Clear(c *Controller, onlyForeign bool) error {
+	cmds, _ := s.ClearCmds(c, onlyForeign)
+	for _, cmdLine := range cmds {
+		if _, err := s.run(cmdLine...); err != nil {
+			return err
+		}
 	}
-	_, err := s.run("delete", "-o", "vd", "-i", "0", "-f", "--waiveconfirmation")
+	return nil
+}
+
+func (s *MVCli) UpdateCmds(c *Controller, v *Volume, spec *VolSpec) ([][]string, error) {
+	return nil, fmt.Errorf("Changing volume policies is not supported")
+}
+
+func (s *MVCli) Update(c *Controller, v *Volume, spec *VolSpec) error {
+	_, err := s.UpdateCmds(c, v, spec)
+	return err
+}
+
+func (s *MVCli) SetBootCmds(c *Controller, v *Volume) ([][]string, error) {
+	return nil, fmt.Errorf("Setting the boot volume is not supported")
+}
+
+func (s *MVCli) SetBoot(c *Controller, v *Volume) error {
+	_, err := s.SetBootCmds(c, v)
 	return err
 }
 
+// runCmds runs cmds in order, stopping at the first one that fails.
+func (s *MVCli) runCmds(cmds [][]string) error {
+	for _, cmdLine := range cmds {
+		s.log.Printf("Running %s %s", s.executable, strings.Join(cmdLine, " "))
+		res, err := s.run(cmdLine...)
+		if len(res) > 0 {
+			s.log.Println(strings.Join(res, "\n"))
+		}
+		if err != nil {
+			s.log.Printf("Error running command: %s", strings.Join(cmdLine, " "))
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MVCli) DeleteCmds(c *Controller, v *Volume) ([][]string, error) {
+	return [][]string{{"delete", "-o", "vd", "-i", v.ID, "-f", "--waiveconfirmation"}}, nil
+}
+
+func (s *MVCli) Delete(c *Controller, v *Volume) error {
+	cmds, _ := s.DeleteCmds(c, v)
+	return s.runCmds(cmds)
+}
+
 func (s MVCli) Refresh(c *Controller) {
 	lines, err := s.run("info", "-o", "hba", "-i", c.ID)
 	if err != nil {
@@ -280,9 +328,12 @@ func (s *MVCli) diskList(disks []VolSpecDisk) string {
 	return fmt.Sprintf("%s", strings.Join(parts, ","))
 }
 
-func (s *MVCli) Create(c *Controller, v *VolSpec, forceGood bool) error {
+func (s *MVCli) CreateCmds(c *Controller, v *VolSpec, forceGood bool) ([][]string, error) {
 	if !v.compiled {
-		return fmt.Errorf("Cannot create a VolSpec that has not been compiled")
+		return nil, fmt.Errorf("Cannot create a VolSpec that has not been compiled")
+	}
+	if len(v.HotSpareDisks) > 0 {
+		return nil, fmt.Errorf("Hot spares not supported")
 	}
 	cmdLine := []string{
 		"create",
@@ -296,16 +347,16 @@ func (s *MVCli) Create(c *Controller, v *VolSpec, forceGood bool) error {
 	case "jbod":
 		if len(v.Disks) == 1 {
 			s.log.Printf("Controller always puts drives as volumes")
-			return nil
+			return [][]string{}, nil
 		}
-		return fmt.Errorf("Cannot create multi-drive jbod")
+		return nil, fmt.Errorf("Cannot create multi-drive jbod")
 	case "raid0":
 		cmdLine = append(cmdLine, "-r0")
 	case "raid1":
 		if len(v.Disks) == 2 {
 			cmdLine = append(cmdLine, "-r1")
 		} else {
-			return fmt.Errorf("Cannot create more than 2 drive raid1")
+			return nil, fmt.Errorf("Cannot create more than 2 drive raid1")
 		}
 	case "raid5":
 		cmdLine = append(cmdLine, "-r5")
@@ -314,24 +365,133 @@ func (s *MVCli) Create(c *Controller, v *VolSpec, forceGood bool) error {
 	case "raid1e":
 		cmdLine = append(cmdLine, "-r1e")
 	default:
-		return fmt.Errorf("Raid level %s not supported", v.RaidLevel)
+		return nil, fmt.Errorf("Raid level %s not supported", v.RaidLevel)
 	}
 	if v.Name != "" {
 		cmdLine = append(cmdLine, "-n")
 		cmdLine = append(cmdLine, v.Name)
 	}
 	cmdLine = append(cmdLine, "-d", s.diskList(v.Disks))
-	s.log.Printf("Running %s %s", s.executable, strings.Join(cmdLine, " "))
-	res, err := s.run(cmdLine...)
-	if len(res) > 0 {
-		s.log.Println(strings.Join(res, "\n"))
-	}
+	return [][]string{cmdLine}, nil
+}
+
+func (s *MVCli) Create(c *Controller, v *VolSpec, forceGood bool) error {
+	cmds, err := s.CreateCmds(c, v, forceGood)
 	if err != nil {
-		s.log.Printf("Error running command: %s", strings.Join(cmdLine, " "))
+		return err
 	}
-	return err
+	return s.runCmds(cmds)
+}
+
+func (s *MVCli) EncryptCmds(c *Controller, key, password string) ([][]string, error) {
+	return nil, fmt.Errorf("Encryption not supported")
 }
 
 func (s *MVCli) Encrypt(c *Controller, key, password string) error {
-	return fmt.Errorf("Encryption not supported")
+	_, err := s.EncryptCmds(c, key, password)
+	return err
+}
+
+func (s *MVCli) SecureCmds(c *Controller, v *Volume) ([][]string, error) {
+	return nil, fmt.Errorf("Encryption not supported")
+}
+
+func (s *MVCli) Secure(c *Controller, v *Volume) error {
+	_, err := s.SecureCmds(c, v)
+	return err
+}
+
+func (s *MVCli) RekeyCmds(c *Controller, sec *Secrets) ([][]string, error) {
+	return nil, fmt.Errorf("Encryption not supported")
+}
+
+func (s *MVCli) Rekey(c *Controller, sec *Secrets) error {
+	_, err := s.RekeyCmds(c, sec)
+	return err
+}
+
+func (s *MVCli) UnlockCmds(c *Controller, password string) ([][]string, error) {
+	return nil, fmt.Errorf("Encryption not supported")
+}
+
+func (s *MVCli) Unlock(c *Controller, password string) error {
+	_, err := s.UnlockCmds(c, password)
+	return err
+}
+
+// Health only has the disk status to go on, mvcli does not report
+// error counts or SMART data.
+func (s *MVCli) Health(c *Controller, d *PhysicalDisk) (*DiskHealth, error) {
+	return &DiskHealth{Source: s.name}, nil
+}
+
+func (s *MVCli) LocateCmds(c *Controller, d *PhysicalDisk, on bool) ([][]string, error) {
+	return nil, fmt.Errorf("Locating drives is not supported")
+}
+
+func (s *MVCli) Locate(c *Controller, d *PhysicalDisk, on bool) error {
+	_, err := s.LocateCmds(c, d, on)
+	return err
+}
+
+func (s *MVCli) BackgroundOps(c *Controller) ([]*BackgroundOp, error) {
+	return nil, fmt.Errorf("Background operation progress is not supported")
+}
+
+func (s *MVCli) CheckCmds(c *Controller, v *Volume, start bool) ([][]string, error) {
+	return nil, fmt.Errorf("Consistency checks are not supported")
+}
+
+func (s *MVCli) Check(c *Controller, v *Volume, start bool) error {
+	_, err := s.CheckCmds(c, v, start)
+	return err
+}
+
+func (s *MVCli) EraseCmds(c *Controller, d *PhysicalDisk, method string) ([][]string, error) {
+	return nil, fmt.Errorf("Erasing disks is not supported")
+}
+
+func (s *MVCli) Erase(c *Controller, d *PhysicalDisk, method string) error {
+	_, err := s.EraseCmds(c, d, method)
+	return err
+}
+
+func (s *MVCli) EraseProgress(c *Controller, d *PhysicalDisk, method string) (*EraseStatus, error) {
+	return nil, fmt.Errorf("Erasing disks is not supported")
+}
+
+func (s *MVCli) ExpandCmds(c *Controller, e *Expansion) ([][]string, error) {
+	return nil, fmt.Errorf("Expanding volumes is not supported")
+}
+
+func (s *MVCli) Expand(c *Controller, e *Expansion) error {
+	_, err := s.ExpandCmds(c, e)
+	return err
+}
+
+// ForeignConfigs reports none, as mvcli has no notion of a foreign config.
+func (s *MVCli) ForeignConfigs(c *Controller) ([]*ForeignConfig, error) {
+	return []*ForeignConfig{}, nil
+}
+
+func (s *MVCli) ImportForeignCmds(c *Controller, id string) ([][]string, error) {
+	return nil, fmt.Errorf("Importing foreign configs is not supported")
+}
+
+func (s *MVCli) ImportForeign(c *Controller, id string) error {
+	_, err := s.ImportForeignCmds(c, id)
+	return err
+}
+
+func (s *MVCli) Settings(c *Controller) (*ControllerSettings, error) {
+	return nil, fmt.Errorf("Changing controller settings is not supported")
+}
+
+func (s *MVCli) SettingsCmds(c *Controller, spec *ControllerSpec) ([][]string, error) {
+	return nil, fmt.Errorf("Changing controller settings is not supported")
+}
+
+func (s *MVCli) ApplySettings(c *Controller, spec *ControllerSpec) error {
+	_, err := s.SettingsCmds(c, spec)
+	return err
 }
@@ -0,0 +1,566 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+ * NvmeCli manages namespaces on direct-attached NVMe drives.
+ *
+ * Every NVMe controller is a Controller with a single PhysicalDisk in
+ * slot 0, and every namespace on the drive is a Volume with RaidLevel
+ * namespace.  Namespaces can only be created and deleted on drives that
+ * support namespace management.
+ *
+ * This driver is not enabled by default, since adding the NVMe drives
+ * to the list of controllers changes the index of any controller that
+ * sorts after them.  Use -tools nvme to turn it on.
+ */
+
+type NvmeCli struct {
+	name       string
+	executable string
+	order      int
+	log        *log.Logger
+	enabled    bool
+}
+
+var (
+	nvmeNsListRE  = regexp.MustCompile(`^\[\s*\d+\]:(0x[0-9a-fA-F]+)`)
+	nvmeCreatedRE = regexp.MustCompile(`nsid:\s*(\d+)`)
+)
+
+func (n *NvmeCli) Logger(l *log.Logger) {
+	n.log = l
+}
+
+func (n *NvmeCli) Order() int    { return n.order }
+func (n *NvmeCli) Enabled() bool { return n.enabled }
+func (n *NvmeCli) Enable()       { n.enabled = true }
+func (n *NvmeCli) Disable()      { n.enabled = false }
+
+func (n *NvmeCli) Name() string { return n.name }
+
+func (n *NvmeCli) Executable() string { return n.executable }
+
+func (n *NvmeCli) run(args ...string) ([]string, error) {
+	if fake {
+		return []string{}, nil
+	}
+	stdout, stderr, err := executor.Run(false, n.executable, args...)
+	if err != nil {
+		stdout = append(stdout, stderr...)
+	}
+	return strings.Split(string(stdout), "\n"), err
+}
+
+func (n *NvmeCli) Useable() bool {
+	_, err := n.run("version")
+	return err == nil
+}
+
+func (n *NvmeCli) device(c *Controller) string {
+	return "/dev/nvme" + c.ID
+}
+
+// decode parses nvme-cli JSON output.  Capacities can be larger than
+// a float64 can hold exactly, so numbers are kept as json.Number.
+func (n *NvmeCli) decode(buf []byte) (map[string]interface{}, error) {
+	res := map[string]interface{}{}
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.UseNumber()
+	err := dec.Decode(&res)
+	return res, err
+}
+
+func nvmeUint(m map[string]interface{}, k string) uint64 {
+	switch v := m[k].(type) {
+	case json.Number:
+		if res, err := strconv.ParseUint(v.String(), 10, 64); err == nil {
+			return res
+		}
+		f, _ := v.Float64()
+		return uint64(f)
+	case string:
+		res, _ := strconv.ParseUint(strings.TrimSpace(v), 0, 64)
+		return res
+	}
+	return 0
+}
+
+func nvmeString(m map[string]interface{}, k string) string {
+	if v, ok := m[k]; ok && v != nil {
+		return strings.TrimSpace(fmt.Sprintf("%v", v))
+	}
+	return ""
+}
+
+// lbaFormat returns the block size of the LBA format in use by the
+// passed-in id-ns output.
+func (n *NvmeCli) lbaFormat(ns map[string]interface{}) (format, blockSize uint64) {
+	format = nvmeUint(ns, "flbas") & 0xf
+	lbafs, _ := ns["lbafs"].([]interface{})
+	if int(format) >= len(lbafs) {
+		return format, 512
+	}
+	lbaf, _ := lbafs[format].(map[string]interface{})
+	return format, 1 << nvmeUint(lbaf, "ds")
+}
+
+// fillController parses the output of nvme id-ctrl.
+func (n *NvmeCli) fillController(c *Controller, buf []byte) {
+	c.Disks = []*PhysicalDisk{}
+	c.Volumes = []*Volume{}
+	c.Info = map[string]string{}
+	ctrl, err := n.decode(buf)
+	if err != nil {
+		n.log.Printf("Failed to process id-ctrl json for %s: %v", n.device(c), err)
+		return
+	}
+	nsMgmt := nvmeUint(ctrl, "oacs")&0x8 != 0
+	c.Info["Model"] = nvmeString(ctrl, "mn")
+	c.Info["Serial Number"] = nvmeString(ctrl, "sn")
+	c.Info["Firmware"] = nvmeString(ctrl, "fr")
+	c.Info["Controller ID"] = strconv.FormatUint(nvmeUint(ctrl, "cntlid"), 10)
+	c.Info["Max Namespaces"] = strconv.FormatUint(nvmeUint(ctrl, "nn"), 10)
+	c.Info["Namespace Management"] = strconv.FormatBool(nsMgmt)
+	c.Info["LBA Format"] = "0"
+	c.Info["Block Size"] = "512"
+	c.JBODCapable = false
+	c.AutoJBOD = false
+	c.RaidCapable = nsMgmt
+	c.RaidLevels = []string{}
+	if nsMgmt {
+		c.RaidLevels = append(c.RaidLevels, "namespace")
+	}
+	total := nvmeUint(ctrl, "tnvmcap")
+	d := &PhysicalDisk{
+		ControllerID:       c.ID,
+		ControllerDriver:   c.Driver,
+		Size:               total,
+		UsedSize:           total - nvmeUint(ctrl, "unvmcap"),
+		PhysicalSectorSize: 512,
+		LogicalSectorSize:  512,
+		Protocol:           "nvme",
+		MediaType:          "ssd",
+		Status:             "Good",
+		Info: map[string]string{
+			"Model":         c.Info["Model"],
+			"Serial Number": c.Info["Serial Number"],
+			"Firmware":      c.Info["Firmware"],
+			"Device":        n.device(c),
+		},
+		controller: c,
+		driver:     n,
+	}
+	c.Disks = append(c.Disks, d)
+}
+
+// fillVolume parses the output of nvme id-ns for a single namespace.
+func (n *NvmeCli) fillVolume(c *Controller, vol *Volume, buf []byte) {
+	vol.Info = map[string]string{}
+	ns, err := n.decode(buf)
+	if err != nil {
+		n.log.Printf("Failed to process id-ns json for namespace %s: %v", vol.ID, err)
+		return
+	}
+	format, blockSize := n.lbaFormat(ns)
+	d := c.Disks[0]
+	vol.RaidLevel = "namespace"
+	vol.Status = "Optimal"
+	vol.Size = nvmeUint(ns, "nsze") * blockSize
+	vol.Spans, vol.SpanLength = 1, 1
+	vol.Disks = []*PhysicalDisk{d}
+	vol.Info["Device"] = fmt.Sprintf("/dev/nvme%sn%s", c.ID, vol.ID)
+	vol.Info["LBA Format"] = strconv.FormatUint(format, 10)
+	vol.Info["Block Size"] = strconv.FormatUint(blockSize, 10)
+	d.VolumeID = vol.ID
+	d.volume = vol
+	d.LogicalSectorSize = blockSize
+	// New namespaces get the same format as the existing ones.
+	c.Info["LBA Format"] = vol.Info["LBA Format"]
+	c.Info["Block Size"] = vol.Info["Block Size"]
+}
+
+// namespaces parses the output of nvme list-ns.
+func (n *NvmeCli) namespaces(lines []string) []string {
+	res := []string{}
+	for _, line := range lines {
+		matches := nvmeNsListRE.FindStringSubmatch(strings.TrimSpace(line))
+		if len(matches) < 2 {
+			continue
+		}
+		nsid, err := strconv.ParseUint(matches[1], 0, 64)
+		if err != nil || nsid == 0 {
+			continue
+		}
+		res = append(res, strconv.FormatUint(nsid, 10))
+	}
+	return res
+}
+
+func (n *NvmeCli) fillVolumes(c *Controller) {
+	dev := n.device(c)
+	out, err := n.run("list-ns", dev, "--all")
+	if err != nil {
+		n.log.Printf("Failed to list namespaces on %s: %v", dev, err)
+		return
+	}
+	for _, nsid := range n.namespaces(out) {
+		idNs, err := n.run("id-ns", dev, "--namespace-id="+nsid, "--output-format=json")
+		if err != nil {
+			n.log.Printf("Failed to get namespace %s on %s: %v", nsid, dev, err)
+			continue
+		}
+		vol := &Volume{
+			ControllerID:     c.ID,
+			ControllerDriver: c.Driver,
+			ID:               nsid,
+			controller:       c,
+			driver:           n,
+		}
+		n.fillVolume(c, vol, []byte(strings.Join(idNs, "\n")))
+		c.Volumes = append(c.Volumes, vol)
+	}
+	// Drives without namespace management do not report their capacity.
+	if d := c.Disks[0]; d.Size == 0 {
+		for _, vol := range c.Volumes {
+			d.Size += vol.Size
+		}
+		d.UsedSize = d.Size
+	}
+	d := c.Disks[0]
+	d.SectorCount = d.Size / d.LogicalSectorSize
+}
+
+func (n *NvmeCli) Refresh(c *Controller) {
+	out, err := n.run("id-ctrl", n.device(c), "--output-format=json")
+	if err != nil {
+		n.log.Printf("Failed to identify %s: %v", n.device(c), err)
+		return
+	}
+	n.fillController(c, []byte(strings.Join(out, "\n")))
+	if len(c.Disks) > 0 {
+		n.fillVolumes(c)
+	}
+}
+
+func (n *NvmeCli) Controllers() []*Controller {
+	res := []*Controller{}
+	if fake {
+		return res
+	}
+	names, err := executor.ReadDir("/sys/class/nvme")
+	if err != nil {
+		return res
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		base := filepath.Join("/sys/class/nvme", name)
+		// Skip NVMe over Fabrics controllers.
+		if transport, err := executor.ReadFile(filepath.Join(base, "transport")); err != nil || strings.TrimSpace(string(transport)) != "pcie" {
+			continue
+		}
+		c := &Controller{
+			ID:     strings.TrimPrefix(name, "nvme"),
+			Driver: n.name,
+			driver: n,
+		}
+		if addr, err := executor.ReadFile(filepath.Join(base, "address")); err == nil {
+			var domain int64
+			fmt.Sscanf(strings.TrimSpace(string(addr)), "%x:%x:%x.%x", &domain, &c.PCI.Bus, &c.PCI.Device, &c.PCI.Function)
+		}
+		n.Refresh(c)
+		res = append(res, c)
+	}
+	return res
+}
+
+// createCmd returns the command that creates a namespace of size bytes
+// using the LBA format of the existing namespaces.
+func (n *NvmeCli) createCmd(c *Controller, size uint64) ([]string, error) {
+	blockSize, _ := strconv.ParseUint(c.Info["Block Size"], 10, 64)
+	if blockSize == 0 {
+		blockSize = 512
+	}
+	blocks := size / blockSize
+	if blocks == 0 {
+		return nil, fmt.Errorf("Namespace size %d is smaller than the block size %d", size, blockSize)
+	}
+	return []string{
+		"create-ns",
+		n.device(c),
+		fmt.Sprintf("--nsze=%d", blocks),
+		fmt.Sprintf("--ncap=%d", blocks),
+		"--flbas=" + c.Info["LBA Format"],
+	}, nil
+}
+
+func (n *NvmeCli) attachCmd(c *Controller, nsid string) []string {
+	return []string{"attach-ns", n.device(c), "--namespace-id=" + nsid, "--controllers=" + c.Info["Controller ID"]}
+}
+
+// namespaceCmds returns the commands makeNamespace would run.  The id of
+// the new namespace is not known until it has been created.
+func (n *NvmeCli) namespaceCmds(c *Controller, size uint64) ([][]string, error) {
+	if c.Info["Namespace Management"] != "true" {
+		return nil, fmt.Errorf("%s does not support namespace management", n.device(c))
+	}
+	cmdLine, err := n.createCmd(c, size)
+	if err != nil {
+		return nil, err
+	}
+	return [][]string{cmdLine, n.attachCmd(c, newVolPlaceholder), {"ns-rescan", n.device(c)}}, nil
+}
+
+// makeNamespace creates a namespace and attaches it to the controller so
+// the OS can see it.
+func (n *NvmeCli) makeNamespace(c *Controller, size uint64) error {
+	cmds, err := n.namespaceCmds(c, size)
+	if err != nil {
+		return err
+	}
+	cmdLine := cmds[0]
+	n.log.Printf("Running %s %s", n.executable, strings.Join(cmdLine, " "))
+	out, err := n.run(cmdLine...)
+	if err != nil {
+		return fmt.Errorf("Error running cmd `%s`: %v\n%s", strings.Join(cmdLine, " "), err, strings.Join(out, "\n"))
+	}
+	if fake {
+		return nil
+	}
+	matches := nvmeCreatedRE.FindStringSubmatch(strings.Join(out, "\n"))
+	if len(matches) < 2 {
+		return fmt.Errorf("Cannot find the new namespace id in:\n%s", strings.Join(out, "\n"))
+	}
+	cmds[1] = n.attachCmd(c, matches[1])
+	return n.runCmds(cmds[1:])
+}
+
+func (n *NvmeCli) runCmds(cmds [][]string) error {
+	for _, cmdLine := range cmds {
+		n.log.Printf("Running %s %s", n.executable, strings.Join(cmdLine, " "))
+		if out, err := n.run(cmdLine...); err != nil {
+			return fmt.Errorf("Error running cmd `%s`: %v\n%s", strings.Join(cmdLine, " "), err, strings.Join(out, "\n"))
+		}
+	}
+	return nil
+}
+
+func (n *NvmeCli) CreateCmds(c *Controller, v *VolSpec, forceGood bool) ([][]string, error) {
+	if !v.compiled {
+		return nil, fmt.Errorf("Cannot create a VolSpec that has not been compiled")
+	}
+	if v.RaidLevel != "namespace" {
+		return nil, fmt.Errorf("Raid level %s not supported", v.RaidLevel)
+	}
+	if v.Encrypt {
+		return nil, fmt.Errorf("Encryption not supported")
+	}
+	return n.namespaceCmds(c, v.sizeBytes())
+}
+
+func (n *NvmeCli) Create(c *Controller, v *VolSpec, forceGood bool) error {
+	if _, err := n.CreateCmds(c, v, forceGood); err != nil {
+		return err
+	}
+	return n.makeNamespace(c, v.sizeBytes())
+}
+
+// DeleteCmds returns the detach, delete, and rescan commands for a
+// namespace.  The detach is allowed to fail.
+func (n *NvmeCli) DeleteCmds(c *Controller, v *Volume) ([][]string, error) {
+	dev := n.device(c)
+	return [][]string{
+		{"detach-ns", dev, "--namespace-id=" + v.ID, "--controllers=" + c.Info["Controller ID"]},
+		{"delete-ns", dev, "--namespace-id=" + v.ID},
+		{"ns-rescan", dev},
+	}, nil
+}
+
+func (n *NvmeCli) Delete(c *Controller, v *Volume) error {
+	cmds, _ := n.DeleteCmds(c, v)
+	n.log.Printf("Running %s %s", n.executable, strings.Join(cmds[0], " "))
+	// Detaching a namespace that is not attached fails, and that is fine.
+	n.run(cmds[0]...)
+	return n.runCmds(cmds[1:])
+}
+
+func (n *NvmeCli) ClearCmds(c *Controller, onlyForeign bool) ([][]string, error) {
+	cmds := [][]string{}
+	if onlyForeign {
+		return cmds, nil
+	}
+	for _, vol := range c.Volumes {
+		volCmds, _ := n.DeleteCmds(c, vol)
+		cmds = append(cmds, volCmds...)
+	}
+	nsCmds, err := n.namespaceCmds(c, c.Disks[0].Size)
+	if err != nil {
+		return nil, err
+	}
+	return append(cmds, nsCmds...), nil
+}
+
+// Clear deletes all the namespaces on the drive, and then recreates a
+// single namespace that uses all of it.
+func (n *NvmeCli) Clear(c *Controller, onlyForeign bool) error {
+	if onlyForeign {
+		// NVMe drives have no notion of a foreign config.
+		return nil
+	}
+	if c.Info["Namespace Management"] != "true" {
+		return fmt.Errorf("%s does not support namespace management", n.device(c))
+	}
+	for _, vol := range c.Volumes {
+		if err := n.Delete(c, vol); err != nil {
+			return err
+		}
+	}
+	if err := n.makeNamespace(c, c.Disks[0].Size); err != nil {
+		return err
+	}
+	if !fake {
+		n.Refresh(c)
+	}
+	return nil
+}
+
+func (n *NvmeCli) UpdateCmds(c *Controller, v *Volume, spec *VolSpec) ([][]string, error) {
+	return nil, fmt.Errorf("Changing volume policies is not supported")
+}
+
+func (n *NvmeCli) Update(c *Controller, v *Volume, spec *VolSpec) error {
+	_, err := n.UpdateCmds(c, v, spec)
+	return err
+}
+
+func (n *NvmeCli) SetBootCmds(c *Controller, v *Volume) ([][]string, error) {
+	return nil, fmt.Errorf("Setting the boot volume is not supported")
+}
+
+func (n *NvmeCli) SetBoot(c *Controller, v *Volume) error {
+	_, err := n.SetBootCmds(c, v)
+	return err
+}
+
+func (n *NvmeCli) EncryptCmds(c *Controller, key, password string) ([][]string, error) {
+	return nil, fmt.Errorf("Encryption not supported")
+}
+
+func (n *NvmeCli) Encrypt(c *Controller, key, password string) error {
+	_, err := n.EncryptCmds(c, key, password)
+	return err
+}
+
+func (n *NvmeCli) SecureCmds(c *Controller, v *Volume) ([][]string, error) {
+	return nil, fmt.Errorf("Encryption not supported")
+}
+
+func (n *NvmeCli) Secure(c *Controller, v *Volume) error {
+	_, err := n.SecureCmds(c, v)
+	return err
+}
+
+func (n *NvmeCli) RekeyCmds(c *Controller, sec *Secrets) ([][]string, error) {
+	return nil, fmt.Errorf("Encryption not supported")
+}
+
+func (n *NvmeCli) Rekey(c *Controller, sec *Secrets) error {
+	_, err := n.RekeyCmds(c, sec)
+	return err
+}
+
+func (n *NvmeCli) UnlockCmds(c *Controller, password string) ([][]string, error) {
+	return nil, fmt.Errorf("Encryption not supported")
+}
+
+func (n *NvmeCli) Unlock(c *Controller, password string) error {
+	_, err := n.UnlockCmds(c, password)
+	return err
+}
+
+func (n *NvmeCli) Health(c *Controller, d *PhysicalDisk) (*DiskHealth, error) {
+	return smartctlHealth(d.Info["Device"])
+}
+
+func (n *NvmeCli) LocateCmds(c *Controller, d *PhysicalDisk, on bool) ([][]string, error) {
+	return nil, fmt.Errorf("Locating drives is not supported")
+}
+
+func (n *NvmeCli) Locate(c *Controller, d *PhysicalDisk, on bool) error {
+	_, err := n.LocateCmds(c, d, on)
+	return err
+}
+
+// BackgroundOps always returns nothing, namespaces have no redundancy to
+// build or check.
+func (n *NvmeCli) BackgroundOps(c *Controller) ([]*BackgroundOp, error) {
+	return []*BackgroundOp{}, nil
+}
+
+func (n *NvmeCli) CheckCmds(c *Controller, v *Volume, start bool) ([][]string, error) {
+	return nil, fmt.Errorf("Consistency checks are not supported")
+}
+
+func (n *NvmeCli) Check(c *Controller, v *Volume, start bool) error {
+	_, err := n.CheckCmds(c, v, start)
+	return err
+}
+
+func (n *NvmeCli) EraseCmds(c *Controller, d *PhysicalDisk, method string) ([][]string, error) {
+	return nil, fmt.Errorf("Erasing disks is not supported")
+}
+
+func (n *NvmeCli) Erase(c *Controller, d *PhysicalDisk, method string) error {
+	_, err := n.EraseCmds(c, d, method)
+	return err
+}
+
+func (n *NvmeCli) EraseProgress(c *Controller, d *PhysicalDisk, method string) (*EraseStatus, error) {
+	return nil, fmt.Errorf("Erasing disks is not supported")
+}
+
+func (n *NvmeCli) ExpandCmds(c *Controller, e *Expansion) ([][]string, error) {
+	return nil, fmt.Errorf("Expanding volumes is not supported")
+}
+
+func (n *NvmeCli) Expand(c *Controller, e *Expansion) error {
+	_, err := n.ExpandCmds(c, e)
+	return err
+}
+
+// ForeignConfigs reports none, as NVMe has no notion of a foreign config.
+func (n *NvmeCli) ForeignConfigs(c *Controller) ([]*ForeignConfig, error) {
+	return []*ForeignConfig{}, nil
+}
+
+func (n *NvmeCli) ImportForeignCmds(c *Controller, id string) ([][]string, error) {
+	return nil, fmt.Errorf("Importing foreign configs is not supported")
+}
+
+func (n *NvmeCli) ImportForeign(c *Controller, id string) error {
+	_, err := n.ImportForeignCmds(c, id)
+	return err
+}
+
+func (n *NvmeCli) Settings(c *Controller) (*ControllerSettings, error) {
+	return nil, fmt.Errorf("Changing controller settings is not supported")
+}
+
+func (n *NvmeCli) SettingsCmds(c *Controller, spec *ControllerSpec) ([][]string, error) {
+	return nil, fmt.Errorf("Changing controller settings is not supported")
+}
+
+func (n *NvmeCli) ApplySettings(c *Controller, spec *ControllerSpec) error {
+	_, err := n.SettingsCmds(c, spec)
+	return err
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+const nvmeIdCtrl = `{
+  "vid" : 32902,
+  "ssvid" : 32902,
+  "sn" : "PHLJ912000AB4P0DGN  ",
+  "mn" : "INTEL SSDPE2KX040T8                     ",
+  "fr" : "VDV10131",
+  "cntlid" : 0,
+  "oacs" : 14,
+  "tnvmcap" : 4000787030016,
+  "unvmcap" : 2000393515008,
+  "nn" : 128
+}`
+
+const nvmeIdNs = `{
+  "nsze" : 3907029168,
+  "ncap" : 3907029168,
+  "nuse" : 3907029168,
+  "flbas" : 0,
+  "nlbaf" : 1,
+  "lbafs" : [
+    { "ms" : 0, "ds" : 9, "rp" : 2 },
+    { "ms" : 0, "ds" : 12, "rp" : 0 }
+  ]
+}`
+
+const nvmeListNs = `[   0]:0x1
+[   1]:0x3
+`
+
+func TestNvmeCli(t *testing.T) {
+	n := &NvmeCli{"nvme", "/usr/sbin/nvme", 75, log.New(os.Stderr, "", 0), true}
+	c := &Controller{ID: "2", Driver: n.name, driver: n}
+	n.fillController(c, []byte(nvmeIdCtrl))
+	if len(c.Disks) != 1 {
+		t.Fatalf("Expected 1 disk, got %d", len(c.Disks))
+	}
+	d := c.Disks[0]
+	if d.Size != 4000787030016 || d.UsedSize != 2000393515008 || d.Protocol != "nvme" || d.MediaType != "ssd" {
+		t.Errorf("Unexpected disk %d %d %s %s", d.Size, d.UsedSize, d.Protocol, d.MediaType)
+	}
+	if c.Info["Model"] != "INTEL SSDPE2KX040T8" || c.Info["Namespace Management"] != "true" ||
+		len(c.RaidLevels) != 1 || c.RaidLevels[0] != "namespace" {
+		t.Errorf("Unexpected controller %v %v", c.Info, c.RaidLevels)
+	}
+	nsids := n.namespaces(strings.Split(nvmeListNs, "\n"))
+	if strings.Join(nsids, ",") != "1,3" {
+		t.Errorf("Unexpected namespaces %v", nsids)
+	}
+	vol := &Volume{ID: "3", controller: c, driver: n}
+	n.fillVolume(c, vol, []byte(nvmeIdNs))
+	if vol.RaidLevel != "namespace" || vol.Size != 3907029168*512 || len(vol.Disks) != 1 ||
+		vol.OSDevice() != "/dev/nvme2n3" || d.VolumeID != "3" {
+		t.Errorf("Unexpected volume %s %d %d %s", vol.RaidLevel, vol.Size, len(vol.Disks), vol.OSDevice())
+	}
+	cmd, err := n.createCmd(c, 100<<30)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := "create-ns /dev/nvme2 --nsze=209715200 --ncap=209715200 --flbas=0"
+	if got := strings.Join(cmd, " "); got != want {
+		t.Errorf("Unexpected create command:\n  got: %s\n want: %s", got, want)
+	}
+	if got := strings.Join(n.attachCmd(c, "4"), " "); got != "attach-ns /dev/nvme2 --namespace-id=4 --controllers=0" {
+		t.Errorf("Unexpected attach command %s", got)
+	}
+	n.fillController(c, []byte(`{"oacs" : 6, "tnvmcap" : 0}`))
+	if c.RaidCapable || len(c.RaidLevels) != 0 {
+		t.Errorf("Drive without namespace management should not be raid capable")
+	}
+}
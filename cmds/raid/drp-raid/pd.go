@@ -19,12 +19,26 @@ type PhysicalDisk struct {
 	MediaType          string
 	Status             string
 	JBOD               bool
+	HotSpare           bool
+	Foreign            bool // has a config from another controller on it
+	SpareFor           string
 	Info               map[string]string
 	volume             *Volume
 	controller         *Controller
 	driver             Driver
 }
 
+func (pd *PhysicalDisk) volSpecDisk() VolSpecDisk {
+	return VolSpecDisk{
+		Size:      pd.Size,
+		Volume:    pd.VolumeID,
+		Enclosure: pd.Enclosure,
+		Type:      pd.MediaType,
+		Protocol:  pd.Protocol,
+		Slot:      pd.Slot,
+	}
+}
+
 func (pd *PhysicalDisk) FreeSpace() uint64 {
 	return pd.Size - pd.UsedSize
 }
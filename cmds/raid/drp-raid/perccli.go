@@ -1,11 +1,9 @@
 package main
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"log"
-	"os/exec"
 	"regexp"
 	"strconv"
 	"strings"
@@ -52,14 +50,12 @@ func (s *PercCli) run(args ...string) ([]string, error) {
 	if fake {
 		return []string{}, nil
 	}
-	cmd := exec.Command(s.executable, args...)
-	outBuf := &bytes.Buffer{}
-	cmd.Stdout, cmd.Stderr = outBuf, outBuf
-	if err := cmd.Run(); err != nil {
+	out, _, err := executor.Run(true, s.executable, args...)
+	if err != nil {
 		return nil, err
 	}
-	out := strings.Split(outBuf.String(), "\n")
-	return out, s.checkLinesForError(out)
+	lines := strings.Split(string(out), "\n")
+	return lines, s.checkLinesForError(lines)
 }
 
 func (s *PercCli) Useable() bool {
@@ -78,6 +74,7 @@ func (s *PercCli) fillDisk(d *PhysicalDisk, lines []string) {
 				d.Enclosure = pieces[1]
 				d.Slot, _ = strconv.ParseUint(pieces[2], 10, 64)
 				d.Status = pieces[4]
+				d.Foreign = pieces[5] == "F"
 				d.Protocol = pieces[7]
 				d.MediaType = pieces[8]
 			}
@@ -235,6 +232,34 @@ func (s *PercCli) fillController(c *Controller, lines []string) {
 	for _, array := range arrays {
 		s.fillArray(c, array)
 	}
+	boot, err := s.bootVolume(c)
+	c.BootUnknown = err != nil
+	for _, vol := range c.Volumes {
+		vol.Bootable = boot != "" && vol.ID == boot
+	}
+}
+
+// bootVolume returns the ID of the volume the controller will boot from,
+// or "" if there is not one.  A JBOD boot disk is reported as
+// PD:<enclosure>:<slot>, which is also the ID of its volume.
+func (s *PercCli) bootVolume(c *Controller) (string, error) {
+	out, err := s.run("/c"+c.ID, "show", "bootdrive")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range out {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.EqualFold(fields[0], "BootDrive") {
+			continue
+		}
+		for _, prefix := range []string{"VD:", "PD:"} {
+			if strings.HasPrefix(fields[1], prefix) {
+				return strings.TrimPrefix(fields[1], prefix), nil
+			}
+		}
+		return "", nil
+	}
+	return "", fmt.Errorf("/c%s show bootdrive did not report a boot drive", c.ID)
 }
 
 func (s *PercCli) Controllers() []*Controller {
@@ -266,18 +291,57 @@ func (s *PercCli) canBeCleared(c *Controller) bool {
 }
 
 // XXX: This is somewhat implemented - initial testing done on HBA only controller
-func (s *PercCli) Clear(c *Controller, onlyForeign bool) error {
+func (s *PercCli) ClearCmds(c *Controller, onlyForeign bool) ([][]string, error) {
 	if onlyForeign {
 		// as far as I can tell, ssacli has no notion of a foreign config.
 		// So if we are asked to clear just the foreign config, do nothing.
-		return nil
+		return [][]string{}, nil
 	}
 	if !s.canBeCleared(c) {
-		return nil
+		return [][]string{}, nil
 	}
-	out, err := s.run("controller", "slot="+c.ID, "delete", "forced", "override")
-	s.log.Printf("GREG: perccli: clear: %v %v\n", out, err)
-	return err
+	return [][]string{{"controller", "slot=" + c.ID, "delete", "forced", "override"}}, nil
+}
+
+func (s *PercCli) Clear(c *Controller, onlyForeign bool) error {
+	cmds, _ := s.ClearCmds(c, onlyForeign)
+	for _, cmdLine := range cmds {
+		out, err := s.run(cmdLine...)
+		s.log.Printf("GREG: perccli: clear: %v %v\n", out, err)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runCmds runs cmds in order, stopping at the first one that fails.
+func (s *PercCli) runCmds(cmds [][]string) error {
+	for _, cmdLine := range cmds {
+		s.log.Printf("Running %s %s", s.executable, strings.Join(cmdLine, " "))
+		res, err := s.run(cmdLine...)
+		if len(res) > 0 {
+			s.log.Println(strings.Join(res, "\n"))
+		}
+		if err != nil {
+			s.log.Printf("Error running command: %s", strings.Join(cmdLine, " "))
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *PercCli) DeleteCmds(c *Controller, v *Volume) ([][]string, error) {
+	if v.RaidLevel == "jbod" {
+		d := v.Disks[0]
+		return [][]string{{fmt.Sprintf("/c%s/e%s/s%d", c.ID, d.Enclosure, d.Slot), "set", "good", "force"}}, nil
+	}
+	return [][]string{{fmt.Sprintf("/c%s/v%s", c.ID, v.ID), "del", "force"}}, nil
+}
+
+func (s *PercCli) Delete(c *Controller, v *Volume) error {
+	cmds, _ := s.DeleteCmds(c, v)
+	return s.runCmds(cmds)
 }
 
 func (s PercCli) Refresh(c *Controller) {
@@ -296,10 +360,118 @@ func (s *PercCli) diskList(disks []VolSpecDisk) string {
 	return fmt.Sprintf("drives=%s", strings.Join(parts, "|"))
 }
 
+// storcliCachePolicy parses the Cache column of a storcli style VD list,
+// which is made up of R/NR, WB/AWB/WT and C/D.
+func storcliCachePolicy(vol *Volume, cache string) {
+	switch {
+	case strings.HasPrefix(cache, "NR"):
+		vol.ReadPolicy = "noreadahead"
+		cache = cache[2:]
+	case strings.HasPrefix(cache, "R"):
+		vol.ReadPolicy = "readahead"
+		cache = cache[1:]
+	}
+	switch {
+	case strings.HasPrefix(cache, "AWB"):
+		vol.WritePolicy = "alwayswriteback"
+	case strings.HasPrefix(cache, "WB"):
+		vol.WritePolicy = "writeback"
+	case strings.HasPrefix(cache, "WT"):
+		vol.WritePolicy = "writethrough"
+	}
+	switch {
+	case strings.HasSuffix(cache, "D"):
+		vol.IOPolicy = "direct"
+	case strings.HasSuffix(cache, "C"):
+		vol.IOPolicy = "cached"
+	}
+}
+
+var storcliPolicyArgs = map[string]string{
+	"writeback":       "wb",
+	"writethrough":    "wt",
+	"alwayswriteback": "awb",
+	"readahead":       "ra",
+	"noreadahead":     "nora",
+	"direct":          "direct",
+	"cached":          "cached",
+	"enabled":         "on",
+	"disabled":        "off",
+	"default":         "default",
+}
+
+// storcliCacheArgs returns the cache policy arguments for a storcli
+// style add vd command.
+func storcliCacheArgs(v *VolSpec) []string {
+	res := []string{}
+	for _, p := range []string{v.WritePolicy, v.ReadPolicy, v.IOPolicy} {
+		if p != "" {
+			res = append(res, storcliPolicyArgs[p])
+		}
+	}
+	if v.DiskCache != "" {
+		res = append(res, "pdcache="+storcliPolicyArgs[v.DiskCache])
+	}
+	return res
+}
+
+// lsiBootCmd returns the storcli and perccli command that makes v the
+// volume the controller boots from.
+func lsiBootCmd(c *Controller, v *Volume) []string {
+	return []string{fmt.Sprintf("/c%s/v%s", c.ID, v.ID), "set", "bootdrive=on"}
+}
+
+// lsiSecureCmd returns the storcli and perccli command that encrypts the
+// existing volume v.
+func lsiSecureCmd(c *Controller, v *Volume) []string {
+	return []string{fmt.Sprintf("/c%s/v%s", c.ID, v.ID), "set", "security=on"}
+}
+
+// lsiRekeyCmd returns the storcli and perccli command that changes the
+// controller security key.
+func lsiRekeyCmd(c *Controller, sec *Secrets) []string {
+	cmd := []string{"/c" + c.ID, "set", "securitykey=" + sec.NewPassword, "oldsecuritykey=" + sec.Password}
+	if sec.NewKeyID != "" {
+		cmd = append(cmd, "keyid="+sec.NewKeyID)
+	}
+	return cmd
+}
+
+// lsiUnlockCmd returns the storcli and perccli command that unlocks and
+// imports the foreign configs on secured disks.
+func lsiUnlockCmd(c *Controller, password string) []string {
+	return []string{"/c" + c.ID + "/fall", "import", "securitykey=" + password}
+}
+
+// storcliSetCmds returns the storcli style commands that change the name
+// and policies of the volume at path.
+func storcliSetCmds(path string, changes map[string]string) [][]string {
+	res := [][]string{}
+	for _, k := range policyNames {
+		val, ok := changes[k]
+		if !ok {
+			continue
+		}
+		switch k {
+		case "Name":
+			res = append(res, []string{path, "set", "name=" + val})
+		case "WritePolicy":
+			res = append(res, []string{path, "set", "wrcache=" + storcliPolicyArgs[val]})
+		case "ReadPolicy":
+			res = append(res, []string{path, "set", "rdcache=" + storcliPolicyArgs[val]})
+		case "DiskCache":
+			res = append(res, []string{path, "set", "pdcache=" + storcliPolicyArgs[val]})
+		case "IOPolicy":
+			res = append(res, []string{path, "set", "iopolicy=" + storcliPolicyArgs[val]})
+		}
+	}
+	return res
+}
+
 // XXX: This is somewhat implemented - initial testing done on HBA only controller
-func (s *PercCli) Create(c *Controller, v *VolSpec, forceGood bool) error {
+func (s *PercCli) CreateCmds(c *Controller, v *VolSpec, forceGood bool) ([][]string, error) {
 	if !v.compiled {
-		return fmt.Errorf("Cannot create a VolSpec that has not been compiled")
+		return nil, fmt.Errorf("Cannot create a VolSpec that has not been compiled")
 	}
 	cmdLine := []string{
 		"add",
@@ -314,7 +486,7 @@ func (s *PercCli) Create(c *Controller, v *VolSpec, forceGood bool) error {
 			// Controller will automatically expose non-configured drives to the OS
 			// So, do nothing.
 			s.log.Printf("Controller in mixed, drive already exposed to OS")
-			return nil
+			return [][]string{}, nil
 		}
 		// Yes, I know this is wrong for jbod, but ssacli Is Not Helpful.
 		cmdLine = append(cmdLine, "r0")
@@ -337,23 +509,261 @@ func (s *PercCli) Create(c *Controller, v *VolSpec, forceGood bool) error {
 	case "raid60":
 		cmdLine = append(cmdLine, "r60")
 	default:
-		return fmt.Errorf("Raid level %s not supported", v.RaidLevel)
+		return nil, fmt.Errorf("Raid level %s not supported", v.RaidLevel)
 	}
 	if v.Name != "" {
 		cmdLine = append(cmdLine, fmt.Sprintf("logicaldrivelabel=%s", v.Name))
 	}
-	cmdLine = append(cmdLine, s.diskList(v.Disks), "forced")
-	s.log.Printf("Running %s %s", s.executable, strings.Join(cmdLine, " "))
-	res, err := s.run(cmdLine...)
-	if len(res) > 0 {
-		s.log.Println(strings.Join(res, "\n"))
+	cmdLine = append(cmdLine, storcliCacheArgs(v)...)
+	cmdLine = append(cmdLine, s.diskList(v.Disks))
+	if len(v.HotSpareDisks) > 0 && !v.GlobalHotSpares {
+		cmdLine = append(cmdLine, "spares="+strings.TrimPrefix(s.diskList(v.HotSpareDisks), "drives="))
+	}
+	cmdLine = append(cmdLine, "forced")
+	cmds := [][]string{cmdLine}
+	if v.GlobalHotSpares {
+		for _, disk := range v.HotSpareDisks {
+			path := "/c" + c.ID
+			if disk.Enclosure != "" {
+				path += "/e" + disk.Enclosure
+			}
+			cmds = append(cmds, []string{fmt.Sprintf("%s/s%d", path, disk.Slot), "add", "hotsparedrive"})
+		}
 	}
+	return cmds, nil
+}
+
+func (s *PercCli) Create(c *Controller, v *VolSpec, forceGood bool) error {
+	cmds, err := s.CreateCmds(c, v, forceGood)
 	if err != nil {
-		s.log.Printf("Error running command: %s", strings.Join(cmdLine, " "))
+		return err
 	}
-	return err
+	return s.runCmds(cmds)
+}
+
+func (s *PercCli) UpdateCmds(c *Controller, v *Volume, spec *VolSpec) ([][]string, error) {
+	if v.RaidLevel == "jbod" {
+		return nil, fmt.Errorf("Cannot change the policies of a jbod")
+	}
+	return storcliSetCmds(fmt.Sprintf("/c%s/v%s", c.ID, v.ID), spec.PolicyChanges(v.VolSpec())), nil
+}
+
+func (s *PercCli) Update(c *Controller, v *Volume, spec *VolSpec) error {
+	cmds, err := s.UpdateCmds(c, v, spec)
+	if err != nil {
+		return err
+	}
+	return s.runCmds(cmds)
+}
+
+func (s *PercCli) SetBootCmds(c *Controller, v *Volume) ([][]string, error) {
+	return [][]string{lsiBootCmd(c, v)}, nil
+}
+
+func (s *PercCli) SetBoot(c *Controller, v *Volume) error {
+	cmds, _ := s.SetBootCmds(c, v)
+	return s.runCmds(cmds)
+}
+
+func (s *PercCli) EncryptCmds(c *Controller, key, password string) ([][]string, error) {
+	return nil, fmt.Errorf("Encryption is not currently supported")
 }
 
 func (s *PercCli) Encrypt(c *Controller, key, password string) error {
 	return fmt.Errorf("Encryption is not currently supported")
 }
+
+func (s *PercCli) SecureCmds(c *Controller, v *Volume) ([][]string, error) {
+	return nil, fmt.Errorf("Encryption is not currently supported")
+}
+
+func (s *PercCli) Secure(c *Controller, v *Volume) error {
+	return fmt.Errorf("Encryption is not currently supported")
+}
+
+func (s *PercCli) RekeyCmds(c *Controller, sec *Secrets) ([][]string, error) {
+	return nil, fmt.Errorf("Encryption is not currently supported")
+}
+
+func (s *PercCli) Rekey(c *Controller, sec *Secrets) error {
+	return fmt.Errorf("Encryption is not currently supported")
+}
+
+func (s *PercCli) UnlockCmds(c *Controller, password string) ([][]string, error) {
+	return nil, fmt.Errorf("Encryption is not currently supported")
+}
+
+func (s *PercCli) Unlock(c *Controller, password string) error {
+	return fmt.Errorf("Encryption is not currently supported")
+}
+
+func (s *PercCli) Health(c *Controller, d *PhysicalDisk) (*DiskHealth, error) {
+	return lsiDiskHealth(s.name, d.Info), nil
+}
+
+func (s *PercCli) LocateCmds(c *Controller, d *PhysicalDisk, on bool) ([][]string, error) {
+	path := "/c" + c.ID
+	if d.Enclosure != "" {
+		path += "/e" + d.Enclosure
+	}
+	path += fmt.Sprintf("/s%d", d.Slot)
+	action := "stop"
+	if on {
+		action = "start"
+	}
+	return [][]string{{path, action, "locate"}}, nil
+}
+
+func (s *PercCli) Locate(c *Controller, d *PhysicalDisk, on bool) error {
+	cmds, _ := s.LocateCmds(c, d, on)
+	return s.runCmds(cmds)
+}
+
+func (s *PercCli) EraseCmds(c *Controller, d *PhysicalDisk, method string) ([][]string, error) {
+	return [][]string{lsiEraseCmd(c, d, method)}, nil
+}
+
+func (s *PercCli) Erase(c *Controller, d *PhysicalDisk, method string) error {
+	cmds, _ := s.EraseCmds(c, d, method)
+	return s.runCmds(cmds)
+}
+
+func (s *PercCli) EraseProgress(c *Controller, d *PhysicalDisk, method string) (*EraseStatus, error) {
+	cmd := lsiEraseShow(c, d, method)
+	if cmd == nil {
+		return &EraseStatus{Erased: true}, nil
+	}
+	out, err := s.run(cmd...)
+	if err != nil {
+		return nil, err
+	}
+	return lsiEraseStatus(c, d, out), nil
+}
+
+func (s *PercCli) BackgroundOps(c *Controller) ([]*BackgroundOp, error) {
+	res := []*BackgroundOp{}
+	if s.canBeCleared(c) {
+		for _, show := range []string{"bgi", "cc", "init"} {
+			lines, err := s.run("/c"+c.ID+"/vall", "show", show)
+			if err != nil {
+				s.log.Printf("Failed to get %s progress on %s: %v", show, c.Name(), err)
+				continue
+			}
+			for _, line := range lines {
+				matches := percVDOpRE.FindStringSubmatch(line)
+				if len(matches) != 5 {
+					continue
+				}
+				op := &BackgroundOp{Controller: c.Name(), Volume: matches[1], Operation: percOpKinds[matches[2]]}
+				op.Progress, _ = progressValue(matches[3])
+				op.Remaining = parseTimeLeft(matches[4])
+				res = append(res, op)
+			}
+		}
+	}
+	for _, show := range []string{"rebuild", "copyback"} {
+		busy := false
+		for _, d := range c.Disks {
+			busy = busy || (show == "rebuild" && d.Status == "Rbld") || (show == "copyback" && d.Status == "Cpybck")
+		}
+		if !busy {
+			continue
+		}
+		lines, err := s.run("/c"+c.ID+"/eall/sall", "show", show)
+		if err != nil {
+			return res, err
+		}
+		for _, line := range lines {
+			matches := percDriveOpRE.FindStringSubmatch(strings.TrimSpace(line))
+			if len(matches) != 5 {
+				continue
+			}
+			op := &BackgroundOp{Controller: c.Name(), Disk: matches[2], Operation: show}
+			if matches[1] != "" {
+				op.Disk = matches[1] + ":" + matches[2]
+			}
+			op.Progress, _ = progressValue(matches[3])
+			op.Remaining = parseTimeLeft(matches[4])
+			res = append(res, op)
+		}
+	}
+	return res, nil
+}
+
+func (s *PercCli) CheckCmds(c *Controller, v *Volume, start bool) ([][]string, error) {
+	if err := checkable(v); err != nil {
+		return nil, err
+	}
+	action := "stop"
+	if start {
+		action = "start"
+	}
+	return [][]string{{fmt.Sprintf("/c%s/v%s", c.ID, v.ID), action, "cc"}}, nil
+}
+
+func (s *PercCli) Check(c *Controller, v *Volume, start bool) error {
+	cmds, err := s.CheckCmds(c, v, start)
+	if err != nil {
+		return err
+	}
+	return s.runCmds(cmds)
+}
+
+func (s *PercCli) ExpandCmds(c *Controller, e *Expansion) ([][]string, error) {
+	return [][]string{lsiMigrateCmd(c, e)}, nil
+}
+
+func (s *PercCli) Expand(c *Controller, e *Expansion) error {
+	cmds, err := s.ExpandCmds(c, e)
+	if err != nil {
+		return err
+	}
+	return s.runCmds(cmds)
+}
+
+// ForeignConfigs uses the JSON output, which is far easier to pick the
+// drive group topology out of.
+func (s *PercCli) ForeignConfigs(c *Controller) ([]*ForeignConfig, error) {
+	out, err := s.run("/c"+c.ID+"/fall", "show", "all", "J")
+	if err != nil {
+		return nil, err
+	}
+	return lsiForeignConfigs(c, strings.Join(out, "\n"))
+}
+
+func (s *PercCli) ImportForeignCmds(c *Controller, id string) ([][]string, error) {
+	cmd, err := lsiImportForeignCmd(c, id)
+	if err != nil {
+		return nil, err
+	}
+	return [][]string{cmd}, nil
+}
+
+func (s *PercCli) ImportForeign(c *Controller, id string) error {
+	cmds, err := s.ImportForeignCmds(c, id)
+	if err != nil {
+		return err
+	}
+	return s.runCmds(cmds)
+}
+
+func (s *PercCli) Settings(c *Controller) (*ControllerSettings, error) {
+	out, err := s.run("/c"+c.ID, "show", "personality")
+	if err != nil {
+		return nil, err
+	}
+	current, requested := lsiPersonalities(out)
+	return lsiSettings(c, current, requested), nil
+}
+
+func (s *PercCli) SettingsCmds(c *Controller, spec *ControllerSpec) ([][]string, error) {
+	return lsiSettingsCmds(c, spec, "HBA"), nil
+}
+
+func (s *PercCli) ApplySettings(c *Controller, spec *ControllerSpec) error {
+	cmds, err := s.SettingsCmds(c, spec)
+	if err != nil {
+		return err
+	}
+	return s.runCmds(cmds)
+}
@@ -1,12 +1,10 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
-	"os/exec"
 	"regexp"
 	"strconv"
 	"strings"
@@ -81,14 +79,11 @@ func (s *PercJsonCli) run(args ...string) (string, error) {
 	if fake {
 		return "", nil
 	}
-	cmd := exec.Command(s.executable, args...)
-	outBuf := &bytes.Buffer{}
-	cmd.Stdout, cmd.Stderr = outBuf, outBuf
-	if err := cmd.Run(); err != nil {
+	out, _, err := executor.Run(true, s.executable, args...)
+	if err != nil {
 		return "", err
 	}
-	out := strings.Split(outBuf.String(), "\n")
-	return outBuf.String(), s.checkLinesForError(out)
+	return string(out), s.checkLinesForError(strings.Split(string(out), "\n"))
 }
 
 func (s *PercJsonCli) Useable() bool {
@@ -112,6 +107,8 @@ func (s *PercJsonCli) fillDisk(d *PhysicalDisk, phy *PercJsonPhysicalDiskCntr) {
 	}
 	d.Slot, _ = strconv.ParseUint(strings.Split(phy.EidSlt, ":")[1], 10, 64)
 	d.Status = phy.State
+	d.JBOD = phy.State == "JBOD"
+	d.Foreign = phy.DG == "F"
 	d.Protocol = strings.ToLower(phy.Intf)
 	d.MediaType = strings.ToLower(phy.Med)
 	if d.MediaType == "hdd" {
@@ -167,34 +164,39 @@ func (s *PercJsonCli) fillDisk(d *PhysicalDisk, phy *PercJsonPhysicalDiskCntr) {
 	}
 }
 
-func (s *PercJsonCli) fillVolume(vol *Volume, ld *PercJsonVolumeCntr) {
-	vol.Info = map[string]string{}
-	s.convertToKV(ld, vol.Info)
-
-	vol.Name = ld.Name
-	vol.ID = strings.Split(ld.DGVD, "/")[1]
-	vol.Status = ld.State
-	switch ld.TYPE {
+// storcliRaidLevel translates the TYPE storcli reports for a volume.
+func storcliRaidLevel(t string) string {
+	switch t {
 	case "RAID0":
-		vol.RaidLevel = "raid0"
+		return "raid0"
 	case "RAID1", "RAID1ADM":
-		vol.RaidLevel = "raid1"
+		return "raid1"
 	case "RAID5":
-		vol.RaidLevel = "raid5"
+		return "raid5"
 	case "RAID6":
-		vol.RaidLevel = "raid6"
+		return "raid6"
 	case "RAID1+0", "RAID10":
-		vol.RaidLevel = "raid10"
+		return "raid10"
 	case "RAID1+0ADM":
-		vol.RaidLevel = "raid10"
+		return "raid10"
 	case "RAID50":
-		vol.RaidLevel = "raid50"
+		return "raid50"
 	case "RAID60":
-		vol.RaidLevel = "raid60"
-	default:
-		vol.RaidLevel = ld.TYPE
+		return "raid60"
 	}
+	return t
+}
+
+func (s *PercJsonCli) fillVolume(vol *Volume, ld *PercJsonVolumeCntr) {
+	vol.Info = map[string]string{}
+	s.convertToKV(ld, vol.Info)
+
+	vol.Name = ld.Name
+	vol.ID = strings.Split(ld.DGVD, "/")[1]
+	vol.Status = ld.State
+	vol.RaidLevel = storcliRaidLevel(ld.TYPE)
 	vol.Size, _ = sizeParser(ld.Size)
+	storcliCachePolicy(vol, ld.Cache)
 
 	path := fmt.Sprintf("/c%d", vol.controller.idx)
 	path += fmt.Sprintf("/v%d", vol.idx)
@@ -211,6 +213,8 @@ func (s *PercJsonCli) fillVolume(vol *Volume, ld *PercJsonVolumeCntr) {
 			switch strings.ToLower(k) {
 			case "name":
 				vol.Name = v
+			case "encryption":
+				vol.Secured = securedState(v)
 			case "span depth":
 				vol.Spans, _ = strconv.ParseUint(v, 10, 64)
 			case "number of drives", "number of drives per span":
@@ -227,6 +231,15 @@ func (s *PercJsonCli) fillVolume(vol *Volume, ld *PercJsonVolumeCntr) {
 					s.log.Fatalf("megacli returned a non-parseable stripe size %s: %v", v, err)
 				}
 				vol.StripeSize = ss
+			case "disk cache policy":
+				switch v {
+				case "Enabled":
+					vol.DiskCache = "enabled"
+				case "Disabled":
+					vol.DiskCache = "disabled"
+				case "Disk's Default":
+					vol.DiskCache = "default"
+				}
 			}
 		}
 
@@ -273,7 +286,7 @@ func (s *PercJsonCli) fillArray(c *Controller, rs *PercJsonController) {
 			controller:       c,
 			ControllerID:     c.ID,
 			ControllerDriver: c.Driver,
-			driver:           s,
+			driver:           c.driver,
 		}
 		s.fillDisk(d, phy)
 		disks[i] = d
@@ -282,17 +295,38 @@ func (s *PercJsonCli) fillArray(c *Controller, rs *PercJsonController) {
 		}
 	}
 	c.Disks = append(c.Disks, disks...)
+	groups := map[string]*Volume{}
 	if len(rs.VDList) > 0 {
 		for idx, ld := range rs.VDList {
 			vol := &Volume{
 				ControllerID:     c.ID,
 				ControllerDriver: c.Driver,
 				controller:       c,
-				driver:           s,
+				driver:           c.driver,
 				idx:              idx,
 			}
 			s.fillVolume(vol, ld)
 			c.Volumes = append(c.Volumes, vol)
+			groups[strings.Split(ld.DGVD, "/")[0]] = vol
+		}
+		boot, err := s.bootVolume(c)
+		c.BootUnknown = err != nil
+		for _, vol := range c.Volumes {
+			vol.Bootable = vol.ID == boot
+		}
+	}
+	// DHS disks are dedicated to the volumes in their disk group, GHS disks are global.
+	for i, phy := range rs.PDList {
+		d := disks[i]
+		switch phy.State {
+		case "GHS":
+			d.HotSpare = true
+		case "DHS":
+			d.HotSpare = true
+			if vol, ok := groups[string(phy.DG)]; ok {
+				d.SpareFor = vol.ID
+				vol.HotSpares = append(vol.HotSpares, d)
+			}
 		}
 	}
 
@@ -319,13 +353,58 @@ func (s *PercJsonCli) fillArray(c *Controller, rs *PercJsonController) {
 			}
 		}
 		for _, d := range c.Disks {
-			if d.VolumeID == "" {
+			if d.VolumeID == "" && !d.HotSpare {
 				c.addJBODVolume(d)
 			}
 		}
 	}
 }
 
+// ctrlProps runs a show command against the controller, and returns the
+// Controller Properties it reports.
+func (s *PercJsonCli) ctrlProps(c *Controller, args ...string) (map[string]string, error) {
+	out, err := s.run(append(append([]string{"/c" + c.ID, "show"}, args...), "J")...)
+	if err != nil {
+		return nil, err
+	}
+	cc := &struct {
+		Controllers []*PercJsonCommand
+	}{}
+	if err := json.Unmarshal([]byte(out), cc); err != nil {
+		return nil, err
+	}
+	if len(cc.Controllers) == 0 {
+		return nil, fmt.Errorf("No controller in show %s output", strings.Join(args, " "))
+	}
+	props := &struct {
+		Props []struct {
+			Prop  string `json:"Ctrl_Prop"`
+			Value string
+		} `json:"Controller Properties"`
+	}{}
+	utils.Remarshal(cc.Controllers[0].ResponseData, props)
+	res := map[string]string{}
+	for _, prop := range props.Props {
+		res[prop.Prop] = prop.Value
+	}
+	return res, nil
+}
+
+// bootVolume returns the ID of the volume the controller will boot from,
+// or "" if there is not one.
+func (s *PercJsonCli) bootVolume(c *Controller) (string, error) {
+	props, err := s.ctrlProps(c, "bootdrive")
+	if err != nil {
+		return "", err
+	}
+	for k, v := range props {
+		if strings.EqualFold(k, "BootDrive") && strings.HasPrefix(v, "VD:") {
+			return strings.TrimPrefix(v, "VD:"), nil
+		}
+	}
+	return "", nil
+}
+
 func (s *PercJsonCli) fillController(c *Controller, rs *PercJsonController) {
 	c.Disks = []*PhysicalDisk{}
 	c.Volumes = []*Volume{}
@@ -396,9 +475,9 @@ type PercJsonControllerBus struct {
 }
 
 type PercJsonPhysicalDiskCntr struct {
-	DG     int    `json:"DG"`
-	DID    int    `json:"DID"`
-	EidSlt string `json:"EID:Slt"`
+	DG     lsiField `json:"DG"`
+	DID    int      `json:"DID"`
+	EidSlt string   `json:"EID:Slt"`
 	Intf   string
 	Med    string
 	Model  string
@@ -437,6 +516,12 @@ type PercJsonController struct {
 }
 
 func (s *PercJsonCli) Controllers() []*Controller {
+	return s.controllers(s)
+}
+
+// controllers finds the controllers the tool can see, and hands them to
+// drv, which is s or a driver that builds on it.
+func (s *PercJsonCli) controllers(drv Driver) []*Controller {
 	out, err := s.run("/call", "show", "all", "J")
 	if err != nil {
 		return nil
@@ -454,7 +539,7 @@ func (s *PercJsonCli) Controllers() []*Controller {
 	for i, cmd := range c.Controllers {
 		res[i] = &Controller{
 			Driver: s.name,
-			driver: s,
+			driver: drv,
 			idx:    i,
 		}
 
@@ -476,17 +561,58 @@ func (s *PercJsonCli) canBeCleared(c *Controller) bool {
 	return false
 }
 
-func (s *PercJsonCli) Clear(c *Controller, onlyForeign bool) error {
+func (s *PercJsonCli) ClearCmds(c *Controller, onlyForeign bool) ([][]string, error) {
 	if onlyForeign {
 		// as far as I can tell, ssacli has no notion of a foreign config.
 		// So if we are asked to clear just the foreign config, do nothing.
-		return nil
+		return [][]string{}, nil
 	}
 	if !s.canBeCleared(c) {
-		return nil
+		return [][]string{}, nil
+	}
+	return [][]string{{"/c" + c.ID + "/vall", "del", "force", "J"}}, nil
+}
+
+func (s *PercJsonCli) Clear(c *Controller, onlyForeign bool) error {
+	cmds, _ := s.ClearCmds(c, onlyForeign)
+	for _, cmdLine := range cmds {
+		if _, err := s.run(cmdLine...); err != nil {
+			return err
+		}
 	}
-	_, err := s.run("/c"+c.ID+"/vall", "del", "force", "J")
-	return err
+	return nil
+}
+
+// runCmds runs cmds in order, stopping at the first one that fails.
+func (s *PercJsonCli) runCmds(cmds [][]string) error {
+	for _, cmdLine := range cmds {
+		s.log.Printf("Running %s %s", s.executable, strings.Join(cmdLine, " "))
+		out, err := s.run(cmdLine...)
+		if err != nil {
+			s.log.Println(out)
+			s.log.Printf("Error running command: %s", strings.Join(cmdLine, " "))
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *PercJsonCli) DeleteCmds(c *Controller, v *Volume) ([][]string, error) {
+	if v.RaidLevel == "jbod" {
+		d := v.Disks[0]
+		path := "/c" + c.ID
+		if d.Enclosure != "" {
+			path += "/e" + d.Enclosure
+		}
+		path += fmt.Sprintf("/s%d", d.Slot)
+		return [][]string{{path, "set", "good", "force", "J"}}, nil
+	}
+	return [][]string{{fmt.Sprintf("/c%s/v%s", c.ID, v.ID), "del", "force", "J"}}, nil
+}
+
+func (s *PercJsonCli) Delete(c *Controller, v *Volume) error {
+	cmds, _ := s.DeleteCmds(c, v)
+	return s.runCmds(cmds)
 }
 
 func (s PercJsonCli) Refresh(c *Controller) {
@@ -495,13 +621,15 @@ func (s PercJsonCli) Refresh(c *Controller) {
 		return
 	}
 	cc := &struct {
-		Controllers []*PercJsonController
+		Controllers []*PercJsonCommand
 	}{}
-	if err := json.Unmarshal([]byte(out), cc); err != nil {
+	if err := json.Unmarshal([]byte(out), cc); err != nil || len(cc.Controllers) == 0 {
 		s.log.Printf("Failed to process json: %v", err)
 		return
 	}
-	s.fillController(c, cc.Controllers[0])
+	controller := &PercJsonController{}
+	utils.Remarshal(cc.Controllers[0].ResponseData, controller)
+	s.fillController(c, controller)
 }
 
 func (s *PercJsonCli) diskList(disks []VolSpecDisk) string {
@@ -516,9 +644,9 @@ func (s *PercJsonCli) diskList(disks []VolSpecDisk) string {
 	return fmt.Sprintf("drives=%s", strings.Join(parts, ","))
 }
 
-func (s *PercJsonCli) Create(c *Controller, v *VolSpec, forceGood bool) error {
+func (s *PercJsonCli) CreateCmds(c *Controller, v *VolSpec, forceGood bool) ([][]string, error) {
 	if !v.compiled {
-		return fmt.Errorf("Cannot create a VolSpec that has not been compiled")
+		return nil, fmt.Errorf("Cannot create a VolSpec that has not been compiled")
 	}
 	cmdLine := []string{
 		"/c" + c.ID,
@@ -531,7 +659,7 @@ func (s *PercJsonCli) Create(c *Controller, v *VolSpec, forceGood bool) error {
 			// Controller will automatically expose non-configured drives to the OS
 			// So, do nothing.
 			s.log.Printf("Controller in mixed, drive already exposed to OS")
-			return nil
+			return [][]string{}, nil
 		}
 		// Yes, I know this is wrong for jbod, but ssacli Is Not Helpful.
 		cmdLine = append(cmdLine, "r0")
@@ -550,38 +678,95 @@ func (s *PercJsonCli) Create(c *Controller, v *VolSpec, forceGood bool) error {
 	case "raid60":
 		cmdLine = append(cmdLine, "r60")
 	default:
-		return fmt.Errorf("Raid level %s not supported", v.RaidLevel)
+		return nil, fmt.Errorf("Raid level %s not supported", v.RaidLevel)
 	}
 	if v.Name != "" {
 		cmdLine = append(cmdLine, fmt.Sprintf("name=\"%s\"", v.Name))
 	}
+	cmdLine = append(cmdLine, storcliCacheArgs(v)...)
 	cmdLine = append(cmdLine, s.diskList(v.Disks))
+	if len(v.HotSpareDisks) > 0 && !v.GlobalHotSpares {
+		cmdLine = append(cmdLine, "spares="+strings.TrimPrefix(s.diskList(v.HotSpareDisks), "drives="))
+	}
 	cmdLine = append(cmdLine, fmt.Sprintf("strip=%d", v.stripeSize()>>10))
 	cmdLine = append(cmdLine, "force", "J")
-	s.log.Printf("Running %s %s", s.executable, strings.Join(cmdLine, " "))
-	res, err := s.run(cmdLine...)
-	if res != "" {
-		s.log.Println(res)
+	cmds := [][]string{cmdLine}
+	if v.GlobalHotSpares {
+		for _, disk := range v.HotSpareDisks {
+			path := "/c" + c.ID
+			if disk.Enclosure != "" {
+				path += "/e" + disk.Enclosure
+			}
+			cmds = append(cmds, []string{fmt.Sprintf("%s/s%d", path, disk.Slot), "add", "hotsparedrive", "J"})
+		}
+	}
+	return cmds, nil
+}
+
+func (s *PercJsonCli) Create(c *Controller, v *VolSpec, forceGood bool) error {
+	cmds, err := s.CreateCmds(c, v, forceGood)
+	if err != nil {
+		return err
 	}
+	for _, cmd := range cmds {
+		s.log.Printf("Running %s %s", s.executable, strings.Join(cmd, " "))
+		res, err := s.run(cmd...)
+		if res != "" {
+			s.log.Println(res)
+		}
+		if err != nil {
+			s.log.Printf("Error running command: %s", strings.Join(cmd, " "))
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *PercJsonCli) UpdateCmds(c *Controller, v *Volume, spec *VolSpec) ([][]string, error) {
+	if v.RaidLevel == "jbod" {
+		return nil, fmt.Errorf("Cannot change the policies of a jbod")
+	}
+	cmds := storcliSetCmds(fmt.Sprintf("/c%s/v%s", c.ID, v.ID), spec.PolicyChanges(v.VolSpec()))
+	for i := range cmds {
+		cmds[i] = append(cmds[i], "J")
+	}
+	return cmds, nil
+}
+
+func (s *PercJsonCli) Update(c *Controller, v *Volume, spec *VolSpec) error {
+	cmds, err := s.UpdateCmds(c, v, spec)
 	if err != nil {
-		s.log.Printf("Error running command: %s", strings.Join(cmdLine, " "))
+		return err
 	}
-	return err
+	return s.runCmds(cmds)
+}
+
+func (s *PercJsonCli) SetBootCmds(c *Controller, v *Volume) ([][]string, error) {
+	return [][]string{append(lsiBootCmd(c, v), "J")}, nil
+}
+
+func (s *PercJsonCli) SetBoot(c *Controller, v *Volume) error {
+	cmds, _ := s.SetBootCmds(c, v)
+	return s.runCmds(cmds)
+}
+
+func (s *PercJsonCli) EncryptCmds(c *Controller, key, password string) ([][]string, error) {
+	return [][]string{
+		[]string{"delete", "securitykey", "/c" + c.ID},
+		[]string{"set", fmt.Sprintf("securitykey=%s", password), fmt.Sprintf("keyid=%s", key), "/c" + c.ID},
+	}, nil
 }
 
 func (s *PercJsonCli) Encrypt(c *Controller, key, password string) error {
-	cmds := [][]string{
-		[]string{"delete", "securitykey"},
-		[]string{"set", fmt.Sprintf("securitykey=%s", password), fmt.Sprintf("keyid=%s", key)},
-	}
+	cmds, _ := s.EncryptCmds(c, key, password)
 	var (
 		out string
 		err error
 	)
 	for i, cmd := range cmds {
-		cmd = append(cmd, "/c"+c.ID)
 		s.log.Printf("Running command: %s %s", s.executable, strings.Join(cmd, " "))
 		out, err = s.run(cmd...)
+		// There may not be a key to delete.
 		if i == 0 || err == nil {
 			continue
 		}
@@ -590,3 +775,262 @@ func (s *PercJsonCli) Encrypt(c *Controller, key, password string) error {
 	s.log.Println(out)
 	return nil
 }
+
+func (s *PercJsonCli) SecureCmds(c *Controller, v *Volume) ([][]string, error) {
+	return [][]string{append(lsiSecureCmd(c, v), "J")}, nil
+}
+
+func (s *PercJsonCli) Secure(c *Controller, v *Volume) error {
+	cmds, _ := s.SecureCmds(c, v)
+	return s.runCmds(cmds)
+}
+
+func (s *PercJsonCli) RekeyCmds(c *Controller, sec *Secrets) ([][]string, error) {
+	return [][]string{append(lsiRekeyCmd(c, sec), "J")}, nil
+}
+
+func (s *PercJsonCli) Rekey(c *Controller, sec *Secrets) error {
+	cmds, _ := s.RekeyCmds(c, sec)
+	return s.runCmds(cmds)
+}
+
+func (s *PercJsonCli) UnlockCmds(c *Controller, password string) ([][]string, error) {
+	return [][]string{append(lsiUnlockCmd(c, password), "J")}, nil
+}
+
+func (s *PercJsonCli) Unlock(c *Controller, password string) error {
+	cmds, _ := s.UnlockCmds(c, password)
+	return s.runCmds(cmds)
+}
+
+func (s *PercJsonCli) Health(c *Controller, d *PhysicalDisk) (*DiskHealth, error) {
+	return lsiDiskHealth(s.name, d.Info), nil
+}
+
+func (s *PercJsonCli) LocateCmds(c *Controller, d *PhysicalDisk, on bool) ([][]string, error) {
+	path := "/c" + c.ID
+	if d.Enclosure != "" {
+		path += "/e" + d.Enclosure
+	}
+	path += fmt.Sprintf("/s%d", d.Slot)
+	action := "stop"
+	if on {
+		action = "start"
+	}
+	return [][]string{{path, action, "locate", "J"}}, nil
+}
+
+func (s *PercJsonCli) Locate(c *Controller, d *PhysicalDisk, on bool) error {
+	cmds, _ := s.LocateCmds(c, d, on)
+	return s.runCmds(cmds)
+}
+
+func (s *PercJsonCli) EraseCmds(c *Controller, d *PhysicalDisk, method string) ([][]string, error) {
+	return [][]string{append(lsiEraseCmd(c, d, method), "J")}, nil
+}
+
+func (s *PercJsonCli) Erase(c *Controller, d *PhysicalDisk, method string) error {
+	cmds, _ := s.EraseCmds(c, d, method)
+	return s.runCmds(cmds)
+}
+
+func (s *PercJsonCli) EraseProgress(c *Controller, d *PhysicalDisk, method string) (*EraseStatus, error) {
+	cmd := lsiEraseShow(c, d, method)
+	if cmd == nil {
+		return &EraseStatus{Erased: true}, nil
+	}
+	ops, err := s.opStatuses(cmd...)
+	if err != nil {
+		return nil, err
+	}
+	if len(ops) == 0 {
+		return &EraseStatus{}, nil
+	}
+	if ops[0].Status != "In progress" {
+		return eraseStatusFor(ops[0].Status), nil
+	}
+	op := &BackgroundOp{Controller: c.Name(), Disk: d.Name(), Operation: "erase"}
+	op.Progress, _ = progressValue(ops[0].Progress)
+	op.Remaining = parseTimeLeft(ops[0].TimeLeft)
+	return &EraseStatus{Op: op}, nil
+}
+
+type PercJsonOpStatus struct {
+	VD        *int   `json:",omitempty"`
+	DriveID   string `json:"Drive-ID"`
+	Operation string
+	Progress  interface{} `json:"Progress%"`
+	Status    string
+	TimeLeft  string `json:"Estimated Time Left"`
+}
+
+// opStatus runs a show command for background operations, and returns
+// the ones that are in progress.
+func (s *PercJsonCli) opStatus(args ...string) ([]*PercJsonOpStatus, error) {
+	ops, err := s.opStatuses(args...)
+	if err != nil {
+		return nil, err
+	}
+	res := []*PercJsonOpStatus{}
+	for _, op := range ops {
+		if op.Status == "In progress" {
+			res = append(res, op)
+		}
+	}
+	return res, nil
+}
+
+// opStatuses runs a show command for background operations, and returns
+// all of them whatever their status.
+func (s *PercJsonCli) opStatuses(args ...string) ([]*PercJsonOpStatus, error) {
+	out, err := s.run(append(args, "J")...)
+	if err != nil {
+		return nil, err
+	}
+	c := &struct {
+		Controllers []*PercJsonCommand
+	}{}
+	if err := json.Unmarshal([]byte(out), c); err != nil {
+		return nil, err
+	}
+	res := []*PercJsonOpStatus{}
+	for _, cmd := range c.Controllers {
+		ops := []*PercJsonOpStatus{}
+		// Volume operations are under VD Operation Status, drive ones
+		// are the response data.
+		vds := &struct {
+			Ops []*PercJsonOpStatus `json:"VD Operation Status"`
+		}{}
+		if err := utils.Remarshal(cmd.ResponseData, vds); err == nil && len(vds.Ops) > 0 {
+			ops = vds.Ops
+		} else {
+			utils.Remarshal(cmd.ResponseData, &ops)
+		}
+		res = append(res, ops...)
+	}
+	return res, nil
+}
+
+func (s *PercJsonCli) BackgroundOps(c *Controller) ([]*BackgroundOp, error) {
+	res := []*BackgroundOp{}
+	add := func(op *PercJsonOpStatus, bg *BackgroundOp) {
+		bg.Progress, _ = progressValue(op.Progress)
+		bg.Remaining = parseTimeLeft(op.TimeLeft)
+		res = append(res, bg)
+	}
+	if s.canBeCleared(c) {
+		for _, show := range []string{"bgi", "cc", "init"} {
+			ops, err := s.opStatus("/c"+c.ID+"/vall", "show", show)
+			if err != nil {
+				s.log.Printf("Failed to get %s progress on %s: %v", show, c.Name(), err)
+				continue
+			}
+			for _, op := range ops {
+				bg := &BackgroundOp{Controller: c.Name(), Operation: percOpKinds[strings.ToUpper(show)]}
+				if op.VD != nil {
+					bg.Volume = strconv.Itoa(*op.VD)
+				}
+				add(op, bg)
+			}
+		}
+	}
+	for _, show := range []string{"rebuild", "copyback"} {
+		busy := false
+		for _, d := range c.Disks {
+			busy = busy || (show == "rebuild" && d.Status == "Rbld") || (show == "copyback" && d.Status == "Cpybck")
+		}
+		if !busy {
+			continue
+		}
+		ops, err := s.opStatus("/c"+c.ID+"/eall/sall", "show", show)
+		if err != nil {
+			return res, err
+		}
+		for _, op := range ops {
+			name := op.DriveID
+			if parts := percDriveIDRE.FindStringSubmatch(op.DriveID); len(parts) == 3 {
+				name = parts[2]
+				if parts[1] != "" {
+					name = parts[1] + ":" + parts[2]
+				}
+			}
+			add(op, &BackgroundOp{Controller: c.Name(), Disk: name, Operation: show})
+		}
+	}
+	return res, nil
+}
+
+func (s *PercJsonCli) CheckCmds(c *Controller, v *Volume, start bool) ([][]string, error) {
+	if err := checkable(v); err != nil {
+		return nil, err
+	}
+	action := "stop"
+	if start {
+		action = "start"
+	}
+	return [][]string{{fmt.Sprintf("/c%s/v%s", c.ID, v.ID), action, "cc", "J"}}, nil
+}
+
+func (s *PercJsonCli) Check(c *Controller, v *Volume, start bool) error {
+	cmds, err := s.CheckCmds(c, v, start)
+	if err != nil {
+		return err
+	}
+	return s.runCmds(cmds)
+}
+
+func (s *PercJsonCli) ExpandCmds(c *Controller, e *Expansion) ([][]string, error) {
+	return [][]string{append(lsiMigrateCmd(c, e), "J")}, nil
+}
+
+func (s *PercJsonCli) Expand(c *Controller, e *Expansion) error {
+	cmds, err := s.ExpandCmds(c, e)
+	if err != nil {
+		return err
+	}
+	return s.runCmds(cmds)
+}
+
+func (s *PercJsonCli) ForeignConfigs(c *Controller) ([]*ForeignConfig, error) {
+	out, err := s.run("/c"+c.ID+"/fall", "show", "all", "J")
+	if err != nil {
+		return nil, err
+	}
+	return lsiForeignConfigs(c, out)
+}
+
+func (s *PercJsonCli) ImportForeignCmds(c *Controller, id string) ([][]string, error) {
+	cmd, err := lsiImportForeignCmd(c, id)
+	if err != nil {
+		return nil, err
+	}
+	return [][]string{append(cmd, "J")}, nil
+}
+
+func (s *PercJsonCli) ImportForeign(c *Controller, id string) error {
+	cmds, err := s.ImportForeignCmds(c, id)
+	if err != nil {
+		return err
+	}
+	return s.runCmds(cmds)
+}
+
+func (s *PercJsonCli) Settings(c *Controller) (*ControllerSettings, error) {
+	props, err := s.ctrlProps(c, "personality")
+	if err != nil {
+		return nil, err
+	}
+	return lsiSettings(c, props["Current Personality"], props["Requested Personality"]), nil
+}
+
+func (s *PercJsonCli) SettingsCmds(c *Controller, spec *ControllerSpec) ([][]string, error) {
+	return lsiSettingsCmds(c, spec, "HBA", "J"), nil
+}
+
+func (s *PercJsonCli) ApplySettings(c *Controller, spec *ControllerSpec) error {
+	cmds, err := s.SettingsCmds(c, spec)
+	if err != nil {
+		return err
+	}
+	return s.runCmds(cmds)
+}
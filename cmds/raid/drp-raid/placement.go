@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// AutoController is the Controller index that lets VolSpecs.Compile pick
+// the controller a VolSpec goes on.
+const AutoController = -1
+
+// PCIAddress returns the bus, device, and function of the controller in
+// the usual bb:dd.f form.
+func (c *Controller) PCIAddress() string {
+	return fmt.Sprintf("%02x:%02x.%x", c.PCI.Bus, c.PCI.Device, c.PCI.Function)
+}
+
+// Model returns the model of the controller, or "" if the driver does
+// not report one.
+func (c *Controller) Model() string {
+	for _, k := range []string{"Product Name", "Model"} {
+		if v := strings.TrimSpace(c.Info[k]); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// autoPlaced is true if Compile picks the controller for the VolSpec.
+func (v *VolSpec) autoPlaced() bool {
+	return v.Controller == AutoController || v.ControllerSelector != ""
+}
+
+// controllerMatcher compiles ControllerSelector.
+func (v *VolSpec) controllerMatcher() (*regexp.Regexp, error) {
+	if v.ControllerSelector == "" {
+		return nil, nil
+	}
+	res, err := regexp.Compile(v.ControllerSelector)
+	if err != nil {
+		return nil, fmt.Errorf("ControllerSelector `%s` is not a valid regular expression: %v", v.ControllerSelector, err)
+	}
+	return res, nil
+}
+
+// placeable returns why the VolSpec cannot go on c, or "" if it could if
+// there are disks for it.
+func (v *VolSpec) placeable(c *Controller, sel *regexp.Regexp) string {
+	if sel != nil && !sel.MatchString(c.Driver) && !sel.MatchString(c.Model()) && !sel.MatchString(c.PCIAddress()) {
+		return fmt.Sprintf("does not match %s", v.ControllerSelector)
+	}
+	lvl := v.RaidLevel
+	switch lvl {
+	case "jbod":
+		// Turned into raid0 volumes if the controller cannot do JBOD.
+		if c.JBODCapable {
+			return ""
+		}
+		lvl = "raid0"
+	case "raidS":
+		lvl = "raid0"
+	}
+	if len(c.RaidLevels) == 0 {
+		return ""
+	}
+	for _, l := range c.RaidLevels {
+		if l == lvl {
+			return ""
+		}
+	}
+	return fmt.Sprintf("does not support %s", lvl)
+}
+
+// place picks the controller for an auto placed VolSpec, compiles it
+// against that controller's disks, and returns the controller index.
+// Controllers are tried in PCI order starting with start, so that specs
+// spread out across the controllers instead of all piling on to the
+// first one that has room.
+func (v *VolSpec) place(s *session, c Controllers, pools []VolSpecDisks, start int) (int, error) {
+	sel, err := v.controllerMatcher()
+	if err != nil {
+		return 0, err
+	}
+	reasons := []string{}
+	for n := range c {
+		i := (start + n) % len(c)
+		if why := v.placeable(c[i], sel); why != "" {
+			reasons = append(reasons, fmt.Sprintf("%s %s", c[i].Name(), why))
+			continue
+		}
+		try := *v
+		try.Controller = i
+		s.log.Printf("Trying %s for %s", c[i].Name(), v.RaidLevel)
+		pool, err := try.compileOn(s, pools[i])
+		if err != nil {
+			reasons = append(reasons, fmt.Sprintf("%s: %v", c[i].Name(), err))
+			continue
+		}
+		*v = try
+		pools[i] = pool
+		s.log.Printf("Placed %s on %s", v.RaidLevel, c[i].Name())
+		return i, nil
+	}
+	return 0, fmt.Errorf("No controller can hold it: %s", strings.Join(reasons, "; "))
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordReplay(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "calls.json")
+	rec := newRecorder(dest)
+	out, _, err := rec.Run(true, "echo", "hello")
+	if err != nil || string(out) != "hello\n" {
+		t.Fatalf("Unexpected echo output %q: %v", out, err)
+	}
+	if _, err := rec.Stat(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatalf("Expected stat of a missing file to fail")
+	}
+	r, err := newReplayer(dest)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if out, _, err := r.Run(true, "echo", "hello"); err != nil || string(out) != "hello\n" {
+		t.Errorf("Unexpected replayed echo output %q: %v", out, err)
+	}
+	if _, _, err := r.Run(true, "echo", "hello"); err == nil {
+		t.Errorf("Expected a second echo to not be replayed")
+	}
+	if len(r.Missed()) != 1 || len(r.Unused()) != 1 || r.Unused()[0].Op != "stat" {
+		t.Errorf("Expected 1 missed and 1 unused call, got %v and %v", r.Missed(), r.Unused())
+	}
+
+	sec := &Secrets{KeyID: "k1", Password: "Hunter2Hunter2!"}
+	dest = filepath.Join(t.TempDir(), "secret.json")
+	rec = newRecorder(dest)
+	rec.secrets = sec
+	if _, _, err := rec.Run(true, "echo", "key="+sec.Password); err != nil {
+		t.Fatalf("%v", err)
+	}
+	buf, err := ioutil.ReadFile(dest)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	calls := []*Call{}
+	if err := json.Unmarshal(buf, &calls); err != nil || len(calls) != 1 {
+		t.Fatalf("Unexpected recording %s: %v", buf, err)
+	}
+	if strings.Contains(string(buf), sec.Password) || calls[0].Args[0] != "key=<secret>" {
+		t.Errorf("Expected the password to be redacted, got %s", buf)
+	}
+	if fi, err := os.Stat(dest); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("Expected the recording to be mode 0600, got %v %v", fi.Mode(), err)
+	}
+	if r, err = newReplayer(dest); err != nil {
+		t.Fatalf("%v", err)
+	}
+	r.secrets = sec
+	if out, _, err := r.Run(true, "echo", "key="+sec.Password); err != nil || string(out) != "key=<secret>\n" {
+		t.Errorf("Unexpected replayed output %q: %v", out, err)
+	}
+}
+
+// replay answers every command the drivers run from the calls recorded
+// in test-data/replay/<name>.json until the test is done, and then
+// checks that all of them were used.
+func replay(t *testing.T, name string) {
+	t.Helper()
+	r, err := newReplayer(filepath.Join("test-data", "replay", name+".json"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	oldExecutor, oldFake := executor, fake
+	executor, fake = r, false
+	t.Cleanup(func() {
+		executor, fake = oldExecutor, oldFake
+		for _, c := range r.Missed() {
+			t.Errorf("Not recorded: %s", c)
+		}
+		for _, c := range r.Unused() {
+			t.Errorf("Not replayed: %s", c)
+		}
+	})
+}
+
+// replayController finds the one controller d manages in the recording.
+func replayController(t *testing.T, d Driver) *Controller {
+	t.Helper()
+	d.Logger(log.New(ioutil.Discard, "", 0))
+	if err := DriverInstalled(d); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !d.Useable() {
+		t.Fatalf("%s is not useable", d.Name())
+	}
+	controllers := d.Controllers()
+	if len(controllers) != 1 {
+		t.Fatalf("Expected 1 controller, got %d", len(controllers))
+	}
+	return controllers[0]
+}
+
+// replaySpec returns a compiled volspec for the disks in the passed-in
+// slots.
+func replaySpec(c *Controller, raidLevel string, slots ...uint64) *VolSpec {
+	spec := &VolSpec{
+		RaidLevel:  raidLevel,
+		StripeSize: "64 KB",
+		Disks:      VolSpecDisks{},
+		compiled:   true,
+	}
+	for _, d := range c.VolSpecDisks() {
+		for _, slot := range slots {
+			if d.Slot == slot {
+				spec.Disks = append(spec.Disks, d)
+			}
+		}
+	}
+	return spec
+}
+
+func TestReplayMegaCli(t *testing.T) {
+	replay(t, "megacli")
+	c := replayController(t, &MegaCli{"megacli", "/opt/MegaRAID/MegaCli/MegaCli64", 40, nil, true})
+	if c.ID != "0" || c.PCI.Bus != 8 || !c.RaidCapable || len(c.Disks) != 4 || len(c.Volumes) != 0 {
+		t.Fatalf("Unexpected controller %s bus %d raid %v disks %d volumes %d",
+			c.ID, c.PCI.Bus, c.RaidCapable, len(c.Disks), len(c.Volumes))
+	}
+	if d := c.Disks[0]; d.Protocol != "sata" || d.MediaType != "disk" || d.Size == 0 {
+		t.Errorf("Unexpected disk %s %s %d", d.Protocol, d.MediaType, d.Size)
+	}
+	if h, err := c.Health(c.Disks[0]); err != nil || h.Temperature == 0 || h.MediaErrors != 0 || h.SmartAlert {
+		t.Errorf("Unexpected disk health %+v: %v", h, err)
+	}
+	if err := c.Create(replaySpec(c, "raid1", 0, 1), false); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	c.driver.Refresh(c)
+	if len(c.Volumes) != 1 {
+		t.Fatalf("Expected 1 volume, got %d", len(c.Volumes))
+	}
+	if v := c.Volumes[0]; v.RaidLevel != "raid1" || len(v.Disks) != 2 || !v.Bootable || v.WritePolicy != "writeback" {
+		t.Errorf("Unexpected volume %s disks %d boot %v write %s", v.RaidLevel, len(v.Disks), v.Bootable, v.WritePolicy)
+	}
+	if err := c.Clear(); err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+	if len(c.Volumes) != 0 {
+		t.Errorf("Expected no volumes after clear, got %d", len(c.Volumes))
+	}
+}
+
+func TestReplayPercCli(t *testing.T) {
+	replay(t, "perccli")
+	c := replayController(t, &PercCli{"perccli", "/opt/MegaRAID/perccli/perccli64", 50, nil, true})
+	if c.ID != "0" || c.PCI.Bus != 2 || len(c.Disks) != 3 {
+		t.Fatalf("Unexpected controller %s bus %d disks %d", c.ID, c.PCI.Bus, len(c.Disks))
+	}
+	if d := c.Disks[0]; d.Enclosure != "32" || d.Slot != 0 || !d.JBOD || d.Size == 0 || d.SectorCount != 1172123568 {
+		t.Errorf("Unexpected disk %s:%d jbod %v size %d sectors %d", d.Enclosure, d.Slot, d.JBOD, d.Size, d.SectorCount)
+	}
+	if c.Disks[1].JBOD || c.Disks[1].Status != "UGood" {
+		t.Errorf("Unexpected disk 1 jbod %v status %s", c.Disks[1].JBOD, c.Disks[1].Status)
+	}
+	if v := c.Volume("32:0"); c.BootUnknown || v == nil || !v.Bootable {
+		t.Errorf("Expected the jbod for 32:0 to be the boot volume, got %+v unknown %v", v, c.BootUnknown)
+	}
+	if err := c.Create(replaySpec(c, "raid0", 1, 2), false); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	c.driver.Refresh(c)
+	if len(c.Disks) != 3 || c.Disks[1].Status != "Onln" || c.Disks[2].Status != "Onln" {
+		t.Errorf("Disks not online after create")
+	}
+	if err := c.Locate(c.Disks[1], true); err != nil {
+		t.Errorf("Locate: %v", err)
+	}
+	// Only the faked up jbod volumes are present, so there is nothing to clear.
+	if err := c.Clear(); err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+}
+
+func TestReplayPercJsonCli(t *testing.T) {
+	replay(t, "perccli-json")
+	c := replayController(t, &PercJsonCli{"perccli-json", "/opt/MegaRAID/perccli/perccli64", 70, nil, true})
+	if c.ID != "0" || c.PCI.Bus != 24 || !c.RaidCapable || c.JBODCapable || len(c.Disks) != 4 || len(c.Volumes) != 1 {
+		t.Fatalf("Unexpected controller %s bus %d raid %v jbod %v disks %d volumes %d",
+			c.ID, c.PCI.Bus, c.RaidCapable, c.JBODCapable, len(c.Disks), len(c.Volumes))
+	}
+	if d := c.Disks[2]; d.Enclosure != "32" || d.Slot != 2 || d.Protocol != "sas" || d.MediaType != "disk" ||
+		d.SectorCount != 0x45cc0000 || d.LogicalSectorSize != 512 {
+		t.Errorf("Unexpected disk %s:%d %s %s sectors %d block %d",
+			d.Enclosure, d.Slot, d.Protocol, d.MediaType, d.SectorCount, d.LogicalSectorSize)
+	}
+	if v := c.Volumes[0]; v.ID != "0" || v.Name != "os" || v.RaidLevel != "raid1" || !v.Bootable ||
+		len(v.Disks) != 2 || v.StripeSize != 64<<10 || v.WritePolicy != "writeback" || v.DiskCache != "default" {
+		t.Errorf("Unexpected volume %s %s %s boot %v disks %d stripe %d write %s disk cache %s",
+			v.ID, v.Name, v.RaidLevel, v.Bootable, len(v.Disks), v.StripeSize, v.WritePolicy, v.DiskCache)
+	}
+	spec := replaySpec(c, "raid1", 2, 3)
+	spec.Name, spec.WritePolicy = "data", "writethrough"
+	if err := c.Create(spec, false); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	c.driver.Refresh(c)
+	if len(c.Disks) != 4 || len(c.Volumes) != 2 {
+		t.Fatalf("Expected 4 disks and 2 volumes after create, got %d and %d", len(c.Disks), len(c.Volumes))
+	}
+	if v := c.Volumes[1]; v.ID != "1" || v.Name != "data" || v.WritePolicy != "writethrough" || len(v.Disks) != 2 {
+		t.Errorf("Unexpected new volume %s %s write %s disks %d", v.ID, v.Name, v.WritePolicy, len(v.Disks))
+	}
+	if err := c.Clear(); err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+}
+
+func TestReplayStorCliJson(t *testing.T) {
+	replay(t, "storcli-json")
+	c := replayController(t, &StorCliJson{PercJsonCli{"storcli7-json", "/opt/MegaRAID/storcli7/storcli", 15, nil, true}})
+	if c.Driver != "storcli7-json" || !c.RaidCapable || !c.JBODCapable || len(c.Disks) != 7 || len(c.Volumes) != 2 {
+		t.Fatalf("Unexpected controller %s raid %v jbod %v disks %d volumes %d",
+			c.Driver, c.RaidCapable, c.JBODCapable, len(c.Disks), len(c.Volumes))
+	}
+	if _, ok := c.driver.(*StorCliJson); !ok {
+		t.Errorf("Expected the controller to use the storcli json driver, got %T", c.driver)
+	}
+	if v := c.Volumes[0]; v.RaidLevel != "jbod" || v.Fake || v.ID != "32:2" {
+		t.Errorf("Unexpected jbod volume %s %s fake %v", v.ID, v.RaidLevel, v.Fake)
+	}
+	if v := c.Volumes[1]; v.ID != "0" || v.RaidLevel != "raid1" || !v.Bootable || len(v.Disks) != 2 {
+		t.Errorf("Unexpected volume %s %s boot %v disks %d", v.ID, v.RaidLevel, v.Bootable, len(v.Disks))
+	}
+	for _, tc := range []struct {
+		raidLevel string
+		slots     []uint64
+		want      []string
+	}{
+		{"jbod", []uint64{2, 3}, []string{"/c0/e32/s3 set jbod J"}},
+		{"raid1", []uint64{2, 3}, []string{"/c0/e32/s2 set good force J", "/c0 add vd r1 drives=32:2,32:3 strip=64 force J"}},
+	} {
+		cmds, err := c.driver.CreateCmds(c, replaySpec(c, tc.raidLevel, tc.slots...), false)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.raidLevel, err)
+		}
+		got := []string{}
+		for _, cmd := range cmds {
+			got = append(got, strings.Join(cmd, " "))
+		}
+		if strings.Join(got, "|") != strings.Join(tc.want, "|") {
+			t.Errorf("%s: expected %v, got %v", tc.raidLevel, tc.want, got)
+		}
+	}
+	spec := replaySpec(c, "raid10", 3, 4, 5, 6)
+	spec.Name = "data"
+	if err := c.Create(spec, false); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := c.Encrypt("drp", "secret"); err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if err := c.Clear(); err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+	if len(c.Disks) != 7 || len(c.Volumes) != 0 {
+		t.Errorf("Expected 7 disks and no volumes after clear, got %d and %d", len(c.Disks), len(c.Volumes))
+	}
+}
+
+func TestReplaySsaCli(t *testing.T) {
+	replay(t, "ssacli")
+	c := replayController(t, &SsaCli{"ssacli", "/opt/smartstorageadmin/ssacli/bin/ssacli", 10, nil, true})
+	if c.ID != "0" || c.PCI.Bus != 3 || !c.RaidCapable || c.JBODCapable || len(c.Disks) != 4 || len(c.Volumes) != 1 {
+		t.Fatalf("Unexpected controller %s bus %d raid %v jbod %v disks %d volumes %d",
+			c.ID, c.PCI.Bus, c.RaidCapable, c.JBODCapable, len(c.Disks), len(c.Volumes))
+	}
+	if d := c.Disks[2]; d.Enclosure != "1I:1" || d.Slot != 3 || d.Protocol != "sas" || d.MediaType != "disk" || d.Size == 0 {
+		t.Errorf("Unexpected disk %s:%d %s %s %d", d.Enclosure, d.Slot, d.Protocol, d.MediaType, d.Size)
+	}
+	if h, err := c.Health(c.Disks[2]); err != nil || h.Temperature != 31 || h.PredictiveFailures != 0 {
+		t.Errorf("Unexpected disk health %+v: %v", h, err)
+	}
+	if v := c.Volumes[0]; v.ID != "1" || v.Name != "01A2B3C4" || v.RaidLevel != "raid1" || !v.Bootable ||
+		len(v.Disks) != 2 || v.StripeSize != 256<<10 || v.WritePolicy != "writeback" || v.Info["Array"] != "A" {
+		t.Errorf("Unexpected volume %s %s %s boot %v disks %d stripe %d write %s array %s",
+			v.ID, v.Name, v.RaidLevel, v.Bootable, len(v.Disks), v.StripeSize, v.WritePolicy, v.Info["Array"])
+	}
+	if err := c.Create(replaySpec(c, "raid1", 3, 4), false); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	c.driver.Refresh(c)
+	if len(c.Disks) != 4 || len(c.Volumes) != 2 {
+		t.Fatalf("Expected 4 disks and 2 volumes after create, got %d and %d", len(c.Disks), len(c.Volumes))
+	}
+	if v := c.Volumes[1]; v.ID != "2" || v.Bootable || v.Info["Array"] != "B" {
+		t.Errorf("Unexpected new volume %s boot %v array %s", v.ID, v.Bootable, v.Info["Array"])
+	}
+	if err := c.Locate(c.Disks[2], false); err != nil {
+		t.Errorf("Unlocate: %v", err)
+	}
+	if err := c.Clear(); err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+}
+
+func TestReplayMVCli(t *testing.T) {
+	replay(t, "mvcli")
+	c := replayController(t, &MVCli{"mvcli", "/usr/local/bin/mvcli", 60, nil, true})
+	if c.ID != "0" || !c.RaidCapable || len(c.Disks) != 2 || len(c.Volumes) != 1 {
+		t.Fatalf("Unexpected controller %s raid %v disks %d volumes %d", c.ID, c.RaidCapable, len(c.Disks), len(c.Volumes))
+	}
+	if d := c.Disks[1]; d.Slot != 1 || d.Protocol != "sata" || d.MediaType != "ssd" || d.Size == 0 {
+		t.Errorf("Unexpected disk %d %s %s %d", d.Slot, d.Protocol, d.MediaType, d.Size)
+	}
+	if v := c.Volumes[0]; v.ID != "0" || v.RaidLevel != "raid1" || v.StripeSize != 64<<10 {
+		t.Errorf("Unexpected volume %s %s stripe %d", v.ID, v.RaidLevel, v.StripeSize)
+	}
+	if err := c.Clear(); err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+	c.driver.Refresh(c)
+	for _, v := range c.Volumes {
+		if !v.Fake {
+			t.Errorf("Volume %s still present after clear", v.ID)
+		}
+	}
+	spec := replaySpec(c, "raid1", 0, 1)
+	spec.Name = "BOSS"
+	if err := c.Create(spec, false); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+}
+
+func TestReplayMNVCli(t *testing.T) {
+	replay(t, "mnvcli")
+	c := replayController(t, &MNVCli{"mnvcli", "/usr/local/bin/mnv_cli", 65, nil, true})
+	if c.ID != "6" || !c.RaidCapable || len(c.Disks) != 2 || len(c.Volumes) != 1 {
+		t.Fatalf("Unexpected controller %s raid %v disks %d volumes %d", c.ID, c.RaidCapable, len(c.Disks), len(c.Volumes))
+	}
+	if d := c.Disks[0]; d.Slot != 0 || d.Protocol != "nvme" || d.MediaType != "ssd" || d.Size == 0 {
+		t.Errorf("Unexpected disk %d %s %s %d", d.Slot, d.Protocol, d.MediaType, d.Size)
+	}
+	if v := c.Volumes[0]; v.ID != "0" || v.RaidLevel != "raid1" || v.StripeSize != 128<<10 || len(v.Disks) != 2 {
+		t.Errorf("Unexpected volume %s %s stripe %d disks %d", v.ID, v.RaidLevel, v.StripeSize, len(v.Disks))
+	}
+	if err := c.Clear(); err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+	c.driver.Refresh(c)
+	for _, v := range c.Volumes {
+		if !v.Fake {
+			t.Errorf("Volume %s still present after clear", v.ID)
+		}
+	}
+	// The controller only supports 128K stripes and up, so 64K is rounded up.
+	if err := c.Create(replaySpec(c, "raid1", 0, 1), false); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+}
+
+func TestReplayMdAdm(t *testing.T) {
+	replay(t, "mdadm")
+	c := replayController(t, &MdAdm{"mdadm", "/sbin/mdadm", 80, nil, true})
+	if len(c.Disks) != 3 || len(c.Volumes) != 2 {
+		t.Fatalf("Expected 3 disks and 2 volumes, got %d and %d", len(c.Disks), len(c.Volumes))
+	}
+	if v := c.Volumes[0]; v.ID != "/dev/md/data" || v.RaidLevel != "raid1" || len(v.Disks) != 2 {
+		t.Errorf("Unexpected volume %s %s disks %d", v.ID, v.RaidLevel, len(v.Disks))
+	}
+	if err := c.Clear(); err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+	if len(c.Volumes) != 3 {
+		t.Fatalf("Expected 3 jbod volumes after clear, got %d", len(c.Volumes))
+	}
+	if err := c.Create(replaySpec(c, "raid1", 1, 2), true); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	c.driver.Refresh(c)
+	if len(c.Volumes) != 2 || c.Volumes[0].ID != "/dev/md/vol0" {
+		t.Errorf("Expected /dev/md/vol0 after create")
+	}
+}
+
+func TestReplayNvmeCli(t *testing.T) {
+	replay(t, "nvme")
+	c := replayController(t, &NvmeCli{"nvme", "/usr/sbin/nvme", 75, nil, true})
+	if c.ID != "0" || c.PCI.Bus != 0x5e || !c.RaidCapable || len(c.Disks) != 1 || len(c.Volumes) != 1 {
+		t.Fatalf("Unexpected controller %s bus %d raid %v disks %d volumes %d",
+			c.ID, c.PCI.Bus, c.RaidCapable, len(c.Disks), len(c.Volumes))
+	}
+	if d := c.Disks[0]; d.Size != 4000787030016 || d.UsedSize != 1000<<30 || d.VolumeID != "1" {
+		t.Errorf("Unexpected disk size %d used %d volume %s", d.Size, d.UsedSize, d.VolumeID)
+	}
+	spec := replaySpec(c, "namespace", 0)
+	spec.Size = "1000 GB"
+	if err := c.Create(spec, false); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	c.driver.Refresh(c)
+	if len(c.Volumes) != 2 || c.Volumes[1].ID != "2" || c.Volumes[1].Size != 1000<<30 {
+		t.Fatalf("Expected a second 1000 GB namespace after create")
+	}
+	if err := c.Clear(); err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+	if len(c.Volumes) != 1 || c.Volumes[0].Size != c.Disks[0].Size {
+		t.Errorf("Expected a single namespace filling the drive after clear")
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Secrets is the key material used to encrypt volumes.  It is read as
+// JSON from a file descriptor or a file so that it never shows up in the
+// arguments of drp-raid.
+type Secrets struct {
+	// KeyID names the key the controller secures volumes with.
+	KeyID string
+	// Password is the current security key or passphrase.
+	Password string
+	// NewKeyID and NewPassword are what -rekey changes the key to.
+	NewKeyID    string `json:",omitempty"`
+	NewPassword string `json:",omitempty"`
+}
+
+// readSecrets reads the Secrets from fd if it is not -1, or from file if
+// it is not empty.  The -key and -password flags fill in anything they
+// do not set.  It returns nil if there are no secrets to read.
+func readSecrets(fd int, file, key, password string) (*Secrets, error) {
+	var r io.Reader
+	switch {
+	case fd >= 0 && file != "":
+		return nil, fmt.Errorf("Cannot use -secrets-fd and -secrets-file together")
+	case fd >= 0:
+		f := os.NewFile(uintptr(fd), fmt.Sprintf("fd %d", fd))
+		if f == nil {
+			return nil, fmt.Errorf("File descriptor %d is not open", fd)
+		}
+		defer f.Close()
+		r = f
+	case file != "":
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	case key == "" && password == "":
+		return nil, nil
+	}
+	res := &Secrets{}
+	if r != nil {
+		if err := json.NewDecoder(r).Decode(res); err != nil {
+			return nil, fmt.Errorf("Error reading secrets: %v", err)
+		}
+	}
+	if res.KeyID == "" {
+		res.KeyID = key
+	}
+	if res.Password == "" {
+		res.Password = password
+	}
+	return res, nil
+}
+
+// redact replaces the passwords in cmds so that plans can be shown
+// without giving them away.
+func (sec *Secrets) redact(cmds [][]string) [][]string {
+	if sec == nil {
+		return cmds
+	}
+	res := make([][]string, len(cmds))
+	for i, cmd := range cmds {
+		res[i] = make([]string, len(cmd))
+		for j, arg := range cmd {
+			res[i][j] = sec.redactString(arg)
+		}
+	}
+	return res
+}
+
+// redactString replaces the passwords in val with <secret>.
+func (sec *Secrets) redactString(val string) string {
+	if sec == nil {
+		return val
+	}
+	for _, secret := range []string{sec.Password, sec.NewPassword} {
+		if secret != "" {
+			val = strings.ReplaceAll(val, secret, "<secret>")
+		}
+	}
+	return val
+}
+
+// redactErr returns err with the passwords in it replaced with <secret>.
+func (sec *Secrets) redactErr(err error) error {
+	if sec == nil || err == nil {
+		return err
+	}
+	if msg := sec.redactString(err.Error()); msg != err.Error() {
+		return errors.New(msg)
+	}
+	return err
+}
+
+// redactWriter replaces the passwords in everything written to it.  The
+// drivers log the commands they run, and the commands that change or use
+// the controller keys have the passwords in them.
+type redactWriter struct {
+	w       io.Writer
+	secrets *Secrets
+}
+
+func (r *redactWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.w, r.secrets.redactString(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Secrets sets the key material for the session, and keeps the passwords
+// in it out of everything the session and its drivers log.
+func (s *session) Secrets(sec *Secrets) *session {
+	s.secrets = sec
+	if sec != nil {
+		s.log.SetOutput(&redactWriter{w: s.log.Writer(), secrets: sec})
+	}
+	return s
+}
+
+// hasSecuredVolumes is true if any volume on the controller is encrypted.
+func (c *Controller) hasSecuredVolumes() bool {
+	for _, v := range c.Volumes {
+		if v.Secured {
+			return true
+		}
+	}
+	return false
+}
+
+// KeyRecord says which volumes on a controller are secured with which
+// key, so that the key can be escrowed along with what it unlocks.
+type KeyRecord struct {
+	Controller string
+	KeyID      string `json:",omitempty"`
+	Volumes    []string
+}
+
+// KeyRecords returns a KeyRecord for each controller with secured volumes.
+// The key ID is the one in the session secrets, or the new one for the
+// controllers Rekey changed, since the controllers do not report it.
+func (s *session) KeyRecords() []*KeyRecord {
+	res := []*KeyRecord{}
+	for _, c := range s.controllers {
+		rec := &KeyRecord{Controller: c.Name(), Volumes: []string{}}
+		if id, ok := s.keyIDs[c.Name()]; ok {
+			rec.KeyID = id
+		} else if s.secrets != nil {
+			rec.KeyID = s.secrets.KeyID
+		}
+		for _, v := range c.Volumes {
+			if v.Secured {
+				rec.Volumes = append(rec.Volumes, v.ID)
+			}
+		}
+		if len(rec.Volumes) == 0 {
+			continue
+		}
+		sort.Strings(rec.Volumes)
+		res = append(res, rec)
+	}
+	return res
+}
+
+// needSecrets checks that the session has the passwords an operation
+// needs.
+func (s *session) needSecrets(op string, rekey bool) bool {
+	switch {
+	case s.secrets == nil || s.secrets.Password == "":
+		s.Errorf("%s needs a password from -secrets-fd or -secrets-file", op)
+	case rekey && s.secrets.NewPassword == "":
+		s.Errorf("%s needs a NewPassword from -secrets-fd or -secrets-file", op)
+	default:
+		return true
+	}
+	return false
+}
+
+// keyControllers returns the controllers that need a key set before
+// the wanted volumes can be encrypted.  A controller that already has
+// secured volumes is assumed to have the key.
+func (s *session) keyControllers(cmp map[string]VolSpecs) Controllers {
+	res := Controllers{}
+	seen := map[int]bool{}
+	for _, spec := range append(cmp[`add`], cmp[`secure`]...) {
+		c := s.controllers[spec.Controller]
+		if !spec.Encrypt || seen[spec.Controller] || c.hasSecuredVolumes() {
+			continue
+		}
+		seen[spec.Controller] = true
+		res = append(res, c)
+	}
+	return res
+}
+
+// setKeys sets the session key on every controller that needs one to
+// encrypt the wanted volumes.  Without secrets, the controllers are
+// assumed to have been set up with -encrypt already.
+func (s *session) setKeys(cmp map[string]VolSpecs) {
+	if s.secrets == nil {
+		return
+	}
+	for _, c := range s.keyControllers(cmp) {
+		if err := c.Encrypt(s.secrets.KeyID, s.secrets.Password); err != nil {
+			s.Errorf("Error setting the security key on %s: %v", c.Name(), err)
+			continue
+		}
+		s.log.Printf("Set security key %s on %s", s.secrets.KeyID, c.Name())
+	}
+}
+
+// unsecured returns the wanted encrypted volumes that exist but are not
+// secured, such as ones created by controllers that cannot secure volumes
+// as they create them.
+func (s *session) unsecured() VolSpecs {
+	res := VolSpecs{}
+	current := s.CurrentSpecs(true).ByKey()
+	for _, spec := range s.compiledSpecs {
+		cur, ok := current[spec.Key()]
+		if !spec.Encrypt || !ok || cur.Encrypt {
+			continue
+		}
+		v := *spec
+		v.VolumeID = cur.VolumeID
+		res = append(res, &v)
+	}
+	return res
+}
+
+// secureVolumes encrypts the existing volumes in specs.
+func (s *session) secureVolumes(specs VolSpecs) {
+	for _, spec := range specs {
+		c := s.controllers[spec.Controller]
+		vol := c.Volume(spec.VolumeID)
+		if vol == nil {
+			s.Errorf("Volume %s not found on %s", spec.VolumeID, c.Name())
+			continue
+		}
+		if err := c.Secure(vol); err != nil {
+			s.Errorf("Error securing %s %s on %s: %v", vol.RaidLevel, vol.ID, c.Name(), err)
+			continue
+		}
+		s.log.Printf("Secured %s %s on %s", vol.RaidLevel, vol.ID, c.Name())
+	}
+}
+
+// Rekey changes the security key on every controller with secured
+// volumes from Password to NewPassword.  Only the controllers that were
+// changed get NewKeyID in their key records.
+func (s *session) Rekey() {
+	if !s.needSecrets("-rekey", true) {
+		return
+	}
+	for _, c := range s.controllers {
+		if !c.hasSecuredVolumes() {
+			continue
+		}
+		if err := c.Rekey(s.secrets); err != nil {
+			s.Errorf("Error changing the security key on %s: %v", c.Name(), err)
+			continue
+		}
+		s.log.Printf("Changed the security key on %s", c.Name())
+		if s.secrets.NewKeyID != "" {
+			if s.keyIDs == nil {
+				s.keyIDs = map[string]string{}
+			}
+			s.keyIDs[c.Name()] = s.secrets.NewKeyID
+		}
+	}
+}
+
+// Unlock unlocks the secured disks moved from another controller with
+// Password, and imports the configuration on them.
+func (s *session) Unlock() {
+	if !s.needSecrets("-unlock", false) {
+		return
+	}
+	for _, c := range s.controllers {
+		if !c.hasForeignDisks() {
+			continue
+		}
+		if err := c.Unlock(s.secrets.Password); err != nil {
+			s.Errorf("Error unlocking %s: %v", c.Name(), err)
+			continue
+		}
+		s.log.Printf("Unlocked %s", c.Name())
+	}
+	if !fake {
+		s.Controllers("")
+	}
+}
+
+// PlanRekey returns the commands Rekey would run.
+func (s *session) PlanRekey() *Plan {
+	p := &Plan{Steps: []PlanStep{}}
+	if !s.needSecrets("-rekey", true) {
+		return p
+	}
+	for _, c := range s.controllers {
+		if !c.hasSecuredVolumes() {
+			continue
+		}
+		cmds, err := c.RekeyCmds(s.secrets)
+		s.planStep(p, c, "rekey", cmds, err)
+	}
+	return p
+}
+
+// PlanUnlock returns the commands Unlock would run.
+func (s *session) PlanUnlock() *Plan {
+	p := &Plan{Steps: []PlanStep{}}
+	if !s.needSecrets("-unlock", false) {
+		return p
+	}
+	for _, c := range s.controllers {
+		if !c.hasForeignDisks() {
+			continue
+		}
+		cmds, err := c.UnlockCmds(s.secrets.Password)
+		s.planStep(p, c, "unlock", cmds, err)
+	}
+	return p
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadSecrets(t *testing.T) {
+	if sec, err := readSecrets(-1, "", "", ""); sec != nil || err != nil {
+		t.Errorf("Expected no secrets, got %+v: %v", sec, err)
+	}
+	if sec, err := readSecrets(-1, "", "key", "pass"); err != nil || sec.KeyID != "key" || sec.Password != "pass" {
+		t.Errorf("Expected the flag secrets, got %+v: %v", sec, err)
+	}
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "secrets.json")
+	ioutil.WriteFile(file, []byte(`{"KeyID": "key", "Password": "old", "NewPassword": "new"}`), 0600)
+	if sec, err := readSecrets(-1, file, "", ""); err != nil || sec.KeyID != "key" || sec.Password != "old" || sec.NewPassword != "new" {
+		t.Errorf("Expected the file secrets, got %+v: %v", sec, err)
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte(`{"Password": "piped"}`))
+	w.Close()
+	if sec, err := readSecrets(int(r.Fd()), "", "key", ""); err != nil || sec.KeyID != "key" || sec.Password != "piped" {
+		t.Errorf("Expected the piped secrets, got %+v: %v", sec, err)
+	}
+	if _, err := readSecrets(3, file, "", ""); err == nil {
+		t.Errorf("Expected an error using -secrets-fd and -secrets-file together")
+	}
+}
+
+func TestSecureVolumes(t *testing.T) {
+	oldFake := fake
+	fake = false
+	defer func() { fake = oldFake }()
+	newSess := func(driver string, secured bool, specs VolSpecs) *session {
+		c := ctrlrs(1, driver)[0]
+		c.addDisks(4, 1<<40, "sas", "disk")
+		c.Volumes = append(c.Volumes, &Volume{
+			ControllerID:     c.ID,
+			ControllerDriver: c.Driver,
+			ID:               "0",
+			RaidLevel:        "raid1",
+			Size:             1 << 40,
+			StripeSize:       64 << 10,
+			Disks:            c.Disks[:2],
+			Secured:          secured,
+			Info:             map[string]string{},
+			controller:       c,
+			driver:           c.driver,
+		})
+		for _, d := range c.Disks[:2] {
+			d.VolumeID = "0"
+		}
+		return &session{
+			log:         log.New(ioutil.Discard, "", 0),
+			controllers: Controllers{c},
+			inSpecs:     specs,
+			secrets:     &Secrets{KeyID: "key", Password: "s3cret-old", NewPassword: "s3cret-new"},
+		}
+	}
+	osSpec := func() *VolSpec {
+		return &VolSpec{RaidLevel: "raid1", StripeSize: "64 KB", Disks: VolSpecDisks{{Slot: 0}, {Slot: 1}}, Encrypt: true}
+	}
+
+	for _, v := range []string{"None", "Disabled", ""} {
+		if securedState(v) {
+			t.Errorf("Expected %q to be unsecured", v)
+		}
+	}
+	if !securedState("Encrypted") {
+		t.Errorf("Expected Encrypted to be secured")
+	}
+
+	for _, tc := range []struct {
+		driver string
+		want   []string
+	}{
+		{"megacli", []string{
+			"set key key", "-DeleteSecurityKey -Force -a0",
+			"secure 0", "-LDMakeSecure -L0 -a0",
+		}},
+		{"storcli7-json", []string{
+			"set key key", "/c0 delete securitykey J",
+			"secure 0", "/c0/v0 set security=on J",
+		}},
+		{"ssacli", []string{
+			"set key key", "controller slot=0 clearencryptionconfig forced",
+			"secure 0", "controller slot=0 ld 0 encode preservedata=yes",
+		}},
+	} {
+		s := newSess(tc.driver, false, VolSpecs{osSpec()})
+		p := s.PlanConfigure(false, false, false)
+		if s.HasError() {
+			t.Errorf("%s: unexpected error planning", tc.driver)
+			continue
+		}
+		if len(p.Diff["secure"]) != 1 || len(p.Steps)*2 != len(tc.want) {
+			t.Errorf("%s: expected %d steps, got %+v", tc.driver, len(tc.want)/2, p.Steps)
+			continue
+		}
+		for i, step := range p.Steps {
+			if step.Action != tc.want[i*2] || strings.Join(step.Commands[0][1:], " ") != tc.want[i*2+1] {
+				t.Errorf("%s: step %d: expected %s `%s`, got %s %v", tc.driver, i, tc.want[i*2], tc.want[i*2+1], step.Action, step.Commands)
+			}
+			for _, cmd := range step.Commands {
+				if strings.Contains(strings.Join(cmd, " "), "s3cret") {
+					t.Errorf("%s: step %d: the password was not redacted from %v", tc.driver, i, cmd)
+				}
+			}
+		}
+	}
+
+	s := newSess("megacli", true, VolSpecs{osSpec()})
+	if p := s.PlanConfigure(false, false, false); s.HasError() || len(p.Steps) != 0 {
+		t.Errorf("Expected nothing to do for a secured volume, got %+v", p.Steps)
+	}
+	if recs := s.KeyRecords(); len(recs) != 1 || recs[0].KeyID != "key" || strings.Join(recs[0].Volumes, ",") != "0" {
+		t.Errorf("Unexpected key records %+v", recs)
+	}
+
+	for driver, want := range map[string]string{
+		"megacli":       "-ChangeSecurityKey -OldSecurityKey <secret> -SecurityKey <secret> -a0",
+		"storcli7":      "/c0 set securitykey=<secret> oldsecuritykey=<secret>",
+		"storcli7-json": "/c0 set securitykey=<secret> oldsecuritykey=<secret> J",
+	} {
+		s := newSess(driver, true, nil)
+		if p := s.PlanRekey(); s.HasError() || len(p.Steps) != 1 || strings.Join(p.Steps[0].Commands[0][1:], " ") != want {
+			t.Errorf("%s: expected `%s`, got %+v", driver, want, p.Steps)
+		}
+	}
+	s = newSess("ssacli", true, nil)
+	if s.PlanRekey(); !s.HasError() {
+		t.Errorf("Expected ssacli to be unable to rekey")
+	}
+	fake = true
+	s = newSess("megacli", true, nil)
+	s.controllers = append(s.controllers, newSess("ssacli", true, nil).controllers...)
+	s.secrets.NewKeyID = "key2"
+	s.Rekey()
+	fake = false
+	recs := s.KeyRecords()
+	if !s.HasError() || len(recs) != 2 || recs[0].KeyID != "key2" || recs[1].KeyID != "key" {
+		t.Errorf("Expected only the rekeyed controller to get the new key ID, got %+v", recs)
+	}
+	s = newSess("megacli", true, nil)
+	s.secrets.NewPassword = ""
+	if s.PlanRekey(); !s.HasError() {
+		t.Errorf("Expected -rekey to need a new password")
+	}
+
+	s = newSess("storcli7-json", false, nil)
+	s.controllers[0].Disks[3].Foreign = true
+	if p := s.PlanUnlock(); s.HasError() || len(p.Steps) != 1 ||
+		strings.Join(p.Steps[0].Commands[0][1:], " ") != "/c0/fall import securitykey=<secret> J" {
+		t.Errorf("Unexpected unlock plan %+v", p.Steps)
+	}
+}
+
+// failingExecutor fails every command, and echoes it back the way the
+// controller tools do when they reject one.
+type failingExecutor struct{}
+
+func (failingExecutor) Stat(name string) (os.FileMode, error) {
+	return 0, os.ErrNotExist
+}
+
+func (failingExecutor) Run(combined bool, executable string, args ...string) ([]byte, []byte, error) {
+	out := []byte("Invalid command: " + strings.Join(args, " "))
+	return out, out, errors.New("exit status 1")
+}
+
+func (failingExecutor) ReadFile(name string) ([]byte, error) {
+	return nil, os.ErrNotExist
+}
+
+func (failingExecutor) ReadDir(name string) ([]string, error) {
+	return nil, os.ErrNotExist
+}
+
+func (failingExecutor) EvalSymlinks(name string) (string, error) {
+	return "", os.ErrNotExist
+}
+
+func TestSecretsNotLogged(t *testing.T) {
+	oldExecutor, oldFake := executor, fake
+	executor, fake = failingExecutor{}, false
+	defer func() { executor, fake = oldExecutor, oldFake }()
+	sec := &Secrets{KeyID: "key", Password: "s3cret-old", NewKeyID: "key2", NewPassword: "s3cret-new"}
+	for _, driver := range []string{"megacli", "storcli7", "storcli7-json"} {
+		c := ctrlrs(1, driver)[0]
+		c.addDisks(4, 1<<40, "sas", "disk")
+		c.Disks[3].Foreign = true
+		c.Volumes = append(c.Volumes, &Volume{
+			ControllerID:     c.ID,
+			ControllerDriver: c.Driver,
+			ID:               "0",
+			RaidLevel:        "raid1",
+			Disks:            c.Disks[:2],
+			Secured:          true,
+			Info:             map[string]string{},
+			controller:       c,
+			driver:           c.driver,
+		})
+		buf := &strings.Builder{}
+		s := (&session{log: log.New(buf, "", 0), controllers: Controllers{c}}).Secrets(sec)
+		c.driver.Logger(s.log)
+		s.Rekey()
+		s.ImportForeign("all")
+		s.Unlock()
+		if !s.HasError() || !strings.Contains(buf.String(), "<secret>") {
+			t.Errorf("%s: expected the failed commands to be logged, got %s", driver, buf.String())
+		}
+		if strings.Contains(buf.String(), "s3cret") {
+			t.Errorf("%s: a password was logged: %s", driver, buf.String())
+		}
+		for op, err := range map[string]error{
+			"rekey":   c.Rekey(sec),
+			"unlock":  c.Unlock(sec.Password),
+			"encrypt": c.Encrypt(sec.KeyID, sec.NewPassword),
+		} {
+			if err == nil {
+				t.Errorf("%s: expected %s to fail", driver, op)
+			} else if strings.Contains(err.Error(), "s3cret") {
+				t.Errorf("%s: %s gave away a password: %v", driver, op, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestPercCliHotSpares(t *testing.T) {
+	oldFake := fake
+	fake = false
+	defer func() { fake = oldFake }()
+	spec := func(global bool) *VolSpec {
+		return &VolSpec{
+			RaidLevel:       "raid1",
+			StripeSize:      "64 KB",
+			Disks:           VolSpecDisks{{Slot: 0}, {Slot: 1}},
+			HotSpareDisks:   VolSpecDisks{{Slot: 2}, {Slot: 3}},
+			GlobalHotSpares: global,
+		}
+	}
+	for _, tc := range []struct {
+		driver string
+		global bool
+		want   []string
+	}{
+		{"perccli", false, []string{"add /c0 vd name= Strip=64 r1 drives=:0|:1 spares=:2|:3 forced"}},
+		{"perccli", true, []string{
+			"add /c0 vd name= Strip=64 r1 drives=:0|:1 forced",
+			"/c0/s2 add hotsparedrive",
+			"/c0/s3 add hotsparedrive",
+		}},
+		{"perccli-json", false, []string{"/c0 add vd r1 drives=0,1 spares=2,3 strip=64 force J"}},
+		{"perccli-json", true, []string{
+			"/c0 add vd r1 drives=0,1 strip=64 force J",
+			"/c0/s2 add hotsparedrive J",
+			"/c0/s3 add hotsparedrive J",
+		}},
+	} {
+		c := ctrlrs(1, tc.driver)[0]
+		c.addDisks(4, 1<<40, "sas", "disk")
+		s := &session{
+			log:         log.New(ioutil.Discard, "", 0),
+			controllers: Controllers{c},
+			inSpecs:     VolSpecs{spec(tc.global)},
+		}
+		p := s.PlanConfigure(false, false, false)
+		if s.HasError() || len(p.Steps) != 1 {
+			t.Errorf("%s global=%v: expected one create step, got %+v", tc.driver, tc.global, p.Steps)
+			continue
+		}
+		got := []string{}
+		for _, cmd := range p.Steps[0].Commands {
+			got = append(got, strings.Join(cmd[1:], " "))
+		}
+		if strings.Join(got, "\n") != strings.Join(tc.want, "\n") {
+			t.Errorf("%s global=%v: expected\n%s\ngot\n%s", tc.driver, tc.global, strings.Join(tc.want, "\n"), strings.Join(got, "\n"))
+		}
+	}
+}
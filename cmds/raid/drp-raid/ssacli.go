@@ -1,11 +1,9 @@
 package main
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"log"
-	"os/exec"
 	"regexp"
 	"strconv"
 	"strings"
@@ -51,14 +49,12 @@ func (s *SsaCli) run(args ...string) ([]string, error) {
 	if fake {
 		return []string{}, nil
 	}
-	cmd := exec.Command(s.executable, args...)
-	outBuf := &bytes.Buffer{}
-	cmd.Stdout, cmd.Stderr = outBuf, outBuf
-	if err := cmd.Run(); err != nil {
+	out, _, err := executor.Run(true, s.executable, args...)
+	if err != nil {
 		return nil, err
 	}
-	out := strings.Split(outBuf.String(), "\n")
-	return out, s.checkLinesForError(out)
+	lines := strings.Split(string(out), "\n")
+	return lines, s.checkLinesForError(lines)
 }
 
 func (s *SsaCli) Useable() bool {
@@ -107,6 +103,8 @@ func (s *SsaCli) fillDisk(d *PhysicalDisk, lines []string) {
 			d.Slot, _ = strconv.ParseUint(v, 10, 64)
 		case "Drive exposed to OS":
 			d.JBOD = v == "True"
+		case "Drive Type":
+			d.HotSpare = strings.Contains(v, "Spare")
 		}
 	}
 	d.Enclosure = d.Info["Port"] + ":" + d.Info["Box"]
@@ -114,7 +112,7 @@ func (s *SsaCli) fillDisk(d *PhysicalDisk, lines []string) {
 
 func (s *SsaCli) fillVolume(vol *Volume, lines []string) {
 	vol.Info = map[string]string{}
-	for _, line := range lines[1:] {
+	for _, line := range lines {
 		k, v := kv(line, ": ")
 		if k == "" {
 			break
@@ -152,7 +150,20 @@ func (s *SsaCli) fillVolume(vol *Volume, lines []string) {
 			vol.Size, _ = sizeParser(v)
 		case "Strip Size":
 			vol.StripeSize, _ = sizeParser(v)
-
+		case "Caching":
+			switch v {
+			case "Enabled":
+				vol.WritePolicy = "writeback"
+			case "Disabled":
+				vol.WritePolicy = "writethrough"
+			}
+		case "Boot Volume":
+			switch strings.ToLower(v) {
+			case "primary", "secondary":
+				vol.Bootable = true
+			}
+		case "Encryption":
+			vol.Secured = securedState(v)
 		}
 	}
 }
@@ -178,15 +189,34 @@ func (s *SsaCli) fillArray(c *Controller, array []string) {
 	if len(lds) == 0 {
 		return
 	}
+	members, spares := []*PhysicalDisk{}, []*PhysicalDisk{}
+	for _, d := range disks {
+		if d.HotSpare {
+			spares = append(spares, d)
+		} else {
+			members = append(members, d)
+		}
+	}
+	arrayName := ""
+	if len(arrayInfo) > 0 {
+		if matches := ssaArrayRE.FindStringSubmatch(arrayInfo[0]); len(matches) == 3 {
+			arrayName = matches[2]
+		}
+	}
 	for _, ld := range lds {
 		vol := &Volume{
 			ControllerID:     c.ID,
 			ControllerDriver: c.Driver,
-			Disks:            disks,
+			Disks:            members,
+			HotSpares:        spares,
 			controller:       c,
 			driver:           s,
 		}
 		s.fillVolume(vol, append(ld, arrayInfo...))
+		vol.Info["Array"] = arrayName
+		for _, d := range spares {
+			d.SpareFor = vol.ID
+		}
 		c.Volumes = append(c.Volumes, vol)
 	}
 }
@@ -197,7 +227,9 @@ func (s *SsaCli) fillController(c *Controller, lines []string) {
 	controller, arrays := partitionAt(lines, ssaArrayRE)
 	c.JBODCapable = false
 	c.RaidCapable = true
-	c.Info = map[string]string{}
+	c.Info = map[string]string{
+		"Model": strings.TrimSpace(ssaCliCSlotRE.Split(controller[0], 2)[0]),
+	}
 	for _, line := range controller[1:] {
 		k, v := kv(line, ": ")
 		if k == "" {
@@ -260,17 +292,51 @@ func (s *SsaCli) canBeCleared(c *Controller) bool {
 	return false
 }
 
-func (s *SsaCli) Clear(c *Controller, onlyForeign bool) error {
+func (s *SsaCli) ClearCmds(c *Controller, onlyForeign bool) ([][]string, error) {
 	if onlyForeign {
 		// as far as I can tell, ssacli has no notion of a foreign config.
 		// So if we are asked to clear just the foreign config, do nothing.
-		return nil
+		return [][]string{}, nil
 	}
 	if !s.canBeCleared(c) {
-		return nil
+		return [][]string{}, nil
 	}
-	_, err := s.run("controller", "slot="+c.ID, "delete", "forced", "override")
-	return err
+	return [][]string{{"controller", "slot=" + c.ID, "delete", "forced", "override"}}, nil
+}
+
+func (s *SsaCli) Clear(c *Controller, onlyForeign bool) error {
+	cmds, _ := s.ClearCmds(c, onlyForeign)
+	for _, cmdLine := range cmds {
+		if _, err := s.run(cmdLine...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runCmds runs cmds in order, stopping at the first one that fails.
+func (s *SsaCli) runCmds(cmds [][]string) error {
+	for _, cmdLine := range cmds {
+		s.log.Printf("Running %s %s", s.executable, strings.Join(cmdLine, " "))
+		res, err := s.run(cmdLine...)
+		if len(res) > 0 {
+			s.log.Println(strings.Join(res, "\n"))
+		}
+		if err != nil {
+			s.log.Printf("Error running command: %s", strings.Join(cmdLine, " "))
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SsaCli) DeleteCmds(c *Controller, v *Volume) ([][]string, error) {
+	return [][]string{{"controller", "slot=" + c.ID, "ld", v.ID, "delete", "forced"}}, nil
+}
+
+func (s *SsaCli) Delete(c *Controller, v *Volume) error {
+	cmds, _ := s.DeleteCmds(c, v)
+	return s.runCmds(cmds)
 }
 
 func (s SsaCli) Refresh(c *Controller) {
@@ -289,9 +355,97 @@ func (s *SsaCli) diskList(disks []VolSpecDisk) string {
 	return fmt.Sprintf("drives=%s", strings.Join(parts, ","))
 }
 
-func (s *SsaCli) Create(c *Controller, v *VolSpec, forceGood bool) error {
+func (s *SsaCli) spareList(disks []VolSpecDisk) string {
+	return "spares=" + strings.TrimPrefix(s.diskList(disks), "drives=")
+}
+
+// addSpares adds the hot spares for a freshly created volume.
+// ssacli spares belong to arrays, so global spares are added to all of them.
+func (s *SsaCli) addSpares(c *Controller, v *VolSpec) error {
+	array := "all"
+	if !v.GlobalHotSpares {
+		array = ""
+		s.Refresh(c)
+		for _, vol := range c.Volumes {
+			for _, d := range vol.Disks {
+				if d.Enclosure == v.Disks[0].Enclosure && d.Slot == v.Disks[0].Slot {
+					array = vol.Info["Array"]
+				}
+			}
+		}
+		if array == "" {
+			return fmt.Errorf("Cannot find the array of the new volume to add hot spares to")
+		}
+	}
+	return s.runCmds([][]string{s.spareCmd(c, v, array)})
+}
+
+func (s *SsaCli) spareCmd(c *Controller, v *VolSpec, array string) []string {
+	return []string{"controller", "slot=" + c.ID, "array", array, "add", s.spareList(v.HotSpareDisks), "forced"}
+}
+
+// cachingArg returns the caching argument for the volume.  The array
+// accelerator is the only cache policy ssacli lets us set per volume,
+// so it stands in for the write policy.
+func (s *SsaCli) cachingArg(policies map[string]string) (string, error) {
+	for _, k := range policyNames {
+		switch k {
+		case "Name":
+		case "WritePolicy":
+			switch policies[k] {
+			case "", "writeback", "writethrough":
+			default:
+				return "", fmt.Errorf("ssacli does not support WritePolicy %s", policies[k])
+			}
+		default:
+			if policies[k] != "" {
+				return "", fmt.Errorf("ssacli does not support setting %s", k)
+			}
+		}
+	}
+	switch policies["WritePolicy"] {
+	case "writeback":
+		return "caching=enable", nil
+	case "writethrough":
+		return "caching=disable", nil
+	}
+	return "", nil
+}
+
+func (s *SsaCli) UpdateCmds(c *Controller, v *Volume, spec *VolSpec) ([][]string, error) {
+	changes := spec.PolicyChanges(v.VolSpec())
+	if _, ok := changes["Name"]; ok {
+		return nil, fmt.Errorf("ssacli cannot rename logical drives")
+	}
+	arg, err := s.cachingArg(changes)
+	if err != nil || arg == "" {
+		return [][]string{}, err
+	}
+	return [][]string{{"controller", "slot=" + c.ID, "ld", v.ID, "modify", arg}}, nil
+}
+
+func (s *SsaCli) Update(c *Controller, v *Volume, spec *VolSpec) error {
+	cmds, err := s.UpdateCmds(c, v, spec)
+	if err != nil {
+		return err
+	}
+	return s.runCmds(cmds)
+}
+
+func (s *SsaCli) SetBootCmds(c *Controller, v *Volume) ([][]string, error) {
+	return [][]string{{"controller", "slot=" + c.ID, "ld", v.ID, "modify", "bootvolume=primary"}}, nil
+}
+
+func (s *SsaCli) SetBoot(c *Controller, v *Volume) error {
+	cmds, _ := s.SetBootCmds(c, v)
+	return s.runCmds(cmds)
+}
+
+// createCmds returns the commands that create the volume, not counting
+// hot spares.
+func (s *SsaCli) createCmds(c *Controller, v *VolSpec, forceGood bool) ([][]string, error) {
 	if !v.compiled {
-		return fmt.Errorf("Cannot create a VolSpec that has not been compiled")
+		return nil, fmt.Errorf("Cannot create a VolSpec that has not been compiled")
 	}
 	cmdLine := []string{
 		"controller",
@@ -307,7 +461,7 @@ func (s *SsaCli) Create(c *Controller, v *VolSpec, forceGood bool) error {
 			// Controller will automatically expose non-configured drives to the OS
 			// So, do nothing.
 			s.log.Printf("Controller in mixed mode, drive already exposed to OS")
-			return nil
+			return [][]string{}, nil
 		}
 		// Yes, I know this is wrong for jbod, but ssacli Is Not Helpful.
 		cmdLine = append(cmdLine, "raid=0")
@@ -330,55 +484,316 @@ func (s *SsaCli) Create(c *Controller, v *VolSpec, forceGood bool) error {
 	case "raid60":
 		cmdLine = append(cmdLine, "raid=60")
 	default:
-		return fmt.Errorf("Raid level %s not supported", v.RaidLevel)
+		return nil, fmt.Errorf("Raid level %s not supported", v.RaidLevel)
 	}
 	if v.Name != "" {
 		cmdLine = append(cmdLine, fmt.Sprintf("logicaldrivelabel=%s", v.Name))
 	}
+	caching, err := s.cachingArg(v.policies())
+	if err != nil {
+		return nil, err
+	}
+	if caching != "" {
+		cmdLine = append(cmdLine, caching)
+	}
 	cmdLine = append(cmdLine, s.diskList(v.Disks), "forced")
-	s.log.Printf("Running %s %s", s.executable, strings.Join(cmdLine, " "))
-	res, err := s.run(cmdLine...)
-	if len(res) > 0 {
-		s.log.Println(strings.Join(res, "\n"))
+	return [][]string{cmdLine}, nil
+}
+
+func (s *SsaCli) CreateCmds(c *Controller, v *VolSpec, forceGood bool) ([][]string, error) {
+	cmds, err := s.createCmds(c, v, forceGood)
+	if err != nil || len(v.HotSpareDisks) == 0 || len(cmds) == 0 {
+		return cmds, err
+	}
+	array := "all"
+	if !v.GlobalHotSpares {
+		array = newVolPlaceholder
 	}
+	return append(cmds, s.spareCmd(c, v, array)), nil
+}
+
+func (s *SsaCli) Create(c *Controller, v *VolSpec, forceGood bool) error {
+	cmds, err := s.createCmds(c, v, forceGood)
 	if err != nil {
-		s.log.Printf("Error running command: %s", strings.Join(cmdLine, " "))
+		return err
 	}
-	return err
+	if len(cmds) == 0 {
+		return nil
+	}
+	if err := s.runCmds(cmds); err != nil {
+		return err
+	}
+	if len(v.HotSpareDisks) > 0 {
+		return s.addSpares(c, v)
+	}
+	return nil
+}
+
+func (s *SsaCli) EncryptCmds(c *Controller, key, password string) ([][]string, error) {
+	return [][]string{
+		{
+			"controller",
+			"slot=" + c.ID,
+			"clearencryptionconfig",
+			"forced",
+		},
+		{
+			"controller",
+			"slot=" + c.ID,
+			"enableencryption",
+			"encryption=on",
+			"eula=yes",
+			fmt.Sprintf("masterkey=\"%s\"", key),
+			"localkeymanagermode=on",
+			"mixedvolumes=on",
+			fmt.Sprintf("password=\"%s\"", password),
+		},
+	}, nil
 }
 
 func (s *SsaCli) Encrypt(c *Controller, key, password string) error {
-	cmdLine := []string{
-		"controller",
-		"slot=" + c.ID,
-		"clearencryptionconfig",
-		"forced",
+	cmds, _ := s.EncryptCmds(c, key, password)
+	var err error
+	for _, cmdLine := range cmds {
+		s.log.Printf("Running %s %s", s.executable, strings.Join(cmdLine, " "))
+		var res []string
+		res, err = s.run(cmdLine...)
+		if len(res) > 0 {
+			s.log.Println(strings.Join(res, "\n"))
+		}
+		// Assume clear worked
 	}
-	s.log.Printf("Running %s %s", s.executable, strings.Join(cmdLine, " "))
-	res, err := s.run(cmdLine...)
-	if len(res) > 0 {
-		s.log.Println(strings.Join(res, "\n"))
+	if err != nil {
+		s.log.Printf("Error running command: %s", strings.Join(cmds[len(cmds)-1], " "))
 	}
-	// Assume clear worked
+	return err
+}
 
-	cmdLine = []string{
-		"controller",
-		"slot=" + c.ID,
-		"enableencryption",
-		"encryption=on",
-		"eula=yes",
-		fmt.Sprintf("masterkey=\"%s\"", key),
-		"localkeymanagermode=on",
-		"mixedvolumes=on",
-		fmt.Sprintf("password=\"%s\"", password),
-	}
-	s.log.Printf("Running %s %s", s.executable, strings.Join(cmdLine, " "))
-	res, err = s.run(cmdLine...)
-	if len(res) > 0 {
-		s.log.Println(strings.Join(res, "\n"))
+func (s *SsaCli) SecureCmds(c *Controller, v *Volume) ([][]string, error) {
+	return [][]string{{"controller", "slot=" + c.ID, "ld", v.ID, "encode", "preservedata=yes"}}, nil
+}
+
+func (s *SsaCli) Secure(c *Controller, v *Volume) error {
+	cmds, _ := s.SecureCmds(c, v)
+	return s.runCmds(cmds)
+}
+
+func (s *SsaCli) RekeyCmds(c *Controller, sec *Secrets) ([][]string, error) {
+	return nil, fmt.Errorf("Changing the key of a Smart Array controller is not supported")
+}
+
+func (s *SsaCli) Rekey(c *Controller, sec *Secrets) error {
+	_, err := s.RekeyCmds(c, sec)
+	return err
+}
+
+func (s *SsaCli) UnlockCmds(c *Controller, password string) ([][]string, error) {
+	return nil, fmt.Errorf("Smart Array controllers unlock moved disks on their own")
+}
+
+func (s *SsaCli) Unlock(c *Controller, password string) error {
+	_, err := s.UnlockCmds(c, password)
+	return err
+}
+
+// Health uses the temperature and status ssacli reports for each drive.
+// ssacli does not report error counts.
+func (s *SsaCli) Health(c *Controller, d *PhysicalDisk) (*DiskHealth, error) {
+	h := &DiskHealth{Source: s.name}
+	h.Temperature = leadingInt(d.Info["Current Temperature (C)"])
+	if strings.Contains(d.Status, "Predictive Failure") {
+		h.PredictiveFailures = 1
+	}
+	return h, nil
+}
+
+func (s *SsaCli) LocateCmds(c *Controller, d *PhysicalDisk, on bool) ([][]string, error) {
+	led := "led=off"
+	if on {
+		led = "led=on"
 	}
+	return [][]string{{"controller", "slot=" + c.ID, "physicaldrive", d.Name(), "modify", led}}, nil
+}
+
+func (s *SsaCli) Locate(c *Controller, d *PhysicalDisk, on bool) error {
+	cmds, _ := s.LocateCmds(c, d, on)
+	return s.runCmds(cmds)
+}
+
+// ssacliErasePatterns are the erasepattern= values for each erase method.
+var ssacliErasePatterns = map[string]string{
+	"crypto":    "crypto",
+	"overwrite": "overwrite",
+	"block":     "block",
+}
+
+func (s *SsaCli) EraseCmds(c *Controller, d *PhysicalDisk, method string) ([][]string, error) {
+	return [][]string{{"controller", "slot=" + c.ID, "physicaldrive", d.Name(), "modify", "erase",
+		"erasepattern=" + ssacliErasePatterns[method], "forced"}}, nil
+}
+
+func (s *SsaCli) Erase(c *Controller, d *PhysicalDisk, method string) error {
+	cmds, _ := s.EraseCmds(c, d, method)
+	return s.runCmds(cmds)
+}
+
+// EraseProgress uses the status of the drive, which is Erase In Progress
+// while it is being erased, Erase Complete once it has been, and Erase
+// Error if it failed.
+func (s *SsaCli) EraseProgress(c *Controller, d *PhysicalDisk, method string) (*EraseStatus, error) {
+	out, err := s.run("controller", "slot="+c.ID, "physicaldrive", d.Name(), "show")
 	if err != nil {
-		s.log.Printf("Error running command: %s", strings.Join(cmdLine, " "))
+		return nil, err
+	}
+	res := &EraseStatus{}
+	for _, line := range out {
+		k, v := kv(strings.TrimSpace(line), ": ")
+		switch {
+		case k == "Status" && strings.Contains(v, "Erase In Progress"):
+			res.Op = &BackgroundOp{Controller: c.Name(), Disk: d.Name(), Operation: "erase"}
+		case k == "Status" && strings.Contains(v, "Erase"):
+			res = eraseStatusFor(v)
+		case res.Op != nil && strings.Contains(k, "Erase") && strings.Contains(v, "%"):
+			res.Op.Progress, _ = parsePercent(v)
+		}
 	}
+	return res, nil
+}
+
+// BackgroundOps uses the logical drive status, which has the progress of
+// rebuilds and transformations, and the parity initialization progress.
+func (s *SsaCli) BackgroundOps(c *Controller) ([]*BackgroundOp, error) {
+	res := []*BackgroundOp{}
+	for _, vol := range c.Volumes {
+		if vol.Fake {
+			continue
+		}
+		kind := ""
+		switch {
+		case strings.HasPrefix(vol.Status, "Recovering"):
+			kind = "rebuild"
+		case strings.HasPrefix(vol.Status, "Transforming"):
+			kind = "reshape"
+		}
+		if progress, ok := parsePercent(vol.Status); ok && kind != "" {
+			res = append(res, &BackgroundOp{Controller: c.Name(), Volume: vol.ID, Operation: kind, Progress: progress})
+		}
+		if vol.Info["Parity Initialization Status"] == "In Progress" {
+			progress, _ := parsePercent(vol.Info["Parity Initialization Progress"])
+			res = append(res, &BackgroundOp{Controller: c.Name(), Volume: vol.ID, Operation: "init", Progress: progress})
+		}
+	}
+	return res, nil
+}
+
+// ssacli only has a controller wide surface scan, not a per volume
+// consistency check.
+func (s *SsaCli) CheckCmds(c *Controller, v *Volume, start bool) ([][]string, error) {
+	return nil, fmt.Errorf("Consistency checks are not supported")
+}
+
+func (s *SsaCli) Check(c *Controller, v *Volume, start bool) error {
+	_, err := s.CheckCmds(c, v, start)
 	return err
 }
+
+// ssacliRaidLevels are the raid= values ssacli takes for each raid level.
+var ssacliRaidLevels = map[string]string{
+	"raid0":  "0",
+	"raid1":  "1",
+	"raid1e": "1adm",
+	"raid5":  "5",
+	"raid6":  "6",
+}
+
+// ExpandCmds adds the new disks to the array the volume is on, grows the
+// volume onto them, and then migrates it to the new raid level.
+func (s *SsaCli) ExpandCmds(c *Controller, e *Expansion) ([][]string, error) {
+	slot := "slot=" + c.ID
+	ld := []string{"controller", slot, "ld", e.VolumeID, "modify"}
+	res := [][]string{}
+	if len(e.disks) > 0 {
+		array := e.volume.Info["Array"]
+		if array == "" {
+			return nil, fmt.Errorf("Cannot find the array volume %s is on", e.VolumeID)
+		}
+		res = append(res,
+			[]string{"controller", slot, "array", array, "add", "drives=" + driveList(e.disks), "forced"},
+			append(ld, "size=max", "forced"))
+	}
+	if e.From != e.To {
+		lvl, ok := ssacliRaidLevels[e.To]
+		if !ok {
+			return nil, fmt.Errorf("Cannot migrate to %s", e.To)
+		}
+		res = append(res, append(append([]string{}, ld...), "raid="+lvl, "forced"))
+	}
+	return res, nil
+}
+
+func (s *SsaCli) Expand(c *Controller, e *Expansion) error {
+	cmds, err := s.ExpandCmds(c, e)
+	if err != nil {
+		return err
+	}
+	return s.runCmds(cmds)
+}
+
+// ForeignConfigs reports none.  Smart Array controllers pick up arrays
+// moved from other controllers on their own, so disks are never left
+// waiting with a foreign config.
+func (s *SsaCli) ForeignConfigs(c *Controller) ([]*ForeignConfig, error) {
+	return []*ForeignConfig{}, nil
+}
+
+func (s *SsaCli) ImportForeignCmds(c *Controller, id string) ([][]string, error) {
+	return nil, fmt.Errorf("Smart Array controllers import moved arrays on their own")
+}
+
+func (s *SsaCli) ImportForeign(c *Controller, id string) error {
+	_, err := s.ImportForeignCmds(c, id)
+	return err
+}
+
+// Settings reports the controller mode.  Older controllers only have an
+// HBA mode switch, newer ones also have a mixed mode that passes through
+// the disks that are not in a volume.
+func (s *SsaCli) Settings(c *Controller) (*ControllerSettings, error) {
+	res := &ControllerSettings{Personality: "raid"}
+	if strings.EqualFold(c.Info["HBA Mode Enabled"], "True") {
+		res.Personality = "hba"
+	}
+	switch strings.ToLower(c.Info["Controller Mode"]) {
+	case "hba":
+		res.Personality = "hba"
+	case "mixed":
+		res.Personality = "mixed"
+	}
+	jbod := res.Personality == "mixed"
+	res.AutoJBOD = &jbod
+	return res, nil
+}
+
+func (s *SsaCli) SettingsCmds(c *Controller, spec *ControllerSpec) ([][]string, error) {
+	ctrl := []string{"controller", "slot=" + c.ID, "modify"}
+	switch spec.Personality {
+	case "raid":
+		return [][]string{append(ctrl, "hbamode=off", "forced")}, nil
+	case "hba":
+		return [][]string{append(ctrl, "hbamode=on", "forced")}, nil
+	case "mixed":
+		return [][]string{append(ctrl, "controllermode=mixed", "forced")}, nil
+	}
+	if spec.AutoJBOD != nil {
+		return nil, fmt.Errorf("Set the mixed personality to pass through unused disks")
+	}
+	return [][]string{}, nil
+}
+
+func (s *SsaCli) ApplySettings(c *Controller, spec *ControllerSpec) error {
+	cmds, err := s.SettingsCmds(c, spec)
+	if err != nil {
+		return err
+	}
+	return s.runCmds(cmds)
+}
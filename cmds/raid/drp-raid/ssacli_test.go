@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const ssaCliArray = `   Array: A
+      Interface Type: SAS
+      Status: OK
+
+      Logical Drive: 1
+         Size: 279.37 GB
+         Fault Tolerance: 0
+         Strip Size: 256 KB
+         Status: OK
+         Caching:  Enabled
+         Boot Volume: None
+         Logical Drive Label: 01A2B3C4
+
+      Logical Drive: 2
+         Size: 279.37 GB
+         Fault Tolerance: 0
+         Strip Size: 64 KB
+         Status: OK
+         Caching:  Disabled
+         Boot Volume: Primary
+         Logical Drive Label: 05D6E7F8
+
+      physicaldrive 1I:1:1
+         Port: 1I
+         Box: 1
+         Bay: 1
+         Status: OK
+         Drive Type: Data Drive
+         Interface Type: SAS
+         Size: 600 GB
+
+      physicaldrive 1I:1:2
+         Port: 1I
+         Box: 1
+         Bay: 2
+         Status: OK
+         Drive Type: Spare Drive
+         Interface Type: SAS
+         Size: 600 GB
+`
+
+// The Logical Drive line that starts each volume carries its ID, so it
+// must be parsed along with the rest of the volume.
+func TestSsaCliFillArray(t *testing.T) {
+	c := ctrlrs(1, "ssacli")[0]
+	s := c.driver.(*SsaCli)
+	s.fillArray(c, strings.Split(ssaCliArray, "\n"))
+	if len(c.Disks) != 2 || len(c.Volumes) != 2 {
+		t.Fatalf("Expected 2 disks and 2 volumes, got %d and %d", len(c.Disks), len(c.Volumes))
+	}
+	for i, want := range []struct {
+		id, name string
+		stripe   uint64
+		write    string
+		boot     bool
+	}{
+		{"1", "01A2B3C4", 256 << 10, "writeback", false},
+		{"2", "05D6E7F8", 64 << 10, "writethrough", true},
+	} {
+		v := c.Volumes[i]
+		if v.ID != want.id || v.Info["Logical Drive"] != want.id || v.Name != want.name || v.RaidLevel != "raid0" ||
+			v.StripeSize != want.stripe || v.WritePolicy != want.write || v.Bootable != want.boot || v.Info["Array"] != "A" {
+			t.Errorf("Unexpected volume %d: %s %s %s stripe %d write %s boot %v array %s",
+				i, v.ID, v.Name, v.RaidLevel, v.StripeSize, v.WritePolicy, v.Bootable, v.Info["Array"])
+		}
+		if len(v.Disks) != 1 || len(v.HotSpares) != 1 {
+			t.Errorf("Volume %s: expected 1 disk and 1 spare, got %d and %d", v.ID, len(v.Disks), len(v.HotSpares))
+		}
+	}
+	if d := c.Disks[1]; !d.HotSpare || d.SpareFor != "2" {
+		t.Errorf("Expected %s:%d to be a spare for volume 2, got %v %q", d.Enclosure, d.Slot, d.HotSpare, d.SpareFor)
+	}
+}
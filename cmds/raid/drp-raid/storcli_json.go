@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+ * StorCliJson drives storcli through its JSON output instead of scraping
+ * the MegaCli style text the storcli6 and storcli7 drivers parse.
+ *
+ * perccli is storcli with a Dell badge, so the JSON they produce is the
+ * same, and this reuses the perccli-json models and parsing.  It only
+ * differs where perccli-json was written for HBA-only controllers, and
+ * in the places storcli takes different arguments.
+ */
+
+type StorCliJson struct {
+	PercJsonCli
+}
+
+func (s *StorCliJson) Controllers() []*Controller {
+	return s.controllers(s)
+}
+
+// drivePath returns the storcli path to a disk.
+func (s *StorCliJson) drivePath(c *Controller, d VolSpecDisk) string {
+	path := "/c" + c.ID
+	if d.Enclosure != "" {
+		path += "/e" + d.Enclosure
+	}
+	return fmt.Sprintf("%s/s%d", path, d.Slot)
+}
+
+func (s *StorCliJson) ClearCmds(c *Controller, onlyForeign bool) ([][]string, error) {
+	cmds := [][]string{{"/c" + c.ID + "/fall", "del", "J"}}
+	if onlyForeign {
+		return cmds, nil
+	}
+	for _, v := range c.Volumes {
+		if v.RaidLevel == "jbod" && !v.Fake {
+			cmds = append(cmds, []string{s.drivePath(c, v.Disks[0].volSpecDisk()), "set", "good", "force", "J"})
+		}
+	}
+	if s.canBeCleared(c) {
+		cmds = append(cmds, []string{"/c" + c.ID + "/vall", "del", "force", "J"})
+	}
+	return cmds, nil
+}
+
+func (s *StorCliJson) Clear(c *Controller, onlyForeign bool) error {
+	cmds, _ := s.ClearCmds(c, onlyForeign)
+	for i, cmd := range cmds {
+		out, err := s.run(cmd...)
+		// There may not be a foreign config to clear.
+		if err != nil && i > 0 {
+			return fmt.Errorf("Error running cmd `%s`: %v\n%s", strings.Join(cmd, " "), err, out)
+		}
+	}
+	s.Refresh(c)
+	return nil
+}
+
+// storcliRaidArgs are the add vd arguments for each raid level.  Spanned
+// levels also need pdperarray.
+var storcliRaidArgs = map[string]string{
+	"raid0":  "r0",
+	"raid1":  "r1",
+	"raid5":  "r5",
+	"raid6":  "r6",
+	"raid00": "r00",
+	"raid10": "r10",
+	"raid50": "r50",
+	"raid60": "r60",
+}
+
+func (s *StorCliJson) CreateCmds(c *Controller, v *VolSpec, forceGood bool) ([][]string, error) {
+	if !v.compiled {
+		return nil, fmt.Errorf("Cannot create a VolSpec that has not been compiled")
+	}
+	cmds := [][]string{}
+	good := func(d VolSpecDisk) {
+		cmds = append(cmds, []string{s.drivePath(c, d), "set", "good", "force", "J"})
+	}
+	for _, d := range v.Disks {
+		if forceGood || (v.RaidLevel != "jbod" && d.info["State"] == "JBOD") {
+			good(d)
+		}
+	}
+	if v.RaidLevel == "jbod" {
+		for _, d := range v.Disks {
+			if d.info["State"] != "JBOD" {
+				cmds = append(cmds, []string{s.drivePath(c, d), "set", "jbod", "J"})
+			}
+		}
+		if len(cmds) == 0 {
+			s.log.Printf("%s is already a JBOD, nothing to do", s.diskList(v.Disks))
+		}
+		return cmds, nil
+	}
+	lvl, ok := storcliRaidArgs[v.RaidLevel]
+	if !ok {
+		return nil, fmt.Errorf("Cannot create a %s volume", v.RaidLevel)
+	}
+	add := []string{"/c" + c.ID, "add", "vd", lvl}
+	if v.Name != "" {
+		add = append(add, "name="+v.Name)
+	}
+	add = append(add, storcliCacheArgs(v)...)
+	add = append(add, s.diskList(v.Disks))
+	switch v.RaidLevel {
+	case "raid00", "raid10", "raid50", "raid60":
+		_, perSpan := v.raid().Spans(uint64(len(v.Disks)))
+		add = append(add, fmt.Sprintf("pdperarray=%d", perSpan))
+	}
+	if len(v.HotSpareDisks) > 0 && !v.GlobalHotSpares {
+		add = append(add, "spares="+strings.TrimPrefix(s.diskList(v.HotSpareDisks), "drives="))
+	}
+	add = append(add, fmt.Sprintf("strip=%d", v.stripeSize()>>10), "force", "J")
+	cmds = append(cmds, add)
+	if v.Encrypt {
+		cmds = append(cmds, []string{fmt.Sprintf("/c%s/v%d", c.ID, v.index), "set", "security=on", "J"})
+	}
+	if v.GlobalHotSpares {
+		for _, d := range v.HotSpareDisks {
+			cmds = append(cmds, []string{s.drivePath(c, d), "add", "hotsparedrive", "J"})
+		}
+	}
+	return cmds, nil
+}
+
+func (s *StorCliJson) Create(c *Controller, v *VolSpec, forceGood bool) error {
+	cmds, err := s.CreateCmds(c, v, forceGood)
+	if err != nil {
+		return err
+	}
+	return s.runCmds(cmds)
+}
+
+func (s *StorCliJson) EncryptCmds(c *Controller, key, password string) ([][]string, error) {
+	return [][]string{
+		{"/c" + c.ID, "delete", "securitykey", "J"},
+		{"/c" + c.ID, "set", "securitykey=" + password, "keyid=" + key, "J"},
+	}, nil
+}
+
+func (s *StorCliJson) Encrypt(c *Controller, key, password string) error {
+	cmds, _ := s.EncryptCmds(c, key, password)
+	var (
+		out string
+		err error
+	)
+	for i, cmd := range cmds {
+		s.log.Printf("Running command: %s %s", s.executable, strings.Join(cmd, " "))
+		out, err = s.run(cmd...)
+		// There may not be a key to delete.
+		if i == 0 || err == nil {
+			continue
+		}
+		return fmt.Errorf("Error running cmd `%s`: %v\n%s", strings.Join(cmd, " "), err, out)
+	}
+	s.log.Println(out)
+	return nil
+}
+
+func (s *StorCliJson) SettingsCmds(c *Controller, spec *ControllerSpec) ([][]string, error) {
+	return lsiSettingsCmds(c, spec, "JBOD", "J"), nil
+}
+
+func (s *StorCliJson) ApplySettings(c *Controller, spec *ControllerSpec) error {
+	cmds, err := s.SettingsCmds(c, spec)
+	if err != nil {
+		return err
+	}
+	return s.runCmds(cmds)
+}
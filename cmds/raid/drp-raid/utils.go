@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"log"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -102,3 +103,54 @@ func partitionAt(lines []string, splitter *regexp.Regexp) (prefix []string, sect
 	}
 	return prefix, sections
 }
+
+// blockDevInUse checks to see if the passed block device (or any of its
+// partitions) is mounted, used for swap, or held by another block device
+// such as an LVM volume, md array, or dm-crypt mapping.  It looks through
+// the executor so that the check can be recorded and replayed.
+func blockDevInUse(dev string) bool {
+	if real, err := executor.EvalSymlinks(dev); err == nil {
+		dev = real
+	}
+	base := filepath.Base(dev)
+	names := []string{base}
+	// Partitions show up in the sysfs directory of the disk they are on.
+	entries, _ := executor.ReadDir(filepath.Join("/sys/class/block", base))
+	for _, entry := range entries {
+		if entry != base && strings.HasPrefix(entry, base) {
+			names = append(names, entry)
+		}
+	}
+	for _, name := range names {
+		holders, _ := executor.ReadDir(filepath.Join("/sys/class/block", name, "holders"))
+		if len(holders) > 0 {
+			return true
+		}
+	}
+	for _, src := range []string{"/proc/mounts", "/proc/swaps"} {
+		buf, err := executor.ReadFile(src)
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(buf), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 0 || !strings.HasPrefix(fields[0], "/dev/") {
+				continue
+			}
+			used := fields[0]
+			// Only the names under /dev/disk and the like are links to
+			// the device nodes at the top of /dev.
+			if filepath.Dir(used) != "/dev" {
+				if real, err := executor.EvalSymlinks(used); err == nil {
+					used = real
+				}
+			}
+			for _, name := range names {
+				if filepath.Base(used) == name {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
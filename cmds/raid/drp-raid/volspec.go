@@ -189,6 +189,9 @@ func (v VolSpecDisks) Protocol(p string) VolSpecDisks {
 }
 
 func (v VolSpecDisks) First(n int) VolSpecDisks {
+	if n > len(v) {
+		n = len(v)
+	}
 	res := make([]VolSpecDisk, n)
 	copy(res, v)
 	return res
@@ -243,6 +246,22 @@ func (v VolSpecDisks) Remove(o VolSpecDisks) VolSpecDisks {
 	return res
 }
 
+// Shrink takes size bytes off the disks in o, and drops the ones that
+// do not have room for another volume left.
+func (v VolSpecDisks) Shrink(o VolSpecDisks, size uint64) VolSpecDisks {
+	res := make(VolSpecDisks, 0, len(v))
+	for _, vsd := range v {
+		if o.Contains(vsd) != nil {
+			if vsd.Size < size+(100<<20) {
+				continue
+			}
+			vsd.Size -= size
+		}
+		res = append(res, vsd)
+	}
+	return res
+}
+
 // Returns all the disks that are too much smaller or too much bigger than
 // the first disk in the VolSpecDisks
 func (v VolSpecDisks) ValidateSizeVariance() VolSpecDisks {
@@ -309,8 +328,12 @@ type VolSpec struct {
 	// should be placed on.  drp-raid orders controllers in ascending
 	// order of their PCI address.  Since this value defaults to 0,
 	// VolSpecs will be placed on the first controller unless otherwise
-	// specified.
+	// specified.  -1 has drp-raid pick the controller.
 	Controller int `json:",omitempty"`
+	// ControllerSelector is a regular expression matched against the
+	// driver name, model, and PCI address of each controller.  If it is
+	// set, drp-raid picks the controller from the ones that match.
+	ControllerSelector string `json:",omitempty"`
 	// Disks is the list of VolSpecDisks to be used to build the RAID volume.
 	// Only the Enclosure and Slot fields need to be filled out.
 	// Either this must be set or DiskCount must be non-zero.
@@ -335,10 +358,55 @@ type VolSpec struct {
 	// AllowMixedSizes allows a RAID volume to be created that has individual disk sizes that
 	// vary by more than 10% across all disks in the volume.
 	AllowMixedSizes bool `json:",omitempty"`
-	diskCount       int
-	controller      *Controller
-	compiled        bool
-	index           int // Index in the created volumes.
+	// HotSpares is the number of hot spares to add to the volume.
+	// They will be picked from the disks left over after all the
+	// volumes have picked their disks, and will be of the same type
+	// and protocol and at least as large as the disks in the volume.
+	// It cannot be set along with HotSpareDisks.
+	HotSpares int `json:",omitempty"`
+	// HotSpareDisks is the list of VolSpecDisks to use as hot spares.
+	// Only the Enclosure and Slot fields need to be filled out.
+	HotSpareDisks VolSpecDisks `json:",omitempty"`
+	// GlobalHotSpares indicates that the hot spares should be
+	// available to every volume on the controller instead of being
+	// dedicated to this one.
+	GlobalHotSpares bool `json:",omitempty"`
+	// WritePolicy is the write cache policy of the volume.  It can be
+	// "writeback", "writethrough", or "alwayswriteback".  If unset, the
+	// controller default is used.
+	WritePolicy string `json:",omitempty"`
+	// ReadPolicy is the read cache policy of the volume.  It can be
+	// "readahead" or "noreadahead".  If unset, the controller default is used.
+	ReadPolicy string `json:",omitempty"`
+	// DiskCache is the cache policy of the physical disks in the volume.
+	// It can be "enabled", "disabled", or "default".
+	DiskCache string `json:",omitempty"`
+	// IOPolicy is whether reads are buffered in the controller cache.
+	// It can be "direct" or "cached".
+	IOPolicy string `json:",omitempty"`
+	// Model and Serial are regular expressions that the model and serial
+	// number of the disks picked for the volume must match.
+	Model  string `json:",omitempty"`
+	Serial string `json:",omitempty"`
+	// MinDiskSize and MaxDiskSize limit the size of each disk picked for
+	// the volume.  They take the same suffixes as Size.
+	MinDiskSize string `json:",omitempty"`
+	MaxDiskSize string `json:",omitempty"`
+	// Enclosures and Slots limit the disks picked to comma separated
+	// lists of enclosures and slots.  Numbers can be given as ranges,
+	// as in "0-3,8".
+	Enclosures string `json:",omitempty"`
+	Slots      string `json:",omitempty"`
+	// RotationalSpeed limits the disks picked to ones that spin at this
+	// many RPM.
+	RotationalSpeed int64 `json:",omitempty"`
+	// ExcludeDisks is a list of VolSpecDisks that must not be picked.
+	// Only the Enclosure and Slot fields need to be filled out.
+	ExcludeDisks VolSpecDisks `json:",omitempty"`
+	diskCount    int
+	controller   *Controller
+	compiled     bool
+	index        int // Index in the created volumes.
 	// Used to indicate this is a drp-raid created volume because of Passthru or other case.
 	Fake bool `json:",omitempty"`
 }
@@ -346,6 +414,10 @@ type VolSpec struct {
 func (v *VolSpec) Key() string {
 	kps := make([]string, len(v.Disks)+1)
 	kps[0] = fmt.Sprintf("%d:%s", v.Controller, v.RaidLevel)
+	if v.raid().sharesDisks {
+		// Several volumes can live on the same disks, so tell them apart by size.
+		kps[0] += "@" + v.Size
+	}
 	for i, d := range v.Disks {
 		kps[i+1] = fmt.Sprintf("%s:%d", d.Enclosure, d.Slot)
 	}
@@ -389,8 +461,56 @@ func (v *VolSpec) stripeSize() uint64 {
 var (
 	diskProtos = []string{"pcie", "nvme", "sas", "sata", "scsi"}
 	diskTypes  = []string{"disk", "ssd"}
+	// Hot spares do nothing for RAID levels that cannot rebuild.
+	noSpareLevels = map[string]struct{}{
+		"jbod":      {},
+		"raidS":     {},
+		"concat":    {},
+		"raid0":     {},
+		"raid00":    {},
+		"namespace": {},
+	}
 )
 
+// policyNames is the order drivers apply policy changes in.
+var policyNames = []string{"Name", "WritePolicy", "ReadPolicy", "DiskCache", "IOPolicy"}
+
+var volPolicies = map[string][]string{
+	"WritePolicy": {"writeback", "writethrough", "alwayswriteback"},
+	"ReadPolicy":  {"readahead", "noreadahead"},
+	"DiskCache":   {"enabled", "disabled", "default"},
+	"IOPolicy":    {"direct", "cached"},
+}
+
+func (v *VolSpec) policies() map[string]string {
+	return map[string]string{
+		"Name":        v.Name,
+		"WritePolicy": v.WritePolicy,
+		"ReadPolicy":  v.ReadPolicy,
+		"DiskCache":   v.DiskCache,
+		"IOPolicy":    v.IOPolicy,
+	}
+}
+
+// PolicyChanges returns the name and cache policies that are set in this
+// VolSpec and that differ from the ones in current.  Policies that current
+// does not report are assumed to be unchanged.
+func (v *VolSpec) PolicyChanges(current *VolSpec) map[string]string {
+	res := map[string]string{}
+	have := current.policies()
+	for k, want := range v.policies() {
+		if want == "" || have[k] == "" || want == have[k] {
+			continue
+		}
+		res[k] = want
+	}
+	return res
+}
+
+func (v *VolSpec) hasSpares() bool {
+	return v.HotSpares > 0 || len(v.HotSpareDisks) > 0
+}
+
 func (v *VolSpec) Fill() error {
 	if v == nil {
 		return fmt.Errorf("Cannot fill a nil VolSpec")
@@ -447,6 +567,42 @@ func (v *VolSpec) Fill() error {
 		}
 	}
 
+	for _, k := range policyNames {
+		want := v.policies()[k]
+		allowed, ok := volPolicies[k]
+		if !ok || want == "" {
+			continue
+		}
+		found := false
+		for i := range allowed {
+			if allowed[i] == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s must be one of %s, not `%s`", k, strings.Join(allowed, ","), want)
+		}
+	}
+	if v.HotSpares < 0 {
+		return fmt.Errorf("HotSpares must not be negative")
+	}
+	if v.HotSpares > 0 && len(v.HotSpareDisks) > 0 {
+		return fmt.Errorf("Cannot have HotSpares and HotSpareDisks set in the same VolSpec")
+	}
+	if _, ok := noSpareLevels[v.RaidLevel]; ok && v.hasSpares() {
+		return fmt.Errorf("Raid level %s cannot have hot spares", v.RaidLevel)
+	}
+	// jbod and raidS make a volume per disk, and only one can be the boot volume.
+	if v.Bootable && (v.RaidLevel == "jbod" || v.RaidLevel == "raidS") && len(v.Disks) != 1 && v.DiskCount != "1" {
+		return fmt.Errorf("Raid level %s makes a volume per disk, so it can only be Bootable with one disk", v.RaidLevel)
+	}
+	if _, err := v.selector(); err != nil {
+		return err
+	}
+	if _, err := v.controllerMatcher(); err != nil {
+		return err
+	}
 	if v.IsManual() {
 		spans, dps := raidLevels[v.RaidLevel].spans(uint64(len(v.Disks)))
 		minDisks := raidLevels[v.RaidLevel].minDisks(spans)
@@ -542,7 +698,7 @@ func (v *VolSpec) compileOneBucket(s *session, disks VolSpecDisks) VolSpecDisks
 			useDisks)
 		return nil
 	}
-	if useDisks < wantedDisks {
+	if uint64(len(disks)) < useDisks {
 		s.log.Printf("Want to make a %s with %d disks, but only %d available",
 			v.RaidLevel,
 			useDisks,
@@ -573,6 +729,10 @@ func (v *VolSpec) compileOneBucket(s *session, disks VolSpecDisks) VolSpecDisks
 
 func (v *VolSpec) compileAuto(s *session, disks VolSpecDisks) (VolSpecDisks, error) {
 	s.log.Printf("Picking disks heuristically")
+	disks, err := v.selectDisks(s, disks)
+	if err != nil {
+		return nil, err
+	}
 	if v.RaidLevel == "jbod" && v.DiskCount == "max" {
 		s.log.Printf("Max JBOD, taking the rest of the disks")
 		// Take all of the remaining disks on the controller
@@ -585,7 +745,6 @@ func (v *VolSpec) compileAuto(s *session, disks VolSpecDisks) (VolSpecDisks, err
 	}
 	buckets := disks.Bucketize()
 	res := VolSpecDisks{}
-	var err error
 	var pt, dt string
 	for _, proto := range strings.Split(v.Protocol, ",") {
 		for _, diskType := range strings.Split(v.Type, ",") {
@@ -670,12 +829,75 @@ func (v *VolSpec) Compile(s *session, disks VolSpecDisks) (res VolSpecDisks, err
 		return nil, errors.New("No disks available")
 	}
 	s.log.Printf("Picked %d disks", len(res))
-	v.Size = sizeStringer(lvl.FinalSize(res))
+	if lvl.sharesDisks && v.Size != "min" && v.Size != "max" {
+		v.Size = sizeStringer(roundToStripe(v.stripeSize(), v.sizeBytes()))
+	} else {
+		v.Size = sizeStringer(lvl.FinalSize(res))
+	}
 	v.Type = res[0].Type
 	v.Protocol = res[0].Protocol
 	return
 }
 
+// compileOn picks the disks and hot spares for the VolSpec from pool,
+// and returns the disks left in pool afterwards.
+func (v *VolSpec) compileOn(s *session, pool VolSpecDisks) (VolSpecDisks, error) {
+	pickedDisks, err := v.Compile(s, pool)
+	if err != nil {
+		return nil, err
+	}
+	if v.raid().sharesDisks {
+		pool = pool.Shrink(pickedDisks, v.sizeBytes())
+	} else {
+		pool = pool.Remove(pickedDisks)
+	}
+	v.Disks = pickedDisks
+	v.Type = pickedDisks[0].Type
+	v.Protocol = pickedDisks[0].Protocol
+	spares, err := v.compileSpares(s, pool)
+	if err != nil {
+		return nil, err
+	}
+	if len(spares) > 0 {
+		pool = pool.Remove(spares)
+		v.HotSpares = 0
+		v.HotSpareDisks = spares
+	}
+	return pool, nil
+}
+
+// compileSpares picks the hot spares for an already compiled volume
+// from the disks that are left.
+func (v *VolSpec) compileSpares(s *session, disks VolSpecDisks) (VolSpecDisks, error) {
+	if !v.hasSpares() {
+		return nil, nil
+	}
+	minSize := v.Disks.BySize()[0].Size
+	if len(v.HotSpareDisks) > 0 {
+		res := disks.Contains(v.HotSpareDisks...)
+		if res == nil {
+			return nil, fmt.Errorf("Requested hot spares are not available")
+		}
+		for _, d := range res {
+			if d.Size < minSize {
+				return nil, fmt.Errorf("Hot spare %s:%d is smaller than the disks in the volume", d.Enclosure, d.Slot)
+			}
+		}
+		return res.ByPos(), nil
+	}
+	disks, err := v.selectDisks(s, disks)
+	if err != nil {
+		return nil, err
+	}
+	candidates := disks.Type(v.Type).Protocol(v.Protocol).MinSize(minSize)
+	if len(candidates) < v.HotSpares {
+		return nil, fmt.Errorf("Want %d hot spares of type %s speaking protocol %s, but only %d available",
+			v.HotSpares, v.Type, v.Protocol, len(candidates))
+	}
+	s.log.Printf("Picked %d hot spares", v.HotSpares)
+	return candidates.First(v.HotSpares).ByPos(), nil
+}
+
 type VolSpecs []*VolSpec
 
 func (v VolSpecs) ByController() map[int]VolSpecs {
@@ -693,10 +915,27 @@ func (v VolSpecs) ByController() map[int]VolSpecs {
 	return res
 }
 
+// Keys returns the keys of the VolSpecs in order.  Volumes that would
+// have the same key get a #n suffix, so that several identical volumes
+// on shared disks are still counted separately.
+func (v VolSpecs) Keys() []string {
+	res := make([]string, len(v))
+	seen := map[string]int{}
+	for i, spec := range v {
+		k := spec.Key()
+		seen[k]++
+		if seen[k] > 1 {
+			k = fmt.Sprintf("%s#%d", k, seen[k])
+		}
+		res[i] = k
+	}
+	return res
+}
+
 func (v VolSpecs) ByKey() map[string]*VolSpec {
 	res := map[string]*VolSpec{}
-	for _, spec := range v {
-		res[spec.Key()] = spec
+	for i, k := range v.Keys() {
+		res[k] = v[i]
 	}
 	return res
 }
@@ -734,20 +973,37 @@ func (v VolSpecs) Compile(s *session, c Controllers) (VolSpecs, error) {
 	for i := range c {
 		diskPools[i] = c[i].VolSpecDisks()
 	}
-	// First pass: pick disks from controllers
-	for i := range v {
-		spec := v[i]
-		s.log.Printf("Considering spec %s:%s at %d", spec.RaidLevel, spec.DiskCount, i)
-		pool := diskPools[spec.Controller]
-		pickedDisks, err := spec.Compile(s, pool)
-		if err != nil {
-			s.Errorf("spec at %d: %v", i, err)
-			continue
+	// First pass: pick disks from controllers.  Specs with a fixed
+	// controller go first, so that the automatically placed ones only
+	// get the disks that are left over.
+	next := 0
+	for _, auto := range []bool{false, true} {
+		for i := range v {
+			spec := v[i]
+			if spec.autoPlaced() != auto {
+				continue
+			}
+			s.log.Printf("Considering spec %s:%s at %d", spec.RaidLevel, spec.DiskCount, i)
+			if auto {
+				idx, err := spec.place(s, c, diskPools, next)
+				if err != nil {
+					s.Errorf("spec at %d: %v", i, err)
+					continue
+				}
+				next = idx + 1
+				continue
+			}
+			if spec.Controller < 0 || spec.Controller >= len(c) {
+				s.Errorf("spec at %d: controller %d does not exist", i, spec.Controller)
+				continue
+			}
+			pool, err := spec.compileOn(s, diskPools[spec.Controller])
+			if err != nil {
+				s.Errorf("spec at %d: %v", i, err)
+				continue
+			}
+			diskPools[spec.Controller] = pool
 		}
-		diskPools[spec.Controller] = pool.Remove(pickedDisks)
-		spec.Disks = pickedDisks
-		spec.Type = pickedDisks[0].Type
-		spec.Protocol = pickedDisks[0].Protocol
 	}
 	// Second pass: split JBOD/RAID0 VolSpecs into one per disk, and convert to RAID0 if needed
 	for i, spec := range v {
@@ -778,5 +1034,16 @@ func (v VolSpecs) Compile(s *session, c Controllers) (VolSpecs, error) {
 			}
 		}
 	}
+	boot := map[int]int{}
+	for _, spec := range res {
+		if spec.Bootable {
+			boot[spec.Controller]++
+		}
+	}
+	for i := range c {
+		if boot[i] > 1 {
+			s.Errorf("Only one volume on controller %d can be Bootable, not %d", i, boot[i])
+		}
+	}
 	return res, nil
 }
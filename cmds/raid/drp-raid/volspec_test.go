@@ -1,7 +1,11 @@
 package main
 
 import (
+	"fmt"
+	"io/ioutil"
+	"log"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -480,9 +484,388 @@ func TestVolSpecFill(t *testing.T) {
 			"",
 		},
 		{"bad raid level", &VolSpec{RaidLevel: "jjbod"}, nil, "Raid level 'jjbod' is not supported"},
+		{"raid0 spares", &VolSpec{RaidLevel: "raid0", HotSpares: 1}, nil, "Raid level raid0 cannot have hot spares"},
+		{"negative spares", &VolSpec{RaidLevel: "raid5", HotSpares: -1}, nil, "HotSpares must not be negative"},
+		{"bad write policy", &VolSpec{RaidLevel: "raid5", WritePolicy: "wb"}, nil, "WritePolicy must be one of writeback,writethrough,alwayswriteback, not `wb`"},
+		{
+			"spares and spare disks",
+			&VolSpec{RaidLevel: "raid5", HotSpares: 1, HotSpareDisks: VolSpecDisks{{Slot: 1}}},
+			nil,
+			"Cannot have HotSpares and HotSpareDisks set in the same VolSpec",
+		},
 	}
 
 	for _, ft := range fillTests {
 		ft.Run(t)
 	}
 }
+
+func TestVolSpecHotSpares(t *testing.T) {
+	ctrl := ctrlrs(1, "megacli")
+	ctrl[0].addDisks(6, mustSize("1 TB"), "sas", "disk").
+		addDisks(1, mustSize("600 GB"), "sas", "disk").
+		addDisks(1, mustSize("2 TB"), "sata", "disk").
+		addDisks(2, mustSize("1 TB"), "sas", "ssd")
+	for _, tc := range []struct {
+		name   string
+		specs  VolSpecs
+		spares [][]uint64
+		err    bool
+	}{
+		{
+			"count",
+			VolSpecs{{RaidLevel: "raid6", DiskCount: "4", Type: "disk", Protocol: "sas", HotSpares: 2}},
+			[][]uint64{{4, 5}},
+			false,
+		},
+		{
+			"too many",
+			VolSpecs{{RaidLevel: "raid6", DiskCount: "4", Type: "disk", Protocol: "sas", HotSpares: 3}},
+			nil,
+			true,
+		},
+		{
+			"not shared",
+			VolSpecs{
+				{RaidLevel: "raid5", DiskCount: "3", Type: "disk", Protocol: "sas", HotSpares: 1},
+				{RaidLevel: "raid1", DiskCount: "2", Type: "ssd", Protocol: "sas", HotSpares: 1},
+			},
+			nil,
+			true,
+		},
+		{
+			"explicit",
+			VolSpecs{{RaidLevel: "raid1", Disks: VolSpecDisks{{Slot: 0}, {Slot: 1}}, HotSpareDisks: VolSpecDisks{{Slot: 7}}}},
+			[][]uint64{{7}},
+			false,
+		},
+		{
+			"explicit too small",
+			VolSpecs{{RaidLevel: "raid1", Disks: VolSpecDisks{{Slot: 0}, {Slot: 1}}, HotSpareDisks: VolSpecDisks{{Slot: 6}}}},
+			nil,
+			true,
+		},
+		{
+			"two volumes",
+			VolSpecs{
+				{RaidLevel: "raid1", DiskCount: "2", Type: "ssd", Protocol: "sas"},
+				{RaidLevel: "raid5", DiskCount: "3", Type: "disk", Protocol: "sas", HotSpares: 1, GlobalHotSpares: true},
+			},
+			[][]uint64{nil, {3}},
+			false,
+		},
+	} {
+		s := &session{log: log.New(ioutil.Discard, "", 0)}
+		res, _ := tc.specs.Compile(s, ctrl)
+		if s.HasError() != tc.err {
+			t.Errorf("%s: expected error %v, got %v", tc.name, tc.err, s.HasError())
+			continue
+		}
+		if tc.err {
+			continue
+		}
+		for i, spec := range res {
+			slots := []uint64{}
+			for _, d := range spec.HotSpareDisks {
+				slots = append(slots, d.Slot)
+			}
+			if len(slots) != len(tc.spares[i]) || (len(slots) > 0 && !reflect.DeepEqual(slots, tc.spares[i])) {
+				t.Errorf("%s: spec %d: expected spares %v, got %v", tc.name, i, tc.spares[i], slots)
+			}
+			if spec.HotSpares != 0 {
+				t.Errorf("%s: spec %d: HotSpares should be cleared after compile", tc.name, i)
+			}
+		}
+	}
+}
+
+func TestVolSpecDiskSelectors(t *testing.T) {
+	ctrl := ctrlrs(1, "megacli")
+	ctrl[0].addDisks(2, mustSize("480 GB"), "sas", "ssd").
+		addDisks(2, mustSize("960 GB"), "sas", "ssd").
+		addDisks(6, mustSize("8 TB"), "sas", "disk").
+		addDisks(2, mustSize("4 TB"), "sas", "disk")
+	for i, d := range ctrl[0].Disks {
+		d.Enclosure = "32"
+		d.Info = map[string]string{"Model": "SSD-A", "SN": fmt.Sprintf("S%d", i)}
+		switch {
+		case i >= 10:
+			d.Info["Model"], d.Info["Rotational Speed"] = "HDD-4", "7200 RPM"
+		case i >= 4:
+			d.Info["Model"], d.Info["Rotational Speed"] = "HDD-8", "7200 RPM"
+		case i >= 2:
+			d.Info["Model"] = "SSD-B"
+		}
+	}
+	for _, tc := range []struct {
+		name  string
+		spec  *VolSpec
+		slots []uint64
+	}{
+		{"smallest ssds", &VolSpec{RaidLevel: "raid1", DiskCount: "2", Type: "ssd", MaxDiskSize: "500 GB"}, []uint64{0, 1}},
+		{"8TB except slot 4", &VolSpec{RaidLevel: "raid6", DiskCount: "max", Type: "disk", MinDiskSize: "7 TB",
+			ExcludeDisks: VolSpecDisks{{Enclosure: "32", Slot: 4}}}, []uint64{5, 6, 7, 8, 9}},
+		{"model", &VolSpec{RaidLevel: "raid1", DiskCount: "2", Model: "^SSD-B$"}, []uint64{2, 3}},
+		{"serial", &VolSpec{RaidLevel: "raid1", DiskCount: "2", Serial: "^S1[01]$"}, []uint64{10, 11}},
+		{"slot ranges", &VolSpec{RaidLevel: "raid5", DiskCount: "max", Enclosures: "30-33", Slots: "4-5,9"}, []uint64{4, 5, 9}},
+		{"rotational speed", &VolSpec{RaidLevel: "raid0", DiskCount: "2", RotationalSpeed: 7200, Size: "min"}, []uint64{10, 11}},
+		{"no such speed", &VolSpec{RaidLevel: "raid0", DiskCount: "1", RotationalSpeed: 10000}, nil},
+		{"no such enclosure", &VolSpec{RaidLevel: "raid0", DiskCount: "1", Enclosures: "0-31"}, nil},
+		{"bad model", &VolSpec{RaidLevel: "raid0", DiskCount: "1", Model: "("}, nil},
+		{"bad sizes", &VolSpec{RaidLevel: "raid0", DiskCount: "1", MinDiskSize: "2 TB", MaxDiskSize: "1 TB"}, nil},
+		{"bad slots", &VolSpec{RaidLevel: "raid0", DiskCount: "1", Slots: "5-2"}, nil},
+	} {
+		s := &session{log: log.New(ioutil.Discard, "", 0)}
+		res, _ := VolSpecs{tc.spec}.Compile(s, ctrl)
+		if s.HasError() != (tc.slots == nil) {
+			t.Errorf("%s: expected error %v, got %v", tc.name, tc.slots == nil, s.HasError())
+			continue
+		}
+		if tc.slots == nil {
+			continue
+		}
+		slots := []uint64{}
+		for _, d := range res[0].Disks {
+			slots = append(slots, d.Slot)
+		}
+		if !reflect.DeepEqual(slots, tc.slots) {
+			t.Errorf("%s: expected slots %v, got %v", tc.name, tc.slots, slots)
+		}
+	}
+}
+
+func TestVolSpecDiskCounts(t *testing.T) {
+	if got := disks.BySize().First(len(disks) + 2); len(got) != len(disks) {
+		t.Errorf("Expected First to stop at %d disks, got %d", len(disks), len(got))
+	}
+	ctrl := ctrlrs(1, "megacli")
+	ctrl[0].addDisks(2, mustSize("1 TB"), "sas", "disk").addDisks(1, mustSize("960 GB"), "sas", "disk")
+	for _, tc := range []struct {
+		name  string
+		spec  *VolSpec
+		slots []uint64
+	}{
+		{"all of them", &VolSpec{RaidLevel: "raid5", DiskCount: "3", Size: "min"}, []uint64{0, 1, 2}},
+		{"big enough", &VolSpec{RaidLevel: "raid1", DiskCount: "2", Size: "980 GB"}, []uint64{0, 1}},
+		// Asking for more disks than there are must fail rather than
+		// padding the volume out with empty disks or running off the end,
+		// even when mixed sizes would let an empty disk through.
+		{"too many min", &VolSpec{RaidLevel: "raid5", DiskCount: "4", Size: "min", AllowMixedSizes: true}, nil},
+		{"too many max", &VolSpec{RaidLevel: "raid5", DiskCount: "4", Size: "max", AllowMixedSizes: true}, nil},
+		{"too few big enough", &VolSpec{RaidLevel: "raid5", DiskCount: "3", Size: "1950 GB", AllowMixedSizes: true}, nil},
+	} {
+		s := &session{log: log.New(ioutil.Discard, "", 0)}
+		res, _ := VolSpecs{tc.spec}.Compile(s, ctrl)
+		if s.HasError() != (tc.slots == nil) {
+			t.Errorf("%s: expected error %v, got %v", tc.name, tc.slots == nil, s.HasError())
+			continue
+		}
+		if tc.slots == nil {
+			continue
+		}
+		slots := []uint64{}
+		for _, d := range res[0].Disks {
+			slots = append(slots, d.Slot)
+		}
+		if !reflect.DeepEqual(slots, tc.slots) {
+			t.Errorf("%s: expected slots %v, got %v", tc.name, tc.slots, slots)
+		}
+	}
+}
+
+func TestVolSpecPlacement(t *testing.T) {
+	newCtrls := func() Controllers {
+		cs := Controllers{ctrlrs(1, "megacli")[0], ctrlrs(1, "ssacli")[0]}
+		cs[0].Info = map[string]string{"Product Name": "PERC H730P Mini"}
+		cs[0].addDisks(2, mustSize("480 GB"), "sas", "ssd").addDisks(4, mustSize("4 TB"), "sas", "disk")
+		cs[1].ID, cs[1].idx, cs[1].PCI.Device = "1", 1, 1
+		cs[1].Info = map[string]string{"Model": "Smart Array P440ar"}
+		cs[1].RaidLevels = []string{"raid0", "raid1", "raid5"}
+		cs[1].addDisks(4, mustSize("8 TB"), "sas", "disk")
+		return cs
+	}
+	for _, tc := range []struct {
+		name  string
+		specs VolSpecs
+		ctrls []int
+		err   string
+	}{
+		{"only fits one", VolSpecs{{Controller: AutoController, RaidLevel: "raid1", Type: "ssd"}}, []int{0}, ""},
+		{"spread", VolSpecs{
+			{Controller: AutoController, RaidLevel: "raid1", Type: "disk"},
+			{Controller: AutoController, RaidLevel: "raid1", Type: "disk"},
+		}, []int{0, 1}, ""},
+		{"by model", VolSpecs{{ControllerSelector: "P440", RaidLevel: "raid1"}}, []int{1}, ""},
+		{"by pci address", VolSpecs{{ControllerSelector: `^00:01\.0$`, RaidLevel: "raid1"}}, []int{1}, ""},
+		{"raid level", VolSpecs{{Controller: AutoController, RaidLevel: "raid6", DiskCount: "4", Type: "disk"}}, []int{0}, ""},
+		{"fixed first", VolSpecs{
+			{Controller: AutoController, RaidLevel: "raid1", Type: "ssd"},
+			{RaidLevel: "raid1", Type: "ssd"},
+		}, nil, "megacli:0: No disks available"},
+		{"nothing fits", VolSpecs{{Controller: AutoController, RaidLevel: "raid6", DiskCount: "5"}}, nil, "ssacli:1 does not support raid6"},
+		{"no such controller", VolSpecs{{Controller: 5, RaidLevel: "raid1"}}, nil, "controller 5 does not exist"},
+		{"bad selector", VolSpecs{{ControllerSelector: "(", RaidLevel: "raid1"}}, nil, "not a valid regular expression"},
+	} {
+		buf := &strings.Builder{}
+		s := &session{log: log.New(buf, "", 0)}
+		res, _ := tc.specs.Compile(s, newCtrls())
+		if tc.err != "" {
+			if !s.HasError() || !strings.Contains(buf.String(), tc.err) {
+				t.Errorf("%s: expected error containing %q, got %s", tc.name, tc.err, buf.String())
+			}
+			continue
+		}
+		if s.HasError() {
+			t.Errorf("%s: unexpected error: %s", tc.name, buf.String())
+			continue
+		}
+		ctrls := []int{}
+		for _, spec := range res {
+			ctrls = append(ctrls, spec.Controller)
+		}
+		if !reflect.DeepEqual(ctrls, tc.ctrls) {
+			t.Errorf("%s: expected controllers %v, got %v", tc.name, tc.ctrls, ctrls)
+		}
+	}
+}
+
+func TestToVolSpecsHotSpares(t *testing.T) {
+	ctrl := ctrlrs(1, "megacli")
+	c := ctrl[0].addDisks(6, mustSize("1 TB"), "sas", "disk")
+	for _, d := range c.Disks[:3] {
+		d.VolumeID = "0"
+	}
+	vol := &Volume{ID: "0", RaidLevel: "raid5", Disks: c.Disks[:3], controller: c, driver: c.driver, Info: map[string]string{}}
+	c.Volumes = append(c.Volumes, vol)
+	c.Disks[3].HotSpare, c.Disks[3].SpareFor = true, "0"
+	vol.HotSpares = []*PhysicalDisk{c.Disks[3]}
+	c.Disks[4].HotSpare = true
+	vol2 := &Volume{ID: "1", RaidLevel: "raid1", Disks: c.Disks[5:], controller: c, driver: c.driver, Info: map[string]string{}}
+	c.Volumes = append(c.Volumes, vol2)
+
+	specs := ctrl.ToVolSpecs(true)
+	if len(specs) != 2 {
+		t.Fatalf("Expected 2 specs, got %d", len(specs))
+	}
+	for _, spec := range specs {
+		switch spec.VolumeID {
+		case "0":
+			if len(spec.HotSpareDisks) != 1 || spec.HotSpareDisks[0].Slot != 3 || spec.GlobalHotSpares {
+				t.Errorf("Volume 0 should have dedicated spare in slot 3, got %v", spec.HotSpareDisks)
+			}
+		case "1":
+			if len(spec.HotSpareDisks) != 1 || spec.HotSpareDisks[0].Slot != 4 || !spec.GlobalHotSpares {
+				t.Errorf("Volume 1 should report global spare in slot 4, got %v", spec.HotSpareDisks)
+			}
+		}
+	}
+	for _, spec := range ctrl.ToVolSpecs(false) {
+		if spec.HotSpares != 1 || len(spec.HotSpareDisks) != 0 {
+			t.Errorf("Generic spec for %s should have a spare count of 1, got %d", spec.RaidLevel, spec.HotSpares)
+		}
+	}
+}
+
+func TestCachePolicies(t *testing.T) {
+	for _, tc := range []struct {
+		cache, write, read, io string
+	}{
+		{"RWBD", "writeback", "readahead", "direct"},
+		{"NRWTD", "writethrough", "noreadahead", "direct"},
+		{"RAWBC", "alwayswriteback", "readahead", "cached"},
+	} {
+		vol := &Volume{}
+		storcliCachePolicy(vol, tc.cache)
+		if vol.WritePolicy != tc.write || vol.ReadPolicy != tc.read || vol.IOPolicy != tc.io {
+			t.Errorf("%s: got %s %s %s", tc.cache, vol.WritePolicy, vol.ReadPolicy, vol.IOPolicy)
+		}
+	}
+	m := &MegaCli{name: "megacli"}
+	vol := &Volume{Disks: []*PhysicalDisk{{}}}
+	m.cachePolicy(vol, "WriteBack, ReadAheadNone, Cached, Write Cache OK if Bad BBU")
+	if vol.WritePolicy != "alwayswriteback" || vol.ReadPolicy != "noreadahead" || vol.IOPolicy != "cached" {
+		t.Errorf("megacli: got %s %s %s", vol.WritePolicy, vol.ReadPolicy, vol.IOPolicy)
+	}
+	want := &VolSpec{Name: "data", WritePolicy: "writethrough", ReadPolicy: "readahead", DiskCache: "disabled"}
+	changes := want.PolicyChanges(vol.VolSpec())
+	if len(changes) != 2 || changes["WritePolicy"] != "writethrough" || changes["ReadPolicy"] != "readahead" {
+		t.Errorf("Unexpected policy changes %v", changes)
+	}
+	got := []string{}
+	for _, cmd := range storcliSetCmds("/c0/v1", changes) {
+		got = append(got, strings.Join(cmd, " "))
+	}
+	if strings.Join(got, ";") != "/c0/v1 set wrcache=wt;/c0/v1 set rdcache=ra" {
+		t.Errorf("Unexpected storcli commands %v", got)
+	}
+}
+
+func TestVolSpecNamespaces(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		specs VolSpecs
+		sizes []uint64
+		err   bool
+	}{
+		{
+			"carve",
+			VolSpecs{
+				{RaidLevel: "namespace", Size: "1 TB"},
+				{RaidLevel: "namespace", Size: "1 TB"},
+				{RaidLevel: "namespace"},
+			},
+			[]uint64{1 << 40, 1 << 40, 4000787030016 - 2<<40},
+			false,
+		},
+		{
+			"too big",
+			VolSpecs{
+				{RaidLevel: "namespace", Size: "3 TB"},
+				{RaidLevel: "namespace", Size: "1 TB"},
+			},
+			nil,
+			true,
+		},
+		{
+			"second drive",
+			VolSpecs{{RaidLevel: "namespace", Controller: 1, Disks: VolSpecDisks{{Slot: 0}}, Size: "100 GB"}},
+			[]uint64{100 << 30},
+			false,
+		},
+		{
+			"two disks",
+			VolSpecs{{RaidLevel: "namespace", Disks: VolSpecDisks{{Slot: 0}, {Slot: 1}}}},
+			nil,
+			true,
+		},
+		{
+			"spares",
+			VolSpecs{{RaidLevel: "namespace", HotSpares: 1}},
+			nil,
+			true,
+		},
+	} {
+		ctrl := readController(`test-data/controllers/nvme.json`)
+		s := &session{log: log.New(ioutil.Discard, "", 0)}
+		res, _ := tc.specs.Compile(s, ctrl)
+		if s.HasError() != tc.err {
+			t.Errorf("%s: expected error %v, got %v", tc.name, tc.err, s.HasError())
+			continue
+		}
+		if tc.err {
+			continue
+		}
+		if len(res) != len(tc.sizes) {
+			t.Errorf("%s: expected %d specs, got %d", tc.name, len(tc.sizes), len(res))
+			continue
+		}
+		for i, spec := range res {
+			if spec.Size != sizeStringer(tc.sizes[i]) {
+				t.Errorf("%s: spec %d: expected size %s, got %s", tc.name, i, sizeStringer(tc.sizes[i]), spec.Size)
+			}
+		}
+		if keys := res.ByKey(); len(keys) != len(res) {
+			t.Errorf("%s: expected %d distinct keys, got %d", tc.name, len(res), len(keys))
+		}
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"sort"
 	"strconv"
+	"strings"
 )
 
 // Volume represents a RAID array
@@ -17,8 +18,15 @@ type Volume struct {
 	StripeSize       uint64
 	Spans            uint64
 	SpanLength       uint64
+	WritePolicy      string
+	ReadPolicy       string
+	DiskCache        string
+	IOPolicy         string
 	Disks            []*PhysicalDisk
+	HotSpares        []*PhysicalDisk
 	Info             map[string]string
+	Bootable         bool
+	Secured          bool // encrypted with the controller key
 	controller       *Controller
 	driver           Driver
 	Fake             bool
@@ -27,13 +35,19 @@ type Volume struct {
 
 func (v *Volume) VolSpec() *VolSpec {
 	res := &VolSpec{
-		VolumeID:   v.ID,
-		RaidLevel:  v.RaidLevel,
-		Name:       v.Name,
-		Size:       sizeStringer(v.Size),
-		StripeSize: sizeStringer(v.StripeSize),
-		Disks:      make([]VolSpecDisk, len(v.Disks)),
-		Fake:       v.Fake,
+		VolumeID:    v.ID,
+		RaidLevel:   v.RaidLevel,
+		Name:        v.Name,
+		Size:        sizeStringer(v.Size),
+		StripeSize:  sizeStringer(v.StripeSize),
+		Disks:       make([]VolSpecDisk, len(v.Disks)),
+		Fake:        v.Fake,
+		WritePolicy: v.WritePolicy,
+		ReadPolicy:  v.ReadPolicy,
+		DiskCache:   v.DiskCache,
+		IOPolicy:    v.IOPolicy,
+		Bootable:    v.Bootable,
+		Encrypt:     v.Secured,
 	}
 	if v.controller == nil {
 		res.Controller, _ = strconv.Atoi(v.ControllerID)
@@ -51,11 +65,43 @@ func (v *Volume) VolSpec() *VolSpec {
 		}
 	}
 	sort.Stable(vsdByPos(res.Disks))
+	if len(v.HotSpares) > 0 {
+		res.HotSpareDisks = make(VolSpecDisks, len(v.HotSpares))
+		for i := range v.HotSpares {
+			res.HotSpareDisks[i] = v.HotSpares[i].volSpecDisk()
+		}
+		sort.Stable(vsdByPos(res.HotSpareDisks))
+	}
 	res.Type = res.Disks[0].Type
 	res.Protocol = res.Disks[0].Protocol
 	return res
 }
 
+// OSDevice returns the block device the running OS sees this volume
+// as, or "" if the driver does not report it.
+func (v *Volume) OSDevice() string {
+	for _, k := range []string{"OS Drive Name", "Disk Name", "Device"} {
+		if dev := v.Info[k]; strings.HasPrefix(dev, "/dev/") {
+			return dev
+		}
+	}
+	return ""
+}
+
+// InUse returns true if the OS has filesystems mounted from, swap on, or
+// other block devices stacked on top of this volume.  Volumes we cannot
+// map to a block device might be in use, so they count as in use.
+func (v *Volume) InUse() bool {
+	if fake {
+		return false
+	}
+	dev := v.OSDevice()
+	if dev == "" {
+		return true
+	}
+	return blockDevInUse(dev)
+}
+
 func (v *Volume) PerDiskSize() uint64 {
 	return roundToStripe(v.StripeSize,
 		raidLevels[v.RaidLevel].perDiskSize(v.Spans, v.SpanLength, v.Size))
@@ -81,3 +127,13 @@ func (v Volumes) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
 func (v Volumes) Less(i, j int) bool {
 	return v[i].Less(v[j])
 }
+
+// securedState reads the encryption state controllers report for a
+// volume, which is None or Disabled when it is not encrypted.
+func securedState(v string) bool {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "", "none", "no", "disabled", "not encrypted":
+		return false
+	}
+	return true
+}